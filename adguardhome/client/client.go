@@ -2,9 +2,15 @@ package client
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"net/url"
+	"os"
 	"strings"
 	"time"
 
@@ -12,101 +18,259 @@ import (
 )
 
 type Client struct {
-	Auth       string
+	Username   string
+	Password   string
 	Endpoint   *url.URL
 	HttpClient http.Client
+
+	tlsConfig *tls.Config
 }
 
-func New(endpoint, auth string) *Client {
+type ClientOption func(*Client) error
+
+// APIError is returned for responses with a non-2xx status code.
+type APIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Status     string
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("%s %s: unexpected status: %s", e.Method, e.Path, e.Status)
+	}
+	return fmt.Sprintf("%s %s: unexpected status: %s: %s", e.Method, e.Path, e.Status, e.Body)
+}
+
+func New(endpoint string, options ...ClientOption) (*Client, error) {
 	u, err := url.Parse(endpoint)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("could not parse endpoint: %w", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("endpoint must be an absolute URL: %q", endpoint)
 	}
 
 	c := &Client{
-		Auth:     auth,
 		Endpoint: u,
 		HttpClient: http.Client{
 			Timeout: time.Second * 10,
-			Transport: http.RoundTripper(&http.Transport{
-				MaxIdleConns:        100,
-				MaxConnsPerHost:     100,
-				MaxIdleConnsPerHost: 100,
-				IdleConnTimeout:     time.Second * 90,
-			}),
 		},
 	}
 
-	return c
+	for _, option := range options {
+		err := option(c)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if c.HttpClient.Transport == nil {
+		c.HttpClient.Transport = &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			TLSClientConfig:     c.tlsConfig,
+			MaxIdleConns:        100,
+			MaxConnsPerHost:     100,
+			MaxIdleConnsPerHost: 100,
+			IdleConnTimeout:     time.Second * 90,
+		}
+	}
+
+	return c, nil
+}
+
+// URL returns the endpoint joined with path, keeping any path prefix the
+// endpoint already has.
+func (c *Client) URL(path string) string {
+	return c.Endpoint.JoinPath(path).String()
 }
 
-func (c *Client) doRequest(r *http.Request, res interface{}) (*http.Response, error) {
-	up := strings.Split(c.Auth, ":")
-	r.SetBasicAuth(up[0], up[1])
+func (c *Client) doRequest(ctx context.Context, method, path string, in, res interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("could not encode request: %w", err)
+		}
+		body = bytes.NewReader(b)
+	}
 
-	r.Header.Add("Content-Type", "application/json")
+	r, err := http.NewRequestWithContext(ctx, method, c.URL(path), body)
+	if err != nil {
+		return err
+	}
+
+	if c.Username != "" || c.Password != "" {
+		r.SetBasicAuth(c.Username, c.Password)
+	}
+	if in != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	r.Header.Set("Accept", "application/json")
 
 	resp, err := c.HttpClient.Do(r)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &APIError{
+			Method:     method,
+			Path:       path,
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Body:       strings.TrimSpace(string(b)),
+		}
+	}
+
 	if res != nil {
 		err = json.NewDecoder(resp.Body).Decode(res)
 		if err != nil {
-			return resp, err
+			return fmt.Errorf("could not decode response: %w", err)
 		}
 	}
-	return resp, nil
+
+	return nil
 }
 
-func (c *Client) List() (adguardhome.RewriteListResponse, error) {
-	req, err := http.NewRequest("GET", c.Endpoint.RequestURI()+"/control/rewrite/list", nil)
+// Status returns the server status, useful to check reachability and
+// credentials.
+func (c *Client) Status(ctx context.Context) (adguardhome.StatusResponse, error) {
+	var res adguardhome.StatusResponse
+	err := c.doRequest(ctx, http.MethodGet, "control/status", nil, &res)
 	if err != nil {
-		panic(err)
+		return adguardhome.StatusResponse{}, err
 	}
 
+	return res, nil
+}
+
+// List returns all DNS rewrites.
+func (c *Client) List(ctx context.Context) (adguardhome.RewriteListResponse, error) {
 	var res adguardhome.RewriteListResponse
-	_, err = c.doRequest(req, &res)
+	err := c.doRequest(ctx, http.MethodGet, "control/rewrite/list", nil, &res)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	return res, nil
 }
 
-func (c *Client) Add(in adguardhome.RewriteListResponseItem) {
-	c.post("add", in)
+// Add creates a DNS rewrite.
+func (c *Client) Add(ctx context.Context, in adguardhome.RewriteListResponseItem) error {
+	return c.doRequest(ctx, http.MethodPost, "control/rewrite/add", in, nil)
 }
 
-func (c *Client) Delete(in adguardhome.RewriteListResponseItem) {
-	c.post("delete", in)
+// Delete removes a DNS rewrite matching domain and answer.
+func (c *Client) Delete(ctx context.Context, in adguardhome.RewriteListResponseItem) error {
+	return c.doRequest(ctx, http.MethodPost, "control/rewrite/delete", in, nil)
 }
 
-func (c *Client) post(op string, in adguardhome.RewriteListResponseItem) {
-	b, err := json.Marshal(in)
-	if err != nil {
-		panic(err)
+// Update replaces the rewrite target with update in place.
+func (c *Client) Update(ctx context.Context, target, update adguardhome.RewriteListResponseItem) error {
+	return c.doRequest(ctx, http.MethodPut, "control/rewrite/update", adguardhome.RewriteUpdateRequest{
+		Target: target,
+		Update: update,
+	}, nil)
+}
+
+// WithBasicAuth sets the credentials used for every request.
+func WithBasicAuth(username, password string) ClientOption {
+	return func(c *Client) error {
+		c.Username = username
+		c.Password = password
+		return nil
 	}
+}
 
-	body := bytes.NewReader(b)
-	req, err := http.NewRequest("POST", c.Endpoint.String()+"/control/rewrite/"+op, body)
-	if err != nil {
-		panic(err)
+// WithAuth parses credentials in the format user:password. An empty string
+// disables authentication.
+func WithAuth(auth string) ClientOption {
+	return func(c *Client) error {
+		if auth == "" {
+			return nil
+		}
+
+		username, password, ok := strings.Cut(auth, ":")
+		if !ok {
+			return fmt.Errorf("auth must be in the format user:password")
+		}
+
+		return WithBasicAuth(username, password)(c)
 	}
+}
 
-	r, err := c.doRequest(req, nil)
-	if err != nil {
-		panic(err)
+// WithCredentialsFile reads credentials in the format user:password from
+// path, so they do not have to be passed on the command line.
+func WithCredentialsFile(path string) ClientOption {
+	return func(c *Client) error {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("could not read credentials file: %w", err)
+		}
+
+		auth := strings.TrimSpace(string(b))
+		if auth == "" {
+			return fmt.Errorf("credentials file %s is empty", path)
+		}
+
+		return WithAuth(auth)(c)
+	}
+}
+
+// WithTimeout sets the timeout of a single request.
+func WithTimeout(timeout time.Duration) ClientOption {
+	return func(c *Client) error {
+		c.HttpClient.Timeout = timeout
+		return nil
 	}
-	// log.Printf("status: %s", r.Status)
+}
 
-	if r.StatusCode >= 500 {
-		panic("status not ok: " + r.Status)
+// WithInsecureSkipVerify disables TLS certificate verification.
+func WithInsecureSkipVerify() ClientOption {
+	return func(c *Client) error {
+		if c.tlsConfig == nil {
+			c.tlsConfig = &tls.Config{}
+		}
+		c.tlsConfig.InsecureSkipVerify = true
+		return nil
 	}
+}
 
-	// if r.StatusCode != http.StatusOK {
-	// 	panic("status not ok: " + r.Status)
-	// }
+// WithCACertFile trusts the PEM encoded certificates in path in addition to
+// the system roots.
+func WithCACertFile(path string) ClientOption {
+	return func(c *Client) error {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("could not read CA certificate: %w", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(b) {
+			return fmt.Errorf("no certificates found in %s", path)
+		}
+
+		if c.tlsConfig == nil {
+			c.tlsConfig = &tls.Config{}
+		}
+		c.tlsConfig.RootCAs = pool
+		return nil
+	}
+}
+
+// WithHTTPClient replaces the underlying HTTP client. TLS options are ignored
+// if the client brings its own transport.
+func WithHTTPClient(httpClient http.Client) ClientOption {
+	return func(c *Client) error {
+		c.HttpClient = httpClient
+		return nil
+	}
 }
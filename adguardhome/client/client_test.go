@@ -0,0 +1,166 @@
+package client_test
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/soupdiver/creg/adguardhome"
+	"github.com/soupdiver/creg/adguardhome/client"
+)
+
+func TestURLKeepsEndpointPath(t *testing.T) {
+	tests := map[string]string{
+		"http://adguard.lan":          "http://adguard.lan/control/rewrite/list",
+		"http://adguard.lan/":         "http://adguard.lan/control/rewrite/list",
+		"https://proxy.lan/adguard":   "https://proxy.lan/adguard/control/rewrite/list",
+		"https://proxy.lan/adguard/":  "https://proxy.lan/adguard/control/rewrite/list",
+		"http://10.0.0.1:3000/nested": "http://10.0.0.1:3000/nested/control/rewrite/list",
+	}
+
+	for endpoint, expected := range tests {
+		c, err := client.New(endpoint)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if v := c.URL("control/rewrite/list"); v != expected {
+			t.Fatalf("%s: expected %s, got %s", endpoint, expected, v)
+		}
+	}
+}
+
+func TestInvalidOptions(t *testing.T) {
+	_, err := client.New("adguard.lan")
+	if err == nil {
+		t.Fatal("expected relative endpoint to fail")
+	}
+
+	_, err = client.New("http://adguard.lan", client.WithAuth("admin"))
+	if err == nil {
+		t.Fatal("expected auth without password to fail")
+	}
+
+	_, err = client.New("http://adguard.lan", client.WithCredentialsFile(filepath.Join(t.TempDir(), "missing")))
+	if err == nil {
+		t.Fatal("expected missing credentials file to fail")
+	}
+}
+
+func TestRequests(t *testing.T) {
+	var lastUpdate adguardhome.RewriteUpdateRequest
+	mux := http.NewServeMux()
+	mux.HandleFunc("/prefix/control/rewrite/list", func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "admin" || pass != "secret:with:colons" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(adguardhome.RewriteListResponse{{Domain: "app.lan", Answer: "10.0.0.1"}})
+	})
+	mux.HandleFunc("/prefix/control/rewrite/update", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		json.NewDecoder(r.Body).Decode(&lastUpdate)
+	})
+	mux.HandleFunc("/prefix/control/rewrite/add", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "rewrite already exists", http.StatusBadRequest)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	credentials := filepath.Join(t.TempDir(), "credentials")
+	err := os.WriteFile(credentials, []byte("admin:secret:with:colons\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := client.New(srv.URL+"/prefix", client.WithCredentialsFile(credentials))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+
+	list, err := c.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Domain != "app.lan" {
+		t.Fatalf("unexpected list: %+v", list)
+	}
+
+	target := adguardhome.RewriteListResponseItem{Domain: "app.lan", Answer: "10.0.0.1"}
+	update := adguardhome.RewriteListResponseItem{Domain: "app.lan", Answer: "10.0.0.2"}
+	err = c.Update(ctx, target, update)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lastUpdate.Target != target || lastUpdate.Update != update {
+		t.Fatalf("unexpected update: %+v", lastUpdate)
+	}
+
+	err = c.Add(ctx, update)
+	var apiErr *client.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest || apiErr.Body != "rewrite already exists" {
+		t.Fatalf("expected APIError with status 400, got %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = c.List(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(adguardhome.StatusResponse{Version: "v0.107.0", Running: true})
+	}))
+	defer srv.Close()
+
+	c, err := client.New(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Status(context.Background())
+	if err == nil {
+		t.Fatal("expected untrusted certificate to fail")
+	}
+
+	c, err = client.New(srv.URL, client.WithInsecureSkipVerify())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caCert := filepath.Join(t.TempDir(), "ca.pem")
+	err = os.WriteFile(caCert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err = client.New(srv.URL, client.WithCACertFile(caCert))
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := c.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Running || status.Version != "v0.107.0" {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+}
@@ -6,3 +6,17 @@ type RewriteListResponseItem struct {
 	Domain string `json:"domain"`
 	Answer string `json:"answer"`
 }
+
+// RewriteUpdateRequest replaces the rewrite Target with Update.
+type RewriteUpdateRequest struct {
+	Target RewriteListResponseItem `json:"target"`
+	Update RewriteListResponseItem `json:"update"`
+}
+
+// StatusResponse is the subset of /control/status used by creg.
+type StatusResponse struct {
+	Version           string `json:"version"`
+	Running           bool   `json:"running"`
+	DNSPort           int    `json:"dns_port"`
+	ProtectionEnabled bool   `json:"protection_enabled"`
+}
@@ -0,0 +1,217 @@
+package backends
+
+import (
+	"fmt"
+	"net"
+	"sort"
+
+	ctypes "github.com/soupdiver/creg/types"
+)
+
+// LabelAddress overrides the address services of a container are
+// registered at, regardless of the address strategy.
+const LabelAddress = "creg.address"
+
+// LabelNetwork registers the services of a container at its IP on the named
+// network with the container ports, regardless of the address strategy.
+const LabelNetwork = "creg.network"
+
+// Address strategy modes
+const (
+	// AddressStatic registers services at the forward address
+	AddressStatic = "static"
+	// AddressNetwork registers services at the container IP on a network
+	// with the container ports
+	AddressNetwork = "network"
+	// AddressHostIP registers services at the host IP they are published on
+	// if it is a specific one
+	AddressHostIP = "hostip"
+)
+
+// AddressStrategy selects the address services of a container are
+// registered at. The forward address is used if the strategy does not yield
+// an address, e.g. because the container is not on the network.
+type AddressStrategy struct {
+	// Mode is one of the Address* modes, empty is AddressStatic
+	Mode string
+	// Network of AddressNetwork, empty uses the only network of a container
+	Network string
+}
+
+// Validate returns an error for unknown modes.
+func (s AddressStrategy) Validate() error {
+	switch s.Mode {
+	case "", AddressStatic, AddressNetwork, AddressHostIP:
+		return nil
+	default:
+		return fmt.Errorf("unknown address strategy %q, must be %s, %s or %s", s.Mode, AddressStatic, AddressNetwork, AddressHostIP)
+	}
+}
+
+// Address returns the address of container, fallback is the forward
+// address.
+func (s AddressStrategy) Address(container ctypes.ContainerInfo, fallback string) string {
+	if v := container.Labels[LabelAddress]; v != "" {
+		return v
+	}
+
+	if ip := s.ContainerIP(container); ip != "" {
+		return ip
+	}
+
+	switch s.Mode {
+	case AddressHostIP:
+		// The first specific host IP of any port
+		var ports []string
+		for port := range container.NetworkSettings.Ports {
+			ports = append(ports, string(port))
+		}
+		sort.Strings(ports)
+
+		for _, port := range ports {
+			if ip := specificHostIP(container.NetworkSettings.Ports[ctypes.Port(port)]); ip != "" {
+				return ip
+			}
+		}
+	}
+
+	return fallback
+}
+
+// PortAddress returns the address of the service on the container port
+// port, in the format port/proto.
+func (s AddressStrategy) PortAddress(container ctypes.ContainerInfo, port ctypes.Port, fallback string) string {
+	if s.Mode == AddressHostIP && container.Labels[LabelAddress] == "" && container.Labels[LabelNetwork] == "" {
+		if ip := specificHostIP(container.NetworkSettings.Ports[port]); ip != "" {
+			return ip
+		}
+		return fallback
+	}
+
+	return s.Address(container, fallback)
+}
+
+// ContainerIP returns the IP services of container are reached at directly
+// on a container network, selected by the creg.network label or the network
+// strategy. It is empty if they are reached on the host.
+func (s AddressStrategy) ContainerIP(container ctypes.ContainerInfo) string {
+	if container.Labels[LabelAddress] != "" {
+		return ""
+	}
+	if v := container.Labels[LabelNetwork]; v != "" {
+		return NetworkIP(container.NetworkSettings, v)
+	}
+	if s.Mode == AddressNetwork {
+		return NetworkIP(container.NetworkSettings, s.Network)
+	}
+
+	return ""
+}
+
+// Endpoint returns the address and port of the service on the container
+// port port, in the format port/proto. Services reached on the container IP
+// use the container port and all others the host port, unless the
+// creg.port-mode label selects one.
+func (s AddressStrategy) Endpoint(container ctypes.ContainerInfo, port ctypes.Port, fallback string) (string, string) {
+	address := s.ContainerIP(container)
+	direct := address != ""
+	if !direct {
+		address = s.PortAddress(container, port, fallback)
+	}
+
+	if UseContainerPort(container, direct) {
+		return address, port.Port()
+	}
+	return address, HostPort(container.NetworkSettings, port)
+}
+
+// TCPEndpoint returns the address and port of the tcp containerPort, see
+// Endpoint. If containerPort is empty the only published tcp port is used,
+// or the only exposed one if the container port is used.
+func (s AddressStrategy) TCPEndpoint(container ctypes.ContainerInfo, containerPort, fallback string) (string, string, error) {
+	address := s.ContainerIP(container)
+	direct := address != ""
+
+	var port string
+	var err error
+	if UseContainerPort(container, direct) {
+		port, err = ExposedPort(container.NetworkSettings, containerPort)
+	} else {
+		port, err = PublishedPort(container.NetworkSettings, containerPort)
+	}
+	if err != nil {
+		return "", "", err
+	}
+
+	switch {
+	case direct:
+		return address, port, nil
+	case containerPort == "":
+		return s.Address(container, fallback), port, nil
+	default:
+		return s.PortAddress(container, ctypes.Port(containerPort+"/tcp"), fallback), port, nil
+	}
+}
+
+// NetworkIP returns the IP of the container on network. If network is empty
+// and the container is on a single network its IP is returned.
+func NetworkIP(settings ctypes.NetworkSettings, network string) string {
+	if network == "" {
+		if len(settings.Networks) != 1 {
+			return ""
+		}
+		for name := range settings.Networks {
+			network = name
+		}
+	}
+
+	n := settings.Networks[network]
+	if n.IPAddress != "" {
+		return n.IPAddress
+	}
+	return n.GlobalIPv6Address
+}
+
+func specificHostIP(bindings []ctypes.PortBinding) string {
+	for _, binding := range bindings {
+		ip := net.ParseIP(binding.HostIP)
+		if ip != nil && !ip.IsUnspecified() {
+			return binding.HostIP
+		}
+	}
+
+	return ""
+}
+
+// DetectAddress returns the first IPv4 address of the network interface
+// iface, or of the interface of the default route if iface is empty.
+func DetectAddress(iface string) (string, error) {
+	if iface == "" {
+		// Nothing is sent, connecting a UDP socket only selects the route
+		conn, err := net.Dial("udp", "192.0.2.1:9")
+		if err != nil {
+			return "", fmt.Errorf("could not find default route: %w", err)
+		}
+		defer conn.Close()
+
+		return conn.LocalAddr().(*net.UDPAddr).IP.String(), nil
+	}
+
+	i, err := net.InterfaceByName(iface)
+	if err != nil {
+		return "", fmt.Errorf("could not find interface: %w", err)
+	}
+	addrs, err := i.Addrs()
+	if err != nil {
+		return "", fmt.Errorf("could not get addresses of %s: %w", iface, err)
+	}
+
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if ok && ipnet.IP.To4() != nil && !ipnet.IP.IsLinkLocalUnicast() {
+			return ipnet.IP.String(), nil
+		}
+	}
+
+	return "", fmt.Errorf("interface %s has no IPv4 address", iface)
+}
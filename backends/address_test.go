@@ -0,0 +1,163 @@
+package backends_test
+
+import (
+	"testing"
+
+	"github.com/soupdiver/creg/backends"
+	ctypes "github.com/soupdiver/creg/types"
+)
+
+func TestAddressStrategy(t *testing.T) {
+	container := ctypes.ContainerInfo{
+		Labels: map[string]string{},
+		NetworkSettings: ctypes.NetworkSettings{
+			Ports: map[ctypes.Port][]ctypes.PortBinding{
+				"80/tcp":  {{HostIP: "0.0.0.0", HostPort: "8080"}},
+				"443/tcp": {{HostIP: "192.168.1.10", HostPort: "8443"}},
+			},
+			Networks: map[string]ctypes.Network{
+				"frontend": {IPAddress: "172.18.0.2"},
+				"backend":  {GlobalIPv6Address: "fd00::2"},
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		strategy backends.AddressStrategy
+		expected string
+	}{
+		{"static", backends.AddressStrategy{}, "10.0.0.1"},
+		{"network", backends.AddressStrategy{Mode: backends.AddressNetwork, Network: "frontend"}, "172.18.0.2"},
+		{"network ipv6", backends.AddressStrategy{Mode: backends.AddressNetwork, Network: "backend"}, "fd00::2"},
+		{"unknown network", backends.AddressStrategy{Mode: backends.AddressNetwork, Network: "other"}, "10.0.0.1"},
+		{"ambiguous network", backends.AddressStrategy{Mode: backends.AddressNetwork}, "10.0.0.1"},
+		{"hostip", backends.AddressStrategy{Mode: backends.AddressHostIP}, "192.168.1.10"},
+	}
+	for _, test := range tests {
+		address := test.strategy.Address(container, "10.0.0.1")
+		if address != test.expected {
+			t.Fatalf("%s: expected %s, got %s", test.name, test.expected, address)
+		}
+	}
+
+	hostIP := backends.AddressStrategy{Mode: backends.AddressHostIP}
+	if address := hostIP.PortAddress(container, "80/tcp", "10.0.0.1"); address != "10.0.0.1" {
+		t.Fatalf("expected 10.0.0.1 for a port bound to all IPs, got %s", address)
+	}
+	if address := hostIP.PortAddress(container, "443/tcp", "10.0.0.1"); address != "192.168.1.10" {
+		t.Fatalf("expected 192.168.1.10, got %s", address)
+	}
+
+	container.Labels[backends.LabelAddress] = "10.0.0.99"
+	if address := hostIP.PortAddress(container, "443/tcp", "10.0.0.1"); address != "10.0.0.99" {
+		t.Fatalf("expected the label to override the strategy, got %s", address)
+	}
+}
+
+func TestAddressStrategySingleNetwork(t *testing.T) {
+	strategy := backends.AddressStrategy{Mode: backends.AddressNetwork}
+	container := ctypes.ContainerInfo{
+		NetworkSettings: ctypes.NetworkSettings{
+			Networks: map[string]ctypes.Network{"bridge": {IPAddress: "172.17.0.5"}},
+		},
+	}
+
+	if address := strategy.Address(container, "10.0.0.1"); address != "172.17.0.5" {
+		t.Fatalf("expected 172.17.0.5, got %s", address)
+	}
+}
+
+func TestAddressStrategyValidate(t *testing.T) {
+	if err := (backends.AddressStrategy{Mode: "dhcp"}).Validate(); err == nil {
+		t.Fatalf("expected error for unknown mode")
+	}
+	if err := (backends.AddressStrategy{}).Validate(); err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+}
+
+func TestDetectAddressUnknownInterface(t *testing.T) {
+	_, err := backends.DetectAddress("creg-does-not-exist0")
+	if err == nil {
+		t.Fatalf("expected error for unknown interface")
+	}
+}
+
+func TestAddressStrategyEndpoint(t *testing.T) {
+	container := ctypes.ContainerInfo{
+		Labels: map[string]string{},
+		NetworkSettings: ctypes.NetworkSettings{
+			Ports: map[ctypes.Port][]ctypes.PortBinding{
+				"80/tcp":   {{HostIP: "0.0.0.0", HostPort: "8080"}},
+				"9000/tcp": {},
+			},
+			Networks: map[string]ctypes.Network{
+				"bridge":  {IPAddress: "172.17.0.5"},
+				"overlay": {IPAddress: "10.10.0.5"},
+			},
+		},
+	}
+
+	static := backends.AddressStrategy{}
+	address, port := static.Endpoint(container, "80/tcp", "10.0.0.1")
+	if address != "10.0.0.1" || port != "8080" {
+		t.Fatalf("expected 10.0.0.1:8080, got %s:%s", address, port)
+	}
+
+	network := backends.AddressStrategy{Mode: backends.AddressNetwork, Network: "overlay"}
+	address, port = network.Endpoint(container, "80/tcp", "10.0.0.1")
+	if address != "10.10.0.5" || port != "80" {
+		t.Fatalf("expected 10.10.0.5:80, got %s:%s", address, port)
+	}
+
+	container.Labels[backends.LabelNetwork] = "bridge"
+	address, port = static.Endpoint(container, "80/tcp", "10.0.0.1")
+	if address != "172.17.0.5" || port != "80" {
+		t.Fatalf("expected 172.17.0.5:80, got %s:%s", address, port)
+	}
+
+	// Unpublished ports are reachable on the container IP
+	address, port, err := static.TCPEndpoint(container, "9000", "10.0.0.1")
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if address != "172.17.0.5" || port != "9000" {
+		t.Fatalf("expected 172.17.0.5:9000, got %s:%s", address, port)
+	}
+
+	delete(container.Labels, backends.LabelNetwork)
+	if _, _, err := static.TCPEndpoint(container, "9000", "10.0.0.1"); err == nil {
+		t.Fatalf("expected error for unpublished port on the host")
+	}
+	address, port, err = static.TCPEndpoint(container, "", "10.0.0.1")
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if address != "10.0.0.1" || port != "8080" {
+		t.Fatalf("expected 10.0.0.1:8080, got %s:%s", address, port)
+	}
+}
+
+func TestServicesForContainerNetwork(t *testing.T) {
+	container := ctypes.ContainerInfo{
+		Labels: map[string]string{
+			backends.ServiceLabelPort: "80/tcp:web",
+			backends.LabelNetwork:     "overlay",
+		},
+		NetworkSettings: ctypes.NetworkSettings{
+			Ports: map[ctypes.Port][]ctypes.PortBinding{
+				"80/tcp": {{HostIP: "0.0.0.0", HostPort: "8080"}},
+			},
+			Networks: map[string]ctypes.Network{"overlay": {IPAddress: "10.10.0.5"}},
+		},
+	}
+
+	services := backends.ServicesForContainer(container, backends.AddressStrategy{}, "10.0.0.1", nil, nil)
+	if len(services) != 1 {
+		t.Fatalf("expected 1 service, got %+v", services)
+	}
+	if services[0].Address != "10.10.0.5" || services[0].Port != 80 {
+		t.Fatalf("expected 10.10.0.5:80, got %s:%d", services[0].Address, services[0].Port)
+	}
+}
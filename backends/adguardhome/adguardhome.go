@@ -2,63 +2,257 @@ package adguardhome
 
 import (
 	"context"
-	"errors"
-	"log"
-	"strings"
+	"fmt"
+	"time"
 
 	"github.com/sirupsen/logrus"
 
 	"github.com/soupdiver/creg/adguardhome"
 	"github.com/soupdiver/creg/adguardhome/client"
+	"github.com/soupdiver/creg/backends"
 	ctypes "github.com/soupdiver/creg/types"
 )
 
 type Backend struct {
-	Client *client.Client
-	Log    *logrus.Entry
+	Name            string
+	Client          *client.Client
+	Log             *logrus.Entry
+	ForwardAddress  string
+	AddressStrategy backends.AddressStrategy
+
+	// StatePath is the file the owned rewrites are persisted in, see
+	// backends.LoadState
+	StatePath string
+
+	// owned holds the rewrites created by this instance. AdGuard Home has no
+	// way to attach metadata to a rewrite, so only rewrites found here are
+	// ever updated or removed.
+	owned *backends.OwnedEntries
+
+	clientOptions []client.ClientOption
 }
 
-func New(address, auth string) (*Backend, error) {
+type AdguardHomeOption func(*Backend)
+
+func New(address string, options ...AdguardHomeOption) (*Backend, error) {
 	b := &Backend{
-		Client: client.New(address, auth),
+		Name: "adguardhome",
+		Log:  logrus.NewEntry(logrus.StandardLogger()),
+	}
+
+	for _, option := range options {
+		option(b)
+	}
+
+	c, err := client.New(address, b.clientOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("could not create adguardhome client: %w", err)
+	}
+	b.Client = c
+
+	b.owned, err = backends.NewOwnedEntries(rewriteClient{c}, b.StatePath, b.Log)
+	if err != nil {
+		return nil, err
 	}
 
 	return b, nil
 }
 
 func (b *Backend) Run(ctx context.Context, events chan ctypes.ContainerEventV2, purgeOnStart bool, containersToRefresh []ctypes.ContainerInfo) error {
+	var err error
+	if purgeOnStart {
+		err = b.Purge()
+		if err != nil {
+			return fmt.Errorf("could not purge: %w", err)
+		}
+	}
+
+	if len(containersToRefresh) > 0 {
+		err = b.Refresh(containersToRefresh)
+		if err != nil {
+			return fmt.Errorf("could not refresh: %w", err)
+		}
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
 			b.Log.Infof("AdguardHome exting: %s", "context cancelled")
 			return nil
 		case event := <-events:
-			log.Printf("handle event adguardhome: %s", event.Action)
+			b.Log.Debugf("handle event adguardhome: %s", event.Action)
+
+			rewrites := b.Rewrites(event.Container)
+			if len(rewrites) == 0 {
+				continue
+			}
+
 			switch event.Action {
 			case "start":
-				if v, ok := event.Container.Labels["creg.dns"]; ok {
-					sp := strings.Split(v, ",")
-					b.Client.Add(adguardhome.RewriteListResponseItem{Domain: sp[0], Answer: sp[1]})
+				err := b.RegisterRewrites(ctx, rewrites)
+				if err != nil {
+					b.Log.Errorf("Could not RegisterRewrites: %s", err)
+					continue
 				}
 			case "stop":
-				if v, ok := event.Container.Labels["creg.dns"]; ok {
-					sp := strings.Split(v, ",")
-					b.Client.Delete(adguardhome.RewriteListResponseItem{Domain: sp[0], Answer: sp[1]})
+				err := b.DeregisterRewrites(ctx, rewrites)
+				if err != nil {
+					b.Log.Errorf("Could not DeregisterRewrites: %s", err)
+					continue
 				}
 			}
 		}
 	}
-	return nil
 }
 
 func (b *Backend) GetName() string {
-	return "adguardhome"
+	return b.Name
 }
 
+// RewritesFromLabels parses the creg.dns label, see backends.ParseDNSLabel.
+// Entries without an answer point to the ForwardAddress. Malformed entries
+// are logged and skipped.
+func (b *Backend) RewritesFromLabels(labels map[string]string) []adguardhome.RewriteListResponseItem {
+	return b.Rewrites(ctypes.ContainerInfo{Labels: labels})
+}
+
+// Rewrites returns the rewrites of the creg.dns label of container, entries
+// without an answer point to the address selected by the AddressStrategy.
+func (b *Backend) Rewrites(container ctypes.ContainerInfo) []adguardhome.RewriteListResponseItem {
+	v, ok := container.Labels[backends.LabelDNS]
+	if !ok {
+		return nil
+	}
+
+	entries, err := backends.ParseDNSLabel(v, b.AddressStrategy.Address(container, b.ForwardAddress))
+	if err != nil {
+		b.Log.Errorf("Invalid %s label: %s", backends.LabelDNS, err)
+	}
+
+	var rewrites []adguardhome.RewriteListResponseItem
+	for _, entry := range entries {
+		rewrites = append(rewrites, adguardhome.RewriteListResponseItem{Domain: entry.Domain, Answer: entry.Answer})
+	}
+
+	return rewrites
+}
+
+// RegisterRewrites adds the rewrites which do not exist yet, see
+// backends.OwnedEntries.Register.
+func (b *Backend) RegisterRewrites(ctx context.Context, rewrites []adguardhome.RewriteListResponseItem) error {
+	return b.owned.Register(ctx, toEntries(rewrites))
+}
+
+// DeregisterRewrites deletes the given rewrites owned by this instance.
+func (b *Backend) DeregisterRewrites(ctx context.Context, rewrites []adguardhome.RewriteListResponseItem) error {
+	return b.owned.Deregister(ctx, toEntries(rewrites))
+}
+
+// Purge deletes all rewrites owned by this instance which still exist in
+// AdGuard Home. Rewrites created by anything else are left untouched.
 func (b *Backend) Purge() error {
-	return errors.New("not implemented")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	return b.owned.Purge(ctx)
 }
 
+// Refresh registers the rewrites of all given containers.
 func (b *Backend) Refresh(containers []ctypes.ContainerInfo) error {
-	return errors.New("not implemented")
+	b.Log.Debugf("Refreshing %d adguardhome containers", len(containers))
+
+	var rewrites []adguardhome.RewriteListResponseItem
+	for _, container := range containers {
+		rewrites = append(rewrites, b.Rewrites(container)...)
+	}
+
+	if len(rewrites) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	return b.RegisterRewrites(ctx, rewrites)
+}
+
+// rewriteClient manages rewrites as backends.Entry.
+type rewriteClient struct {
+	*client.Client
+}
+
+func (c rewriteClient) List(ctx context.Context) ([]backends.Entry, error) {
+	rewrites, err := c.Client.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return toEntries(rewrites), nil
+}
+
+func (c rewriteClient) Add(ctx context.Context, entry backends.Entry) error {
+	return c.Client.Add(ctx, toRewrite(entry))
+}
+
+func (c rewriteClient) Update(ctx context.Context, old, entry backends.Entry) error {
+	return c.Client.Update(ctx, toRewrite(old), toRewrite(entry))
+}
+
+func (c rewriteClient) Delete(ctx context.Context, entry backends.Entry) error {
+	return c.Client.Delete(ctx, toRewrite(entry))
+}
+
+func toEntries(rewrites []adguardhome.RewriteListResponseItem) []backends.Entry {
+	entries := make([]backends.Entry, 0, len(rewrites))
+	for _, rewrite := range rewrites {
+		entries = append(entries, backends.Entry{Domain: rewrite.Domain, Answer: rewrite.Answer})
+	}
+
+	return entries
+}
+
+func toRewrite(entry backends.Entry) adguardhome.RewriteListResponseItem {
+	return adguardhome.RewriteListResponseItem{Domain: entry.Domain, Answer: entry.Answer}
+}
+
+func WithLogger(log *logrus.Entry) func(b *Backend) {
+	return func(b *Backend) {
+		b.Log = log.WithField("backend", "adguardhome")
+	}
+}
+
+func WithForwardAddress(address string) func(b *Backend) {
+	return func(b *Backend) {
+		b.ForwardAddress = address
+	}
+}
+
+// WithStatePath persists the owned rewrites in the file path, so they are
+// purged after a restart.
+func WithStatePath(path string) func(b *Backend) {
+	return func(b *Backend) {
+		b.StatePath = path
+	}
+}
+
+func WithClientOptions(options ...client.ClientOption) func(b *Backend) {
+	return func(b *Backend) {
+		b.clientOptions = append(b.clientOptions, options...)
+	}
+}
+
+// WithName sets the backend name, see backends.Settings.
+func WithName(name string) func(b *Backend) {
+	return func(b *Backend) {
+		if name != "" {
+			b.Name = name
+		}
+	}
+}
+
+// WithAddressStrategy sets the address strategy, see backends.AddressStrategy.
+func WithAddressStrategy(strategy backends.AddressStrategy) func(b *Backend) {
+	return func(b *Backend) {
+		b.AddressStrategy = strategy
+	}
 }
@@ -0,0 +1,318 @@
+package adguardhome_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/soupdiver/creg/adguardhome"
+	"github.com/soupdiver/creg/adguardhome/client"
+	adguardhomebackend "github.com/soupdiver/creg/backends/adguardhome"
+	"github.com/soupdiver/creg/backends/backendtest"
+	ctypes "github.com/soupdiver/creg/types"
+)
+
+// fakeAdguardHome is a minimal stand-in for the AdGuard Home rewrite API.
+type fakeAdguardHome struct {
+	mtx      sync.Mutex
+	rewrites []adguardhome.RewriteListResponseItem
+	fail     bool
+}
+
+func (f *fakeAdguardHome) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if user, pass, ok := r.BasicAuth(); !ok || user != "admin" || pass != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if f.fail {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/control/rewrite/list":
+		json.NewEncoder(w).Encode(f.rewrites)
+	case "/control/rewrite/add":
+		var item adguardhome.RewriteListResponseItem
+		if err := json.NewDecoder(r.Body).Decode(&item); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.rewrites = append(f.rewrites, item)
+	case "/control/rewrite/delete":
+		var item adguardhome.RewriteListResponseItem
+		if err := json.NewDecoder(r.Body).Decode(&item); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		for i, v := range f.rewrites {
+			if v == item {
+				f.rewrites = append(f.rewrites[:i], f.rewrites[i+1:]...)
+				break
+			}
+		}
+	case "/control/rewrite/update":
+		var req adguardhome.RewriteUpdateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		for i, v := range f.rewrites {
+			if v == req.Target {
+				f.rewrites[i] = req.Update
+				return
+			}
+		}
+		w.WriteHeader(http.StatusBadRequest)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeAdguardHome) Rewrites() []adguardhome.RewriteListResponseItem {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	return append([]adguardhome.RewriteListResponseItem(nil), f.rewrites...)
+}
+
+func newTestBackend(t *testing.T, fake *fakeAdguardHome, options ...adguardhomebackend.AdguardHomeOption) *adguardhomebackend.Backend {
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	options = append([]adguardhomebackend.AdguardHomeOption{adguardhomebackend.WithLogger(backendtest.Logger())}, options...)
+	options = append(options, adguardhomebackend.WithClientOptions(client.WithBasicAuth("admin", "secret")))
+	b, err := adguardhomebackend.New(srv.URL, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func TestRefreshSkipsInvalidLabelsAndIsIdempotent(t *testing.T) {
+	fake := &fakeAdguardHome{}
+	b := newTestBackend(t, fake)
+
+	containers := []ctypes.ContainerInfo{
+		{ID: "a", Labels: map[string]string{"creg.dns": "app.lan,10.0.0.1"}},
+		{ID: "b", Labels: map[string]string{"creg.dns": "db.lan,10.0.0.2"}},
+		{ID: "c", Labels: map[string]string{"creg.dns": "broken"}},
+		{ID: "d", Labels: map[string]string{}},
+	}
+
+	backendtest.Refresh(t, b, containers...)
+	if len(fake.Rewrites()) != 2 {
+		t.Fatalf("expected %d rewrites, got %+v", 2, fake.Rewrites())
+	}
+
+	// A second refresh must not create duplicates
+	backendtest.Refresh(t, b, containers...)
+	if len(fake.Rewrites()) != 2 {
+		t.Fatalf("expected %d rewrites, got %+v", 2, fake.Rewrites())
+	}
+}
+
+func TestPurgeKeepsForeignRewrites(t *testing.T) {
+	foreign := adguardhome.RewriteListResponseItem{Domain: "router.lan", Answer: "192.168.1.1"}
+	fake := &fakeAdguardHome{rewrites: []adguardhome.RewriteListResponseItem{foreign}}
+	b := newTestBackend(t, fake)
+
+	backendtest.Refresh(t, b, ctypes.ContainerInfo{ID: "a", Labels: map[string]string{"creg.dns": "app.lan,10.0.0.1"}})
+	backendtest.Purge(t, b)
+
+	rewrites := fake.Rewrites()
+	if len(rewrites) != 1 || rewrites[0] != foreign {
+		t.Fatalf("expected only %+v to remain, got %+v", foreign, rewrites)
+	}
+}
+
+func TestPurgeAfterRestart(t *testing.T) {
+	foreign := adguardhome.RewriteListResponseItem{Domain: "router.lan", Answer: "192.168.1.1"}
+	fake := &fakeAdguardHome{rewrites: []adguardhome.RewriteListResponseItem{foreign}}
+	state := filepath.Join(t.TempDir(), "adguardhome.json")
+
+	b := newTestBackend(t, fake, adguardhomebackend.WithStatePath(state))
+	err := b.Refresh([]ctypes.ContainerInfo{{ID: "a", Labels: map[string]string{"creg.dns": "app.lan,10.0.0.1"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A new instance knows the rewrites of the previous one from the state
+	restarted := newTestBackend(t, fake, adguardhomebackend.WithStatePath(state))
+	err = restarted.Purge()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rewrites := fake.Rewrites()
+	if len(rewrites) != 1 || rewrites[0] != foreign {
+		t.Fatalf("expected only %+v to remain, got %+v", foreign, rewrites)
+	}
+}
+
+func TestRegisterAndDeregister(t *testing.T) {
+	fake := &fakeAdguardHome{}
+	b := newTestBackend(t, fake)
+
+	rewrites := b.RewritesFromLabels(map[string]string{"creg.dns": "app.lan,10.0.0.1"})
+	if len(rewrites) != 1 {
+		t.Fatalf("expected %d rewrites, got %d", 1, len(rewrites))
+	}
+
+	err := b.RegisterRewrites(context.Background(), rewrites)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fake.Rewrites()) != 1 {
+		t.Fatalf("expected %d rewrites, got %d", 1, len(fake.Rewrites()))
+	}
+
+	err = b.DeregisterRewrites(context.Background(), rewrites)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fake.Rewrites()) != 0 {
+		t.Fatalf("expected %d rewrites, got %d", 0, len(fake.Rewrites()))
+	}
+}
+
+func TestServerErrors(t *testing.T) {
+	fake := &fakeAdguardHome{fail: true}
+	b := newTestBackend(t, fake)
+
+	err := b.Purge()
+	if err == nil {
+		t.Fatal("expected purge to fail")
+	}
+
+	err = b.Refresh([]ctypes.ContainerInfo{{ID: "a", Labels: map[string]string{"creg.dns": "app.lan,10.0.0.1"}}})
+	if err == nil {
+		t.Fatal("expected refresh to fail")
+	}
+}
+
+func TestUnreachableServer(t *testing.T) {
+	b, err := adguardhomebackend.New("http://127.0.0.1:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.Purge()
+	if err == nil {
+		t.Fatal("expected purge to fail")
+	}
+}
+
+func TestRewritesFromLabels(t *testing.T) {
+	b := newTestBackend(t, &fakeAdguardHome{}, adguardhomebackend.WithForwardAddress("6.6.6.6"))
+
+	tests := []struct {
+		label    string
+		expected []adguardhome.RewriteListResponseItem
+	}{
+		{"app.lan,10.0.0.1", []adguardhome.RewriteListResponseItem{{Domain: "app.lan", Answer: "10.0.0.1"}}},
+		{"app.lan", []adguardhome.RewriteListResponseItem{{Domain: "app.lan", Answer: "6.6.6.6"}}},
+		{"'app.lan;www.app.lan,10.0.0.2; *.app.lan'", []adguardhome.RewriteListResponseItem{
+			{Domain: "app.lan", Answer: "6.6.6.6"},
+			{Domain: "www.app.lan", Answer: "10.0.0.2"},
+			{Domain: "*.app.lan", Answer: "6.6.6.6"},
+		}},
+		{"app.lan;app.lan", []adguardhome.RewriteListResponseItem{{Domain: "app.lan", Answer: "6.6.6.6"}}},
+		{"a.*.lan;*;,10.0.0.1;app..lan", nil},
+	}
+
+	for _, test := range tests {
+		rewrites := b.RewritesFromLabels(map[string]string{"creg.dns": test.label})
+		if len(rewrites) != len(test.expected) {
+			t.Fatalf("%q: expected %+v, got %+v", test.label, test.expected, rewrites)
+		}
+		for i := range rewrites {
+			if rewrites[i] != test.expected[i] {
+				t.Fatalf("%q: expected %+v, got %+v", test.label, test.expected, rewrites)
+			}
+		}
+	}
+}
+
+func TestRewritesWithoutForwardAddress(t *testing.T) {
+	b := newTestBackend(t, &fakeAdguardHome{})
+
+	rewrites := b.RewritesFromLabels(map[string]string{"creg.dns": "app.lan;db.lan,10.0.0.2"})
+	if len(rewrites) != 1 || rewrites[0].Domain != "db.lan" {
+		t.Fatalf("expected only db.lan, got %+v", rewrites)
+	}
+}
+
+func TestRegisterReplacesChangedAnswer(t *testing.T) {
+	fake := &fakeAdguardHome{rewrites: []adguardhome.RewriteListResponseItem{
+		{Domain: "other.lan", Answer: "10.0.0.1"},
+	}}
+	b := newTestBackend(t, fake, adguardhomebackend.WithForwardAddress("6.6.6.6"))
+
+	err := b.RegisterRewrites(context.Background(), b.RewritesFromLabels(map[string]string{"creg.dns": "app.lan"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The owned rewrite is updated when its answer changes
+	err = b.RegisterRewrites(context.Background(), b.RewritesFromLabels(map[string]string{"creg.dns": "app.lan,10.0.0.3"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rewrites := fake.Rewrites()
+	if len(rewrites) != 2 {
+		t.Fatalf("expected %d rewrites, got %+v", 2, rewrites)
+	}
+	for _, rewrite := range rewrites {
+		if rewrite.Domain == "app.lan" && rewrite.Answer != "10.0.0.3" {
+			t.Fatalf("expected app.lan to be updated, got %+v", rewrite)
+		}
+	}
+}
+
+func TestRegisterKeepsForeignRewrites(t *testing.T) {
+	foreign := []adguardhome.RewriteListResponseItem{
+		{Domain: "app.lan", Answer: "192.168.1.10"},
+		{Domain: "db.lan", Answer: "10.0.0.2"},
+	}
+	fake := &fakeAdguardHome{rewrites: append([]adguardhome.RewriteListResponseItem(nil), foreign...)}
+	b := newTestBackend(t, fake)
+
+	rewrites := b.RewritesFromLabels(map[string]string{"creg.dns": "app.lan,10.0.0.1;db.lan,10.0.0.2"})
+	err := b.RegisterRewrites(context.Background(), rewrites)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fake.Rewrites()) != 3 {
+		t.Fatalf("expected the hand-made app.lan rewrite to be kept, got %+v", fake.Rewrites())
+	}
+
+	// Identical rewrites are not claimed, so neither deregistering nor
+	// purging removes the hand-made ones
+	err = b.DeregisterRewrites(context.Background(), rewrites)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = b.Purge()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remaining := fake.Rewrites()
+	if len(remaining) != 2 || remaining[0] != foreign[0] || remaining[1] != foreign[1] {
+		t.Fatalf("expected %+v to remain, got %+v", foreign, remaining)
+	}
+}
@@ -0,0 +1,47 @@
+package adguardhome
+
+import (
+	"github.com/soupdiver/creg/adguardhome/client"
+	"github.com/soupdiver/creg/backends"
+)
+
+// BackendConfig configures an adguardhome backend in the config file.
+type BackendConfig struct {
+	Address string `yaml:"address"`
+	// Auth is user:password
+	Auth string `yaml:"auth"`
+	// AuthFile contains user:password, it takes precedence over Auth
+	AuthFile string `yaml:"auth_file"`
+	// CACert verifies the server certificate
+	CACert   string `yaml:"ca_cert"`
+	Insecure bool   `yaml:"insecure"`
+}
+
+func init() {
+	backends.Register("adguardhome", backends.Factory{
+		Config: func() interface{} { return &BackendConfig{} },
+		New: func(config interface{}, settings backends.Settings) (backends.Backend, error) {
+			cfg := config.(*BackendConfig)
+
+			clientOptions := []client.ClientOption{client.WithAuth(cfg.Auth)}
+			if cfg.AuthFile != "" {
+				clientOptions = append(clientOptions, client.WithCredentialsFile(cfg.AuthFile))
+			}
+			if cfg.CACert != "" {
+				clientOptions = append(clientOptions, client.WithCACertFile(cfg.CACert))
+			}
+			if cfg.Insecure {
+				clientOptions = append(clientOptions, client.WithInsecureSkipVerify())
+			}
+
+			return New(cfg.Address,
+				WithName(settings.Name),
+				WithLogger(settings.Log),
+				WithForwardAddress(settings.ForwardAddress),
+				WithAddressStrategy(settings.AddressStrategy),
+				WithStatePath(settings.StatePath("adguardhome")),
+				WithClientOptions(clientOptions...),
+			)
+		},
+	})
+}
@@ -0,0 +1,66 @@
+// Package backendtest provides helpers for the tests of backends.
+package backendtest
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	ctypes "github.com/soupdiver/creg/types"
+)
+
+// Logger returns a logger which discards everything.
+func Logger() *logrus.Entry {
+	logger := logrus.New()
+	logger.Out = io.Discard
+	return logrus.NewEntry(logger)
+}
+
+// Container returns a running container with the service web on port
+// 80/tcp, published on hostPort.
+func Container(id, hostPort string) ctypes.ContainerInfo {
+	return ctypes.ContainerInfo{
+		ID:     id,
+		Labels: map[string]string{"creg.port": "80/tcp:web"},
+		NetworkSettings: ctypes.NetworkSettings{
+			Ports: map[ctypes.Port][]ctypes.PortBinding{"80/tcp": {{HostIP: "0.0.0.0", HostPort: hostPort}}},
+		},
+	}
+}
+
+// Refresher is the part of a backend the helpers use.
+type Refresher interface {
+	Refresh(containers []ctypes.ContainerInfo) error
+	Purge() error
+}
+
+// Refresh refreshes b with containers and fails the test on errors.
+func Refresh(t testing.TB, b Refresher, containers ...ctypes.ContainerInfo) {
+	t.Helper()
+
+	err := b.Refresh(containers)
+	if err != nil {
+		t.Fatalf("could not refresh: %s", err)
+	}
+}
+
+// Purge purges b and fails the test on errors.
+func Purge(t testing.TB, b Refresher) {
+	t.Helper()
+
+	err := b.Purge()
+	if err != nil {
+		t.Fatalf("could not purge: %s", err)
+	}
+}
+
+// ExpectStrings fails the test if got differs from expected.
+func ExpectStrings(t testing.TB, expected, got []string) {
+	t.Helper()
+
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("expected\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+}
@@ -0,0 +1,351 @@
+package caddy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/soupdiver/creg/backends"
+	"github.com/soupdiver/creg/caddy"
+	"github.com/soupdiver/creg/caddy/client"
+	ctypes "github.com/soupdiver/creg/types"
+)
+
+const (
+	// LabelHost lists the hosts proxied to the container, separated by commas
+	LabelHost = "creg.caddy.host"
+	// LabelPort selects the container port to proxy to, it is only required
+	// if the container publishes more than one tcp port
+	LabelPort = "creg.caddy.port"
+)
+
+// Backend creates a reverse proxy route per host in a Caddy HTTP server
+// through the admin API. Containers sharing a host become upstreams of the
+// same route. Routes are identified by their @id, IDPrefix followed by the
+// host, so Purge and Refresh never touch routes created by anything else.
+type Backend struct {
+	Name            string
+	Client          *client.Client
+	Log             *logrus.Entry
+	ForwardAddress  string
+	AddressStrategy backends.AddressStrategy
+	// Server is the name of the Caddy HTTP server the routes are added to
+	Server   string
+	IDPrefix string
+
+	// upstreams holds the upstreams of each container by container ID and host
+	upstreams    map[string]map[string]string
+	upstreamsMtx sync.Mutex
+
+	clientOptions []client.ClientOption
+}
+
+type CaddyOption func(*Backend)
+
+func New(address string, options ...CaddyOption) (*Backend, error) {
+	b := &Backend{
+		Name:      "caddy",
+		Log:       logrus.NewEntry(logrus.StandardLogger()),
+		Server:    "srv0",
+		IDPrefix:  "creg:",
+		upstreams: map[string]map[string]string{},
+	}
+
+	for _, option := range options {
+		option(b)
+	}
+
+	c, err := client.New(address, b.clientOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("could not create caddy client: %w", err)
+	}
+	b.Client = c
+
+	return b, nil
+}
+
+func (b *Backend) Run(ctx context.Context, events chan ctypes.ContainerEventV2, purgeOnStart bool, containersToRefresh []ctypes.ContainerInfo) error {
+	var err error
+	if purgeOnStart {
+		err = b.Purge()
+		if err != nil {
+			return fmt.Errorf("could not purge: %w", err)
+		}
+	}
+
+	// Always refresh, this removes routes of containers which stopped while
+	// creg was not running
+	err = b.Refresh(containersToRefresh)
+	if err != nil {
+		return fmt.Errorf("could not refresh: %w", err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			b.Log.Infof("Caddy exting: %s", "context cancelled")
+			return nil
+		case event := <-events:
+			b.Log.Debugf("handle event caddy: %s", event.Action)
+
+			b.upstreamsMtx.Lock()
+			switch event.Action {
+			case "start":
+				b.setContainer(event.Container)
+			case "stop":
+				delete(b.upstreams, event.Container.ID)
+			default:
+				b.upstreamsMtx.Unlock()
+				continue
+			}
+			b.upstreamsMtx.Unlock()
+
+			err := b.Sync(ctx)
+			if err != nil {
+				b.Log.Errorf("Could not Sync: %s", err)
+				continue
+			}
+		}
+	}
+}
+
+func (b *Backend) GetName() string {
+	return b.Name
+}
+
+// Upstreams returns the dial address of container by host, or nil if the
+// container has no creg.caddy.host label.
+func (b *Backend) Upstreams(container ctypes.ContainerInfo) (map[string]string, error) {
+	v, ok := container.Labels[LabelHost]
+	if !ok {
+		return nil, nil
+	}
+
+	var hosts []string
+	for _, host := range strings.Split(v, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	if len(hosts) == 0 {
+		return nil, fmt.Errorf("%s has no hosts", LabelHost)
+	}
+
+	address, port, err := b.AddressStrategy.TCPEndpoint(container, container.Labels[LabelPort], b.ForwardAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	upstreams := map[string]string{}
+	for _, host := range hosts {
+		upstreams[host] = net.JoinHostPort(address, port)
+	}
+
+	return upstreams, nil
+}
+
+// setContainer must be called with upstreamsMtx held.
+func (b *Backend) setContainer(container ctypes.ContainerInfo) {
+	upstreams, err := b.Upstreams(container)
+	if err != nil {
+		b.Log.Errorf("Invalid caddy labels on %s: %s", container.ID, err)
+	}
+
+	if len(upstreams) == 0 {
+		delete(b.upstreams, container.ID)
+		return
+	}
+	b.upstreams[container.ID] = upstreams
+}
+
+// RouteID returns the @id of the route for host.
+func (b *Backend) RouteID(host string) string {
+	return b.IDPrefix + host
+}
+
+// Owns reports whether the route with the given @id is managed by this
+// instance. Hosts never contain a colon, which keeps the routes of instances
+// with different IDs apart.
+func (b *Backend) Owns(id string) bool {
+	return strings.HasPrefix(id, b.IDPrefix) && !strings.Contains(id[len(b.IDPrefix):], ":")
+}
+
+// Routes returns the wanted routes by @id.
+func (b *Backend) Routes() map[string]caddy.Route {
+	b.upstreamsMtx.Lock()
+	dials := map[string]map[string]struct{}{}
+	for _, upstreams := range b.upstreams {
+		for host, dial := range upstreams {
+			if dials[host] == nil {
+				dials[host] = map[string]struct{}{}
+			}
+			dials[host][dial] = struct{}{}
+		}
+	}
+	b.upstreamsMtx.Unlock()
+
+	routes := map[string]caddy.Route{}
+	for host, v := range dials {
+		var upstreams []caddy.Upstream
+		for dial := range v {
+			upstreams = append(upstreams, caddy.Upstream{Dial: dial})
+		}
+		sort.Slice(upstreams, func(i, j int) bool {
+			return upstreams[i].Dial < upstreams[j].Dial
+		})
+
+		route := caddy.Route{
+			ID:       b.RouteID(host),
+			Match:    []caddy.MatchSet{{Host: []string{host}}},
+			Handle:   []caddy.Handler{{Handler: "reverse_proxy", Upstreams: upstreams}},
+			Terminal: true,
+		}
+		routes[route.ID] = route
+	}
+
+	return routes
+}
+
+// Sync makes the creg routes of the server match the wanted routes. Routes
+// which changed are replaced in place, so their position is kept.
+func (b *Backend) Sync(ctx context.Context) error {
+	wanted := b.Routes()
+
+	existing, err := b.Client.Routes(ctx, b.Server)
+	if err != nil {
+		return fmt.Errorf("could not list routes: %w", err)
+	}
+
+	var errs []error
+	seen := map[string]struct{}{}
+	for _, route := range existing {
+		if !b.Owns(route.ID) {
+			continue
+		}
+		seen[route.ID] = struct{}{}
+
+		want, ok := wanted[route.ID]
+		if !ok {
+			b.Log.Debugf("Delete route: %s", route.ID)
+			err := b.Client.DeleteRoute(ctx, route.ID)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("could not delete %s: %w", route.ID, err))
+			}
+			continue
+		}
+
+		if !reflect.DeepEqual(route, want) {
+			b.Log.Debugf("Replace route: %s", route.ID)
+			err := b.Client.ReplaceRoute(ctx, want)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("could not replace %s: %w", route.ID, err))
+			}
+		}
+	}
+
+	ids := make([]string, 0, len(wanted))
+	for id := range wanted {
+		if _, ok := seen[id]; !ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		b.Log.Debugf("Add route: %s", id)
+		err := b.Client.AddRoute(ctx, b.Server, wanted[id])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not add %s: %w", id, err))
+		}
+	}
+
+	return backends.JoinErrors(errs)
+}
+
+// Purge deletes all routes owned by this instance.
+func (b *Backend) Purge() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	b.upstreamsMtx.Lock()
+	b.upstreams = map[string]map[string]string{}
+	b.upstreamsMtx.Unlock()
+
+	return b.Sync(ctx)
+}
+
+// Refresh replaces the routes with the ones of containers.
+func (b *Backend) Refresh(containers []ctypes.ContainerInfo) error {
+	b.Log.Debugf("Refreshing %d caddy containers", len(containers))
+
+	b.upstreamsMtx.Lock()
+	b.upstreams = map[string]map[string]string{}
+	for _, container := range containers {
+		b.setContainer(container)
+	}
+	b.upstreamsMtx.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	return b.Sync(ctx)
+}
+
+func WithLogger(log *logrus.Entry) func(b *Backend) {
+	return func(b *Backend) {
+		b.Log = log.WithField("backend", "caddy")
+	}
+}
+
+func WithForwardAddress(address string) func(b *Backend) {
+	return func(b *Backend) {
+		b.ForwardAddress = address
+	}
+}
+
+// WithServer sets the Caddy HTTP server the routes are added to, servers
+// adapted from a Caddyfile are named srv0, srv1, ...
+func WithServer(server string) func(b *Backend) {
+	return func(b *Backend) {
+		b.Server = server
+	}
+}
+
+// WithID makes the routes of several creg instances sharing one Caddy
+// distinguishable, each instance only manages routes with its own ID.
+func WithID(id string) func(b *Backend) {
+	return func(b *Backend) {
+		if id != "" {
+			b.IDPrefix = "creg:" + id + ":"
+		}
+	}
+}
+
+func WithClientOptions(options ...client.ClientOption) func(b *Backend) {
+	return func(b *Backend) {
+		b.clientOptions = append(b.clientOptions, options...)
+	}
+}
+
+// WithName sets the backend name, see backends.Settings.
+func WithName(name string) func(b *Backend) {
+	return func(b *Backend) {
+		if name != "" {
+			b.Name = name
+		}
+	}
+}
+
+// WithAddressStrategy sets the address strategy, see backends.AddressStrategy.
+func WithAddressStrategy(strategy backends.AddressStrategy) func(b *Backend) {
+	return func(b *Backend) {
+		b.AddressStrategy = strategy
+	}
+}
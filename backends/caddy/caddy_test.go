@@ -0,0 +1,195 @@
+package caddy_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/soupdiver/creg/backends/backendtest"
+	caddybackend "github.com/soupdiver/creg/backends/caddy"
+	"github.com/soupdiver/creg/caddy"
+	ctypes "github.com/soupdiver/creg/types"
+)
+
+const routesPath = "/config/apps/http/servers/srv0/routes"
+
+// fakeCaddy implements the parts of the admin API used by the backend
+type fakeCaddy struct {
+	mtx    sync.Mutex
+	routes []caddy.Route
+}
+
+func (f *fakeCaddy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	index := func(id string) int {
+		for i, route := range f.routes {
+			if route.ID == id {
+				return i
+			}
+		}
+		return -1
+	}
+
+	switch {
+	case r.URL.Path == routesPath && r.Method == http.MethodGet:
+		json.NewEncoder(w).Encode(f.routes)
+	case r.URL.Path == routesPath && r.Method == http.MethodPost:
+		var route caddy.Route
+		if err := json.NewDecoder(r.Body).Decode(&route); err != nil {
+			http.Error(w, `{"error":"invalid route"}`, http.StatusBadRequest)
+			return
+		}
+		f.routes = append(f.routes, route)
+	case strings.HasPrefix(r.URL.Path, "/id/"):
+		i := index(strings.TrimPrefix(r.URL.Path, "/id/"))
+		if i < 0 {
+			http.Error(w, `{"error":"unknown object ID"}`, http.StatusNotFound)
+			return
+		}
+
+		switch r.Method {
+		case http.MethodPatch:
+			var route caddy.Route
+			if err := json.NewDecoder(r.Body).Decode(&route); err != nil {
+				http.Error(w, `{"error":"invalid route"}`, http.StatusBadRequest)
+				return
+			}
+			f.routes[i] = route
+		case http.MethodDelete:
+			f.routes = append(f.routes[:i], f.routes[i+1:]...)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeCaddy) ids() []string {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	var ids []string
+	for _, route := range f.routes {
+		ids = append(ids, route.ID)
+	}
+	return ids
+}
+
+func container(id, host, hostPort string) ctypes.ContainerInfo {
+	return ctypes.ContainerInfo{
+		ID:     id,
+		Labels: map[string]string{caddybackend.LabelHost: host},
+		NetworkSettings: ctypes.NetworkSettings{
+			Ports: map[ctypes.Port][]ctypes.PortBinding{"80/tcp": {{HostIP: "0.0.0.0", HostPort: hostPort}}},
+		},
+	}
+}
+
+func newTestBackend(t *testing.T, fake *fakeCaddy) *caddybackend.Backend {
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	b, err := caddybackend.New(server.URL,
+		caddybackend.WithLogger(backendtest.Logger()),
+		caddybackend.WithForwardAddress("10.0.0.1"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+// existingRoutes returns a hand-made route, a route of another creg instance
+// and a stale route of this one.
+func existingRoutes() []caddy.Route {
+	return []caddy.Route{
+		{ID: "static", Match: []caddy.MatchSet{{Host: []string{"static.example.com"}}}},
+		{ID: "creg:other:old.example.com"},
+		{ID: "creg:stale.example.com"},
+	}
+}
+
+func TestRefreshAddsRouteWithUpstreamsPerHost(t *testing.T) {
+	fake := &fakeCaddy{routes: existingRoutes()}
+	b := newTestBackend(t, fake)
+
+	backendtest.Refresh(t, b,
+		container("a", "app.example.com", "8080"),
+		container("b", "app.example.com, www.example.com", "8081"),
+		ctypes.ContainerInfo{ID: "unlabelled"},
+	)
+
+	// The stale route is removed, the ones of others are kept
+	backendtest.ExpectStrings(t, []string{"static", "creg:other:old.example.com", "creg:app.example.com", "creg:www.example.com"}, fake.ids())
+
+	route := fake.routes[2]
+	upstreams := route.Handle[0].Upstreams
+	if len(upstreams) != 2 || upstreams[0].Dial != "10.0.0.1:8080" || upstreams[1].Dial != "10.0.0.1:8081" {
+		t.Fatalf("unexpected upstreams: %+v", upstreams)
+	}
+	if route.Match[0].Host[0] != "app.example.com" || !route.Terminal {
+		t.Fatalf("unexpected route: %+v", route)
+	}
+}
+
+func TestRefreshReplacesRoutesInPlace(t *testing.T) {
+	fake := &fakeCaddy{routes: existingRoutes()}
+	b := newTestBackend(t, fake)
+
+	backendtest.Refresh(t, b,
+		container("a", "app.example.com", "8080"),
+		container("b", "app.example.com, www.example.com", "8081"),
+	)
+	backendtest.Refresh(t, b, container("a", "app.example.com", "8080"))
+
+	backendtest.ExpectStrings(t, []string{"static", "creg:other:old.example.com", "creg:app.example.com"}, fake.ids())
+	if upstreams := fake.routes[2].Handle[0].Upstreams; len(upstreams) != 1 {
+		t.Fatalf("expected %d upstreams, got %+v", 1, upstreams)
+	}
+}
+
+func TestPurgeKeepsForeignRoutes(t *testing.T) {
+	fake := &fakeCaddy{routes: existingRoutes()}
+	b := newTestBackend(t, fake)
+
+	backendtest.Refresh(t, b, container("a", "app.example.com", "8080"))
+	backendtest.Purge(t, b)
+
+	backendtest.ExpectStrings(t, []string{"static", "creg:other:old.example.com"}, fake.ids())
+}
+
+func TestUpstreamsPort(t *testing.T) {
+	b := newTestBackend(t, &fakeCaddy{})
+
+	c := ctypes.ContainerInfo{
+		ID:     "app",
+		Labels: map[string]string{caddybackend.LabelHost: "app.example.com"},
+		NetworkSettings: ctypes.NetworkSettings{
+			Ports: map[ctypes.Port][]ctypes.PortBinding{
+				"80/tcp":   {{HostIP: "0.0.0.0", HostPort: "8080"}},
+				"9000/tcp": {{HostIP: "0.0.0.0", HostPort: "9000"}},
+			},
+		},
+	}
+
+	_, err := b.Upstreams(c)
+	if err == nil {
+		t.Fatal("expected ambiguous port to fail")
+	}
+
+	c.Labels[caddybackend.LabelPort] = "80"
+	upstreams, err := b.Upstreams(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upstreams["app.example.com"] != "10.0.0.1:8080" {
+		t.Fatalf("expected %q, got %+v", "10.0.0.1:8080", upstreams)
+	}
+}
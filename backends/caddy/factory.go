@@ -0,0 +1,33 @@
+package caddy
+
+import (
+	"github.com/soupdiver/creg/backends"
+)
+
+// BackendConfig configures a caddy backend in the config file.
+type BackendConfig struct {
+	// Address of the admin API, e.g. http://localhost:2019
+	Address string `yaml:"address"`
+	// Server routes are added to
+	Server string `yaml:"server"`
+}
+
+func init() {
+	backends.Register("caddy", backends.Factory{
+		Config: func() interface{} {
+			return &BackendConfig{Server: "srv0"}
+		},
+		New: func(config interface{}, settings backends.Settings) (backends.Backend, error) {
+			cfg := config.(*BackendConfig)
+
+			return New(cfg.Address,
+				WithName(settings.Name),
+				WithLogger(settings.Log),
+				WithID(settings.ID),
+				WithForwardAddress(settings.ForwardAddress),
+				WithAddressStrategy(settings.AddressStrategy),
+				WithServer(cfg.Server),
+			)
+		},
+	})
+}
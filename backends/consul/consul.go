@@ -3,7 +3,6 @@ package consul
 import (
 	"context"
 	"fmt"
-	"strconv"
 	"strings"
 
 	consulapi "github.com/hashicorp/consul/api"
@@ -14,13 +13,14 @@ import (
 )
 
 type Backend struct {
-	ID             string
-	Name           string
-	Log            *logrus.Entry
-	ConsulClient   *consulapi.Client
-	ForwardAddress string
-	StaticLabels   []string
-	ServicePrefix  string
+	ID              string
+	Name            string
+	Log             *logrus.Entry
+	ConsulClient    *consulapi.Client
+	ForwardAddress  string
+	AddressStrategy backends.AddressStrategy
+	StaticLabels    []string
+	ServicePrefix   string
 }
 
 func New(cfg *consulapi.Config, options ...ConsulOption) (*Backend, error) {
@@ -67,16 +67,7 @@ func (b *Backend) Run(ctx context.Context, events chan ctypes.ContainerEventV2,
 			// log.Printf("handle event consul: %s", event.Event.Action)
 			switch event.Action {
 			case "start":
-				ports := backends.ExtractPorts(event.Container.Labels, backends.ServiceLabelPort)
-				// for port, info := range event.Container.NetworkSettings.Ports {
-				// 	if v, ok := ports[port.Port()+"/"+port.Proto()]; ok && len(info) > 0 {
-				// 		ports[info[0].HostPort] = v
-				// 		delete(ports, port.Port()+"/"+port.Proto())
-				// 	}
-				// }
-
-				servicesByPort := backends.MapServices(ports, event.Container.Labels, b.StaticLabels, []backends.FilterFunc{backends.TraefikLabelFilter})
-				err := b.RegisterServices(servicesByPort)
+				err := b.RegisterServices(b.services(event.Container))
 				if err != nil {
 					b.Log.Errorf("Could not RegisterServices: %s", err)
 					continue
@@ -114,28 +105,27 @@ func (b *Backend) DeregisterServices(ports map[string]backends.ServiceWithLabels
 	return nil
 }
 
-// functen that registgers ports to consul
-func (b *Backend) RegisterServices(ports map[string]backends.ServiceWithLabels) error {
-	// log.Printf("Registering %+v services", ports)
+// services returns the services of container, the traefik labels of a
+// service are added to its tags.
+func (b *Backend) services(container ctypes.ContainerInfo) []backends.Service {
+	return backends.ServicesForContainer(container, b.AddressStrategy, b.ForwardAddress, b.StaticLabels, []backends.FilterFunc{backends.TraefikLabelFilter})
+}
+
+func (b *Backend) RegisterServices(services []backends.Service) error {
+	// log.Printf("Registering %+v services", services)
 
-	for port, service := range ports {
+	for _, service := range services {
 		registration := &consulapi.AgentServiceRegistration{
 			ID:      fmt.Sprintf("%s-%s", service.Name, b.ID),
 			Name:    service.Name,
-			Address: b.ForwardAddress,
-			Tags:    service.Labels,
-		}
-
-		var err error
-		registration.Port, err = strconv.Atoi(strings.Split(port, "/")[0])
-		if err != nil {
-			b.Log.Errorf("error parsing port: %s", err)
-			continue
+			Address: service.Address,
+			Port:    service.Port,
+			Tags:    service.Tags,
 		}
 
 		// log.Printf("Registering %+v", registration)
 
-		err = b.ConsulClient.Agent().ServiceRegister(registration)
+		err := b.ConsulClient.Agent().ServiceRegister(registration)
 		if err != nil {
 			b.Log.Errorf("error register: %s", err)
 		}
@@ -171,17 +161,7 @@ func (b *Backend) Refresh(containers []ctypes.ContainerInfo) error {
 	b.Log.Debugf("Refreshing %d consul services", len(containers))
 	for _, container := range containers {
 		// log.Printf("container labels: %+v", container.Config.Labels)
-		ports := backends.ExtractPorts(container.Labels, backends.ServiceLabelPort)
-
-		for port, info := range container.NetworkSettings.Ports {
-			if v, ok := ports[port.Port()+"/"+port.Proto()]; ok {
-				ports[info[0].HostPort] = v
-				delete(ports, port.Port()+"/"+port.Proto())
-			}
-		}
-
-		servicesByPort := backends.MapServices(ports, container.Labels, b.StaticLabels, []backends.FilterFunc{backends.TraefikLabelFilter})
-		err := b.RegisterServices(servicesByPort)
+		err := b.RegisterServices(b.services(container))
 		if err != nil {
 			b.Log.Errorf("Could not RegisterServices: %s", err)
 			continue
@@ -210,3 +190,32 @@ func WithID(id string) func(b *Backend) {
 		b.ID = id
 	}
 }
+
+// WithName sets the backend name, see backends.Settings.
+func WithName(name string) func(b *Backend) {
+	return func(b *Backend) {
+		if name != "" {
+			b.Name = name
+		}
+	}
+}
+
+func WithForwardAddress(address string) func(b *Backend) {
+	return func(b *Backend) {
+		b.ForwardAddress = address
+	}
+}
+
+// WithServicePrefix sets the prefix of the services removed by Purge.
+func WithServicePrefix(prefix string) func(b *Backend) {
+	return func(b *Backend) {
+		b.ServicePrefix = prefix
+	}
+}
+
+// WithAddressStrategy sets the address strategy, see backends.AddressStrategy.
+func WithAddressStrategy(strategy backends.AddressStrategy) func(b *Backend) {
+	return func(b *Backend) {
+		b.AddressStrategy = strategy
+	}
+}
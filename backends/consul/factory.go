@@ -0,0 +1,45 @@
+package consul
+
+import (
+	consulapi "github.com/hashicorp/consul/api"
+
+	"github.com/soupdiver/creg/backends"
+)
+
+// BackendConfig configures a consul backend in the config file.
+type BackendConfig struct {
+	// Address of the consul agent, the consul defaults and environment
+	// variables are used if empty
+	Address string `yaml:"address"`
+	// ServicePrefix of the services removed by Purge, defaults to the
+	// enable label
+	ServicePrefix string `yaml:"service_prefix"`
+}
+
+func init() {
+	backends.Register("consul", backends.Factory{
+		Config: func() interface{} { return &BackendConfig{} },
+		New: func(config interface{}, settings backends.Settings) (backends.Backend, error) {
+			cfg := config.(*BackendConfig)
+
+			consulConfig := consulapi.DefaultConfig()
+			if cfg.Address != "" {
+				consulConfig.Address = cfg.Address
+			}
+			prefix := cfg.ServicePrefix
+			if prefix == "" {
+				prefix = settings.EnableLabel
+			}
+
+			return New(consulConfig,
+				WithName(settings.Name),
+				WithLogger(settings.Log),
+				WithID(settings.ID),
+				WithForwardAddress(settings.ForwardAddress),
+				WithAddressStrategy(settings.AddressStrategy),
+				WithStaticLabels(settings.StaticLabels),
+				WithServicePrefix(prefix),
+			)
+		},
+	})
+}
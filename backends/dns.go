@@ -0,0 +1,79 @@
+package backends
+
+import (
+	"fmt"
+	"strings"
+)
+
+const LabelDNS = "creg.dns"
+
+// DNSEntry is a single domain from the creg.dns label and the answer it should
+// resolve to.
+type DNSEntry struct {
+	Domain string
+	Answer string
+}
+
+// ParseDNSLabel parses the value of the creg.dns label. The label holds one or
+// more entries separated by ";", each in the format domain[,answer]. Domains
+// may be wildcards like *.example.org. Entries without an answer use
+// defaultAnswer. Valid entries are returned even if others are malformed, the
+// malformed ones are reported in the error.
+func ParseDNSLabel(value, defaultAnswer string) ([]DNSEntry, error) {
+	var entries []DNSEntry
+	var errs []error
+	seen := map[DNSEntry]struct{}{}
+	for _, raw := range strings.Split(strings.Replace(value, "'", "", -1), ";") {
+		if strings.TrimSpace(raw) == "" {
+			continue
+		}
+
+		domain, answer, _ := strings.Cut(raw, ",")
+		domain, answer = strings.TrimSpace(domain), strings.TrimSpace(answer)
+		if !ValidDomain(domain) {
+			errs = append(errs, fmt.Errorf("invalid domain: %q", raw))
+			continue
+		}
+
+		if answer == "" {
+			answer = defaultAnswer
+		}
+		if answer == "" {
+			errs = append(errs, fmt.Errorf("no answer and no default: %q", raw))
+			continue
+		}
+
+		entry := DNSEntry{Domain: domain, Answer: answer}
+		if _, ok := seen[entry]; ok {
+			continue
+		}
+		seen[entry] = struct{}{}
+		entries = append(entries, entry)
+	}
+
+	return entries, JoinErrors(errs)
+}
+
+// ValidDomain reports whether domain can be used in a DNS entry. A single
+// leading "*" label is allowed for wildcards.
+func ValidDomain(domain string) bool {
+	if domain == "" || strings.ContainsAny(domain, " ,;/:") {
+		return false
+	}
+
+	for i, label := range strings.Split(strings.TrimSuffix(domain, "."), ".") {
+		if label == "" {
+			return false
+		}
+		if strings.Contains(label, "*") && (i != 0 || label != "*") {
+			return false
+		}
+	}
+
+	return domain != "*"
+}
+
+// IsWildcard reports whether domain is a wildcard like *.example.org.
+func IsWildcard(domain string) bool {
+	return strings.HasPrefix(domain, "*.")
+}
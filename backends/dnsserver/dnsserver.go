@@ -0,0 +1,345 @@
+package dnsserver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+
+	"github.com/soupdiver/creg/backends"
+	"github.com/soupdiver/creg/backends/dnsutil"
+	ctypes "github.com/soupdiver/creg/types"
+)
+
+// Backend is a built-in authoritative DNS server answering A/AAAA/SRV queries
+// for the services of running containers, see dnsutil.Records for the records
+// served per service.
+type Backend struct {
+	Name            string
+	Log             *logrus.Entry
+	Listen          string
+	Zone            string
+	TTL             uint32
+	ForwardAddress  string
+	AddressStrategy backends.AddressStrategy
+
+	// records holds the records of each container by container ID
+	records    map[string][]dns.RR
+	recordsMtx sync.RWMutex
+
+	servers []*dns.Server
+}
+
+type DNSServerOption func(*Backend)
+
+func New(listen, zone string, options ...DNSServerOption) (*Backend, error) {
+	b := &Backend{
+		Name:    "dnsserver",
+		Log:     logrus.NewEntry(logrus.StandardLogger()),
+		Listen:  listen,
+		Zone:    dns.Fqdn(strings.ToLower(zone)),
+		TTL:     60,
+		records: map[string][]dns.RR{},
+	}
+
+	for _, option := range options {
+		option(b)
+	}
+
+	if _, ok := dns.IsDomainName(b.Zone); !ok || zone == "" {
+		return nil, fmt.Errorf("invalid zone: %q", zone)
+	}
+
+	return b, nil
+}
+
+func (b *Backend) Run(ctx context.Context, events chan ctypes.ContainerEventV2, purgeOnStart bool, containersToRefresh []ctypes.ContainerInfo) error {
+	var err error
+	if purgeOnStart {
+		err = b.Purge()
+		if err != nil {
+			return fmt.Errorf("could not purge: %w", err)
+		}
+	}
+
+	if len(containersToRefresh) > 0 {
+		err = b.Refresh(containersToRefresh)
+		if err != nil {
+			return fmt.Errorf("could not refresh: %w", err)
+		}
+	}
+
+	err = b.ListenAndServe()
+	if err != nil {
+		return fmt.Errorf("could not start dns server: %w", err)
+	}
+	defer b.Shutdown()
+
+	for {
+		select {
+		case <-ctx.Done():
+			b.Log.Infof("DNSServer exting: %s", "context cancelled")
+			return nil
+		case event := <-events:
+			switch event.Action {
+			case "start":
+				b.SetContainer(event.Container)
+			case "stop":
+				b.RemoveContainer(event.Container.ID)
+			}
+		}
+	}
+}
+
+func (b *Backend) GetName() string {
+	return b.Name
+}
+
+// ListenAndServe starts serving on Listen via UDP and TCP and returns once
+// both listeners are up.
+func (b *Backend) ListenAndServe() error {
+	pc, err := net.ListenPacket("udp", b.Listen)
+	if err != nil {
+		return err
+	}
+
+	// Use the port picked for UDP so ":0" works for both transports
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		pc.Close()
+		return err
+	}
+
+	b.servers = []*dns.Server{
+		{PacketConn: pc, Handler: b},
+		{Listener: l, Handler: b},
+	}
+
+	for _, srv := range b.servers {
+		started := make(chan struct{})
+		srv.NotifyStartedFunc = func() { close(started) }
+
+		go func(srv *dns.Server) {
+			err := srv.ActivateAndServe()
+			if err != nil {
+				b.Log.Errorf("DNS server stopped: %s", err)
+			}
+		}(srv)
+
+		select {
+		case <-started:
+		case <-time.After(5 * time.Second):
+			return fmt.Errorf("timeout waiting for dns server")
+		}
+	}
+
+	b.Log.Infof("Serving %s on %s", b.Zone, pc.LocalAddr())
+
+	return nil
+}
+
+// Addr returns the address the UDP server listens on.
+func (b *Backend) Addr() string {
+	if len(b.servers) == 0 {
+		return ""
+	}
+	return b.servers[0].PacketConn.LocalAddr().String()
+}
+
+func (b *Backend) Shutdown() {
+	for _, srv := range b.servers {
+		srv.Shutdown()
+	}
+}
+
+// SetContainer replaces the records of container with the ones of its current
+// services.
+func (b *Backend) SetContainer(container ctypes.ContainerInfo) {
+	services := backends.ServicesForContainer(container, b.AddressStrategy, b.ForwardAddress, nil, nil)
+
+	records, err := dnsutil.Records(services, b.Zone, b.TTL, "")
+	if err != nil {
+		b.Log.Errorf("Could not build records: %s", err)
+	}
+
+	b.recordsMtx.Lock()
+	defer b.recordsMtx.Unlock()
+
+	if len(records) == 0 {
+		delete(b.records, container.ID)
+		return
+	}
+	b.records[container.ID] = records
+}
+
+func (b *Backend) RemoveContainer(id string) {
+	b.recordsMtx.Lock()
+	defer b.recordsMtx.Unlock()
+
+	delete(b.records, id)
+}
+
+// Purge forgets all records.
+func (b *Backend) Purge() error {
+	b.recordsMtx.Lock()
+	defer b.recordsMtx.Unlock()
+
+	b.records = map[string][]dns.RR{}
+
+	return nil
+}
+
+// Refresh sets the records of all given containers.
+func (b *Backend) Refresh(containers []ctypes.ContainerInfo) error {
+	b.Log.Debugf("Refreshing %d dnsserver containers", len(containers))
+
+	for _, container := range containers {
+		b.SetContainer(container)
+	}
+
+	return nil
+}
+
+// lookup returns all records with name and, if qtype is not dns.TypeANY, of
+// type qtype. exists reports whether name has records of any type.
+func (b *Backend) lookup(name string, qtype uint16) (records []dns.RR, exists bool) {
+	b.recordsMtx.RLock()
+	defer b.recordsMtx.RUnlock()
+
+	seen := map[string]struct{}{}
+	for _, rrs := range b.records {
+		for _, rr := range rrs {
+			if rr.Header().Name != name {
+				continue
+			}
+			exists = true
+
+			if qtype != dns.TypeANY && rr.Header().Rrtype != qtype {
+				continue
+			}
+			if _, ok := seen[rr.String()]; ok {
+				continue
+			}
+			seen[rr.String()] = struct{}{}
+			records = append(records, rr)
+		}
+	}
+
+	return records, exists
+}
+
+func (b *Backend) soa() dns.RR {
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: b.Zone, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: b.TTL},
+		Ns:      "ns." + b.Zone,
+		Mbox:    "hostmaster." + b.Zone,
+		Serial:  uint32(time.Now().Unix()),
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  86400,
+		Minttl:  b.TTL,
+	}
+}
+
+// ServeDNS answers queries for names in Zone and refuses everything else.
+func (b *Backend) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Authoritative = true
+
+	if len(r.Question) != 1 || r.Opcode != dns.OpcodeQuery {
+		m.Rcode = dns.RcodeNotImplemented
+		w.WriteMsg(m)
+		return
+	}
+
+	q := r.Question[0]
+	name := strings.ToLower(q.Name)
+	if !dns.IsSubDomain(b.Zone, name) {
+		m.Authoritative = false
+		m.Rcode = dns.RcodeRefused
+		w.WriteMsg(m)
+		return
+	}
+
+	if name == b.Zone {
+		if q.Qtype == dns.TypeSOA || q.Qtype == dns.TypeANY {
+			m.Answer = append(m.Answer, b.soa())
+		} else {
+			m.Ns = append(m.Ns, b.soa())
+		}
+		w.WriteMsg(m)
+		return
+	}
+
+	records, exists := b.lookup(name, q.Qtype)
+	switch {
+	case !exists:
+		m.Rcode = dns.RcodeNameError
+		m.Ns = append(m.Ns, b.soa())
+	case len(records) == 0:
+		m.Ns = append(m.Ns, b.soa())
+	default:
+		m.Answer = append(m.Answer, records...)
+	}
+
+	// Add the addresses of SRV targets we know about
+	for _, rr := range m.Answer {
+		if srv, ok := rr.(*dns.SRV); ok {
+			extra, _ := b.lookup(srv.Target, dns.TypeANY)
+			m.Extra = append(m.Extra, extra...)
+		}
+	}
+
+	size := dns.MinMsgSize
+	if opt := r.IsEdns0(); opt != nil {
+		m.SetEdns0(dns.DefaultMsgSize, false)
+		if int(opt.UDPSize()) > size {
+			size = int(opt.UDPSize())
+		}
+	}
+	if w.LocalAddr().Network() == "udp" {
+		m.Truncate(size)
+	}
+
+	w.WriteMsg(m)
+}
+
+func WithLogger(log *logrus.Entry) func(b *Backend) {
+	return func(b *Backend) {
+		b.Log = log.WithField("backend", "dnsserver")
+	}
+}
+
+func WithForwardAddress(address string) func(b *Backend) {
+	return func(b *Backend) {
+		b.ForwardAddress = address
+	}
+}
+
+func WithTTL(ttl uint32) func(b *Backend) {
+	return func(b *Backend) {
+		b.TTL = ttl
+	}
+}
+
+// WithName sets the backend name, see backends.Settings.
+func WithName(name string) func(b *Backend) {
+	return func(b *Backend) {
+		if name != "" {
+			b.Name = name
+		}
+	}
+}
+
+// WithAddressStrategy sets the address strategy, see backends.AddressStrategy.
+func WithAddressStrategy(strategy backends.AddressStrategy) func(b *Backend) {
+	return func(b *Backend) {
+		b.AddressStrategy = strategy
+	}
+}
@@ -0,0 +1,143 @@
+package dnsserver_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/soupdiver/creg/backends/backendtest"
+	"github.com/soupdiver/creg/backends/dnsserver"
+	ctypes "github.com/soupdiver/creg/types"
+)
+
+func newTestBackend(t *testing.T) *dnsserver.Backend {
+	b, err := dnsserver.New("127.0.0.1:0", "creg.local",
+		dnsserver.WithLogger(backendtest.Logger()),
+		dnsserver.WithForwardAddress("10.0.0.1"),
+		dnsserver.WithTTL(30),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.ListenAndServe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(b.Shutdown)
+
+	return b
+}
+
+func query(t *testing.T, addr, network, name string, qtype uint16) *dns.Msg {
+	m := new(dns.Msg)
+	m.SetQuestion(name, qtype)
+
+	c := &dns.Client{Net: network, Timeout: 2 * time.Second}
+	r, _, err := c.Exchange(m, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return r
+}
+
+func TestQueries(t *testing.T) {
+	b := newTestBackend(t)
+
+	err := b.Refresh([]ctypes.ContainerInfo{
+		{
+			ID:     "web",
+			Labels: map[string]string{"creg.port": "80/tcp:web"},
+			NetworkSettings: ctypes.NetworkSettings{
+				Ports: map[ctypes.Port][]ctypes.PortBinding{"80/tcp": {{HostIP: "0.0.0.0", HostPort: "8080"}}},
+			},
+		},
+		{ID: "other", Labels: map[string]string{}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := query(t, b.Addr(), "udp", "web.creg.local.", dns.TypeA)
+	if r.Rcode != dns.RcodeSuccess || len(r.Answer) != 1 || !r.Authoritative {
+		t.Fatalf("unexpected answer: %s", r)
+	}
+	if a, ok := r.Answer[0].(*dns.A); !ok || a.A.String() != "10.0.0.1" || a.Hdr.Ttl != 30 {
+		t.Fatalf("unexpected answer: %s", r.Answer[0])
+	}
+
+	r = query(t, b.Addr(), "tcp", "_web._tcp.creg.local.", dns.TypeSRV)
+	if r.Rcode != dns.RcodeSuccess || len(r.Answer) != 1 || len(r.Extra) != 1 {
+		t.Fatalf("unexpected answer: %s", r)
+	}
+	if srv, ok := r.Answer[0].(*dns.SRV); !ok || srv.Port != 8080 || srv.Target != "web.creg.local." {
+		t.Fatalf("unexpected answer: %s", r.Answer[0])
+	}
+
+	// Existing name without records of the type
+	r = query(t, b.Addr(), "udp", "web.creg.local.", dns.TypeAAAA)
+	if r.Rcode != dns.RcodeSuccess || len(r.Answer) != 0 || len(r.Ns) != 1 {
+		t.Fatalf("unexpected answer: %s", r)
+	}
+
+	r = query(t, b.Addr(), "udp", "missing.creg.local.", dns.TypeA)
+	if r.Rcode != dns.RcodeNameError {
+		t.Fatalf("expected NXDOMAIN, got %s", r)
+	}
+
+	r = query(t, b.Addr(), "udp", "example.org.", dns.TypeA)
+	if r.Rcode != dns.RcodeRefused {
+		t.Fatalf("expected REFUSED, got %s", r)
+	}
+
+	r = query(t, b.Addr(), "udp", "creg.local.", dns.TypeSOA)
+	if r.Rcode != dns.RcodeSuccess || len(r.Answer) != 1 {
+		t.Fatalf("unexpected answer: %s", r)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	b, err := dnsserver.New("127.0.0.1:0", "creg.local",
+		dnsserver.WithLogger(backendtest.Logger()),
+		dnsserver.WithForwardAddress("2001:db8::1"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := make(chan ctypes.ContainerEventV2)
+	done := make(chan error)
+	go func() {
+		done <- b.Run(ctx, events, true, nil)
+	}()
+
+	container := ctypes.ContainerInfo{ID: "db", Labels: map[string]string{"creg.port": "5432:db"}}
+	events <- ctypes.ContainerEventV2{Action: "start", Container: container}
+	// The next send only succeeds once the start event was handled
+	events <- ctypes.ContainerEventV2{Action: "create", Container: container}
+
+	r := query(t, b.Addr(), "udp", "db.creg.local.", dns.TypeAAAA)
+	if r.Rcode != dns.RcodeSuccess || len(r.Answer) != 1 {
+		t.Fatalf("unexpected answer: %s", r)
+	}
+
+	events <- ctypes.ContainerEventV2{Action: "stop", Container: container}
+	events <- ctypes.ContainerEventV2{Action: "create", Container: container}
+
+	r = query(t, b.Addr(), "udp", "db.creg.local.", dns.TypeAAAA)
+	if r.Rcode != dns.RcodeNameError {
+		t.Fatalf("expected NXDOMAIN, got %s", r)
+	}
+
+	cancel()
+	err = <-done
+	if err != nil {
+		t.Fatal(err)
+	}
+}
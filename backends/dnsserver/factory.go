@@ -0,0 +1,32 @@
+package dnsserver
+
+import (
+	"github.com/soupdiver/creg/backends"
+)
+
+// BackendConfig configures a dnsserver backend in the config file.
+type BackendConfig struct {
+	// Listen address, e.g. :5353
+	Listen string `yaml:"listen"`
+	Zone   string `yaml:"zone"`
+	TTL    uint32 `yaml:"ttl"`
+}
+
+func init() {
+	backends.Register("dnsserver", backends.Factory{
+		Config: func() interface{} {
+			return &BackendConfig{Zone: "creg.local", TTL: 60}
+		},
+		New: func(config interface{}, settings backends.Settings) (backends.Backend, error) {
+			cfg := config.(*BackendConfig)
+
+			return New(cfg.Listen, cfg.Zone,
+				WithName(settings.Name),
+				WithLogger(settings.Log),
+				WithForwardAddress(settings.ForwardAddress),
+				WithAddressStrategy(settings.AddressStrategy),
+				WithTTL(cfg.TTL),
+			)
+		},
+	})
+}
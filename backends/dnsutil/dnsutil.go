@@ -0,0 +1,71 @@
+package dnsutil
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+
+	"github.com/soupdiver/creg/backends"
+)
+
+// Records returns the DNS records for services in zone. For a service web on
+// port 8080/tcp in zone example.org these are
+//
+//	web.example.org.            A    <Address>
+//	_web._tcp.example.org.      SRV  0 0 8080 <target or web.example.org.>
+//
+// AAAA records are used for IPv6 addresses. Duplicate records are returned
+// once.
+func Records(services []backends.Service, zone string, ttl uint32, target string) ([]dns.RR, error) {
+	zone = dns.Fqdn(zone)
+
+	var records []dns.RR
+	var errs []error
+	seen := map[string]struct{}{}
+	for _, service := range services {
+		address := net.ParseIP(service.Address)
+		if address == nil {
+			errs = append(errs, fmt.Errorf("invalid address for service %s: %q", service.Name, service.Address))
+			continue
+		}
+
+		name := strings.ToLower(service.Name) + "." + zone
+		if _, ok := dns.IsDomainName(name); !ok || strings.Contains(service.Name, ".") {
+			errs = append(errs, fmt.Errorf("invalid service name for DNS: %s", service.Name))
+			continue
+		}
+
+		hdr := dns.RR_Header{Name: name, Class: dns.ClassINET, Ttl: ttl}
+		var addressRecord dns.RR
+		if v4 := address.To4(); v4 != nil {
+			hdr.Rrtype = dns.TypeA
+			addressRecord = &dns.A{Hdr: hdr, A: v4}
+		} else {
+			hdr.Rrtype = dns.TypeAAAA
+			addressRecord = &dns.AAAA{Hdr: hdr, AAAA: address}
+		}
+
+		srvTarget := name
+		if target != "" {
+			srvTarget = dns.Fqdn(target)
+		}
+
+		srv := &dns.SRV{
+			Hdr:    dns.RR_Header{Name: "_" + strings.ToLower(service.Name) + "._" + service.Proto + "." + zone, Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: ttl},
+			Port:   uint16(service.Port),
+			Target: srvTarget,
+		}
+
+		for _, rr := range []dns.RR{addressRecord, srv} {
+			if _, ok := seen[rr.String()]; ok {
+				continue
+			}
+			seen[rr.String()] = struct{}{}
+			records = append(records, rr)
+		}
+	}
+
+	return records, backends.JoinErrors(errs)
+}
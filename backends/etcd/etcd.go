@@ -14,11 +14,12 @@ import (
 )
 
 type Backend struct {
-	Name           string
-	Log            *logrus.Entry
-	EtcdClient     *clientv3.Client
-	ForwardAddress string
-	StaticLabels   []string
+	Name            string
+	Log             *logrus.Entry
+	EtcdClient      *clientv3.Client
+	ForwardAddress  string
+	AddressStrategy backends.AddressStrategy
+	StaticLabels    []string
 }
 
 type EtcdOption func(*Backend)
@@ -63,18 +64,18 @@ func (b *Backend) Run(ctx context.Context, events chan ctypes.ContainerEventV2,
 		case <-ctx.Done():
 			return nil
 		case event := <-events:
-			ports := backends.ExtractPorts(event.Container.Labels, backends.ServiceLabelPort)
-			servicesByPort := backends.MapServices(ports, event.Container.Labels, b.StaticLabels, []backends.FilterFunc{backends.TraefikLabelFilter})
-
 			switch event.Action {
 			case "start":
-				b.Log.Debugf("Registering services: %+v", servicesByPort)
-				err := b.RegisterServices(servicesByPort)
+				services := backends.ServicesForContainer(event.Container, b.AddressStrategy, b.ForwardAddress, b.StaticLabels, nil)
+				b.Log.Debugf("Registering services: %+v", services)
+				err := b.RegisterServices(services)
 				if err != nil {
 					b.Log.Errorf("Could not RegisterServices: %s", err)
 					continue
 				}
 			case "stop":
+				ports := backends.ExtractPorts(event.Container.Labels, backends.ServiceLabelPort)
+				servicesByPort := backends.MapServices(ports, event.Container.Labels, b.StaticLabels, []backends.FilterFunc{backends.TraefikLabelFilter})
 				b.Log.Debugf("Unregistering services: %+v", servicesByPort)
 				err := b.UnregisterServices(servicesByPort)
 				if err != nil {
@@ -126,15 +127,15 @@ func (b *Backend) UnregisterServices(ports map[string]backends.ServiceWithLabels
 	return nil
 }
 
-func (b *Backend) RegisterServices(ports map[string]backends.ServiceWithLabels) error {
+func (b *Backend) RegisterServices(services []backends.Service) error {
 	var err error
 
-	for port, service := range ports {
+	for _, service := range services {
 		// Use the etcd client to put the key-value pair
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
-		_, err = b.EtcdClient.Put(ctx, GenerateServiceKey(service.Name), fmt.Sprintf("%s:%s", b.ForwardAddress, port))
+		_, err = b.EtcdClient.Put(ctx, GenerateServiceKey(service.Name), fmt.Sprintf("%s:%d", service.Address, service.Port))
 		if err != nil {
 			return err
 		}
@@ -158,3 +159,25 @@ func WithLogger(log *logrus.Entry) func(b *Backend) {
 		b.Log = log.WithField("backend", "etcd")
 	}
 }
+
+// WithName sets the backend name, see backends.Settings.
+func WithName(name string) func(b *Backend) {
+	return func(b *Backend) {
+		if name != "" {
+			b.Name = name
+		}
+	}
+}
+
+func WithForwardAddress(address string) func(b *Backend) {
+	return func(b *Backend) {
+		b.ForwardAddress = address
+	}
+}
+
+// WithAddressStrategy sets the address strategy, see backends.AddressStrategy.
+func WithAddressStrategy(strategy backends.AddressStrategy) func(b *Backend) {
+	return func(b *Backend) {
+		b.AddressStrategy = strategy
+	}
+}
@@ -0,0 +1,29 @@
+package etcd
+
+import (
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/soupdiver/creg/backends"
+)
+
+// BackendConfig configures an etcd backend in the config file.
+type BackendConfig struct {
+	Endpoints []string `yaml:"endpoints"`
+}
+
+func init() {
+	backends.Register("etcd", backends.Factory{
+		Config: func() interface{} { return &BackendConfig{} },
+		New: func(config interface{}, settings backends.Settings) (backends.Backend, error) {
+			cfg := config.(*BackendConfig)
+
+			return New(clientv3.Config{Endpoints: cfg.Endpoints},
+				WithName(settings.Name),
+				WithLogger(settings.Log),
+				WithForwardAddress(settings.ForwardAddress),
+				WithAddressStrategy(settings.AddressStrategy),
+				WithStaticLabels(settings.StaticLabels),
+			)
+		},
+	})
+}
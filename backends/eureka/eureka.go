@@ -0,0 +1,411 @@
+package eureka
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/soupdiver/creg/backends"
+	"github.com/soupdiver/creg/eureka"
+	"github.com/soupdiver/creg/eureka/client"
+	ctypes "github.com/soupdiver/creg/types"
+)
+
+const (
+	// LabelMetadataPrefix prefixes labels added to the instance metadata,
+	// creg.eureka.metadata.management.port=8081 adds management.port
+	LabelMetadataPrefix = "creg.eureka.metadata."
+	// MetadataInstance holds the ID of the creg instance which registered
+	// an instance, Purge and Refresh only touch instances with their own ID
+	MetadataInstance = "creg-instance"
+)
+
+// Backend registers every service as an instance of the Eureka application
+// with the upper cased service name. Eureka drops instances whose lease is
+// not renewed, so a heartbeat is sent every RenewalInterval and instances the
+// server forgot are registered again.
+type Backend struct {
+	ID              string
+	Name            string
+	Log             *logrus.Entry
+	Client          *client.Client
+	ForwardAddress  string
+	AddressStrategy backends.AddressStrategy
+	StaticLabels    []string
+	DataCenter      string
+	// RenewalInterval is the heartbeat interval, LeaseDuration the time
+	// after which Eureka drops an instance without heartbeats
+	RenewalInterval time.Duration
+	LeaseDuration   time.Duration
+
+	// registered holds the instances registered for each container by
+	// container ID
+	registered    map[string][]eureka.Instance
+	registeredMtx sync.Mutex
+
+	clientOptions []client.ClientOption
+}
+
+type EurekaOption func(*Backend)
+
+// New creates a backend for the Eureka server at endpoint, e.g.
+// http://localhost:8761/eureka.
+func New(endpoint string, options ...EurekaOption) (*Backend, error) {
+	b := &Backend{
+		ID:              "creg",
+		Name:            "eureka",
+		Log:             logrus.NewEntry(logrus.StandardLogger()),
+		DataCenter:      eureka.DataCenterMyOwn,
+		RenewalInterval: 30 * time.Second,
+		LeaseDuration:   90 * time.Second,
+		registered:      map[string][]eureka.Instance{},
+	}
+
+	for _, option := range options {
+		option(b)
+	}
+
+	if b.RenewalInterval < time.Second || b.LeaseDuration <= b.RenewalInterval {
+		return nil, fmt.Errorf("renewal interval must be at least 1s and shorter than the lease duration: %s, %s", b.RenewalInterval, b.LeaseDuration)
+	}
+
+	c, err := client.New(endpoint, b.clientOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("could not create eureka client: %w", err)
+	}
+	b.Client = c
+
+	return b, nil
+}
+
+func (b *Backend) Run(ctx context.Context, events chan ctypes.ContainerEventV2, purgeOnStart bool, containersToRefresh []ctypes.ContainerInfo) error {
+	var err error
+	if purgeOnStart {
+		err = b.Purge()
+		if err != nil {
+			return fmt.Errorf("could not purge: %w", err)
+		}
+	}
+
+	// Always refresh, this removes instances of containers which stopped
+	// while creg was not running
+	err = b.Refresh(containersToRefresh)
+	if err != nil {
+		return fmt.Errorf("could not refresh: %w", err)
+	}
+
+	heartbeat := time.NewTicker(b.RenewalInterval)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			b.Log.Infof("Eureka exting: %s", "context cancelled")
+			return nil
+		case <-heartbeat.C:
+			err := b.Heartbeat(ctx)
+			if err != nil {
+				b.Log.Errorf("Could not Heartbeat: %s", err)
+			}
+		case event := <-events:
+			b.Log.Debugf("handle event eureka: %s", event.Action)
+
+			switch event.Action {
+			case "start":
+				instances := b.Instances(event.Container)
+				if len(instances) == 0 {
+					continue
+				}
+
+				err := b.Register(ctx, event.Container.ID, instances)
+				if err != nil {
+					b.Log.Errorf("Could not Register: %s", err)
+					continue
+				}
+			case "stop":
+				err := b.Deregister(ctx, event.Container.ID)
+				if err != nil {
+					b.Log.Errorf("Could not Deregister: %s", err)
+					continue
+				}
+			}
+		}
+	}
+}
+
+func (b *Backend) GetName() string {
+	return b.Name
+}
+
+// Metadata returns the metadata of a service with tags on container. Tags of
+// the form key=value and labels with LabelMetadataPrefix are added.
+func (b *Backend) Metadata(container ctypes.ContainerInfo, tags []string) map[string]string {
+	metadata := map[string]string{}
+	for _, tag := range tags {
+		k, v, ok := strings.Cut(tag, "=")
+		if ok && k != "" {
+			metadata[k] = v
+		}
+	}
+	for k, v := range container.Labels {
+		if key := strings.TrimPrefix(k, LabelMetadataPrefix); key != k && key != "" {
+			metadata[key] = v
+		}
+	}
+	metadata[MetadataInstance] = b.ID
+
+	return metadata
+}
+
+// Instances returns an instance for every service of container. The instance
+// ID is <ID>-<container ID>-<port>-<proto>.
+func (b *Backend) Instances(container ctypes.ContainerInfo) []eureka.Instance {
+	id := container.ID
+	if len(id) > 12 {
+		id = id[:12]
+	}
+
+	var instances []eureka.Instance
+	for _, service := range backends.ServicesForContainer(container, b.AddressStrategy, b.ForwardAddress, b.StaticLabels, nil) {
+		hostPort := net.JoinHostPort(service.Address, strconv.Itoa(service.Port))
+		instances = append(instances, eureka.Instance{
+			InstanceID:       fmt.Sprintf("%s-%s-%d-%s", b.ID, id, service.Port, service.Proto),
+			HostName:         service.Address,
+			App:              strings.ToUpper(service.Name),
+			IPAddr:           service.Address,
+			VIPAddress:       service.Name,
+			SecureVIPAddress: service.Name,
+			Status:           eureka.StatusUp,
+			Port:             eureka.NewPort(service.Port),
+			SecurePort:       eureka.NewPort(0),
+			HomePageURL:      "http://" + hostPort + "/",
+			DataCenterInfo:   eureka.NewDataCenterInfo(b.DataCenter),
+			LeaseInfo: eureka.LeaseInfo{
+				RenewalIntervalInSecs: int(b.RenewalInterval.Seconds()),
+				DurationInSecs:        int(b.LeaseDuration.Seconds()),
+			},
+			Metadata: b.Metadata(container, service.Tags),
+		})
+	}
+
+	return instances
+}
+
+// Register registers the instances of the container with id.
+func (b *Backend) Register(ctx context.Context, id string, instances []eureka.Instance) error {
+	var errs []error
+	for _, instance := range instances {
+		err := b.Client.Register(ctx, instance)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not register %s: %w", instance.InstanceID, err))
+		}
+	}
+
+	// Failed registrations are retried by the heartbeat
+	b.registeredMtx.Lock()
+	b.registered[id] = instances
+	b.registeredMtx.Unlock()
+
+	return backends.JoinErrors(errs)
+}
+
+// Deregister cancels the instances of the container with id.
+func (b *Backend) Deregister(ctx context.Context, id string) error {
+	b.registeredMtx.Lock()
+	instances := b.registered[id]
+	delete(b.registered, id)
+	b.registeredMtx.Unlock()
+
+	var errs []error
+	for _, instance := range instances {
+		err := b.Client.Cancel(ctx, instance.App, instance.InstanceID)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not cancel %s: %w", instance.InstanceID, err))
+		}
+	}
+
+	return backends.JoinErrors(errs)
+}
+
+// Heartbeat renews the leases of all registered instances and registers the
+// ones Eureka does not know, e.g. after it restarted.
+func (b *Backend) Heartbeat(ctx context.Context) error {
+	b.registeredMtx.Lock()
+	var instances []eureka.Instance
+	for _, v := range b.registered {
+		instances = append(instances, v...)
+	}
+	b.registeredMtx.Unlock()
+
+	var errs []error
+	for _, instance := range instances {
+		err := b.Client.Heartbeat(ctx, instance.App, instance.InstanceID)
+		if client.IsNotFound(err) {
+			b.Log.Debugf("Registering unknown instance %s again", instance.InstanceID)
+			err = b.Client.Register(ctx, instance)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not renew %s: %w", instance.InstanceID, err))
+		}
+	}
+
+	return backends.JoinErrors(errs)
+}
+
+// owned returns the instances registered by this creg instance.
+func (b *Backend) owned(ctx context.Context) ([]eureka.Instance, error) {
+	apps, err := b.Client.Applications(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("could not list applications: %w", err)
+	}
+
+	var instances []eureka.Instance
+	for _, app := range apps {
+		for _, instance := range app.Instances {
+			if instance.Metadata[MetadataInstance] == b.ID {
+				instances = append(instances, instance)
+			}
+		}
+	}
+
+	return instances, nil
+}
+
+// Purge cancels all instances registered by this creg instance.
+func (b *Backend) Purge() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	instances, err := b.owned(ctx)
+	if err != nil {
+		return err
+	}
+
+	b.registeredMtx.Lock()
+	b.registered = map[string][]eureka.Instance{}
+	b.registeredMtx.Unlock()
+
+	var errs []error
+	for _, instance := range instances {
+		err := b.Client.Cancel(ctx, instance.App, instance.InstanceID)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not cancel %s: %w", instance.InstanceID, err))
+		}
+	}
+
+	return backends.JoinErrors(errs)
+}
+
+// Refresh registers the services of containers and cancels all other
+// instances registered by this creg instance.
+func (b *Backend) Refresh(containers []ctypes.ContainerInfo) error {
+	b.Log.Debugf("Refreshing %d eureka containers", len(containers))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	existing, err := b.owned(ctx)
+	if err != nil {
+		return err
+	}
+
+	b.registeredMtx.Lock()
+	b.registered = map[string][]eureka.Instance{}
+	b.registeredMtx.Unlock()
+
+	wanted := map[string]struct{}{}
+	var errs []error
+	for _, container := range containers {
+		instances := b.Instances(container)
+		if len(instances) == 0 {
+			continue
+		}
+		for _, instance := range instances {
+			wanted[instance.App+"/"+instance.InstanceID] = struct{}{}
+		}
+
+		err := b.Register(ctx, container.ID, instances)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	sort.Slice(existing, func(i, j int) bool { return existing[i].InstanceID < existing[j].InstanceID })
+	for _, instance := range existing {
+		if _, ok := wanted[instance.App+"/"+instance.InstanceID]; ok {
+			continue
+		}
+		err := b.Client.Cancel(ctx, instance.App, instance.InstanceID)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not cancel %s: %w", instance.InstanceID, err))
+		}
+	}
+
+	return backends.JoinErrors(errs)
+}
+
+func WithLogger(log *logrus.Entry) func(b *Backend) {
+	return func(b *Backend) {
+		b.Log = log.WithField("backend", "eureka")
+	}
+}
+
+func WithForwardAddress(address string) func(b *Backend) {
+	return func(b *Backend) {
+		b.ForwardAddress = address
+	}
+}
+
+// WithStaticLabels adds labels of the form key=value to the metadata of
+// every instance.
+func WithStaticLabels(labels []string) func(b *Backend) {
+	return func(b *Backend) {
+		b.StaticLabels = labels
+	}
+}
+
+func WithID(id string) func(b *Backend) {
+	return func(b *Backend) {
+		if id != "" {
+			b.ID = id
+		}
+	}
+}
+
+// WithRenewalInterval sets the heartbeat interval, the lease duration is
+// three times the interval like Eureka's defaults.
+func WithRenewalInterval(interval time.Duration) func(b *Backend) {
+	return func(b *Backend) {
+		b.RenewalInterval = interval
+		b.LeaseDuration = 3 * interval
+	}
+}
+
+func WithClientOptions(options ...client.ClientOption) func(b *Backend) {
+	return func(b *Backend) {
+		b.clientOptions = append(b.clientOptions, options...)
+	}
+}
+
+// WithName sets the backend name, see backends.Settings.
+func WithName(name string) func(b *Backend) {
+	return func(b *Backend) {
+		if name != "" {
+			b.Name = name
+		}
+	}
+}
+
+// WithAddressStrategy sets the address strategy, see backends.AddressStrategy.
+func WithAddressStrategy(strategy backends.AddressStrategy) func(b *Backend) {
+	return func(b *Backend) {
+		b.AddressStrategy = strategy
+	}
+}
@@ -0,0 +1,213 @@
+package eureka_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/soupdiver/creg/backends/backendtest"
+	eurekabackend "github.com/soupdiver/creg/backends/eureka"
+	"github.com/soupdiver/creg/eureka"
+	ctypes "github.com/soupdiver/creg/types"
+)
+
+// fakeEureka implements the parts of the Eureka REST API below /eureka.
+type fakeEureka struct {
+	mtx        sync.Mutex
+	instances  map[string]eureka.Instance
+	heartbeats map[string]int
+}
+
+func (f *fakeEureka) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/eureka/apps"), "/")
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/eureka/apps":
+		apps := map[string][]eureka.Instance{}
+		for _, instance := range f.instances {
+			apps[instance.App] = append(apps[instance.App], instance)
+		}
+		var res eureka.ApplicationsResponse
+		for name, instances := range apps {
+			res.Applications.Applications = append(res.Applications.Applications, eureka.Application{Name: name, Instances: instances})
+		}
+		json.NewEncoder(w).Encode(res)
+	case r.Method == http.MethodPost && len(parts) == 2:
+		var req eureka.InstanceRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil || req.Instance.App != parts[1] {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.instances[parts[1]+"/"+req.Instance.InstanceID] = req.Instance
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut && len(parts) == 3:
+		key := parts[1] + "/" + parts[2]
+		if _, ok := f.instances[key]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		f.heartbeats[key]++
+	case r.Method == http.MethodDelete && len(parts) == 3:
+		key := parts[1] + "/" + parts[2]
+		if _, ok := f.instances[key]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(f.instances, key)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeEureka) keys() []string {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	var keys []string
+	for key := range f.instances {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func container(id, hostPort string) ctypes.ContainerInfo {
+	return ctypes.ContainerInfo{
+		ID: id,
+		Labels: map[string]string{
+			"creg.port":                            "80/tcp:web",
+			"creg.eureka.metadata.management.port": "8081",
+		},
+		NetworkSettings: ctypes.NetworkSettings{
+			Ports: map[ctypes.Port][]ctypes.PortBinding{"80/tcp": {{HostIP: "0.0.0.0", HostPort: hostPort}}},
+		},
+	}
+}
+
+func newTestBackend(t *testing.T) (*eurekabackend.Backend, *fakeEureka) {
+	f := &fakeEureka{instances: map[string]eureka.Instance{}, heartbeats: map[string]int{}}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+
+	b, err := eurekabackend.New(server.URL+"/eureka",
+		eurekabackend.WithLogger(backendtest.Logger()),
+		eurekabackend.WithID("test"),
+		eurekabackend.WithForwardAddress("10.0.0.1"),
+		eurekabackend.WithStaticLabels([]string{"zone=remote"}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b, f
+}
+
+// addInstances adds instances of another creg instance and of another
+// client, and a stale one of a previous run of this instance.
+func addInstances(f *fakeEureka) {
+	f.instances["WEB/other"] = eureka.Instance{InstanceID: "other", App: "WEB", Metadata: map[string]string{"creg-instance": "other"}}
+	f.instances["WEB/spring"] = eureka.Instance{InstanceID: "spring", App: "WEB"}
+	f.instances["WEB/test-gone-80-tcp"] = eureka.Instance{InstanceID: "test-gone-80-tcp", App: "WEB", Metadata: map[string]string{"creg-instance": "test"}}
+}
+
+func TestRefreshRegistersInstancesAndRemovesStaleOnes(t *testing.T) {
+	b, f := newTestBackend(t)
+	addInstances(f)
+
+	backendtest.Refresh(t, b, container("0123456789abcdef", "8080"))
+
+	backendtest.ExpectStrings(t, []string{"WEB/other", "WEB/spring", "WEB/test-0123456789ab-8080-tcp"}, f.keys())
+
+	instance := f.instances["WEB/test-0123456789ab-8080-tcp"]
+	if instance.IPAddr != "10.0.0.1" || instance.Port.Port != 8080 || instance.Port.Enabled != "true" || instance.VIPAddress != "web" || instance.Status != eureka.StatusUp {
+		t.Fatalf("unexpected instance: %+v", instance)
+	}
+	if instance.Metadata["management.port"] != "8081" || instance.Metadata["zone"] != "remote" || instance.Metadata["creg-instance"] != "test" {
+		t.Fatalf("unexpected metadata: %v", instance.Metadata)
+	}
+	if instance.LeaseInfo.RenewalIntervalInSecs != 30 || instance.LeaseInfo.DurationInSecs != 90 {
+		t.Fatalf("unexpected lease: %+v", instance.LeaseInfo)
+	}
+}
+
+func TestPurgeKeepsInstancesOfOtherClients(t *testing.T) {
+	b, f := newTestBackend(t)
+	addInstances(f)
+
+	backendtest.Refresh(t, b, container("0123456789abcdef", "8080"))
+	backendtest.Purge(t, b)
+
+	backendtest.ExpectStrings(t, []string{"WEB/other", "WEB/spring"}, f.keys())
+}
+
+func TestHeartbeatRegistersAgain(t *testing.T) {
+	b, f := newTestBackend(t)
+	ctx := context.Background()
+
+	c := container("a", "8080")
+	err := b.Register(ctx, c.ID, b.Instances(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.Heartbeat(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.heartbeats["WEB/test-a-8080-tcp"] != 1 {
+		t.Fatalf("expected 1 heartbeat, got %d", f.heartbeats["WEB/test-a-8080-tcp"])
+	}
+
+	// Eureka restarted and lost the instance
+	f.mtx.Lock()
+	delete(f.instances, "WEB/test-a-8080-tcp")
+	f.mtx.Unlock()
+
+	err = b.Heartbeat(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := strings.Join(f.keys(), ","); keys != "WEB/test-a-8080-tcp" {
+		t.Fatalf("expected instance to be registered again, got %s", keys)
+	}
+}
+
+func TestRunCancelsOnStop(t *testing.T) {
+	b, f := newTestBackend(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := make(chan ctypes.ContainerEventV2)
+	done := make(chan error)
+	go func() {
+		done <- b.Run(ctx, events, false, nil)
+	}()
+
+	events <- ctypes.ContainerEventV2{Action: "start", Container: container("a", "8080")}
+	events <- ctypes.ContainerEventV2{Action: "start", Container: container("b", "8081")}
+	events <- ctypes.ContainerEventV2{Action: "stop", Container: ctypes.ContainerInfo{ID: "b"}}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for strings.Join(f.keys(), ",") != "WEB/test-a-8080-tcp" {
+		if time.Now().After(deadline) {
+			t.Fatalf("unexpected instances: %v", f.keys())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	err := <-done
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,36 @@
+package eureka
+
+import (
+	"time"
+
+	"github.com/soupdiver/creg/backends"
+)
+
+// BackendConfig configures a eureka backend in the config file.
+type BackendConfig struct {
+	// Endpoint including the context path, e.g. http://localhost:8761/eureka
+	Endpoint string `yaml:"endpoint"`
+	// RenewalInterval of the leases, they expire after three intervals
+	RenewalInterval time.Duration `yaml:"renewal_interval"`
+}
+
+func init() {
+	backends.Register("eureka", backends.Factory{
+		Config: func() interface{} {
+			return &BackendConfig{RenewalInterval: 30 * time.Second}
+		},
+		New: func(config interface{}, settings backends.Settings) (backends.Backend, error) {
+			cfg := config.(*BackendConfig)
+
+			return New(cfg.Endpoint,
+				WithName(settings.Name),
+				WithLogger(settings.Log),
+				WithID(settings.ID),
+				WithForwardAddress(settings.ForwardAddress),
+				WithAddressStrategy(settings.AddressStrategy),
+				WithStaticLabels(settings.StaticLabels),
+				WithRenewalInterval(cfg.RenewalInterval),
+			)
+		},
+	})
+}
@@ -0,0 +1,46 @@
+package backends
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+)
+
+// WriteFileAtomic writes data to a temporary file next to path and renames it
+// over path, so readers never see a partially written file. It reports whether
+// the content changed, an unchanged file is not rewritten.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) (bool, error) {
+	current, err := os.ReadFile(path)
+	if err == nil && bytes.Equal(current, data) {
+		return false, nil
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return false, err
+	}
+
+	err = os.Chmod(f.Name(), perm)
+	if err != nil {
+		return false, err
+	}
+
+	err = os.Rename(f.Name(), path)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,28 @@
+package haproxy
+
+import (
+	"github.com/soupdiver/creg/backends"
+)
+
+// BackendConfig configures a haproxy backend in the config file.
+type BackendConfig struct {
+	// Address of the runtime API, a unix socket path or host:port
+	Address string `yaml:"address"`
+}
+
+func init() {
+	backends.Register("haproxy", backends.Factory{
+		Config: func() interface{} { return &BackendConfig{} },
+		New: func(config interface{}, settings backends.Settings) (backends.Backend, error) {
+			cfg := config.(*BackendConfig)
+
+			return New(cfg.Address,
+				WithName(settings.Name),
+				WithLogger(settings.Log),
+				WithID(settings.ID),
+				WithForwardAddress(settings.ForwardAddress),
+				WithAddressStrategy(settings.AddressStrategy),
+			)
+		},
+	})
+}
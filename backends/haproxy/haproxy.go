@@ -0,0 +1,354 @@
+package haproxy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/soupdiver/creg/backends"
+	"github.com/soupdiver/creg/haproxy"
+	"github.com/soupdiver/creg/haproxy/client"
+	ctypes "github.com/soupdiver/creg/types"
+)
+
+const (
+	// LabelBackend lists the HAProxy backends the container is added to,
+	// separated by commas. The backends have to exist in the configuration.
+	LabelBackend = "creg.haproxy.backend"
+	// LabelPort selects the container port to balance to, it is only required
+	// if the container publishes more than one tcp port
+	LabelPort = "creg.haproxy.port"
+)
+
+// Server is a server creg manages in an HAProxy backend.
+type Server struct {
+	Backend string
+	Name    string
+	Address string
+	Port    string
+}
+
+// Backend adds containers as servers to pre-declared HAProxy backends through
+// the runtime API, so no reload is needed. Servers are named ServerPrefix
+// followed by the short container ID, which is how creg recognizes the
+// servers it owns. Servers added by anything else are never touched.
+type Backend struct {
+	Name            string
+	Client          *client.Client
+	Log             *logrus.Entry
+	ForwardAddress  string
+	AddressStrategy backends.AddressStrategy
+	ServerPrefix    string
+
+	clientOptions []client.ClientOption
+}
+
+type HAProxyOption func(*Backend)
+
+func New(address string, options ...HAProxyOption) (*Backend, error) {
+	b := &Backend{
+		Name:         "haproxy",
+		Log:          logrus.NewEntry(logrus.StandardLogger()),
+		ServerPrefix: "creg-",
+	}
+
+	for _, option := range options {
+		option(b)
+	}
+
+	c, err := client.New(address, b.clientOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("could not create haproxy client: %w", err)
+	}
+	b.Client = c
+
+	return b, nil
+}
+
+func (b *Backend) Run(ctx context.Context, events chan ctypes.ContainerEventV2, purgeOnStart bool, containersToRefresh []ctypes.ContainerInfo) error {
+	var err error
+	if purgeOnStart {
+		err = b.Purge()
+		if err != nil {
+			return fmt.Errorf("could not purge: %w", err)
+		}
+	}
+
+	// Always refresh, this removes servers of containers which stopped while
+	// creg was not running
+	err = b.Refresh(containersToRefresh)
+	if err != nil {
+		return fmt.Errorf("could not refresh: %w", err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			b.Log.Infof("HAProxy exting: %s", "context cancelled")
+			return nil
+		case event := <-events:
+			b.Log.Debugf("handle event haproxy: %s", event.Action)
+
+			switch event.Action {
+			case "start":
+				servers, err := b.Servers(event.Container)
+				if err != nil {
+					b.Log.Errorf("Invalid haproxy labels on %s: %s", event.Container.ID, err)
+				}
+				if len(servers) == 0 {
+					continue
+				}
+
+				err = b.RegisterServers(ctx, servers)
+				if err != nil {
+					b.Log.Errorf("Could not RegisterServers: %s", err)
+					continue
+				}
+			case "stop":
+				// The ports of stopped containers are gone, only the names
+				// are needed to remove the servers
+				var servers []Server
+				for _, backend := range b.backendsFromLabels(event.Container.Labels) {
+					servers = append(servers, Server{Backend: backend, Name: b.ServerName(event.Container.ID)})
+				}
+				if len(servers) == 0 {
+					continue
+				}
+
+				err := b.DeregisterServers(ctx, servers)
+				if err != nil {
+					b.Log.Errorf("Could not DeregisterServers: %s", err)
+					continue
+				}
+			}
+		}
+	}
+}
+
+func (b *Backend) GetName() string {
+	return b.Name
+}
+
+// ServerName returns the name of the servers of the container with id.
+func (b *Backend) ServerName(id string) string {
+	if len(id) > 12 {
+		id = id[:12]
+	}
+	return b.ServerPrefix + id
+}
+
+// Owns reports whether the server with the given name is managed by this
+// instance. Container IDs never contain a dash, which keeps the servers of
+// instances with different IDs apart.
+func (b *Backend) Owns(name string) bool {
+	return strings.HasPrefix(name, b.ServerPrefix) && !strings.Contains(name[len(b.ServerPrefix):], "-")
+}
+
+func (b *Backend) backendsFromLabels(labels map[string]string) []string {
+	var names []string
+	for _, v := range strings.Split(labels[LabelBackend], ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			names = append(names, v)
+		}
+	}
+	return names
+}
+
+// Servers returns the servers of container, or nil if it has no
+// creg.haproxy.backend label.
+func (b *Backend) Servers(container ctypes.ContainerInfo) ([]Server, error) {
+	names := b.backendsFromLabels(container.Labels)
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	address, port, err := b.AddressStrategy.TCPEndpoint(container, container.Labels[LabelPort], b.ForwardAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	var servers []Server
+	for _, name := range names {
+		servers = append(servers, Server{
+			Backend: name,
+			Name:    b.ServerName(container.ID),
+			Address: address,
+			Port:    port,
+		})
+	}
+
+	return servers, nil
+}
+
+// RegisterServers adds the servers which do not exist yet, updates the address
+// of existing ones and sets all of them ready.
+func (b *Backend) RegisterServers(ctx context.Context, servers []Server) error {
+	existing, err := b.Client.Servers(ctx)
+	if err != nil {
+		return fmt.Errorf("could not list servers: %w", err)
+	}
+
+	present := map[string]haproxy.Server{}
+	for _, server := range existing {
+		present[server.Backend+"/"+server.Name] = server
+	}
+
+	var errs []error
+	for _, server := range servers {
+		var err error
+		current, ok := present[server.Backend+"/"+server.Name]
+		switch {
+		case !ok:
+			b.Log.Debugf("Add server: %s/%s %s:%s", server.Backend, server.Name, server.Address, server.Port)
+			err = b.Client.AddServer(ctx, server.Backend, server.Name, server.Address, server.Port)
+		case current.Address != server.Address || current.Port != server.Port:
+			b.Log.Debugf("Update server: %s/%s %s:%s", server.Backend, server.Name, server.Address, server.Port)
+			err = b.Client.SetServerAddress(ctx, server.Backend, server.Name, server.Address, server.Port)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not add %s/%s: %w", server.Backend, server.Name, err))
+			continue
+		}
+
+		err = b.Client.SetServerState(ctx, server.Backend, server.Name, haproxy.StateReady)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not enable %s/%s: %w", server.Backend, server.Name, err))
+		}
+	}
+
+	return backends.JoinErrors(errs)
+}
+
+// DeregisterServers puts the servers into maintenance and deletes them. A
+// server which still has connections stays disabled and is reused once the
+// container starts again.
+func (b *Backend) DeregisterServers(ctx context.Context, servers []Server) error {
+	var errs []error
+	for _, server := range servers {
+		b.Log.Debugf("Remove server: %s/%s", server.Backend, server.Name)
+		err := b.Client.SetServerState(ctx, server.Backend, server.Name, haproxy.StateMaint)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not disable %s/%s: %w", server.Backend, server.Name, err))
+			continue
+		}
+
+		err = b.Client.DeleteServer(ctx, server.Backend, server.Name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not delete %s/%s, it stays disabled: %w", server.Backend, server.Name, err))
+		}
+	}
+
+	return backends.JoinErrors(errs)
+}
+
+// sync removes owned servers which are not in wanted and registers wanted.
+func (b *Backend) sync(ctx context.Context, wanted []Server) error {
+	existing, err := b.Client.Servers(ctx)
+	if err != nil {
+		return fmt.Errorf("could not list servers: %w", err)
+	}
+
+	keep := map[string]struct{}{}
+	for _, server := range wanted {
+		keep[server.Backend+"/"+server.Name] = struct{}{}
+	}
+
+	var stale []Server
+	for _, server := range existing {
+		if _, ok := keep[server.Backend+"/"+server.Name]; !ok && b.Owns(server.Name) {
+			stale = append(stale, Server{Backend: server.Backend, Name: server.Name})
+		}
+	}
+
+	var errs []error
+	if len(stale) > 0 {
+		err = b.DeregisterServers(ctx, stale)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(wanted) > 0 {
+		err = b.RegisterServers(ctx, wanted)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return backends.JoinErrors(errs)
+}
+
+// Purge removes all servers owned by this instance from all backends.
+func (b *Backend) Purge() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	return b.sync(ctx, nil)
+}
+
+// Refresh registers the servers of containers and removes all other servers
+// owned by this instance.
+func (b *Backend) Refresh(containers []ctypes.ContainerInfo) error {
+	b.Log.Debugf("Refreshing %d haproxy containers", len(containers))
+
+	var wanted []Server
+	for _, container := range containers {
+		servers, err := b.Servers(container)
+		if err != nil {
+			b.Log.Errorf("Invalid haproxy labels on %s: %s", container.ID, err)
+		}
+		wanted = append(wanted, servers...)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	return b.sync(ctx, wanted)
+}
+
+func WithLogger(log *logrus.Entry) func(b *Backend) {
+	return func(b *Backend) {
+		b.Log = log.WithField("backend", "haproxy")
+	}
+}
+
+func WithForwardAddress(address string) func(b *Backend) {
+	return func(b *Backend) {
+		b.ForwardAddress = address
+	}
+}
+
+// WithID makes the servers of several creg instances sharing one HAProxy
+// distinguishable, each instance only manages servers with its own ID.
+func WithID(id string) func(b *Backend) {
+	return func(b *Backend) {
+		if id != "" {
+			b.ServerPrefix = "creg-" + id + "-"
+		}
+	}
+}
+
+func WithClientOptions(options ...client.ClientOption) func(b *Backend) {
+	return func(b *Backend) {
+		b.clientOptions = append(b.clientOptions, options...)
+	}
+}
+
+// WithName sets the backend name, see backends.Settings.
+func WithName(name string) func(b *Backend) {
+	return func(b *Backend) {
+		if name != "" {
+			b.Name = name
+		}
+	}
+}
+
+// WithAddressStrategy sets the address strategy, see backends.AddressStrategy.
+func WithAddressStrategy(strategy backends.AddressStrategy) func(b *Backend) {
+	return func(b *Backend) {
+		b.AddressStrategy = strategy
+	}
+}
@@ -0,0 +1,226 @@
+package haproxy_test
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/soupdiver/creg/backends/backendtest"
+	haproxybackend "github.com/soupdiver/creg/backends/haproxy"
+	ctypes "github.com/soupdiver/creg/types"
+)
+
+type fakeServer struct {
+	addr  string
+	port  string
+	maint bool
+}
+
+// fakeHAProxy implements the runtime API commands used by the backend
+type fakeHAProxy struct {
+	mtx      sync.Mutex
+	backends map[string]map[string]*fakeServer
+}
+
+func (f *fakeHAProxy) serve(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			line, err := bufio.NewReader(conn).ReadString('\n')
+			if err == nil {
+				io.WriteString(conn, f.handle(strings.TrimSpace(line))+"\n")
+			}
+			conn.Close()
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+func (f *fakeHAProxy) handle(command string) string {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	fields := strings.Fields(command)
+	lookup := func(v string) (map[string]*fakeServer, string, *fakeServer) {
+		backend, name, _ := strings.Cut(v, "/")
+		servers := f.backends[backend]
+		return servers, name, servers[name]
+	}
+
+	switch {
+	case command == "show servers state":
+		out := "1\n# be_id be_name srv_id srv_name srv_addr srv_op_state srv_admin_state ...\n"
+		for backend, servers := range f.backends {
+			for name, s := range servers {
+				admin := 0
+				if s.maint {
+					admin = 1
+				}
+				out += fmt.Sprintf("1 %s 1 %s %s 2 %d 1 1 0 1 0 3 7 0 0 0 - %s - 0 0 - - 0\n", backend, name, s.addr, admin, s.port)
+			}
+		}
+		return out
+	case len(fields) == 4 && fields[0] == "add" && fields[1] == "server":
+		servers, name, s := lookup(fields[2])
+		if servers == nil {
+			return "No such backend."
+		}
+		if s != nil {
+			return "Already exists a server with the same name in backend."
+		}
+		addr, port, _ := net.SplitHostPort(fields[3])
+		servers[name] = &fakeServer{addr: addr, port: port, maint: true}
+		return "New server registered."
+	case len(fields) == 5 && fields[0] == "set" && fields[3] == "state":
+		_, _, s := lookup(fields[2])
+		if s == nil {
+			return "No such server."
+		}
+		s.maint = fields[4] == "maint"
+		return ""
+	case len(fields) == 7 && fields[0] == "set" && fields[3] == "addr":
+		_, _, s := lookup(fields[2])
+		if s == nil {
+			return "No such server."
+		}
+		s.addr, s.port = fields[4], fields[6]
+		return "IP changed"
+	case len(fields) == 3 && fields[0] == "del":
+		servers, name, s := lookup(fields[2])
+		if s == nil {
+			return "No such server."
+		}
+		if !s.maint {
+			return "Only servers in maintenance mode can be deleted."
+		}
+		delete(servers, name)
+		return "Server deleted."
+	}
+
+	return "Unknown command."
+}
+
+// state returns "backend/server addr:port state" of all servers, sorted
+func (f *fakeHAProxy) state() []string {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	var state []string
+	for backend, servers := range f.backends {
+		for name, s := range servers {
+			st := "ready"
+			if s.maint {
+				st = "maint"
+			}
+			state = append(state, fmt.Sprintf("%s/%s %s:%s %s", backend, name, s.addr, s.port, st))
+		}
+	}
+	sort.Strings(state)
+
+	return state
+}
+
+func container(id, backend, hostPort string) ctypes.ContainerInfo {
+	return ctypes.ContainerInfo{
+		ID:     id,
+		Labels: map[string]string{haproxybackend.LabelBackend: backend},
+		NetworkSettings: ctypes.NetworkSettings{
+			Ports: map[ctypes.Port][]ctypes.PortBinding{"80/tcp": {{HostIP: "0.0.0.0", HostPort: hostPort}}},
+		},
+	}
+}
+
+// newFakeHAProxy returns HAProxy with a hand-made server, a server of a
+// previous run and a server of another creg instance.
+func newFakeHAProxy() *fakeHAProxy {
+	return &fakeHAProxy{backends: map[string]map[string]*fakeServer{
+		"web": {
+			"static":   {addr: "10.0.0.9", port: "80"},
+			"creg-old": {addr: "10.0.0.1", port: "9999"},
+		},
+		"api": {
+			"creg-other-aaaa": {addr: "10.0.0.2", port: "80"},
+		},
+	}}
+}
+
+func newTestBackend(t *testing.T, fake *fakeHAProxy) *haproxybackend.Backend {
+	b, err := haproxybackend.New(fake.serve(t),
+		haproxybackend.WithLogger(backendtest.Logger()),
+		haproxybackend.WithForwardAddress("10.0.0.1"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func TestRefreshReplacesServersAndReportsMissingBackends(t *testing.T) {
+	fake := newFakeHAProxy()
+	b := newTestBackend(t, fake)
+
+	err := b.Refresh([]ctypes.ContainerInfo{
+		container("0123456789abcdef", "web, api", "8080"),
+		container("fedcba9876543210", "missing", "8081"),
+	})
+	if err == nil {
+		t.Fatal("expected missing backend to fail")
+	}
+
+	backendtest.ExpectStrings(t, []string{
+		"api/creg-0123456789ab 10.0.0.1:8080 ready",
+		"api/creg-other-aaaa 10.0.0.2:80 ready",
+		"web/creg-0123456789ab 10.0.0.1:8080 ready",
+		"web/static 10.0.0.9:80 ready",
+	}, fake.state())
+}
+
+func TestRegisterReusesServerWithNewPort(t *testing.T) {
+	fake := newFakeHAProxy()
+	b := newTestBackend(t, fake)
+
+	backendtest.Refresh(t, b, container("0123456789abcdef", "web", "8080"))
+
+	// A restarted container reuses its server with the new port
+	err := b.RegisterServers(context.Background(), []haproxybackend.Server{{Backend: "web", Name: "creg-0123456789ab", Address: "10.0.0.1", Port: "8082"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	backendtest.ExpectStrings(t, []string{
+		"api/creg-other-aaaa 10.0.0.2:80 ready",
+		"web/creg-0123456789ab 10.0.0.1:8082 ready",
+		"web/static 10.0.0.9:80 ready",
+	}, fake.state())
+}
+
+func TestPurgeKeepsForeignServers(t *testing.T) {
+	fake := newFakeHAProxy()
+	b := newTestBackend(t, fake)
+
+	backendtest.Refresh(t, b, container("0123456789abcdef", "web, api", "8080"))
+	backendtest.Purge(t, b)
+
+	backendtest.ExpectStrings(t, []string{
+		"api/creg-other-aaaa 10.0.0.2:80 ready",
+		"web/static 10.0.0.9:80 ready",
+	}, fake.state())
+}
@@ -0,0 +1,36 @@
+package hosts
+
+import (
+	"github.com/soupdiver/creg/backends"
+)
+
+// BackendConfig configures a hosts backend in the config file.
+type BackendConfig struct {
+	// Path of the hosts file to maintain a creg block in
+	Path string `yaml:"path"`
+	// Services adds service names, with Domain appended
+	Services bool   `yaml:"services"`
+	Domain   string `yaml:"domain"`
+}
+
+func init() {
+	backends.Register("hosts", backends.Factory{
+		Config: func() interface{} { return &BackendConfig{} },
+		New: func(config interface{}, settings backends.Settings) (backends.Backend, error) {
+			cfg := config.(*BackendConfig)
+
+			options := []HostsOption{
+				WithName(settings.Name),
+				WithLogger(settings.Log),
+				WithID(settings.ID),
+				WithForwardAddress(settings.ForwardAddress),
+				WithAddressStrategy(settings.AddressStrategy),
+			}
+			if cfg.Services {
+				options = append(options, WithServiceNames(cfg.Domain))
+			}
+
+			return New(cfg.Path, options...)
+		},
+	})
+}
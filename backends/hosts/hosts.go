@@ -0,0 +1,357 @@
+package hosts
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/soupdiver/creg/backends"
+	ctypes "github.com/soupdiver/creg/types"
+)
+
+// Backend maintains a block of host entries delimited by marker comments in a
+// hosts file, like /etc/hosts or a file for dnsmasq's addn-hosts. Names come
+// from the creg.dns label and, if enabled, from the service names. Lines
+// outside the block are never changed.
+type Backend struct {
+	ID              string
+	Name            string
+	Log             *logrus.Entry
+	Path            string
+	ForwardAddress  string
+	AddressStrategy backends.AddressStrategy
+	// ServiceNames adds an entry for every service of a container, with
+	// Domain appended if set
+	ServiceNames bool
+	Domain       string
+
+	// entries holds the entries of each container by container ID
+	entries    map[string][]backends.DNSEntry
+	entriesMtx sync.Mutex
+	// writeMtx serializes read-modify-write cycles of the file
+	writeMtx sync.Mutex
+}
+
+type HostsOption func(*Backend)
+
+func New(path string, options ...HostsOption) (*Backend, error) {
+	b := &Backend{
+		Name:    "hosts",
+		Log:     logrus.NewEntry(logrus.StandardLogger()),
+		Path:    path,
+		entries: map[string][]backends.DNSEntry{},
+	}
+
+	for _, option := range options {
+		option(b)
+	}
+
+	return b, nil
+}
+
+func (b *Backend) Run(ctx context.Context, events chan ctypes.ContainerEventV2, purgeOnStart bool, containersToRefresh []ctypes.ContainerInfo) error {
+	var err error
+	if purgeOnStart {
+		err = b.Purge()
+		if err != nil {
+			b.Log.Errorf("Could not Purge: %s", err)
+		}
+	}
+
+	// Always refresh, this removes entries of containers which stopped while
+	// creg was not running
+	err = b.Refresh(containersToRefresh)
+	if err != nil {
+		// Every event writes again, a failed write is not fatal
+		b.Log.Errorf("Could not Refresh: %s", err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			b.Log.Infof("Hosts exting: %s", "context cancelled")
+			return nil
+		case event := <-events:
+			b.entriesMtx.Lock()
+			switch event.Action {
+			case "start":
+				b.setContainer(event.Container)
+			case "stop":
+				delete(b.entries, event.Container.ID)
+			default:
+				b.entriesMtx.Unlock()
+				continue
+			}
+			b.entriesMtx.Unlock()
+
+			err := b.Write()
+			if err != nil {
+				b.Log.Errorf("Could not Write: %s", err)
+				continue
+			}
+		}
+	}
+}
+
+func (b *Backend) GetName() string {
+	return b.Name
+}
+
+// Entries returns the host entries of container. Wildcards and answers which
+// are not IP addresses cannot be expressed in a hosts file and are skipped.
+func (b *Backend) Entries(container ctypes.ContainerInfo) ([]backends.DNSEntry, error) {
+	var entries []backends.DNSEntry
+	var errs []error
+
+	if v, ok := container.Labels[backends.LabelDNS]; ok {
+		parsed, err := backends.ParseDNSLabel(v, b.AddressStrategy.Address(container, b.ForwardAddress))
+		if err != nil {
+			errs = append(errs, err)
+		}
+
+		for _, entry := range parsed {
+			if backends.IsWildcard(entry.Domain) || net.ParseIP(entry.Answer) == nil {
+				errs = append(errs, fmt.Errorf("not supported in hosts files: %s -> %s", entry.Domain, entry.Answer))
+				continue
+			}
+			entries = append(entries, backends.DNSEntry{Domain: strings.TrimSuffix(entry.Domain, "."), Answer: entry.Answer})
+		}
+	}
+
+	if b.ServiceNames {
+		for _, service := range backends.ServicesForContainer(container, b.AddressStrategy, b.ForwardAddress, nil, nil) {
+			// No strategy yields an address and there is no forward address
+			if service.Address == "" {
+				continue
+			}
+			if net.ParseIP(service.Address) == nil {
+				errs = append(errs, fmt.Errorf("not supported in hosts files: %s -> %s", service.Name, service.Address))
+				continue
+			}
+
+			domain := service.Name
+			if b.Domain != "" {
+				domain += "." + strings.Trim(b.Domain, ".")
+			}
+			if !backends.ValidDomain(domain) {
+				errs = append(errs, fmt.Errorf("invalid service name: %q", domain))
+				continue
+			}
+			entries = append(entries, backends.DNSEntry{Domain: domain, Answer: service.Address})
+		}
+	}
+
+	return entries, backends.JoinErrors(errs)
+}
+
+// setContainer must be called with entriesMtx held.
+func (b *Backend) setContainer(container ctypes.ContainerInfo) {
+	entries, err := b.Entries(container)
+	if err != nil {
+		b.Log.Errorf("Invalid hosts entries on %s: %s", container.ID, err)
+	}
+
+	if len(entries) == 0 {
+		delete(b.entries, container.ID)
+		return
+	}
+	b.entries[container.ID] = entries
+}
+
+func (b *Backend) markers() (string, string) {
+	if b.ID != "" {
+		return "# BEGIN creg " + b.ID, "# END creg " + b.ID
+	}
+	return "# BEGIN creg", "# END creg"
+}
+
+// Block returns the managed block with one line per address, or nil if there
+// are no entries.
+func (b *Backend) Block() []byte {
+	b.entriesMtx.Lock()
+	names := map[string]map[string]struct{}{}
+	for _, entries := range b.entries {
+		for _, entry := range entries {
+			if names[entry.Answer] == nil {
+				names[entry.Answer] = map[string]struct{}{}
+			}
+			names[entry.Answer][entry.Domain] = struct{}{}
+		}
+	}
+	b.entriesMtx.Unlock()
+
+	if len(names) == 0 {
+		return nil
+	}
+
+	var lines []string
+	for address, domains := range names {
+		var sorted []string
+		for domain := range domains {
+			sorted = append(sorted, domain)
+		}
+		sort.Strings(sorted)
+		lines = append(lines, address+"\t"+strings.Join(sorted, " "))
+	}
+	sort.Strings(lines)
+
+	begin, end := b.markers()
+	return []byte(begin + "\n" + strings.Join(lines, "\n") + "\n" + end + "\n")
+}
+
+// Replace returns content with the managed block replaced by block. The block
+// is appended if content has none, an empty block removes it.
+func (b *Backend) Replace(content, block []byte) ([]byte, error) {
+	begin, end := b.markers()
+
+	var out bytes.Buffer
+	inBlock, found := false, false
+	for _, line := range strings.SplitAfter(string(content), "\n") {
+		if line == "" {
+			continue
+		}
+
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == begin:
+			if inBlock || found {
+				return nil, fmt.Errorf("duplicate %q marker", begin)
+			}
+			inBlock, found = true, true
+			out.Write(block)
+		case trimmed == end:
+			if !inBlock {
+				return nil, fmt.Errorf("%q marker without %q", end, begin)
+			}
+			inBlock = false
+		case !inBlock:
+			out.WriteString(line)
+			if !strings.HasSuffix(line, "\n") {
+				out.WriteString("\n")
+			}
+		}
+	}
+	if inBlock {
+		return nil, fmt.Errorf("%q marker without %q", begin, end)
+	}
+
+	if !found {
+		out.Write(block)
+	}
+
+	return out.Bytes(), nil
+}
+
+// Write replaces the managed block in the file. The file is replaced
+// atomically, a bind mounted file like /etc/hosts inside a container cannot
+// be renamed over and is rewritten in place instead.
+func (b *Backend) Write() error {
+	b.writeMtx.Lock()
+	defer b.writeMtx.Unlock()
+
+	perm := os.FileMode(0o644)
+	content, err := os.ReadFile(b.Path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not read %s: %w", b.Path, err)
+	}
+	if info, err := os.Stat(b.Path); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	updated, err := b.Replace(content, b.Block())
+	if err != nil {
+		return fmt.Errorf("could not update %s: %w", b.Path, err)
+	}
+
+	changed, err := backends.WriteFileAtomic(b.Path, updated, perm)
+	if errors.Is(err, syscall.EBUSY) || errors.Is(err, syscall.EXDEV) {
+		b.Log.Debugf("Could not replace %s, rewrite in place: %s", b.Path, err)
+		changed, err = true, os.WriteFile(b.Path, updated, perm)
+	}
+	if err != nil {
+		return fmt.Errorf("could not write %s: %w", b.Path, err)
+	}
+	if changed {
+		b.Log.Debugf("Wrote %s", b.Path)
+	}
+
+	return nil
+}
+
+// Purge removes the managed block from the file.
+func (b *Backend) Purge() error {
+	b.entriesMtx.Lock()
+	b.entries = map[string][]backends.DNSEntry{}
+	b.entriesMtx.Unlock()
+
+	return b.Write()
+}
+
+// Refresh replaces the entries with the ones of containers and writes the
+// file.
+func (b *Backend) Refresh(containers []ctypes.ContainerInfo) error {
+	b.Log.Debugf("Refreshing %d hosts containers", len(containers))
+
+	b.entriesMtx.Lock()
+	b.entries = map[string][]backends.DNSEntry{}
+	for _, container := range containers {
+		b.setContainer(container)
+	}
+	b.entriesMtx.Unlock()
+
+	return b.Write()
+}
+
+func WithLogger(log *logrus.Entry) func(b *Backend) {
+	return func(b *Backend) {
+		b.Log = log.WithField("backend", "hosts")
+	}
+}
+
+func WithForwardAddress(address string) func(b *Backend) {
+	return func(b *Backend) {
+		b.ForwardAddress = address
+	}
+}
+
+// WithID makes the blocks of several creg instances sharing one file
+// distinguishable.
+func WithID(id string) func(b *Backend) {
+	return func(b *Backend) {
+		b.ID = id
+	}
+}
+
+// WithServiceNames adds an entry for every service name, with domain
+// appended if it is not empty.
+func WithServiceNames(domain string) func(b *Backend) {
+	return func(b *Backend) {
+		b.ServiceNames = true
+		b.Domain = domain
+	}
+}
+
+// WithName sets the backend name, see backends.Settings.
+func WithName(name string) func(b *Backend) {
+	return func(b *Backend) {
+		if name != "" {
+			b.Name = name
+		}
+	}
+}
+
+// WithAddressStrategy sets the address strategy, see backends.AddressStrategy.
+func WithAddressStrategy(strategy backends.AddressStrategy) func(b *Backend) {
+	return func(b *Backend) {
+		b.AddressStrategy = strategy
+	}
+}
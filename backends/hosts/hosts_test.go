@@ -0,0 +1,196 @@
+package hosts_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/soupdiver/creg/backends"
+	"github.com/soupdiver/creg/backends/backendtest"
+	"github.com/soupdiver/creg/backends/hosts"
+	ctypes "github.com/soupdiver/creg/types"
+)
+
+const original = `127.0.0.1	localhost
+::1	localhost ip6-localhost
+`
+
+func newTestBackend(t *testing.T, content string, options ...hosts.HostsOption) (*hosts.Backend, string) {
+	path := filepath.Join(t.TempDir(), "hosts")
+	err := os.WriteFile(path, []byte(content), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options = append([]hosts.HostsOption{
+		hosts.WithLogger(backendtest.Logger()),
+		hosts.WithForwardAddress("10.0.0.1"),
+	}, options...)
+
+	b, err := hosts.New(path, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b, path
+}
+
+func read(t *testing.T, path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+var testContainers = []ctypes.ContainerInfo{
+	{
+		ID: "app",
+		Labels: map[string]string{
+			"creg.dns":  "app.lan; db.lan,10.0.0.2; *.app.lan; other.lan,other.example.org",
+			"creg.port": "80/tcp:web",
+		},
+		NetworkSettings: ctypes.NetworkSettings{
+			Ports: map[ctypes.Port][]ctypes.PortBinding{"80/tcp": {{HostIP: "0.0.0.0", HostPort: "8080"}}},
+		},
+	},
+	{ID: "api", Labels: map[string]string{"creg.dns": "api.lan"}},
+}
+
+func TestRefreshWritesBlockAndKeepsFileMode(t *testing.T) {
+	b, path := newTestBackend(t, original, hosts.WithServiceNames("creg.local"))
+
+	backendtest.Refresh(t, b, testContainers...)
+
+	expected := original + `# BEGIN creg
+10.0.0.1	api.lan app.lan web.creg.local
+10.0.0.2	db.lan
+# END creg
+`
+	if content := read(t, path); content != expected {
+		t.Fatalf("expected %q, got %q", expected, content)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected mode %o, got %o", 0o600, info.Mode().Perm())
+	}
+}
+
+func TestPurgeRestoresOriginalContent(t *testing.T) {
+	b, path := newTestBackend(t, original, hosts.WithServiceNames("creg.local"))
+
+	backendtest.Refresh(t, b, testContainers...)
+	backendtest.Purge(t, b)
+
+	if content := read(t, path); content != original {
+		t.Fatalf("expected %q, got %q", original, content)
+	}
+}
+
+func TestServiceNamesWithoutForwardAddress(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts")
+	b, err := hosts.New(path,
+		hosts.WithLogger(backendtest.Logger()),
+		hosts.WithAddressStrategy(backends.AddressStrategy{Mode: backends.AddressNetwork}),
+		hosts.WithServiceNames(""),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := b.Entries(ctypes.ContainerInfo{
+		ID:     "web",
+		Labels: map[string]string{"creg.port": "80/tcp:web"},
+		NetworkSettings: ctypes.NetworkSettings{
+			Networks: map[string]ctypes.Network{"backend": {IPAddress: "172.18.0.2"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Domain != "web" || entries[0].Answer != "172.18.0.2" {
+		t.Fatalf("expected web -> 172.18.0.2, got %+v", entries)
+	}
+
+	// Host names can not be written to hosts files
+	entries, err = b.Entries(ctypes.ContainerInfo{
+		ID:     "api",
+		Labels: map[string]string{"creg.port": "80/tcp:api", backends.LabelAddress: "api.example.org"},
+	})
+	if err == nil {
+		t.Fatal("expected host name address to fail")
+	}
+	if len(entries) != 0 {
+		t.Fatalf("expected no entries, got %+v", entries)
+	}
+}
+
+func TestReplaceExistingBlock(t *testing.T) {
+	content := "127.0.0.1 localhost\n# BEGIN creg\n10.0.0.9 old.lan\n# END creg\n192.168.0.1 router"
+	b, path := newTestBackend(t, content)
+
+	err := b.Refresh(testContainers[1:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "127.0.0.1 localhost\n# BEGIN creg\n10.0.0.1\tapi.lan\n# END creg\n192.168.0.1 router\n"
+	if content := read(t, path); content != expected {
+		t.Fatalf("expected %q, got %q", expected, content)
+	}
+}
+
+func TestBrokenMarkers(t *testing.T) {
+	b, path := newTestBackend(t, "127.0.0.1 localhost\n# BEGIN creg\n10.0.0.9 old.lan\n")
+
+	err := b.Refresh(testContainers)
+	if err == nil {
+		t.Fatal("expected unterminated block to fail")
+	}
+
+	if content := read(t, path); content != "127.0.0.1 localhost\n# BEGIN creg\n10.0.0.9 old.lan\n" {
+		t.Fatalf("expected file to be unchanged, got %q", content)
+	}
+}
+
+func TestRunContinuesAfterFailedRefresh(t *testing.T) {
+	b, path := newTestBackend(t, "127.0.0.1 localhost\n# BEGIN creg\n")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan ctypes.ContainerEventV2)
+	done := make(chan error)
+	go func() {
+		done <- b.Run(ctx, events, false, testContainers[1:])
+	}()
+
+	// Events are only received once the refresh failed
+	select {
+	case events <- ctypes.ContainerEventV2{Action: "start", Container: testContainers[1]}:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not handle events after a failed refresh")
+	}
+
+	// The next event writes the file once the markers are repaired
+	err := os.WriteFile(path, []byte(original), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events <- ctypes.ContainerEventV2{Action: "start", Container: testContainers[1]}
+
+	cancel()
+	err = <-done
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := original + "# BEGIN creg\n10.0.0.1\tapi.lan\n# END creg\n"
+	if content := read(t, path); content != expected {
+		t.Fatalf("expected %q, got %q", expected, content)
+	}
+}
@@ -0,0 +1,34 @@
+package mdns
+
+import (
+	"github.com/soupdiver/creg/backends"
+)
+
+// BackendConfig configures an mdns backend in the config file.
+type BackendConfig struct {
+	// Host name in .local the advertised services point to
+	Host string `yaml:"host"`
+	// Interface to advertise on, all if empty
+	Interface string `yaml:"interface"`
+}
+
+func init() {
+	backends.Register("mdns", backends.Factory{
+		Config: func() interface{} {
+			return &BackendConfig{Host: "creg"}
+		},
+		New: func(config interface{}, settings backends.Settings) (backends.Backend, error) {
+			cfg := config.(*BackendConfig)
+
+			return New(
+				WithName(settings.Name),
+				WithLogger(settings.Log),
+				WithForwardAddress(settings.ForwardAddress),
+				WithAddressStrategy(settings.AddressStrategy),
+				WithStaticLabels(settings.StaticLabels),
+				WithHost(cfg.Host),
+				WithInterface(cfg.Interface),
+			)
+		},
+	})
+}
@@ -0,0 +1,789 @@
+package mdns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/ipv4"
+
+	"github.com/soupdiver/creg/backends"
+	ctypes "github.com/soupdiver/creg/types"
+)
+
+const (
+	// LabelType is the DNS-SD service type of all services of a container,
+	// e.g. _http._tcp. Only containers with the label are advertised.
+	// LabelType.<service> sets the type of a single service.
+	LabelType = "creg.mdns.type"
+	// LabelName sets the instance name, it defaults to the service name
+	LabelName = "creg.mdns.name"
+	// LabelTXTPrefix prefixes labels added as TXT key=value pairs,
+	// creg.mdns.txt.path=/admin adds path=/admin
+	LabelTXTPrefix = "creg.mdns.txt."
+
+	domain       = "local."
+	servicesName = "_services._dns-sd._udp.local."
+
+	// TTLs recommended by RFC 6762, 10.
+	hostTTL  = 120
+	otherTTL = 4500
+	// cacheFlush marks records this responder is authoritative for alone
+	cacheFlush = 1 << 15
+)
+
+var (
+	// Group is the IPv4 mDNS multicast group.
+	Group = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: 5353}
+
+	typeRegexp = regexp.MustCompile(`^_[a-zA-Z0-9-]{1,15}\._(tcp|udp)$`)
+)
+
+// Entry is an advertised DNS-SD service instance.
+type Entry struct {
+	Instance string
+	Type     string
+	// Address the service is reached at, the forward address if nil
+	Address net.IP
+	Port    int
+	TXT     []string
+}
+
+// Name returns the fully qualified service instance name.
+func (e Entry) Name() string {
+	return escape(e.Instance) + "." + e.Type + "." + domain
+}
+
+// escape escapes an instance name, which may contain dots and spaces, for
+// use as a single label.
+func escape(instance string) string {
+	var sb strings.Builder
+	for _, r := range instance {
+		switch r {
+		case '.', '\\', ' ', '(', ')', ';', '"', '@', '$':
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// Backend advertises services via multicast DNS and DNS-SD. It answers
+// queries for the service types, instances and the host name Host, which
+// resolves to ForwardAddress, and announces instances when containers start.
+// When containers stop, and when creg exits, goodbye packets with a TTL of 0
+// remove the instances from the caches of other hosts. Only IPv4 is
+// supported and name conflicts are not probed for.
+//
+// SRV records point to Host, unless the address strategy yields another
+// address than ForwardAddress. Such addresses get a host name of their own,
+// Host with the address appended, e.g. creg-172-18-0-2.local.
+type Backend struct {
+	Name            string
+	Log             *logrus.Entry
+	ForwardAddress  string
+	AddressStrategy backends.AddressStrategy
+	StaticLabels    []string
+	// Host is the host name SRV records point to
+	Host string
+	// Interface is the network interface to join the multicast group on,
+	// all interfaces if empty
+	Interface string
+
+	// Conn receives queries and sends responses, GroupAddr is where
+	// multicast responses are sent to
+	Conn      net.PacketConn
+	GroupAddr net.Addr
+
+	// entries holds the entries of each container by container ID
+	entries    map[string][]Entry
+	entriesMtx sync.Mutex
+
+	ip net.IP
+}
+
+type MDNSOption func(*Backend)
+
+func New(options ...MDNSOption) (*Backend, error) {
+	b := &Backend{
+		Name:      "mdns",
+		Log:       logrus.NewEntry(logrus.StandardLogger()),
+		Host:      "creg",
+		GroupAddr: Group,
+		entries:   map[string][]Entry{},
+	}
+
+	for _, option := range options {
+		option(b)
+	}
+
+	b.ip = net.ParseIP(b.ForwardAddress).To4()
+	if b.ip == nil {
+		return nil, fmt.Errorf("forward address must be an IPv4 address: %q", b.ForwardAddress)
+	}
+
+	b.Host = dns.Fqdn(strings.TrimSuffix(strings.TrimSuffix(b.Host, "."), ".local") + ".local")
+	if _, ok := dns.IsDomainName(b.Host); !ok || dns.CountLabel(b.Host) != 2 {
+		return nil, fmt.Errorf("invalid host name: %q", b.Host)
+	}
+
+	return b, nil
+}
+
+func (b *Backend) Run(ctx context.Context, events chan ctypes.ContainerEventV2, purgeOnStart bool, containersToRefresh []ctypes.ContainerInfo) error {
+	if b.Conn == nil {
+		err := b.Listen()
+		if err != nil {
+			return fmt.Errorf("could not listen: %w", err)
+		}
+	}
+	defer b.Conn.Close()
+
+	go b.serve()
+
+	// Nothing outlives creg, there is nothing to purge on start
+	err := b.Refresh(containersToRefresh)
+	if err != nil {
+		return fmt.Errorf("could not refresh: %w", err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			b.Log.Infof("MDNS exting: %s", "context cancelled")
+			err := b.Purge()
+			if err != nil {
+				b.Log.Errorf("Could not Purge: %s", err)
+			}
+			return nil
+		case event := <-events:
+			b.Log.Debugf("handle event mdns: %s", event.Action)
+
+			switch event.Action {
+			case "start":
+				err := b.SetContainer(event.Container)
+				if err != nil {
+					b.Log.Errorf("Could not SetContainer: %s", err)
+				}
+			case "stop":
+				err := b.RemoveContainer(event.Container.ID)
+				if err != nil {
+					b.Log.Errorf("Could not RemoveContainer: %s", err)
+				}
+			}
+		}
+	}
+}
+
+func (b *Backend) GetName() string {
+	return b.Name
+}
+
+// Listen joins the mDNS multicast group on Interface.
+func (b *Backend) Listen() error {
+	var iface *net.Interface
+	if b.Interface != "" {
+		var err error
+		iface, err = net.InterfaceByName(b.Interface)
+		if err != nil {
+			return err
+		}
+	}
+
+	conn, err := net.ListenMulticastUDP("udp4", iface, Group)
+	if err != nil {
+		return err
+	}
+
+	// RFC 6762, 11: multicast packets are sent with a TTL of 255
+	pc := ipv4.NewPacketConn(conn)
+	if err := pc.SetMulticastTTL(255); err != nil {
+		b.Log.Debugf("Could not set multicast TTL: %s", err)
+	}
+	if iface != nil {
+		if err := pc.SetMulticastInterface(iface); err != nil {
+			b.Log.Debugf("Could not set multicast interface: %s", err)
+		}
+	}
+
+	b.Conn = conn
+	return nil
+}
+
+// Entries returns an entry for every service of container with a service
+// type.
+func (b *Backend) Entries(container ctypes.ContainerInfo) ([]Entry, error) {
+	var entries []Entry
+	var errs []error
+	for _, service := range backends.ServicesForContainer(container, b.AddressStrategy, b.ForwardAddress, b.StaticLabels, nil) {
+		serviceType, ok := container.Labels[LabelType+"."+service.Name]
+		if !ok {
+			serviceType, ok = container.Labels[LabelType]
+		}
+		if !ok {
+			continue
+		}
+		serviceType = strings.TrimSuffix(strings.TrimSuffix(serviceType, "."), ".local")
+		if !typeRegexp.MatchString(serviceType) {
+			errs = append(errs, fmt.Errorf("invalid service type of %s: %q", service.Name, serviceType))
+			continue
+		}
+
+		instance := service.Name
+		if v := container.Labels[LabelName]; v != "" {
+			instance = v
+		}
+
+		address := net.ParseIP(service.Address).To4()
+		if address == nil {
+			errs = append(errs, fmt.Errorf("address of %s is not an IPv4 address: %q", service.Name, service.Address))
+			continue
+		}
+		if address.Equal(b.ip) {
+			address = nil
+		}
+
+		entries = append(entries, Entry{
+			Instance: instance,
+			Type:     strings.ToLower(serviceType),
+			Address:  address,
+			Port:     service.Port,
+			TXT:      txt(container.Labels, service.Tags),
+		})
+	}
+
+	return entries, backends.JoinErrors(errs)
+}
+
+// txt returns the sorted key=value pairs of tags and TXT labels.
+func txt(labels map[string]string, tags []string) []string {
+	pairs := map[string]string{}
+	for _, tag := range tags {
+		k, v, _ := strings.Cut(tag, "=")
+		if k != "" {
+			pairs[k] = v
+		}
+	}
+	for k, v := range labels {
+		if key := strings.TrimPrefix(k, LabelTXTPrefix); key != k && key != "" {
+			pairs[key] = v
+		}
+	}
+
+	// RFC 6763, 6.1: a TXT record without pairs holds one empty string
+	if len(pairs) == 0 {
+		return []string{""}
+	}
+
+	var txt []string
+	for k, v := range pairs {
+		txt = append(txt, k+"="+v)
+	}
+	sort.Strings(txt)
+	return txt
+}
+
+// SetContainer advertises the services of container, replacing the ones it
+// had before. Instance names already taken by another container get the
+// container ID appended.
+func (b *Backend) SetContainer(container ctypes.ContainerInfo) error {
+	entries, err := b.Entries(container)
+
+	id := container.ID
+	if len(id) > 12 {
+		id = id[:12]
+	}
+
+	b.entriesMtx.Lock()
+	old := b.entries[container.ID]
+	delete(b.entries, container.ID)
+
+	taken := map[string]struct{}{}
+	for _, other := range b.entries {
+		for _, entry := range other {
+			taken[strings.ToLower(entry.Name())] = struct{}{}
+		}
+	}
+	for i := range entries {
+		if _, ok := taken[strings.ToLower(entries[i].Name())]; ok {
+			entries[i].Instance += "-" + id
+		}
+		taken[strings.ToLower(entries[i].Name())] = struct{}{}
+	}
+	if len(entries) > 0 {
+		b.entries[container.ID] = entries
+	}
+	b.entriesMtx.Unlock()
+
+	var gone []Entry
+	for _, entry := range old {
+		if !containsEntry(entries, entry) {
+			gone = append(gone, entry)
+		}
+	}
+
+	var errs []error
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if len(gone) > 0 {
+		err := b.goodbye(gone)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(entries) > 0 {
+		err := b.announce(entries)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return backends.JoinErrors(errs)
+}
+
+// RemoveContainer stops advertising the services of the container with id
+// and sends goodbye packets for them.
+func (b *Backend) RemoveContainer(id string) error {
+	b.entriesMtx.Lock()
+	entries := b.entries[id]
+	delete(b.entries, id)
+	b.entriesMtx.Unlock()
+
+	if len(entries) == 0 {
+		return nil
+	}
+
+	return b.goodbye(entries)
+}
+
+// Purge sends goodbye packets for all advertised services and forgets them.
+func (b *Backend) Purge() error {
+	b.entriesMtx.Lock()
+	var entries []Entry
+	for _, v := range b.entries {
+		entries = append(entries, v...)
+	}
+	b.entries = map[string][]Entry{}
+	b.entriesMtx.Unlock()
+
+	if len(entries) == 0 || b.Conn == nil {
+		return nil
+	}
+
+	return b.goodbye(entries)
+}
+
+// Refresh advertises the services of containers and sends goodbye packets
+// for all others.
+func (b *Backend) Refresh(containers []ctypes.ContainerInfo) error {
+	b.Log.Debugf("Refreshing %d mdns containers", len(containers))
+
+	wanted := map[string]struct{}{}
+	var errs []error
+	for _, container := range containers {
+		wanted[container.ID] = struct{}{}
+		err := b.SetContainer(container)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	b.entriesMtx.Lock()
+	var stale []string
+	for id := range b.entries {
+		if _, ok := wanted[id]; !ok {
+			stale = append(stale, id)
+		}
+	}
+	b.entriesMtx.Unlock()
+
+	for _, id := range stale {
+		err := b.RemoveContainer(id)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return backends.JoinErrors(errs)
+}
+
+func containsEntry(entries []Entry, entry Entry) bool {
+	for _, e := range entries {
+		if strings.EqualFold(e.Name(), entry.Name()) {
+			return true
+		}
+	}
+	return false
+}
+
+// advertised returns the entries which are still advertised.
+func (b *Backend) advertised(entries []Entry) []Entry {
+	b.entriesMtx.Lock()
+	defer b.entriesMtx.Unlock()
+
+	var current []Entry
+	for _, v := range b.entries {
+		current = append(current, v...)
+	}
+
+	var advertised []Entry
+	for _, entry := range entries {
+		if containsEntry(current, entry) {
+			advertised = append(advertised, entry)
+		}
+	}
+	return advertised
+}
+
+// target returns the host name the SRV record of entry points to.
+func (b *Backend) target(entry Entry) string {
+	if entry.Address == nil {
+		return b.Host
+	}
+
+	host := strings.TrimSuffix(b.Host, "."+domain)
+	return host + "-" + strings.ReplaceAll(entry.Address.String(), ".", "-") + "." + domain
+}
+
+// hostRecords returns the A records of Host and of the addresses of
+// entries.
+func (b *Backend) hostRecords(entries []Entry, ttl uint32) []dns.RR {
+	records := []dns.RR{&dns.A{
+		Hdr: dns.RR_Header{Name: b.Host, Rrtype: dns.TypeA, Class: dns.ClassINET | cacheFlush, Ttl: ttl},
+		A:   b.ip,
+	}}
+
+	seen := map[string]struct{}{}
+	for _, entry := range entries {
+		name := b.target(entry)
+		if _, ok := seen[name]; ok || entry.Address == nil {
+			continue
+		}
+		seen[name] = struct{}{}
+
+		records = append(records, &dns.A{
+			Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET | cacheFlush, Ttl: ttl},
+			A:   entry.Address,
+		})
+	}
+
+	return records
+}
+
+// entryRecords returns the PTR, SRV and TXT records of entry. ttl overrides
+// the default TTLs if it is not nil.
+func (b *Backend) entryRecords(entry Entry, ttl *uint32) []dns.RR {
+	hostTTL, otherTTL := uint32(hostTTL), uint32(otherTTL)
+	if ttl != nil {
+		hostTTL, otherTTL = *ttl, *ttl
+	}
+
+	serviceType := entry.Type + "." + domain
+	return []dns.RR{
+		&dns.PTR{
+			Hdr: dns.RR_Header{Name: servicesName, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: otherTTL},
+			Ptr: serviceType,
+		},
+		&dns.PTR{
+			Hdr: dns.RR_Header{Name: serviceType, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: otherTTL},
+			Ptr: entry.Name(),
+		},
+		&dns.SRV{
+			Hdr:    dns.RR_Header{Name: entry.Name(), Rrtype: dns.TypeSRV, Class: dns.ClassINET | cacheFlush, Ttl: hostTTL},
+			Port:   uint16(entry.Port),
+			Target: b.target(entry),
+		},
+		&dns.TXT{
+			Hdr: dns.RR_Header{Name: entry.Name(), Rrtype: dns.TypeTXT, Class: dns.ClassINET | cacheFlush, Ttl: otherTTL},
+			Txt: entry.TXT,
+		},
+	}
+}
+
+// records returns the records of all advertised entries and the host.
+func (b *Backend) records() []dns.RR {
+	b.entriesMtx.Lock()
+	defer b.entriesMtx.Unlock()
+
+	if len(b.entries) == 0 {
+		return nil
+	}
+
+	var all []Entry
+	for _, entries := range b.entries {
+		all = append(all, entries...)
+	}
+
+	records := b.hostRecords(all, hostTTL)
+	seen := map[string]struct{}{}
+	for _, entries := range b.entries {
+		for _, entry := range entries {
+			for _, rr := range b.entryRecords(entry, nil) {
+				// Types shared by several entries are only added once
+				if _, ok := seen[rr.String()]; ok {
+					continue
+				}
+				seen[rr.String()] = struct{}{}
+				records = append(records, rr)
+			}
+		}
+	}
+
+	return records
+}
+
+func (b *Backend) send(m *dns.Msg, addr net.Addr) error {
+	data, err := m.Pack()
+	if err != nil {
+		return fmt.Errorf("could not pack message: %w", err)
+	}
+
+	_, err = b.Conn.WriteTo(data, addr)
+	return err
+}
+
+func (b *Backend) multicast(records []dns.RR) error {
+	m := new(dns.Msg)
+	m.Response = true
+	m.Authoritative = true
+	m.Answer = records
+
+	err := b.send(m, b.GroupAddr)
+	if err != nil {
+		return fmt.Errorf("could not send to %s: %w", b.GroupAddr, err)
+	}
+	return nil
+}
+
+// announce multicasts the records of entries, a second time after a second
+// as required by RFC 6762, 8.3.
+func (b *Backend) announce(entries []Entry) error {
+	time.AfterFunc(time.Second, func() {
+		// Entries removed in the meantime must not be announced again
+		entries := b.advertised(entries)
+		if len(entries) == 0 {
+			return
+		}
+		err := b.multicast(b.announcement(entries))
+		if err != nil && !errors.Is(err, net.ErrClosed) {
+			b.Log.Errorf("Could not announce: %s", err)
+		}
+	})
+
+	return b.multicast(b.announcement(entries))
+}
+
+func (b *Backend) announcement(entries []Entry) []dns.RR {
+	var records []dns.RR
+	for _, entry := range entries {
+		records = append(records, b.entryRecords(entry, nil)...)
+	}
+	return append(records, b.hostRecords(entries, hostTTL)...)
+}
+
+// goodbye multicasts the records of entries with a TTL of 0. The records of
+// the service type enumeration are omitted, other entries may still use the
+// same service type. Host records are left to expire, other entries may
+// still point to them.
+func (b *Backend) goodbye(entries []Entry) error {
+	var zero uint32
+	var records []dns.RR
+	for _, entry := range entries {
+		records = append(records, b.entryRecords(entry, &zero)[1:]...)
+	}
+
+	return b.multicast(records)
+}
+
+// serve answers queries until Conn is closed.
+func (b *Backend) serve() {
+	buf := make([]byte, 9000)
+	for {
+		n, addr, err := b.Conn.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				b.Log.Errorf("Could not read: %s", err)
+			}
+			return
+		}
+
+		var query dns.Msg
+		err = query.Unpack(buf[:n])
+		if err != nil {
+			b.Log.Debugf("Invalid packet from %s: %s", addr, err)
+			continue
+		}
+
+		response, unicast := b.answer(&query, addr)
+		if response == nil {
+			continue
+		}
+
+		to := b.GroupAddr
+		if unicast {
+			to = addr
+		}
+		err = b.send(response, to)
+		if err != nil && !errors.Is(err, net.ErrClosed) {
+			b.Log.Errorf("Could not answer %s: %s", addr, err)
+		}
+	}
+}
+
+// answer returns the response to query from addr, or nil if there is
+// nothing to answer. unicast reports whether the response goes to addr
+// instead of the group.
+func (b *Backend) answer(query *dns.Msg, addr net.Addr) (response *dns.Msg, unicast bool) {
+	if query.Response || query.Opcode != dns.OpcodeQuery || len(query.Question) == 0 {
+		return nil, false
+	}
+
+	records := b.records()
+	if len(records) == 0 {
+		return nil, false
+	}
+
+	// RFC 6762, 6.7: queries not from port 5353 come from simple resolvers
+	// which expect a regular DNS response
+	legacy := false
+	if udpAddr, ok := addr.(*net.UDPAddr); ok && udpAddr.Port != Group.Port {
+		legacy = true
+	}
+
+	response = new(dns.Msg)
+	response.Response = true
+	response.Authoritative = true
+
+	seen := map[string]struct{}{}
+	add := func(section *[]dns.RR, rr dns.RR) {
+		if _, ok := seen[rr.String()]; ok {
+			return
+		}
+		seen[rr.String()] = struct{}{}
+		*section = append(*section, rr)
+	}
+
+	unicast = legacy
+	for _, q := range query.Question {
+		if q.Qclass&cacheFlush != 0 {
+			unicast = true
+		}
+		for _, rr := range records {
+			if strings.EqualFold(rr.Header().Name, q.Name) && (q.Qtype == dns.TypeANY || q.Qtype == rr.Header().Rrtype) {
+				add(&response.Answer, rr)
+			}
+		}
+	}
+	if len(response.Answer) == 0 {
+		return nil, false
+	}
+
+	// RFC 6763, 12: add the records a client needs next
+	var names []string
+	for _, rr := range response.Answer {
+		switch rr := rr.(type) {
+		case *dns.PTR:
+			// The service type enumeration needs no additional records
+			if rr.Hdr.Name != servicesName {
+				names = append(names, rr.Ptr)
+			}
+		case *dns.SRV:
+			names = append(names, rr.Target)
+		}
+	}
+	// The SRV records added for a PTR need their host record as well
+	for i := 0; i < len(names); i++ {
+		for _, extra := range records {
+			if strings.EqualFold(extra.Header().Name, names[i]) {
+				add(&response.Extra, extra)
+				if srv, ok := extra.(*dns.SRV); ok {
+					names = append(names, srv.Target)
+				}
+			}
+		}
+	}
+
+	if legacy {
+		response.Id = query.Id
+		response.Question = query.Question
+		for _, rr := range append(response.Answer, response.Extra...) {
+			rr.Header().Class &^= cacheFlush
+			if rr.Header().Ttl > 10 {
+				rr.Header().Ttl = 10
+			}
+		}
+	}
+
+	return response, unicast
+}
+
+func WithLogger(log *logrus.Entry) func(b *Backend) {
+	return func(b *Backend) {
+		b.Log = log.WithField("backend", "mdns")
+	}
+}
+
+// WithForwardAddress sets the IPv4 address the host name resolves to.
+func WithForwardAddress(address string) func(b *Backend) {
+	return func(b *Backend) {
+		b.ForwardAddress = address
+	}
+}
+
+// WithStaticLabels adds labels of the form key=value to the TXT records of
+// every instance.
+func WithStaticLabels(labels []string) func(b *Backend) {
+	return func(b *Backend) {
+		b.StaticLabels = labels
+	}
+}
+
+// WithAddressStrategy sets the address strategy, see backends.AddressStrategy.
+func WithAddressStrategy(strategy backends.AddressStrategy) func(b *Backend) {
+	return func(b *Backend) {
+		b.AddressStrategy = strategy
+	}
+}
+
+// WithHost sets the host name SRV records point to, .local is appended.
+func WithHost(host string) func(b *Backend) {
+	return func(b *Backend) {
+		if host != "" {
+			b.Host = host
+		}
+	}
+}
+
+// WithInterface limits advertising to the network interface with name.
+func WithInterface(name string) func(b *Backend) {
+	return func(b *Backend) {
+		b.Interface = name
+	}
+}
+
+// WithConn uses conn instead of joining the multicast group and sends
+// multicast responses to group.
+func WithConn(conn net.PacketConn, group net.Addr) func(b *Backend) {
+	return func(b *Backend) {
+		b.Conn = conn
+		b.GroupAddr = group
+	}
+}
+
+// WithName sets the backend name, see backends.Settings.
+func WithName(name string) func(b *Backend) {
+	return func(b *Backend) {
+		if name != "" {
+			b.Name = name
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package mdns_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/soupdiver/creg/backends"
+	"github.com/soupdiver/creg/backends/backendtest"
+	"github.com/soupdiver/creg/backends/mdns"
+	ctypes "github.com/soupdiver/creg/types"
+)
+
+func container(id, hostPort string) ctypes.ContainerInfo {
+	return ctypes.ContainerInfo{
+		ID: id,
+		Labels: map[string]string{
+			"creg.port":          "80/tcp:web",
+			"creg.mdns.type":     "_http._tcp",
+			"creg.mdns.txt.path": "/admin",
+		},
+		NetworkSettings: ctypes.NetworkSettings{
+			Ports: map[ctypes.Port][]ctypes.PortBinding{"80/tcp": {{HostIP: "0.0.0.0", HostPort: hostPort}}},
+		},
+	}
+}
+
+func listen(t *testing.T) net.PacketConn {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func read(t *testing.T, conn net.PacketConn) *dns.Msg {
+	buf := make([]byte, 9000)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var m dns.Msg
+	err = m.Unpack(buf[:n])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &m
+}
+
+// find returns the record of type rrtype with name in records.
+func find(records []dns.RR, name string, rrtype uint16) dns.RR {
+	for _, rr := range records {
+		if rr.Header().Name == name && rr.Header().Rrtype == rrtype {
+			return rr
+		}
+	}
+	return nil
+}
+
+// TestAdvertise runs the responder on a unicast socket, multicast messages
+// are sent to group instead of the mDNS group.
+func TestAdvertise(t *testing.T) {
+	conn, group, client := listen(t), listen(t), listen(t)
+
+	b, err := mdns.New(
+		mdns.WithConn(conn, group.LocalAddr()),
+		mdns.WithLogger(backendtest.Logger()),
+		mdns.WithForwardAddress("10.0.0.1"),
+		mdns.WithHost("docker"),
+		mdns.WithStaticLabels([]string{"dc=home"}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := make(chan ctypes.ContainerEventV2)
+	done := make(chan error)
+	go func() {
+		done <- b.Run(ctx, events, false, nil)
+	}()
+
+	events <- ctypes.ContainerEventV2{Action: "start", Container: container("a", "8080")}
+
+	announcement := read(t, group)
+	srv, ok := find(announcement.Answer, "web._http._tcp.local.", dns.TypeSRV).(*dns.SRV)
+	if !ok || srv.Port != 8080 || srv.Target != "docker.local." || srv.Hdr.Ttl != 120 {
+		t.Fatalf("unexpected announcement: %s", announcement)
+	}
+	txt, ok := find(announcement.Answer, "web._http._tcp.local.", dns.TypeTXT).(*dns.TXT)
+	if !ok || len(txt.Txt) != 2 || txt.Txt[0] != "dc=home" || txt.Txt[1] != "path=/admin" {
+		t.Fatalf("unexpected announcement: %s", announcement)
+	}
+
+	// A query from a port other than 5353 gets a unicast legacy response
+	query := new(dns.Msg)
+	query.SetQuestion("_http._tcp.local.", dns.TypePTR)
+	data, err := query.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.WriteTo(data, conn.LocalAddr())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response := read(t, client)
+	if response.Id != query.Id || len(response.Question) != 1 {
+		t.Fatalf("expected legacy response, got %s", response)
+	}
+	ptr, ok := find(response.Answer, "_http._tcp.local.", dns.TypePTR).(*dns.PTR)
+	if !ok || ptr.Ptr != "web._http._tcp.local." || ptr.Hdr.Ttl > 10 {
+		t.Fatalf("unexpected response: %s", response)
+	}
+	a, ok := find(response.Extra, "docker.local.", dns.TypeA).(*dns.A)
+	if !ok || !a.A.Equal(net.ParseIP("10.0.0.1")) {
+		t.Fatalf("expected address in additional section, got %s", response)
+	}
+	if find(response.Extra, "web._http._tcp.local.", dns.TypeSRV) == nil {
+		t.Fatalf("expected SRV in additional section, got %s", response)
+	}
+
+	events <- ctypes.ContainerEventV2{Action: "stop", Container: ctypes.ContainerInfo{ID: "a"}}
+
+	// The second announcement may arrive before the goodbye
+	for {
+		goodbye := read(t, group)
+		srv, ok = find(goodbye.Answer, "web._http._tcp.local.", dns.TypeSRV).(*dns.SRV)
+		if !ok {
+			t.Fatalf("unexpected message: %s", goodbye)
+		}
+		if srv.Hdr.Ttl == 0 {
+			break
+		}
+	}
+
+	cancel()
+	err = <-done
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInstanceNameConflict(t *testing.T) {
+	group := listen(t)
+
+	b, err := mdns.New(
+		mdns.WithConn(listen(t), group.LocalAddr()),
+		mdns.WithLogger(backendtest.Logger()),
+		mdns.WithForwardAddress("10.0.0.1"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.SetContainer(container("a", "8080"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if find(read(t, group).Answer, "web._http._tcp.local.", dns.TypeSRV) == nil {
+		t.Fatal("expected first container to keep the service name")
+	}
+
+	err = b.SetContainer(container("0123456789abcdef", "8081"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	announcement := read(t, group)
+	srv, ok := find(announcement.Answer, "web-0123456789ab._http._tcp.local.", dns.TypeSRV).(*dns.SRV)
+	if !ok || srv.Port != 8081 {
+		t.Fatalf("expected container ID to be appended, got %s", announcement)
+	}
+}
+
+func TestAddressStrategy(t *testing.T) {
+	group := listen(t)
+
+	b, err := mdns.New(
+		mdns.WithConn(listen(t), group.LocalAddr()),
+		mdns.WithLogger(backendtest.Logger()),
+		mdns.WithForwardAddress("10.0.0.1"),
+		mdns.WithAddressStrategy(backends.AddressStrategy{Mode: backends.AddressNetwork}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := container("a", "8080")
+	c.NetworkSettings.Networks = map[string]ctypes.Network{"backend": {IPAddress: "172.18.0.2"}}
+	err = b.SetContainer(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The container IP gets a host name of its own
+	announcement := read(t, group)
+	srv, ok := find(announcement.Answer, "web._http._tcp.local.", dns.TypeSRV).(*dns.SRV)
+	if !ok || srv.Port != 80 || srv.Target != "creg-172-18-0-2.local." {
+		t.Fatalf("unexpected announcement: %s", announcement)
+	}
+	a, ok := find(announcement.Answer, "creg-172-18-0-2.local.", dns.TypeA).(*dns.A)
+	if !ok || !a.A.Equal(net.ParseIP("172.18.0.2")) {
+		t.Fatalf("expected address of %s, got %s", srv.Target, announcement)
+	}
+}
+
+func TestInvalidType(t *testing.T) {
+	b, err := mdns.New(mdns.WithForwardAddress("10.0.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := container("a", "8080")
+	c.Labels["creg.mdns.type"] = "http"
+	entries, err := b.Entries(c)
+	if err == nil || len(entries) != 0 {
+		t.Fatalf("expected invalid type to fail, got %+v", entries)
+	}
+}
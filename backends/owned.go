@@ -0,0 +1,213 @@
+package backends
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Entry is a DNS entry in a system which can not attach metadata to its
+// entries, like an AdGuard Home rewrite or a Pi-hole local record. Entries of
+// the same type and domain replace each other.
+type Entry struct {
+	Type   string `json:"type,omitempty"`
+	Domain string `json:"domain"`
+	Answer string `json:"answer"`
+}
+
+// EntryClient manages the entries of a system.
+type EntryClient interface {
+	List(ctx context.Context) ([]Entry, error)
+	Add(ctx context.Context, entry Entry) error
+	// Update replaces old with entry
+	Update(ctx context.Context, old, entry Entry) error
+	Delete(ctx context.Context, entry Entry) error
+}
+
+// OwnedEntries registers entries and keeps track of the ones created by this
+// instance, only those are ever updated or deleted. Ownership is persisted in
+// the state file at StatePath, see LoadState.
+type OwnedEntries struct {
+	Client    EntryClient
+	Log       *logrus.Entry
+	StatePath string
+
+	owned map[Entry]struct{}
+	mtx   sync.Mutex
+}
+
+// NewOwnedEntries loads the entries owned before a restart from the state
+// file at statePath.
+func NewOwnedEntries(client EntryClient, statePath string, log *logrus.Entry) (*OwnedEntries, error) {
+	o := &OwnedEntries{
+		Client:    client,
+		Log:       log,
+		StatePath: statePath,
+		owned:     map[Entry]struct{}{},
+	}
+
+	var owned []Entry
+	err := LoadState(statePath, &owned)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range owned {
+		o.owned[entry] = struct{}{}
+	}
+
+	return o, nil
+}
+
+// Register adds the entries which do not exist yet and marks them as owned.
+// An owned entry of the same type and domain with a different answer is
+// updated instead of adding a second one. Entries created by anything else
+// are never changed or claimed, even if they are identical.
+func (o *OwnedEntries) Register(ctx context.Context, entries []Entry) error {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+
+	existing, err := o.Client.List(ctx)
+	if err != nil {
+		return fmt.Errorf("could not list entries: %w", err)
+	}
+
+	wanted := map[Entry]struct{}{}
+	for _, entry := range entries {
+		wanted[entry] = struct{}{}
+	}
+
+	present := map[Entry]struct{}{}
+	stale := map[Entry][]Entry{}
+	for _, item := range existing {
+		present[item] = struct{}{}
+
+		_, isWanted := wanted[item]
+		_, isOwned := o.owned[item]
+		if isOwned && !isWanted {
+			key := Entry{Type: item.Type, Domain: item.Domain}
+			stale[key] = append(stale[key], item)
+		}
+	}
+
+	var errs []error
+	for _, entry := range entries {
+		if _, ok := present[entry]; ok {
+			continue
+		}
+
+		key := Entry{Type: entry.Type, Domain: entry.Domain}
+		if old := stale[key]; len(old) > 0 {
+			o.Log.Debugf("Update entry: %s -> %s with %s", old[0].Domain, old[0].Answer, entry.Answer)
+			err = o.Client.Update(ctx, old[0], entry)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("could not update %s: %w", entry.Domain, err))
+				continue
+			}
+			stale[key] = old[1:]
+			delete(o.owned, old[0])
+		} else {
+			err = o.Client.Add(ctx, entry)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("could not add %s: %w", entry.Domain, err))
+				continue
+			}
+		}
+		present[entry] = struct{}{}
+		o.owned[entry] = struct{}{}
+	}
+
+	err = o.save()
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	return JoinErrors(errs)
+}
+
+// Deregister deletes the given entries which are owned and releases their
+// ownership.
+func (o *OwnedEntries) Deregister(ctx context.Context, entries []Entry) error {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+
+	var errs []error
+	for _, entry := range entries {
+		if _, ok := o.owned[entry]; !ok {
+			continue
+		}
+
+		err := o.Client.Delete(ctx, entry)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not delete %s: %w", entry.Domain, err))
+			continue
+		}
+		delete(o.owned, entry)
+	}
+
+	err := o.save()
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	return JoinErrors(errs)
+}
+
+// Purge deletes all owned entries which still exist. Entries created by
+// anything else are left untouched.
+func (o *OwnedEntries) Purge(ctx context.Context) error {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+
+	existing, err := o.Client.List(ctx)
+	if err != nil {
+		return fmt.Errorf("could not list entries: %w", err)
+	}
+
+	var toDelete []Entry
+	for _, item := range existing {
+		if _, ok := o.owned[item]; ok {
+			toDelete = append(toDelete, item)
+		}
+	}
+	o.owned = map[Entry]struct{}{}
+
+	var errs []error
+	for _, item := range toDelete {
+		o.Log.Debugf("Purge entry: %s -> %s", item.Domain, item.Answer)
+		err := o.Client.Delete(ctx, item)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not delete %s: %w", item.Domain, err))
+			// Keep the ownership, the next purge tries again
+			o.owned[item] = struct{}{}
+		}
+	}
+
+	err = o.save()
+	if err != nil {
+		errs = append(errs, err)
+	}
+
+	return JoinErrors(errs)
+}
+
+// save persists the owned entries, it must be called with mtx held.
+func (o *OwnedEntries) save() error {
+	owned := make([]Entry, 0, len(o.owned))
+	for entry := range o.owned {
+		owned = append(owned, entry)
+	}
+	sort.Slice(owned, func(i, j int) bool {
+		if owned[i].Domain != owned[j].Domain {
+			return owned[i].Domain < owned[j].Domain
+		}
+		if owned[i].Type != owned[j].Type {
+			return owned[i].Type < owned[j].Type
+		}
+		return owned[i].Answer < owned[j].Answer
+	})
+
+	return SaveState(o.StatePath, owned)
+}
@@ -0,0 +1,93 @@
+package backends_test
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/soupdiver/creg/backends"
+)
+
+// fakeEntries is an in-memory EntryClient.
+type fakeEntries struct {
+	entries map[backends.Entry]struct{}
+}
+
+func (f *fakeEntries) List(ctx context.Context) ([]backends.Entry, error) {
+	var entries []backends.Entry
+	for entry := range f.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Answer < entries[j].Answer })
+
+	return entries, nil
+}
+
+func (f *fakeEntries) Add(ctx context.Context, entry backends.Entry) error {
+	f.entries[entry] = struct{}{}
+	return nil
+}
+
+func (f *fakeEntries) Update(ctx context.Context, old, entry backends.Entry) error {
+	delete(f.entries, old)
+	f.entries[entry] = struct{}{}
+	return nil
+}
+
+func (f *fakeEntries) Delete(ctx context.Context, entry backends.Entry) error {
+	delete(f.entries, entry)
+	return nil
+}
+
+func TestOwnedEntries(t *testing.T) {
+	logger := logrus.New()
+	logger.Out = io.Discard
+
+	foreign := backends.Entry{Domain: "app.lan", Answer: "192.168.1.10"}
+	fake := &fakeEntries{entries: map[backends.Entry]struct{}{foreign: {}}}
+	state := filepath.Join(t.TempDir(), "owned.json")
+	ctx := context.Background()
+
+	o, err := backends.NewOwnedEntries(fake, state, logrus.NewEntry(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = o.Register(ctx, []backends.Entry{{Domain: "app.lan", Answer: "10.0.0.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = o.Register(ctx, []backends.Entry{{Domain: "app.lan", Answer: "10.0.0.2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The owned entry is updated, the foreign one for the same domain is kept
+	expected := map[backends.Entry]struct{}{foreign: {}, {Domain: "app.lan", Answer: "10.0.0.2"}: {}}
+	if len(fake.entries) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, fake.entries)
+	}
+	for entry := range expected {
+		if _, ok := fake.entries[entry]; !ok {
+			t.Fatalf("expected %v, got %v", expected, fake.entries)
+		}
+	}
+
+	// Ownership survives a restart
+	restarted, err := backends.NewOwnedEntries(fake, state, logrus.NewEntry(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = restarted.Purge(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := fake.entries[foreign]; len(fake.entries) != 1 || !ok {
+		t.Fatalf("expected only %v to remain, got %v", foreign, fake.entries)
+	}
+}
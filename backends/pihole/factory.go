@@ -0,0 +1,41 @@
+package pihole
+
+import (
+	"github.com/soupdiver/creg/backends"
+	"github.com/soupdiver/creg/pihole/client"
+)
+
+// BackendConfig configures a pihole backend in the config file.
+type BackendConfig struct {
+	Address  string `yaml:"address"`
+	Password string `yaml:"password"`
+	// PasswordFile contains the password, it takes precedence over Password
+	PasswordFile string `yaml:"password_file"`
+	Insecure     bool   `yaml:"insecure"`
+}
+
+func init() {
+	backends.Register("pihole", backends.Factory{
+		Config: func() interface{} { return &BackendConfig{} },
+		New: func(config interface{}, settings backends.Settings) (backends.Backend, error) {
+			cfg := config.(*BackendConfig)
+
+			clientOptions := []client.ClientOption{client.WithPassword(cfg.Password)}
+			if cfg.PasswordFile != "" {
+				clientOptions = append(clientOptions, client.WithPasswordFile(cfg.PasswordFile))
+			}
+			if cfg.Insecure {
+				clientOptions = append(clientOptions, client.WithInsecureSkipVerify())
+			}
+
+			return New(cfg.Address,
+				WithName(settings.Name),
+				WithLogger(settings.Log),
+				WithForwardAddress(settings.ForwardAddress),
+				WithAddressStrategy(settings.AddressStrategy),
+				WithStatePath(settings.StatePath("pihole")),
+				WithClientOptions(clientOptions...),
+			)
+		},
+	})
+}
@@ -0,0 +1,271 @@
+package pihole
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/soupdiver/creg/backends"
+	"github.com/soupdiver/creg/pihole"
+	"github.com/soupdiver/creg/pihole/client"
+	ctypes "github.com/soupdiver/creg/types"
+)
+
+type Backend struct {
+	Name            string
+	Client          *client.Client
+	Log             *logrus.Entry
+	ForwardAddress  string
+	AddressStrategy backends.AddressStrategy
+
+	// StatePath is the file the owned records are persisted in, see
+	// backends.LoadState
+	StatePath string
+
+	// owned holds the records created by this instance. Pi-hole has no way to
+	// attach metadata to a record, so only records found here are ever
+	// replaced or removed.
+	owned *backends.OwnedEntries
+
+	clientOptions []client.ClientOption
+}
+
+type PiholeOption func(*Backend)
+
+func New(address string, options ...PiholeOption) (*Backend, error) {
+	b := &Backend{
+		Name: "pihole",
+		Log:  logrus.NewEntry(logrus.StandardLogger()),
+	}
+
+	for _, option := range options {
+		option(b)
+	}
+
+	c, err := client.New(address, b.clientOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("could not create pihole client: %w", err)
+	}
+	b.Client = c
+
+	b.owned, err = backends.NewOwnedEntries(recordClient{c}, b.StatePath, b.Log)
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func (b *Backend) Run(ctx context.Context, events chan ctypes.ContainerEventV2, purgeOnStart bool, containersToRefresh []ctypes.ContainerInfo) error {
+	var err error
+	if purgeOnStart {
+		err = b.Purge()
+		if err != nil {
+			return fmt.Errorf("could not purge: %w", err)
+		}
+	}
+
+	if len(containersToRefresh) > 0 {
+		err = b.Refresh(containersToRefresh)
+		if err != nil {
+			return fmt.Errorf("could not refresh: %w", err)
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			b.Log.Infof("Pihole exting: %s", "context cancelled")
+			return nil
+		case event := <-events:
+			b.Log.Debugf("handle event pihole: %s", event.Action)
+
+			records := b.Records(event.Container)
+			if len(records) == 0 {
+				continue
+			}
+
+			switch event.Action {
+			case "start":
+				err := b.RegisterRecords(ctx, records)
+				if err != nil {
+					b.Log.Errorf("Could not RegisterRecords: %s", err)
+					continue
+				}
+			case "stop":
+				err := b.DeregisterRecords(ctx, records)
+				if err != nil {
+					b.Log.Errorf("Could not DeregisterRecords: %s", err)
+					continue
+				}
+			}
+		}
+	}
+}
+
+func (b *Backend) GetName() string {
+	return b.Name
+}
+
+// RecordsFromLabels parses the creg.dns label, see backends.ParseDNSLabel.
+// Answers which are IP addresses become A records, everything else becomes a
+// CNAME record. Entries without an answer point to the ForwardAddress.
+// Pi-hole does not support wildcards, so those are logged and skipped.
+func (b *Backend) RecordsFromLabels(labels map[string]string) []pihole.Record {
+	return b.Records(ctypes.ContainerInfo{Labels: labels})
+}
+
+// Records returns the records of the creg.dns label of container, entries
+// without an answer point to the address selected by the AddressStrategy.
+func (b *Backend) Records(container ctypes.ContainerInfo) []pihole.Record {
+	v, ok := container.Labels[backends.LabelDNS]
+	if !ok {
+		return nil
+	}
+
+	entries, err := backends.ParseDNSLabel(v, b.AddressStrategy.Address(container, b.ForwardAddress))
+	if err != nil {
+		b.Log.Errorf("Invalid %s label: %s", backends.LabelDNS, err)
+	}
+
+	var records []pihole.Record
+	for _, entry := range entries {
+		if backends.IsWildcard(entry.Domain) {
+			b.Log.Errorf("Pihole does not support wildcard domains: %s", entry.Domain)
+			continue
+		}
+
+		record := pihole.Record{Type: pihole.RecordTypeCNAME, Domain: entry.Domain, Answer: entry.Answer}
+		if net.ParseIP(entry.Answer) != nil {
+			record.Type = pihole.RecordTypeA
+		}
+		records = append(records, record)
+	}
+
+	return records
+}
+
+// RegisterRecords adds the records which do not exist yet, an owned record of
+// the same type and domain is replaced. See backends.OwnedEntries.Register.
+func (b *Backend) RegisterRecords(ctx context.Context, records []pihole.Record) error {
+	return b.owned.Register(ctx, toEntries(records))
+}
+
+// DeregisterRecords deletes the given records owned by this instance.
+func (b *Backend) DeregisterRecords(ctx context.Context, records []pihole.Record) error {
+	return b.owned.Deregister(ctx, toEntries(records))
+}
+
+// Purge deletes all records owned by this instance which still exist in
+// Pi-hole. Records created by anything else are left untouched.
+func (b *Backend) Purge() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	return b.owned.Purge(ctx)
+}
+
+// Refresh registers the records of all given containers.
+func (b *Backend) Refresh(containers []ctypes.ContainerInfo) error {
+	b.Log.Debugf("Refreshing %d pihole containers", len(containers))
+
+	var records []pihole.Record
+	for _, container := range containers {
+		records = append(records, b.Records(container)...)
+	}
+
+	if len(records) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	return b.RegisterRecords(ctx, records)
+}
+
+// recordClient manages records as backends.Entry. Pi-hole can not update a
+// record, it is replaced by deleting and adding it.
+type recordClient struct {
+	*client.Client
+}
+
+func (c recordClient) List(ctx context.Context) ([]backends.Entry, error) {
+	records, err := c.Client.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return toEntries(records), nil
+}
+
+func (c recordClient) Add(ctx context.Context, entry backends.Entry) error {
+	return c.Client.Add(ctx, pihole.Record(entry))
+}
+
+func (c recordClient) Update(ctx context.Context, old, entry backends.Entry) error {
+	err := c.Client.Delete(ctx, pihole.Record(old))
+	if err != nil {
+		return err
+	}
+
+	return c.Client.Add(ctx, pihole.Record(entry))
+}
+
+func (c recordClient) Delete(ctx context.Context, entry backends.Entry) error {
+	return c.Client.Delete(ctx, pihole.Record(entry))
+}
+
+func toEntries(records []pihole.Record) []backends.Entry {
+	entries := make([]backends.Entry, 0, len(records))
+	for _, record := range records {
+		entries = append(entries, backends.Entry(record))
+	}
+
+	return entries
+}
+
+func WithLogger(log *logrus.Entry) func(b *Backend) {
+	return func(b *Backend) {
+		b.Log = log.WithField("backend", "pihole")
+	}
+}
+
+func WithForwardAddress(address string) func(b *Backend) {
+	return func(b *Backend) {
+		b.ForwardAddress = address
+	}
+}
+
+// WithStatePath persists the owned records in the file path, so they are
+// purged after a restart.
+func WithStatePath(path string) func(b *Backend) {
+	return func(b *Backend) {
+		b.StatePath = path
+	}
+}
+
+func WithClientOptions(options ...client.ClientOption) func(b *Backend) {
+	return func(b *Backend) {
+		b.clientOptions = append(b.clientOptions, options...)
+	}
+}
+
+// WithName sets the backend name, see backends.Settings.
+func WithName(name string) func(b *Backend) {
+	return func(b *Backend) {
+		if name != "" {
+			b.Name = name
+		}
+	}
+}
+
+// WithAddressStrategy sets the address strategy, see backends.AddressStrategy.
+func WithAddressStrategy(strategy backends.AddressStrategy) func(b *Backend) {
+	return func(b *Backend) {
+		b.AddressStrategy = strategy
+	}
+}
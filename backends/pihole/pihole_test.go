@@ -0,0 +1,283 @@
+package pihole_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/soupdiver/creg/backends/backendtest"
+	piholebackend "github.com/soupdiver/creg/backends/pihole"
+	"github.com/soupdiver/creg/pihole"
+	"github.com/soupdiver/creg/pihole/client"
+	ctypes "github.com/soupdiver/creg/types"
+)
+
+// fakePihole is a minimal stand-in for the Pi-hole v6 local DNS API.
+type fakePihole struct {
+	mtx    sync.Mutex
+	hosts  []string
+	cnames []string
+	sids   map[string]bool
+	logins int
+}
+
+func (f *fakePihole) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if r.URL.Path == "/api/auth" && r.Method == http.MethodPost {
+		var req pihole.AuthRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		f.logins++
+		sid := strings.Repeat("s", f.logins)
+		f.sids[sid] = true
+
+		var res pihole.AuthResponse
+		res.Session.Valid = true
+		res.Session.SID = sid
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	if !f.sids[r.Header.Get("X-FTL-SID")] {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]string{"key": "unauthorized", "message": "Unauthorized"}})
+		return
+	}
+
+	if r.URL.Path == "/api/config/dns" && r.Method == http.MethodGet {
+		var res pihole.ConfigResponse
+		res.Config.DNS.Hosts = f.hosts
+		res.Config.DNS.CNAMERecords = f.cnames
+		json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	var list *[]string
+	var value string
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/api/config/dns/hosts/"):
+		list, value = &f.hosts, strings.TrimPrefix(r.URL.Path, "/api/config/dns/hosts/")
+	case strings.HasPrefix(r.URL.Path, "/api/config/dns/cnameRecords/"):
+		list, value = &f.cnames, strings.TrimPrefix(r.URL.Path, "/api/config/dns/cnameRecords/")
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	value, err := url.PathUnescape(value)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	index := -1
+	for i, v := range *list {
+		if v == value {
+			index = i
+		}
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		if index >= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": map[string]string{"key": "bad_request", "message": "Item already present"}})
+			return
+		}
+		*list = append(*list, value)
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodDelete:
+		if index < 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		*list = append((*list)[:index], (*list)[index+1:]...)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakePihole) Records() []string {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	v := append(append([]string(nil), f.hosts...), f.cnames...)
+	sort.Strings(v)
+	return v
+}
+
+func (f *fakePihole) ExpireSessions() {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	f.sids = map[string]bool{}
+}
+
+func newTestBackend(t *testing.T, fake *fakePihole) *piholebackend.Backend {
+	if fake.sids == nil {
+		fake.sids = map[string]bool{}
+	}
+
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+
+	b, err := piholebackend.New(srv.URL,
+		piholebackend.WithLogger(backendtest.Logger()),
+		piholebackend.WithForwardAddress("6.6.6.6"),
+		piholebackend.WithClientOptions(client.WithPassword("secret")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func TestRecordsFromLabels(t *testing.T) {
+	b := newTestBackend(t, &fakePihole{})
+
+	records := b.RecordsFromLabels(map[string]string{"creg.dns": "app.lan;www.app.lan,app.lan;*.app.lan;db.lan,10.0.0.2"})
+
+	expected := []pihole.Record{
+		{Type: pihole.RecordTypeA, Domain: "app.lan", Answer: "6.6.6.6"},
+		{Type: pihole.RecordTypeCNAME, Domain: "www.app.lan", Answer: "app.lan"},
+		{Type: pihole.RecordTypeA, Domain: "db.lan", Answer: "10.0.0.2"},
+	}
+	if len(records) != len(expected) {
+		t.Fatalf("expected %+v, got %+v", expected, records)
+	}
+	for i := range records {
+		if records[i] != expected[i] {
+			t.Fatalf("expected %+v, got %+v", expected, records)
+		}
+	}
+}
+
+// newFakePiholeWithForeignRecords returns Pi-hole with hand-made records,
+// including an A record for app.lan.
+func newFakePiholeWithForeignRecords() *fakePihole {
+	return &fakePihole{
+		hosts:  []string{"192.168.1.1 router.lan", "10.0.0.9 app.lan"},
+		cnames: []string{"nas.lan,storage.lan"},
+	}
+}
+
+var testContainers = []ctypes.ContainerInfo{
+	{ID: "a", Labels: map[string]string{"creg.dns": "app.lan;www.app.lan,app.lan"}},
+	{ID: "b", Labels: map[string]string{"creg.dns": "db.lan,10.0.0.2"}},
+	{ID: "c", Labels: map[string]string{}},
+}
+
+func TestRefreshKeepsForeignRecords(t *testing.T) {
+	fake := newFakePiholeWithForeignRecords()
+	b := newTestBackend(t, fake)
+
+	backendtest.Refresh(t, b, testContainers...)
+
+	// The hand-made app.lan record is kept
+	backendtest.ExpectStrings(t, []string{"10.0.0.2 db.lan", "10.0.0.9 app.lan", "192.168.1.1 router.lan", "6.6.6.6 app.lan", "nas.lan,storage.lan", "www.app.lan,app.lan"}, fake.Records())
+}
+
+func TestRefreshAfterExpiredSessionDoesNotDuplicate(t *testing.T) {
+	fake := newFakePiholeWithForeignRecords()
+	b := newTestBackend(t, fake)
+
+	backendtest.Refresh(t, b, testContainers...)
+	expected := fake.Records()
+
+	// The second refresh logs in again
+	fake.ExpireSessions()
+	backendtest.Refresh(t, b, testContainers...)
+
+	backendtest.ExpectStrings(t, expected, fake.Records())
+}
+
+func TestPurgeKeepsForeignRecords(t *testing.T) {
+	fake := newFakePiholeWithForeignRecords()
+	b := newTestBackend(t, fake)
+
+	backendtest.Refresh(t, b, testContainers...)
+	backendtest.Purge(t, b)
+
+	backendtest.ExpectStrings(t, []string{"10.0.0.9 app.lan", "192.168.1.1 router.lan", "nas.lan,storage.lan"}, fake.Records())
+}
+
+func TestRegisterReplacesChangedAnswer(t *testing.T) {
+	fake := &fakePihole{hosts: []string{"192.168.1.1 router.lan"}}
+	b := newTestBackend(t, fake)
+
+	err := b.RegisterRecords(context.Background(), b.RecordsFromLabels(map[string]string{"creg.dns": "app.lan,10.0.0.1"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The owned record is replaced when its answer changes
+	err = b.RegisterRecords(context.Background(), b.RecordsFromLabels(map[string]string{"creg.dns": "app.lan,10.0.0.3"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"10.0.0.3 app.lan", "192.168.1.1 router.lan"}
+	if v := fake.Records(); strings.Join(v, "|") != strings.Join(expected, "|") {
+		t.Fatalf("expected %v, got %v", expected, v)
+	}
+}
+
+func TestRegisterAndDeregister(t *testing.T) {
+	fake := &fakePihole{}
+	b := newTestBackend(t, fake)
+
+	records := b.RecordsFromLabels(map[string]string{"creg.dns": "app.lan;www.app.lan,app.lan"})
+
+	err := b.RegisterRecords(context.Background(), records)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fake.Records()) != 2 {
+		t.Fatalf("expected %d records, got %v", 2, fake.Records())
+	}
+
+	err = b.DeregisterRecords(context.Background(), records)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fake.Records()) != 0 {
+		t.Fatalf("expected %d records, got %v", 0, fake.Records())
+	}
+
+	// Deleting records which are already gone is not an error
+	err = b.DeregisterRecords(context.Background(), records)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWrongPassword(t *testing.T) {
+	srv := httptest.NewServer(&fakePihole{sids: map[string]bool{}})
+	defer srv.Close()
+
+	b, err := piholebackend.New(srv.URL, piholebackend.WithClientOptions(client.WithPassword("wrong")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = b.Purge()
+	if err == nil {
+		t.Fatal("expected purge to fail")
+	}
+}
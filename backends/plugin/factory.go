@@ -0,0 +1,32 @@
+package plugin
+
+import (
+	"github.com/soupdiver/creg/backends"
+)
+
+// BackendConfig configures a plugin backend in the config file.
+type BackendConfig struct {
+	// Command of the plugin and its arguments
+	Command []string `yaml:"command"`
+	// Env is added to the environment of the plugin, KEY=value
+	Env []string `yaml:"env"`
+}
+
+func init() {
+	backends.Register("plugin", backends.Factory{
+		Config: func() interface{} { return &BackendConfig{} },
+		New: func(config interface{}, settings backends.Settings) (backends.Backend, error) {
+			cfg := config.(*BackendConfig)
+
+			return New(cfg.Command,
+				WithName(settings.Name),
+				WithLogger(settings.Log),
+				WithID(settings.ID),
+				WithForwardAddress(settings.ForwardAddress),
+				WithAddressStrategy(settings.AddressStrategy),
+				WithStaticLabels(settings.StaticLabels),
+				WithEnv(cfg.Env...),
+			)
+		},
+	})
+}
@@ -0,0 +1,394 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/soupdiver/creg/backends"
+	ctypes "github.com/soupdiver/creg/types"
+)
+
+// Backend runs a plugin and forwards container events to it. Crashed plugins
+// are restarted with an exponential backoff and get the current containers
+// with a refresh. Events arriving while the plugin is down are only
+// recorded, the refresh after the restart includes them.
+type Backend struct {
+	ID              string
+	Name            string
+	Log             *logrus.Entry
+	Command         []string
+	Env             []string
+	ForwardAddress  string
+	AddressStrategy backends.AddressStrategy
+	StaticLabels    []string
+	// CallTimeout limits each request to the plugin
+	CallTimeout time.Duration
+	// RestartBackoff is the delay before the first restart, it doubles with
+	// every failed restart up to MaxRestartBackoff
+	RestartBackoff    time.Duration
+	MaxRestartBackoff time.Duration
+
+	// containers holds the running containers by container ID, registered
+	// the services sent with their register
+	containers map[string]ctypes.ContainerInfo
+	registered map[string][]backends.Service
+	stateMtx   sync.Mutex
+
+	proc    *process
+	procMtx sync.Mutex
+}
+
+type PluginOption func(*Backend)
+
+// New creates a backend running command, the name defaults to the base name
+// of the executable.
+func New(command []string, options ...PluginOption) (*Backend, error) {
+	if len(command) == 0 || command[0] == "" {
+		return nil, fmt.Errorf("empty plugin command")
+	}
+
+	b := &Backend{
+		Name:              filepath.Base(command[0]),
+		Log:               logrus.NewEntry(logrus.StandardLogger()),
+		Command:           command,
+		Env:               os.Environ(),
+		CallTimeout:       30 * time.Second,
+		RestartBackoff:    time.Second,
+		MaxRestartBackoff: time.Minute,
+		containers:        map[string]ctypes.ContainerInfo{},
+		registered:        map[string][]backends.Service{},
+	}
+
+	for _, option := range options {
+		option(b)
+	}
+
+	b.Log = b.Log.WithField("plugin", b.Name)
+
+	return b, nil
+}
+
+func (b *Backend) Run(ctx context.Context, events chan ctypes.ContainerEventV2, purgeOnStart bool, containersToRefresh []ctypes.ContainerInfo) error {
+	defer b.Stop()
+
+	err := b.Start(ctx)
+	if err == nil && purgeOnStart {
+		err = b.Purge()
+	}
+	// Refresh keeps the containers for a restart even if the plugin is not
+	// running
+	if refreshErr := b.Refresh(containersToRefresh); err == nil {
+		err = refreshErr
+	}
+	if err != nil {
+		// The plugin is restarted below like one which exited
+		b.Log.Errorf("Could not start plugin: %s", err)
+		b.Stop()
+	}
+
+	backoff := b.RestartBackoff
+	started := time.Now()
+	exited := b.exited()
+	var restart <-chan time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			b.Log.Infof("Plugin exting: %s", "context cancelled")
+			return nil
+		case <-exited:
+			exited = nil
+			// A plugin which ran for a while is not crashing in a loop
+			if time.Since(started) > b.MaxRestartBackoff {
+				backoff = b.RestartBackoff
+			}
+			b.Log.Errorf("Plugin exited: %s, restarting in %s", b.exitErr(), backoff)
+			restart = time.After(backoff)
+			backoff = minDuration(2*backoff, b.MaxRestartBackoff)
+		case <-restart:
+			restart = nil
+			started = time.Now()
+
+			err := b.Start(ctx)
+			if err == nil {
+				b.Log.Infof("Plugin restarted")
+				err = b.Refresh(b.running())
+			}
+			if err != nil {
+				b.Log.Errorf("Could not restart plugin: %s", err)
+				b.Stop()
+			}
+			// If the plugin is gone again the next restart is scheduled
+			exited = b.exited()
+		case event := <-events:
+			b.Log.Debugf("handle event plugin: %s", event.Action)
+
+			switch event.Action {
+			case "start":
+				err := b.Register(ctx, event.Container)
+				if err != nil {
+					b.Log.Errorf("Could not Register: %s", err)
+					continue
+				}
+			case "stop":
+				err := b.Deregister(ctx, event.Container)
+				if err != nil {
+					b.Log.Errorf("Could not Deregister: %s", err)
+					continue
+				}
+			}
+		}
+	}
+}
+
+func (b *Backend) GetName() string {
+	return b.Name
+}
+
+func minDuration(a, b time.Duration) time.Duration {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// Start starts the plugin and sends init, stopping a running one first.
+func (b *Backend) Start(ctx context.Context) error {
+	b.Stop()
+
+	proc, err := startProcess(b.Command, b.Env, b.Log)
+	if err != nil {
+		return err
+	}
+
+	b.procMtx.Lock()
+	b.proc = proc
+	b.procMtx.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, b.CallTimeout)
+	defer cancel()
+
+	var result InitResult
+	err = proc.call(ctx, MethodInit, InitParams{
+		Version:        ProtocolVersion,
+		ID:             b.ID,
+		ForwardAddress: b.ForwardAddress,
+		StaticLabels:   b.StaticLabels,
+	}, &result)
+	if err != nil {
+		// Stop the plugin, so it is restarted like one which exited
+		b.Stop()
+		return fmt.Errorf("could not init: %w", err)
+	}
+	b.Log.Debugf("Started plugin %s", result.Name)
+
+	return nil
+}
+
+// Stop asks the plugin to exit by closing its stdin.
+func (b *Backend) Stop() {
+	b.procMtx.Lock()
+	proc := b.proc
+	b.proc = nil
+	b.procMtx.Unlock()
+
+	if proc != nil {
+		proc.stop(5 * time.Second)
+	}
+}
+
+// exited returns a channel closed once the current plugin exited.
+func (b *Backend) exited() <-chan struct{} {
+	b.procMtx.Lock()
+	defer b.procMtx.Unlock()
+
+	if b.proc == nil {
+		closed := make(chan struct{})
+		close(closed)
+		return closed
+	}
+	return b.proc.done
+}
+
+func (b *Backend) exitErr() error {
+	b.procMtx.Lock()
+	defer b.procMtx.Unlock()
+
+	if b.proc == nil {
+		return errExited
+	}
+	b.proc.pendingMtx.Lock()
+	defer b.proc.pendingMtx.Unlock()
+	return b.proc.err
+}
+
+func (b *Backend) call(ctx context.Context, method string, params interface{}) error {
+	b.procMtx.Lock()
+	proc := b.proc
+	b.procMtx.Unlock()
+
+	if proc == nil {
+		return errExited
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, b.CallTimeout)
+	defer cancel()
+
+	return proc.call(ctx, method, params, nil)
+}
+
+// running returns the running containers sorted by ID.
+func (b *Backend) running() []ctypes.ContainerInfo {
+	b.stateMtx.Lock()
+	defer b.stateMtx.Unlock()
+
+	containers := make([]ctypes.ContainerInfo, 0, len(b.containers))
+	for _, container := range b.containers {
+		containers = append(containers, container)
+	}
+	sort.Slice(containers, func(i, j int) bool {
+		return containers[i].ID < containers[j].ID
+	})
+
+	return containers
+}
+
+func (b *Backend) services(container ctypes.ContainerInfo) []backends.Service {
+	return backends.ServicesForContainer(container, b.AddressStrategy, b.ForwardAddress, b.StaticLabels, nil)
+}
+
+// Register sends a started container to the plugin.
+func (b *Backend) Register(ctx context.Context, container ctypes.ContainerInfo) error {
+	params := ContainerParams{Container: container, Services: b.services(container)}
+
+	b.stateMtx.Lock()
+	b.containers[container.ID] = container
+	b.registered[container.ID] = params.Services
+	b.stateMtx.Unlock()
+
+	return b.call(ctx, MethodRegister, params)
+}
+
+// Deregister sends a stopped container with the services of its register to
+// the plugin.
+func (b *Backend) Deregister(ctx context.Context, container ctypes.ContainerInfo) error {
+	b.stateMtx.Lock()
+	services, ok := b.registered[container.ID]
+	delete(b.containers, container.ID)
+	delete(b.registered, container.ID)
+	b.stateMtx.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	return b.call(ctx, MethodDeregister, ContainerParams{Container: container, Services: services})
+}
+
+// Purge asks the plugin to remove everything it registered.
+func (b *Backend) Purge() error {
+	b.stateMtx.Lock()
+	b.containers = map[string]ctypes.ContainerInfo{}
+	b.registered = map[string][]backends.Service{}
+	b.stateMtx.Unlock()
+
+	return b.call(context.Background(), MethodPurge, nil)
+}
+
+// Refresh sends the complete set of running containers to the plugin.
+func (b *Backend) Refresh(containers []ctypes.ContainerInfo) error {
+	b.Log.Debugf("Refreshing %d plugin containers", len(containers))
+
+	params := RefreshParams{Containers: []ContainerParams{}}
+	b.stateMtx.Lock()
+	b.containers = map[string]ctypes.ContainerInfo{}
+	b.registered = map[string][]backends.Service{}
+	for _, container := range containers {
+		services := b.services(container)
+		b.containers[container.ID] = container
+		b.registered[container.ID] = services
+		params.Containers = append(params.Containers, ContainerParams{Container: container, Services: services})
+	}
+	b.stateMtx.Unlock()
+
+	return b.call(context.Background(), MethodRefresh, params)
+}
+
+func WithLogger(log *logrus.Entry) func(b *Backend) {
+	return func(b *Backend) {
+		b.Log = log.WithField("backend", "plugin")
+	}
+}
+
+func WithForwardAddress(address string) func(b *Backend) {
+	return func(b *Backend) {
+		b.ForwardAddress = address
+	}
+}
+
+func WithStaticLabels(labels []string) func(b *Backend) {
+	return func(b *Backend) {
+		b.StaticLabels = labels
+	}
+}
+
+func WithID(id string) func(b *Backend) {
+	return func(b *Backend) {
+		b.ID = id
+	}
+}
+
+// WithAddressStrategy sets the address strategy, see backends.AddressStrategy.
+func WithAddressStrategy(strategy backends.AddressStrategy) func(b *Backend) {
+	return func(b *Backend) {
+		b.AddressStrategy = strategy
+	}
+}
+
+// WithName sets the backend name, see backends.Settings.
+func WithName(name string) func(b *Backend) {
+	return func(b *Backend) {
+		if name != "" {
+			b.Name = name
+		}
+	}
+}
+
+// WithEnv adds environment variables of the form KEY=value to the plugin's
+// environment.
+func WithEnv(env ...string) func(b *Backend) {
+	return func(b *Backend) {
+		b.Env = append(b.Env, env...)
+	}
+}
+
+// WithRestartBackoff sets the delay before the first restart of a crashed
+// plugin and the maximum delay.
+func WithRestartBackoff(backoff, max time.Duration) func(b *Backend) {
+	return func(b *Backend) {
+		b.RestartBackoff = backoff
+		b.MaxRestartBackoff = max
+	}
+}
+
+// ParseCommand splits a command line at spaces. An optional name= prefix
+// sets the backend name, e.g. registry=/usr/bin/creg-plugin-file /tmp/x.json.
+func ParseCommand(s string) (name string, command []string) {
+	command = strings.Fields(s)
+	if len(command) > 0 {
+		if n, rest, ok := strings.Cut(command[0], "="); ok && !strings.Contains(n, "/") {
+			name = n
+			command[0] = rest
+		}
+	}
+
+	return name, command
+}
@@ -0,0 +1,305 @@
+package plugin_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/soupdiver/creg/backends/backendtest"
+	"github.com/soupdiver/creg/backends/plugin"
+	ctypes "github.com/soupdiver/creg/types"
+)
+
+// When CREG_TEST_PLUGIN is set the test binary is the plugin, it appends
+// every call and its exit to the file CREG_TEST_PLUGIN. Init fails if
+// CREG_TEST_PLUGIN_FAIL_INIT is set, only the first time if it is once.
+func TestMain(m *testing.M) {
+	if path := os.Getenv("CREG_TEST_PLUGIN"); path != "" {
+		h := &testHandler{path: path, failInit: os.Getenv("CREG_TEST_PLUGIN_FAIL_INIT")}
+		err := plugin.Serve(os.Stdin, os.Stdout, h)
+		h.log("exit")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	os.Exit(m.Run())
+}
+
+type testHandler struct {
+	path     string
+	failInit string
+}
+
+func (h *testHandler) log(line string) error {
+	f, err := os.OpenFile(h.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = fmt.Fprintln(f, line)
+	return err
+}
+
+func describe(params plugin.ContainerParams) string {
+	var names []string
+	for _, service := range params.Services {
+		names = append(names, fmt.Sprintf("%s:%d", service.Name, service.Port))
+	}
+	return params.Container.ID + "=" + strings.Join(names, "+")
+}
+
+func (h *testHandler) Init(params plugin.InitParams) (plugin.InitResult, error) {
+	err := h.log(fmt.Sprintf("init %d %s", params.Version, params.ID))
+	if err != nil || h.failInit == "" {
+		return plugin.InitResult{Name: "test"}, err
+	}
+
+	if h.failInit == "once" {
+		_, err := os.Stat(h.path + ".failed")
+		if !errors.Is(err, os.ErrNotExist) {
+			return plugin.InitResult{Name: "test"}, nil
+		}
+		os.WriteFile(h.path+".failed", nil, 0o644)
+	}
+	return plugin.InitResult{}, errors.New("init failed")
+}
+
+func (h *testHandler) Register(params plugin.ContainerParams) error {
+	err := h.log("register " + describe(params))
+	if err != nil {
+		return err
+	}
+
+	// Crash once on the container crash
+	if params.Container.ID == "crash" {
+		_, err := os.Stat(h.path + ".crashed")
+		if errors.Is(err, os.ErrNotExist) {
+			os.WriteFile(h.path+".crashed", nil, 0o644)
+			os.Exit(2)
+		}
+	}
+
+	return nil
+}
+
+func (h *testHandler) Deregister(params plugin.ContainerParams) error {
+	return h.log("deregister " + describe(params))
+}
+
+func (h *testHandler) Refresh(params plugin.RefreshParams) error {
+	var containers []string
+	for _, container := range params.Containers {
+		containers = append(containers, describe(container))
+	}
+	return h.log("refresh " + strings.Join(containers, ","))
+}
+
+func (h *testHandler) Purge() error {
+	return h.log("purge")
+}
+
+func container(id, port, hostPort string) ctypes.ContainerInfo {
+	return ctypes.ContainerInfo{
+		ID:     id,
+		Labels: map[string]string{"creg.port": port + "/tcp:web"},
+		NetworkSettings: ctypes.NetworkSettings{
+			Ports: map[ctypes.Port][]ctypes.PortBinding{ctypes.Port(port + "/tcp"): {{HostIP: "0.0.0.0", HostPort: hostPort}}},
+		},
+	}
+}
+
+func newTestBackend(t *testing.T, env ...string) (*plugin.Backend, string) {
+	path := filepath.Join(t.TempDir(), "calls")
+
+	b, err := plugin.New([]string{os.Args[0]},
+		plugin.WithLogger(backendtest.Logger()),
+		plugin.WithID("test"),
+		plugin.WithForwardAddress("10.0.0.1"),
+		plugin.WithEnv("CREG_TEST_PLUGIN="+path),
+		plugin.WithRestartBackoff(10*time.Millisecond, time.Second),
+		plugin.WithEnv(env...),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b, path
+}
+
+// waitForCalls waits until the plugin received the expected calls.
+func waitForCalls(t *testing.T, path string, expected ...string) {
+	t.Helper()
+
+	var calls string
+	for i := 0; i < 500; i++ {
+		data, _ := os.ReadFile(path)
+		calls = strings.TrimSpace(string(data))
+		if calls == strings.Join(expected, "\n") {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("expected calls\n%s\ngot\n%s", strings.Join(expected, "\n"), calls)
+}
+
+func TestRun(t *testing.T) {
+	b, path := newTestBackend(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan ctypes.ContainerEventV2)
+	done := make(chan error)
+	go func() {
+		done <- b.Run(ctx, events, true, []ctypes.ContainerInfo{container("a", "80", "8080")})
+	}()
+
+	events <- ctypes.ContainerEventV2{Action: "start", Container: container("b", "80", "8081")}
+	// The services of the register are sent, not the ones of the event
+	events <- ctypes.ContainerEventV2{Action: "stop", Container: container("b", "81", "9000")}
+	// Unknown containers are not sent
+	events <- ctypes.ContainerEventV2{Action: "stop", Container: container("c", "80", "8082")}
+
+	waitForCalls(t, path,
+		"init 1 test",
+		"purge",
+		"refresh a=web:8080",
+		"register b=web:8081",
+		"deregister b=web:8081",
+	)
+
+	cancel()
+	err := <-done
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRestartAfterCrash(t *testing.T) {
+	b, path := newTestBackend(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan ctypes.ContainerEventV2)
+	done := make(chan error)
+	go func() {
+		done <- b.Run(ctx, events, false, []ctypes.ContainerInfo{container("a", "80", "8080")})
+	}()
+
+	events <- ctypes.ContainerEventV2{Action: "start", Container: container("crash", "80", "8081")}
+
+	// The restarted plugin gets all running containers, including the one
+	// it crashed on
+	waitForCalls(t, path,
+		"init 1 test",
+		"refresh a=web:8080",
+		"register crash=web:8081",
+		"init 1 test",
+		"refresh a=web:8080,crash=web:8081",
+	)
+
+	events <- ctypes.ContainerEventV2{Action: "stop", Container: container("a", "80", "8080")}
+
+	waitForCalls(t, path,
+		"init 1 test",
+		"refresh a=web:8080",
+		"register crash=web:8081",
+		"init 1 test",
+		"refresh a=web:8080,crash=web:8081",
+		"deregister a=web:8080",
+	)
+
+	cancel()
+	err := <-done
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStopAfterFailedInit(t *testing.T) {
+	b, path := newTestBackend(t, "CREG_TEST_PLUGIN_FAIL_INIT=1")
+
+	err := b.Start(context.Background())
+	if err == nil {
+		t.Fatal("expected failing init to return an error")
+	}
+
+	// The plugin is stopped before Start returns
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls := strings.TrimSpace(string(data)); calls != "init 1 test\nexit" {
+		t.Fatalf("expected calls\n%s\ngot\n%s", "init 1 test\nexit", calls)
+	}
+}
+
+func TestRestartAfterFailedStart(t *testing.T) {
+	b, path := newTestBackend(t, "CREG_TEST_PLUGIN_FAIL_INIT=once")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan ctypes.ContainerEventV2)
+	done := make(chan error)
+	go func() {
+		done <- b.Run(ctx, events, false, []ctypes.ContainerInfo{container("a", "80", "8080")})
+	}()
+
+	// The restarted plugin gets the containers of the failed start
+	waitForCalls(t, path,
+		"init 1 test",
+		"exit",
+		"init 1 test",
+		"refresh a=web:8080",
+	)
+
+	cancel()
+	err := <-done
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStopWithChildHoldingOutput(t *testing.T) {
+	// The plugin never answers init and its child keeps stdout open after
+	// the plugin exited
+	b, err := plugin.New([]string{"sh", "-c", "sleep 30 & exec cat >/dev/null"},
+		plugin.WithLogger(backendtest.Logger()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.CallTimeout = 10 * time.Millisecond
+
+	done := make(chan error)
+	go func() {
+		done <- b.Start(context.Background())
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("expected init to time out")
+		}
+	case <-time.After(15 * time.Second):
+		t.Fatal("plugin was not stopped")
+	}
+}
+
+func TestParseCommand(t *testing.T) {
+	name, command := plugin.ParseCommand("registry=/usr/bin/creg-plugin-file /tmp/services.json")
+	if name != "registry" || strings.Join(command, " ") != "/usr/bin/creg-plugin-file /tmp/services.json" {
+		t.Fatalf("expected registry and command, got %q %q", name, command)
+	}
+
+	name, command = plugin.ParseCommand("/usr/bin/creg-plugin-file --opt=x")
+	if name != "" || strings.Join(command, " ") != "/usr/bin/creg-plugin-file --opt=x" {
+		t.Fatalf("expected no name, got %q %q", name, command)
+	}
+}
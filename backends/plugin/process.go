@@ -0,0 +1,224 @@
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// maxLineSize limits a single request or response, a refresh with many
+// containers is the largest message.
+const maxLineSize = 16 * 1024 * 1024
+
+var errExited = errors.New("plugin exited")
+
+// process is a running plugin.
+type process struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+	stderr io.ReadCloser
+	log    *logrus.Entry
+
+	writeMtx sync.Mutex
+
+	pending    map[uint64]chan Response
+	pendingMtx sync.Mutex
+	nextID     uint64
+
+	// done is closed once the plugin exited, err holds why
+	done chan struct{}
+	err  error
+}
+
+func startProcess(command []string, env []string, log *logrus.Entry) (*process, error) {
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Env = env
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &process{
+		cmd:     cmd,
+		stdin:   stdin,
+		stdout:  stdout,
+		stderr:  stderr,
+		log:     log,
+		pending: map[uint64]chan Response{},
+		done:    make(chan struct{}),
+	}
+
+	var output sync.WaitGroup
+	output.Add(2)
+	go func() {
+		defer output.Done()
+		p.readResponses(stdout)
+	}()
+	go func() {
+		defer output.Done()
+		p.readLog(stderr)
+	}()
+
+	go func() {
+		// Wait must only be called once all output has been read
+		output.Wait()
+		err := cmd.Wait()
+		if err == nil {
+			err = errExited
+		}
+
+		p.pendingMtx.Lock()
+		p.err = err
+		for id, c := range p.pending {
+			close(c)
+			delete(p.pending, id)
+		}
+		p.pendingMtx.Unlock()
+
+		close(p.done)
+	}()
+
+	return p, nil
+}
+
+func (p *process) readResponses(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	for scanner.Scan() {
+		var res Response
+		err := json.Unmarshal(scanner.Bytes(), &res)
+		if err != nil {
+			p.log.Errorf("Invalid response: %s", err)
+			continue
+		}
+
+		p.pendingMtx.Lock()
+		c, ok := p.pending[res.ID]
+		delete(p.pending, res.ID)
+		p.pendingMtx.Unlock()
+
+		if !ok {
+			p.log.Errorf("Response to unknown request %d", res.ID)
+			continue
+		}
+		c <- res
+	}
+
+	// The output is closed by stop
+	if err := scanner.Err(); err != nil && !errors.Is(err, os.ErrClosed) {
+		p.log.Errorf("Could not read responses: %s", err)
+		// Stop the plugin, it cannot be talked to anymore
+		p.cmd.Process.Kill()
+		io.Copy(io.Discard, r)
+	}
+}
+
+func (p *process) readLog(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		p.log.Info(scanner.Text())
+	}
+}
+
+// call sends a request and decodes the result into result if it is not nil.
+func (p *process) call(ctx context.Context, method string, params, result interface{}) error {
+	req := Request{Method: method}
+	if params != nil {
+		data, err := json.Marshal(params)
+		if err != nil {
+			return fmt.Errorf("could not encode params: %w", err)
+		}
+		req.Params = data
+	}
+
+	c := make(chan Response, 1)
+	p.pendingMtx.Lock()
+	if p.err != nil {
+		p.pendingMtx.Unlock()
+		return p.err
+	}
+	p.nextID++
+	req.ID = p.nextID
+	p.pending[req.ID] = c
+	p.pendingMtx.Unlock()
+
+	line, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	p.writeMtx.Lock()
+	_, err = p.stdin.Write(append(line, '\n'))
+	p.writeMtx.Unlock()
+	if err != nil {
+		p.forget(req.ID)
+		return fmt.Errorf("could not send request: %w", err)
+	}
+
+	select {
+	case res, ok := <-c:
+		if !ok {
+			return p.err
+		}
+		if res.Error != "" {
+			return errors.New(res.Error)
+		}
+		if result != nil && len(res.Result) > 0 {
+			err := json.Unmarshal(res.Result, result)
+			if err != nil {
+				return fmt.Errorf("could not decode result: %w", err)
+			}
+		}
+		return nil
+	case <-ctx.Done():
+		p.forget(req.ID)
+		return ctx.Err()
+	}
+}
+
+func (p *process) forget(id uint64) {
+	p.pendingMtx.Lock()
+	delete(p.pending, id)
+	p.pendingMtx.Unlock()
+}
+
+// stop closes stdin and kills the plugin if it did not exit within timeout.
+func (p *process) stop(timeout time.Duration) {
+	p.stdin.Close()
+
+	select {
+	case <-p.done:
+	case <-time.After(timeout):
+		p.log.Errorf("Plugin did not exit within %s, killing it", timeout)
+		p.cmd.Process.Kill()
+		// Children of the plugin may still hold its output open, which would
+		// keep done from being closed
+		p.stdout.Close()
+		p.stderr.Close()
+		<-p.done
+	}
+}
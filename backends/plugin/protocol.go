@@ -0,0 +1,75 @@
+// Package plugin runs backends as external programs. creg starts the plugin
+// and talks to it with JSON-lines over its stdin and stdout: every line creg
+// writes is a Request, the plugin answers each with a Response carrying the
+// same ID, in any order. Lines the plugin writes to stderr are logged by
+// creg. The plugin exits when its stdin is closed.
+//
+// The methods are
+//
+//	init        InitParams      -> InitResult, always the first request
+//	register    ContainerParams -> null, a container started
+//	deregister  ContainerParams -> null, a container stopped
+//	refresh     RefreshParams   -> null, the complete set of running containers
+//	purge       null            -> null, remove everything the plugin registered
+//
+// Services are computed by creg from the creg.port label, so plugins do not
+// have to parse labels. For deregister the services are the ones sent with
+// the register of the container. A plugin which crashes is restarted and gets
+// an init and a refresh with all running containers, so plugins do not have
+// to persist anything. Serve implements the plugin side of the protocol.
+package plugin
+
+import (
+	"encoding/json"
+
+	"github.com/soupdiver/creg/backends"
+	ctypes "github.com/soupdiver/creg/types"
+)
+
+// ProtocolVersion is sent with init, it changes with incompatible changes of
+// the protocol.
+const ProtocolVersion = 1
+
+const (
+	MethodInit       = "init"
+	MethodRegister   = "register"
+	MethodDeregister = "deregister"
+	MethodRefresh    = "refresh"
+	MethodPurge      = "purge"
+)
+
+type Request struct {
+	ID     uint64          `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+// Response answers the request with ID. The request failed if Error is not
+// empty.
+type Response struct {
+	ID     uint64          `json:"id"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+type InitParams struct {
+	Version        int      `json:"version"`
+	ID             string   `json:"id"`
+	ForwardAddress string   `json:"forward_address"`
+	StaticLabels   []string `json:"static_labels"`
+}
+
+type InitResult struct {
+	// Name is used in log messages
+	Name string `json:"name"`
+}
+
+// ContainerParams is a container and its services.
+type ContainerParams struct {
+	Container ctypes.ContainerInfo `json:"container"`
+	Services  []backends.Service   `json:"services"`
+}
+
+type RefreshParams struct {
+	Containers []ContainerParams `json:"containers"`
+}
@@ -0,0 +1,86 @@
+package plugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Handler implements a plugin, see the package documentation for the
+// meaning of the methods.
+type Handler interface {
+	Init(params InitParams) (InitResult, error)
+	Register(params ContainerParams) error
+	Deregister(params ContainerParams) error
+	Refresh(params RefreshParams) error
+	Purge() error
+}
+
+// Serve reads requests from r, usually os.Stdin, and writes the responses of
+// handler to w, usually os.Stdout. Requests are handled one at a time. It
+// returns nil once r is closed.
+func Serve(r io.Reader, w io.Writer, handler Handler) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLineSize)
+	enc := json.NewEncoder(w)
+
+	for scanner.Scan() {
+		var req Request
+		err := json.Unmarshal(scanner.Bytes(), &req)
+		if err != nil {
+			return fmt.Errorf("could not decode request: %w", err)
+		}
+
+		res := Response{ID: req.ID}
+		result, err := handle(handler, req)
+		if err != nil {
+			res.Error = err.Error()
+		} else if result != nil {
+			res.Result, err = json.Marshal(result)
+			if err != nil {
+				res.Error = err.Error()
+			}
+		}
+
+		err = enc.Encode(res)
+		if err != nil {
+			return fmt.Errorf("could not write response: %w", err)
+		}
+	}
+
+	return scanner.Err()
+}
+
+func handle(handler Handler, req Request) (interface{}, error) {
+	switch req.Method {
+	case MethodInit:
+		var params InitParams
+		err := json.Unmarshal(req.Params, &params)
+		if err != nil {
+			return nil, err
+		}
+		return handler.Init(params)
+	case MethodRegister, MethodDeregister:
+		var params ContainerParams
+		err := json.Unmarshal(req.Params, &params)
+		if err != nil {
+			return nil, err
+		}
+		if req.Method == MethodRegister {
+			return nil, handler.Register(params)
+		}
+		return nil, handler.Deregister(params)
+	case MethodRefresh:
+		var params RefreshParams
+		err := json.Unmarshal(req.Params, &params)
+		if err != nil {
+			return nil, err
+		}
+		return nil, handler.Refresh(params)
+	case MethodPurge:
+		return nil, handler.Purge()
+	default:
+		return nil, fmt.Errorf("unknown method: %q", req.Method)
+	}
+}
@@ -0,0 +1,104 @@
+package backends
+
+import (
+	"fmt"
+	"sort"
+
+	ctypes "github.com/soupdiver/creg/types"
+)
+
+// LabelPortMode selects whether the services of a container are registered
+// with their host or container port. By default services reached on the
+// container IP use the container port and all others the host port.
+const LabelPortMode = "creg.port-mode"
+
+// Port modes
+const (
+	// PortHost registers services with the port they are published on
+	PortHost = "host"
+	// PortContainer registers services with the container port
+	PortContainer = "container"
+)
+
+// UseContainerPort reports whether the services of container are registered
+// with their container port. direct is true if they are reached on the
+// container IP.
+func UseContainerPort(container ctypes.ContainerInfo, direct bool) bool {
+	switch container.Labels[LabelPortMode] {
+	case PortContainer:
+		return true
+	case PortHost:
+		return false
+	default:
+		return direct
+	}
+}
+
+// HostPort returns the host port port is published on, or the port number of
+// port if it is not published. Ports bound to several host IPs, e.g. IPv4 and
+// IPv6, are published on the same host port.
+func HostPort(settings ctypes.NetworkSettings, port ctypes.Port) string {
+	if v := publishedPort(settings.Ports[port]); v != "" {
+		return v
+	}
+
+	return port.Port()
+}
+
+// PublishedPort returns the host port the tcp containerPort is published on.
+// If containerPort is empty the only published tcp port is used.
+func PublishedPort(settings ctypes.NetworkSettings, containerPort string) (string, error) {
+	published := map[string]string{}
+	for port, bindings := range settings.Ports {
+		if v := publishedPort(bindings); port.Proto() == "tcp" && v != "" {
+			published[port.Port()] = v
+		}
+	}
+
+	if containerPort != "" {
+		if v, ok := published[containerPort]; ok {
+			return v, nil
+		}
+		return "", fmt.Errorf("port %s is not published", containerPort)
+	}
+
+	if len(published) == 1 {
+		for _, v := range published {
+			return v, nil
+		}
+	}
+
+	return "", fmt.Errorf("%d published ports and no port selected", len(published))
+}
+
+// ExposedPort returns the tcp containerPort, or the only tcp port the
+// container exposes if it is empty.
+func ExposedPort(settings ctypes.NetworkSettings, containerPort string) (string, error) {
+	if containerPort != "" {
+		return containerPort, nil
+	}
+
+	var exposed []string
+	for port := range settings.Ports {
+		if port.Proto() == "tcp" {
+			exposed = append(exposed, port.Port())
+		}
+	}
+	sort.Strings(exposed)
+	if len(exposed) == 1 {
+		return exposed[0], nil
+	}
+
+	return "", fmt.Errorf("%d exposed ports and no port selected", len(exposed))
+}
+
+// publishedPort returns the first host port of bindings.
+func publishedPort(bindings []ctypes.PortBinding) string {
+	for _, binding := range bindings {
+		if binding.HostPort != "" {
+			return binding.HostPort
+		}
+	}
+
+	return ""
+}
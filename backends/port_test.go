@@ -0,0 +1,71 @@
+package backends_test
+
+import (
+	"testing"
+
+	"github.com/soupdiver/creg/backends"
+	ctypes "github.com/soupdiver/creg/types"
+)
+
+func TestHostPort(t *testing.T) {
+	settings := ctypes.NetworkSettings{
+		Ports: map[ctypes.Port][]ctypes.PortBinding{
+			"80/tcp":   {{HostIP: "0.0.0.0", HostPort: "8080"}, {HostIP: "::", HostPort: "8080"}},
+			"53/udp":   {{HostIP: "127.0.0.1"}, {HostIP: "0.0.0.0", HostPort: "5353"}},
+			"9000/tcp": {},
+		},
+	}
+
+	tests := map[ctypes.Port]string{
+		"80/tcp":   "8080",
+		"53/udp":   "5353",
+		"9000/tcp": "9000",
+		"443/tcp":  "443",
+	}
+	for port, expected := range tests {
+		if v := backends.HostPort(settings, port); v != expected {
+			t.Fatalf("%s: expected %s, got %s", port, expected, v)
+		}
+	}
+
+	port, err := backends.PublishedPort(settings, "")
+	if err != nil {
+		t.Fatalf("expected no error, got %s", err)
+	}
+	if port != "8080" {
+		t.Fatalf("expected 8080, got %s", port)
+	}
+	if _, err := backends.PublishedPort(settings, "9000"); err == nil {
+		t.Fatalf("expected error for unpublished port")
+	}
+}
+
+func TestPortMode(t *testing.T) {
+	container := ctypes.ContainerInfo{
+		Labels: map[string]string{
+			backends.ServiceLabelPort: "80/tcp:web",
+			backends.LabelPortMode:    backends.PortContainer,
+		},
+		NetworkSettings: ctypes.NetworkSettings{
+			Ports: map[ctypes.Port][]ctypes.PortBinding{
+				"80/tcp": {{HostIP: "0.0.0.0", HostPort: "8080"}},
+			},
+			Networks: map[string]ctypes.Network{"overlay": {IPAddress: "10.10.0.5"}},
+		},
+	}
+
+	services := backends.ServicesForContainer(container, backends.AddressStrategy{}, "10.0.0.1", nil, nil)
+	if len(services) != 1 || services[0].Address != "10.0.0.1" || services[0].Port != 80 {
+		t.Fatalf("expected 10.0.0.1:80, got %+v", services)
+	}
+
+	container.Labels[backends.LabelPortMode] = backends.PortHost
+	network := backends.AddressStrategy{Mode: backends.AddressNetwork}
+	address, port := network.Endpoint(container, "80/tcp", "10.0.0.1")
+	if address != "10.10.0.5" || port != "8080" {
+		t.Fatalf("expected 10.10.0.5:8080, got %s:%s", address, port)
+	}
+	if _, _, err := network.TCPEndpoint(container, "9000", "10.0.0.1"); err == nil {
+		t.Fatalf("expected error for unpublished port with host port mode")
+	}
+}
@@ -0,0 +1,56 @@
+package powerdns
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/soupdiver/creg/backends"
+	"github.com/soupdiver/creg/powerdns/client"
+)
+
+// BackendConfig configures a powerdns backend in the config file.
+type BackendConfig struct {
+	// Endpoint of the API, e.g. http://localhost:8081
+	Endpoint string `yaml:"endpoint"`
+	Zone     string `yaml:"zone"`
+	// Server ID
+	Server string `yaml:"server"`
+	TTL    uint32 `yaml:"ttl"`
+	// Target of SRV records, defaults to the service A record
+	Target string `yaml:"target"`
+	// KeyFile contains the API key
+	KeyFile string `yaml:"key_file"`
+}
+
+func init() {
+	backends.Register("powerdns", backends.Factory{
+		Config: func() interface{} {
+			return &BackendConfig{Server: "localhost", TTL: 60}
+		},
+		New: func(config interface{}, settings backends.Settings) (backends.Backend, error) {
+			cfg := config.(*BackendConfig)
+
+			var clientOptions []client.ClientOption
+			if cfg.KeyFile != "" {
+				key, err := os.ReadFile(cfg.KeyFile)
+				if err != nil {
+					return nil, fmt.Errorf("could not read powerdns api key: %w", err)
+				}
+				clientOptions = append(clientOptions, client.WithAPIKey(strings.TrimSpace(string(key))))
+			}
+
+			return New(cfg.Endpoint, cfg.Zone,
+				WithName(settings.Name),
+				WithLogger(settings.Log),
+				WithID(settings.ID),
+				WithForwardAddress(settings.ForwardAddress),
+				WithAddressStrategy(settings.AddressStrategy),
+				WithServer(cfg.Server),
+				WithTTL(cfg.TTL),
+				WithTarget(cfg.Target),
+				WithClientOptions(clientOptions...),
+			)
+		},
+	})
+}
@@ -0,0 +1,384 @@
+package powerdns
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+
+	"github.com/soupdiver/creg/backends"
+	"github.com/soupdiver/creg/backends/dnsutil"
+	"github.com/soupdiver/creg/powerdns"
+	"github.com/soupdiver/creg/powerdns/client"
+	ctypes "github.com/soupdiver/creg/types"
+)
+
+// CommentAccount is the account of the comment marking rrsets managed by
+// creg.
+const CommentAccount = "creg"
+
+// Backend manages A/AAAA and SRV records in a zone of a PowerDNS
+// authoritative server through its HTTP API, see dnsutil.Records for the
+// records created per service. Records of all containers are merged into
+// rrsets which are replaced as a whole. Every rrset creg manages carries a
+// comment with the account creg and the content Marker, rrsets without it
+// are never changed.
+type Backend struct {
+	Name            string
+	Client          *client.Client
+	Log             *logrus.Entry
+	ForwardAddress  string
+	AddressStrategy backends.AddressStrategy
+	// Server is the PowerDNS server ID, localhost for a single server
+	Server string
+	Zone   string
+	TTL    uint32
+	// Target is the SRV target. It defaults to the A record of the service.
+	Target string
+	Marker string
+
+	// records holds the records of each container by container ID
+	records    map[string][]dns.RR
+	recordsMtx sync.Mutex
+
+	clientOptions []client.ClientOption
+}
+
+type PowerDNSOption func(*Backend)
+
+// New creates a backend managing zone through the API at endpoint, e.g.
+// http://localhost:8081.
+func New(endpoint, zone string, options ...PowerDNSOption) (*Backend, error) {
+	b := &Backend{
+		Name:    "powerdns",
+		Log:     logrus.NewEntry(logrus.StandardLogger()),
+		Server:  "localhost",
+		Zone:    dns.Fqdn(strings.ToLower(zone)),
+		TTL:     60,
+		Marker:  "managed by creg",
+		records: map[string][]dns.RR{},
+	}
+
+	for _, option := range options {
+		option(b)
+	}
+
+	if _, ok := dns.IsDomainName(b.Zone); !ok || zone == "" {
+		return nil, fmt.Errorf("invalid zone: %q", zone)
+	}
+
+	c, err := client.New(endpoint, b.clientOptions...)
+	if err != nil {
+		return nil, fmt.Errorf("could not create powerdns client: %w", err)
+	}
+	b.Client = c
+
+	return b, nil
+}
+
+func (b *Backend) Run(ctx context.Context, events chan ctypes.ContainerEventV2, purgeOnStart bool, containersToRefresh []ctypes.ContainerInfo) error {
+	var err error
+	if purgeOnStart {
+		err = b.Purge()
+		if err != nil {
+			return fmt.Errorf("could not purge: %w", err)
+		}
+	}
+
+	// Always refresh, this removes records of containers which stopped while
+	// creg was not running
+	err = b.Refresh(containersToRefresh)
+	if err != nil {
+		return fmt.Errorf("could not refresh: %w", err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			b.Log.Infof("PowerDNS exting: %s", "context cancelled")
+			return nil
+		case event := <-events:
+			b.Log.Debugf("handle event powerdns: %s", event.Action)
+
+			b.recordsMtx.Lock()
+			switch event.Action {
+			case "start":
+				b.setContainer(event.Container)
+			case "stop":
+				delete(b.records, event.Container.ID)
+			default:
+				b.recordsMtx.Unlock()
+				continue
+			}
+			b.recordsMtx.Unlock()
+
+			err := b.Sync(ctx)
+			if err != nil {
+				b.Log.Errorf("Could not Sync: %s", err)
+				continue
+			}
+		}
+	}
+}
+
+func (b *Backend) GetName() string {
+	return b.Name
+}
+
+// RecordsForContainer returns the A/AAAA and SRV records for the services in
+// the creg.port label of container.
+func (b *Backend) RecordsForContainer(container ctypes.ContainerInfo) []dns.RR {
+	services := backends.ServicesForContainer(container, b.AddressStrategy, b.ForwardAddress, nil, nil)
+
+	records, err := dnsutil.Records(services, b.Zone, b.TTL, b.Target)
+	if err != nil {
+		b.Log.Errorf("Could not build records: %s", err)
+	}
+
+	return records
+}
+
+// setContainer must be called with recordsMtx held.
+func (b *Backend) setContainer(container ctypes.ContainerInfo) {
+	records := b.RecordsForContainer(container)
+	if len(records) == 0 {
+		delete(b.records, container.ID)
+		return
+	}
+	b.records[container.ID] = records
+}
+
+// Owns reports whether rrset is managed by this instance.
+func (b *Backend) Owns(rrset powerdns.RRSet) bool {
+	for _, comment := range rrset.Comments {
+		if comment.Account == CommentAccount && comment.Content == b.Marker {
+			return true
+		}
+	}
+	return false
+}
+
+func rrsetKey(name, rrtype string) string {
+	return strings.ToLower(name) + " " + rrtype
+}
+
+// RRSets returns the wanted rrsets by name and type.
+func (b *Backend) RRSets() map[string]powerdns.RRSet {
+	b.recordsMtx.Lock()
+	contents := map[string]map[string]struct{}{}
+	rrsets := map[string]powerdns.RRSet{}
+	for _, records := range b.records {
+		for _, rr := range records {
+			hdr := rr.Header()
+			rrtype := dns.TypeToString[hdr.Rrtype]
+			key := rrsetKey(hdr.Name, rrtype)
+			if _, ok := rrsets[key]; !ok {
+				rrsets[key] = powerdns.RRSet{
+					Name:     hdr.Name,
+					Type:     rrtype,
+					TTL:      hdr.Ttl,
+					Comments: []powerdns.Comment{{Content: b.Marker, Account: CommentAccount}},
+				}
+				contents[key] = map[string]struct{}{}
+			}
+			contents[key][strings.TrimPrefix(rr.String(), hdr.String())] = struct{}{}
+		}
+	}
+	b.recordsMtx.Unlock()
+
+	for key, rrset := range rrsets {
+		for content := range contents[key] {
+			rrset.Records = append(rrset.Records, powerdns.Record{Content: content})
+		}
+		sort.Slice(rrset.Records, func(i, j int) bool {
+			return rrset.Records[i].Content < rrset.Records[j].Content
+		})
+		rrsets[key] = rrset
+	}
+
+	return rrsets
+}
+
+// changed reports whether the existing rrset differs from the wanted one.
+// Comments are only compared by their marker, PowerDNS adds modified_at.
+func changed(existing, want powerdns.RRSet) bool {
+	if existing.TTL != want.TTL || len(existing.Records) != len(want.Records) {
+		return true
+	}
+
+	records := append([]powerdns.Record(nil), existing.Records...)
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Content < records[j].Content
+	})
+
+	return !reflect.DeepEqual(records, want.Records)
+}
+
+// Sync makes the creg rrsets of the zone match the wanted rrsets in a single
+// PATCH. Wanted rrsets which exist but are not managed by creg are skipped.
+func (b *Backend) Sync(ctx context.Context) error {
+	wanted := b.RRSets()
+
+	zone, err := b.Client.Zone(ctx, b.Server, b.Zone)
+	if err != nil {
+		return fmt.Errorf("could not get zone: %w", err)
+	}
+
+	var errs []error
+	var changes []powerdns.RRSet
+	seen := map[string]struct{}{}
+	for _, rrset := range zone.RRSets {
+		key := rrsetKey(rrset.Name, rrset.Type)
+		seen[key] = struct{}{}
+		want, ok := wanted[key]
+
+		if !b.Owns(rrset) {
+			if ok {
+				errs = append(errs, fmt.Errorf("%s %s exists and is not managed by creg", rrset.Name, rrset.Type))
+			}
+			continue
+		}
+
+		if !ok {
+			b.Log.Debugf("Delete rrset: %s %s", rrset.Name, rrset.Type)
+			changes = append(changes, powerdns.RRSet{
+				Name:       rrset.Name,
+				Type:       rrset.Type,
+				ChangeType: powerdns.ChangeTypeDelete,
+				Records:    []powerdns.Record{},
+				Comments:   []powerdns.Comment{},
+			})
+			continue
+		}
+
+		if changed(rrset, want) {
+			b.Log.Debugf("Replace rrset: %s %s", rrset.Name, rrset.Type)
+			want.ChangeType = powerdns.ChangeTypeReplace
+			changes = append(changes, want)
+		}
+	}
+
+	var keys []string
+	for key := range wanted {
+		if _, ok := seen[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		want := wanted[key]
+		b.Log.Debugf("Add rrset: %s %s", want.Name, want.Type)
+		want.ChangeType = powerdns.ChangeTypeReplace
+		changes = append(changes, want)
+	}
+
+	if len(changes) > 0 {
+		err = b.Client.PatchRRSets(ctx, b.Server, b.Zone, changes)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not patch zone: %w", err))
+		}
+	}
+
+	return backends.JoinErrors(errs)
+}
+
+// Purge deletes all rrsets owned by this instance.
+func (b *Backend) Purge() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	b.recordsMtx.Lock()
+	b.records = map[string][]dns.RR{}
+	b.recordsMtx.Unlock()
+
+	return b.Sync(ctx)
+}
+
+// Refresh replaces the records with the ones of containers.
+func (b *Backend) Refresh(containers []ctypes.ContainerInfo) error {
+	b.Log.Debugf("Refreshing %d powerdns containers", len(containers))
+
+	b.recordsMtx.Lock()
+	b.records = map[string][]dns.RR{}
+	for _, container := range containers {
+		b.setContainer(container)
+	}
+	b.recordsMtx.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	return b.Sync(ctx)
+}
+
+func WithLogger(log *logrus.Entry) func(b *Backend) {
+	return func(b *Backend) {
+		b.Log = log.WithField("backend", "powerdns")
+	}
+}
+
+func WithForwardAddress(address string) func(b *Backend) {
+	return func(b *Backend) {
+		b.ForwardAddress = address
+	}
+}
+
+func WithTTL(ttl uint32) func(b *Backend) {
+	return func(b *Backend) {
+		b.TTL = ttl
+	}
+}
+
+func WithTarget(target string) func(b *Backend) {
+	return func(b *Backend) {
+		b.Target = target
+	}
+}
+
+// WithServer sets the PowerDNS server ID.
+func WithServer(server string) func(b *Backend) {
+	return func(b *Backend) {
+		if server != "" {
+			b.Server = server
+		}
+	}
+}
+
+// WithID makes the rrsets of several creg instances sharing one zone
+// distinguishable.
+func WithID(id string) func(b *Backend) {
+	return func(b *Backend) {
+		if id != "" {
+			b.Marker = "managed by creg " + id
+		}
+	}
+}
+
+func WithClientOptions(options ...client.ClientOption) func(b *Backend) {
+	return func(b *Backend) {
+		b.clientOptions = append(b.clientOptions, options...)
+	}
+}
+
+// WithName sets the backend name, see backends.Settings.
+func WithName(name string) func(b *Backend) {
+	return func(b *Backend) {
+		if name != "" {
+			b.Name = name
+		}
+	}
+}
+
+// WithAddressStrategy sets the address strategy, see backends.AddressStrategy.
+func WithAddressStrategy(strategy backends.AddressStrategy) func(b *Backend) {
+	return func(b *Backend) {
+		b.AddressStrategy = strategy
+	}
+}
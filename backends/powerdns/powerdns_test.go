@@ -0,0 +1,188 @@
+package powerdns_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/soupdiver/creg/backends/backendtest"
+	powerdnsbackend "github.com/soupdiver/creg/backends/powerdns"
+	"github.com/soupdiver/creg/powerdns"
+	"github.com/soupdiver/creg/powerdns/client"
+	ctypes "github.com/soupdiver/creg/types"
+)
+
+// fakePowerDNS serves the zone example.org. of server localhost.
+type fakePowerDNS struct {
+	mtx     sync.Mutex
+	rrsets  map[string]powerdns.RRSet
+	patches int
+}
+
+func (f *fakePowerDNS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if r.Header.Get("X-API-Key") != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if r.URL.Path != "/api/v1/servers/localhost/zones/example.org." {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(powerdns.ErrorResponse{Error: "Could not find domain"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		zone := powerdns.Zone{ID: "example.org.", Name: "example.org."}
+		for _, rrset := range f.rrsets {
+			zone.RRSets = append(zone.RRSets, rrset)
+		}
+		json.NewEncoder(w).Encode(zone)
+	case http.MethodPatch:
+		var req powerdns.PatchRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		f.patches++
+		for _, rrset := range req.RRSets {
+			key := rrset.Name + " " + rrset.Type
+			switch rrset.ChangeType {
+			case powerdns.ChangeTypeReplace:
+				rrset.ChangeType = ""
+				f.rrsets[key] = rrset
+			case powerdns.ChangeTypeDelete:
+				delete(f.rrsets, key)
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (f *fakePowerDNS) keys() string {
+	var keys []string
+	for key := range f.rrsets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
+
+func (f *fakePowerDNS) contents(key string) string {
+	var contents []string
+	for _, record := range f.rrsets[key].Records {
+		contents = append(contents, record.Content)
+	}
+	return strings.Join(contents, ",")
+}
+
+func newTestBackend(t *testing.T) (*powerdnsbackend.Backend, *fakePowerDNS) {
+	f := &fakePowerDNS{rrsets: map[string]powerdns.RRSet{
+		"example.org. SOA":  {Name: "example.org.", Type: "SOA", Records: []powerdns.Record{{Content: "ns.example.org. hostmaster.example.org. 1 10800 3600 604800 3600"}}},
+		"db.example.org. A": {Name: "db.example.org.", Type: "A", TTL: 300, Records: []powerdns.Record{{Content: "10.0.0.9"}}},
+		// Left by a previous run
+		"old.example.org. A": {
+			Name: "old.example.org.", Type: "A", TTL: 60,
+			Records:  []powerdns.Record{{Content: "10.0.0.1"}},
+			Comments: []powerdns.Comment{{Content: "managed by creg test", Account: "creg"}},
+		},
+		// Managed by another creg instance
+		"other.example.org. A": {
+			Name: "other.example.org.", Type: "A", TTL: 60,
+			Records:  []powerdns.Record{{Content: "10.0.0.2"}},
+			Comments: []powerdns.Comment{{Content: "managed by creg other", Account: "creg"}},
+		},
+	}}
+	server := httptest.NewServer(f)
+	t.Cleanup(server.Close)
+
+	b, err := powerdnsbackend.New(server.URL, "example.org",
+		powerdnsbackend.WithLogger(backendtest.Logger()),
+		powerdnsbackend.WithID("test"),
+		powerdnsbackend.WithForwardAddress("10.0.0.1"),
+		powerdnsbackend.WithClientOptions(client.WithAPIKey("secret")),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b, f
+}
+
+func TestRefreshReplacesRRSetsOfPreviousRun(t *testing.T) {
+	b, f := newTestBackend(t)
+
+	backendtest.Refresh(t, b, backendtest.Container("a", "8080"), backendtest.Container("b", "8081"))
+
+	expected := "_web._tcp.example.org. SRV,db.example.org. A,example.org. SOA,other.example.org. A,web.example.org. A"
+	if keys := f.keys(); keys != expected {
+		t.Fatalf("expected %s, got %s", expected, keys)
+	}
+	if contents := f.contents("web.example.org. A"); contents != "10.0.0.1" {
+		t.Fatalf("expected 10.0.0.1, got %s", contents)
+	}
+	if contents := f.contents("_web._tcp.example.org. SRV"); contents != "0 0 8080 web.example.org.,0 0 8081 web.example.org." {
+		t.Fatalf("unexpected SRV records: %s", contents)
+	}
+}
+
+func TestRefreshWithoutChangesDoesNotPatch(t *testing.T) {
+	b, f := newTestBackend(t)
+
+	backendtest.Refresh(t, b, backendtest.Container("a", "8080"), backendtest.Container("b", "8081"))
+	patches := f.patches
+	backendtest.Refresh(t, b, backendtest.Container("a", "8080"), backendtest.Container("b", "8081"))
+
+	if f.patches != patches {
+		t.Fatalf("expected no patch, got %d", f.patches-patches)
+	}
+}
+
+func TestRefreshRemovesRecordsOfStoppedContainers(t *testing.T) {
+	b, f := newTestBackend(t)
+
+	backendtest.Refresh(t, b, backendtest.Container("a", "8080"), backendtest.Container("b", "8081"))
+	backendtest.Refresh(t, b, backendtest.Container("a", "8080"))
+
+	if contents := f.contents("_web._tcp.example.org. SRV"); contents != "0 0 8080 web.example.org." {
+		t.Fatalf("unexpected SRV records: %s", contents)
+	}
+}
+
+func TestPurgeKeepsForeignRRSets(t *testing.T) {
+	b, f := newTestBackend(t)
+
+	backendtest.Refresh(t, b, backendtest.Container("a", "8080"))
+	backendtest.Purge(t, b)
+
+	expected := "db.example.org. A,example.org. SOA,other.example.org. A"
+	if keys := f.keys(); keys != expected {
+		t.Fatalf("expected %s, got %s", expected, keys)
+	}
+}
+
+func TestForeignRRSetIsKept(t *testing.T) {
+	b, f := newTestBackend(t)
+
+	c := backendtest.Container("a", "8080")
+	c.Labels["creg.port"] = "80/tcp:db"
+
+	err := b.Refresh([]ctypes.ContainerInfo{c})
+	if err == nil {
+		t.Fatal("expected conflict with unmanaged rrset")
+	}
+
+	if contents := f.contents("db.example.org. A"); contents != "10.0.0.9" {
+		t.Fatalf("expected unmanaged rrset to be kept, got %s", contents)
+	}
+	if contents := f.contents("_db._tcp.example.org. SRV"); contents != "0 0 8080 db.example.org." {
+		t.Fatalf("expected SRV to be created, got %s", contents)
+	}
+}
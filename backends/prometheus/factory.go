@@ -0,0 +1,32 @@
+package prometheus
+
+import (
+	"github.com/soupdiver/creg/backends"
+)
+
+// BackendConfig configures a prometheus backend in the config file.
+type BackendConfig struct {
+	// Path of the file_sd file to write
+	Path string `yaml:"path"`
+	// Format json or yaml, defaults to the file extension
+	Format string `yaml:"format"`
+}
+
+func init() {
+	backends.Register("prometheus", backends.Factory{
+		Config: func() interface{} { return &BackendConfig{} },
+		New: func(config interface{}, settings backends.Settings) (backends.Backend, error) {
+			cfg := config.(*BackendConfig)
+
+			return New(cfg.Path,
+				WithName(settings.Name),
+				WithLogger(settings.Log),
+				WithID(settings.ID),
+				WithForwardAddress(settings.ForwardAddress),
+				WithAddressStrategy(settings.AddressStrategy),
+				WithStaticLabels(settings.StaticLabels),
+				WithFormat(cfg.Format),
+			)
+		},
+	})
+}
@@ -0,0 +1,308 @@
+package prometheus
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+
+	"github.com/soupdiver/creg/backends"
+	ctypes "github.com/soupdiver/creg/types"
+)
+
+const (
+	// LabelEnable selects the containers written to the file
+	LabelEnable = "creg.prometheus"
+	// LabelPrefix adds target labels, creg.prometheus.label.env=prod
+	// becomes env="prod"
+	LabelPrefix = "creg.prometheus.label."
+
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+)
+
+var labelNameRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// TargetGroup is a single entry of a Prometheus file_sd file.
+type TargetGroup struct {
+	Targets []string          `json:"targets" yaml:"targets"`
+	Labels  map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+}
+
+// Backend writes the services of containers labelled creg.prometheus=true to
+// a Prometheus file_sd file.
+type Backend struct {
+	ID              string
+	Name            string
+	Log             *logrus.Entry
+	Path            string
+	Format          string
+	ForwardAddress  string
+	AddressStrategy backends.AddressStrategy
+	StaticLabels    []string
+
+	// groups holds the target groups of each container by container ID
+	groups    map[string][]TargetGroup
+	groupsMtx sync.Mutex
+}
+
+type PrometheusOption func(*Backend)
+
+func New(path string, options ...PrometheusOption) (*Backend, error) {
+	b := &Backend{
+		Name:   "prometheus",
+		Log:    logrus.NewEntry(logrus.StandardLogger()),
+		Path:   path,
+		groups: map[string][]TargetGroup{},
+	}
+
+	for _, option := range options {
+		option(b)
+	}
+
+	if b.Format == "" {
+		b.Format = FormatJSON
+		if ext := filepath.Ext(path); ext == ".yml" || ext == ".yaml" {
+			b.Format = FormatYAML
+		}
+	}
+
+	if b.Format != FormatJSON && b.Format != FormatYAML {
+		return nil, fmt.Errorf("unknown format: %q", b.Format)
+	}
+
+	return b, nil
+}
+
+func (b *Backend) Run(ctx context.Context, events chan ctypes.ContainerEventV2, purgeOnStart bool, containersToRefresh []ctypes.ContainerInfo) error {
+	// Always refresh, this writes the file even if there are no containers.
+	// Refresh replaces all targets, so it purges as well, purging first would
+	// write an empty file.
+	err := b.Refresh(containersToRefresh)
+	if err != nil {
+		// Every event writes again, a failed write is not fatal
+		b.Log.Errorf("Could not Refresh: %s", err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			b.Log.Infof("Prometheus exting: %s", "context cancelled")
+			return nil
+		case event := <-events:
+			b.groupsMtx.Lock()
+			switch event.Action {
+			case "start":
+				b.setContainer(event.Container)
+			case "stop":
+				delete(b.groups, event.Container.ID)
+			default:
+				b.groupsMtx.Unlock()
+				continue
+			}
+			b.groupsMtx.Unlock()
+
+			err := b.Write()
+			if err != nil {
+				b.Log.Errorf("Could not Write: %s", err)
+				continue
+			}
+		}
+	}
+}
+
+func (b *Backend) GetName() string {
+	return b.Name
+}
+
+// TargetGroups returns one target group per service of container, or nil if
+// container is not labelled creg.prometheus=true.
+func (b *Backend) TargetGroups(container ctypes.ContainerInfo) []TargetGroup {
+	if v, ok := container.Labels[LabelEnable]; !ok || v != "true" {
+		return nil
+	}
+
+	labels := map[string]string{}
+	for _, v := range b.StaticLabels {
+		k, v, ok := strings.Cut(v, "=")
+		if ok && labelNameRe.MatchString(k) {
+			labels[k] = v
+		}
+	}
+
+	for k, v := range container.Labels {
+		if !strings.HasPrefix(k, LabelPrefix) {
+			continue
+		}
+
+		name := strings.TrimPrefix(k, LabelPrefix)
+		if !labelNameRe.MatchString(name) || strings.HasPrefix(name, "__") {
+			b.Log.Errorf("Invalid prometheus label name: %s", name)
+			continue
+		}
+		labels[name] = v
+	}
+
+	var groups []TargetGroup
+	for _, service := range backends.ServicesForContainer(container, b.AddressStrategy, b.ForwardAddress, nil, nil) {
+		group := TargetGroup{
+			Targets: []string{net.JoinHostPort(service.Address, strconv.Itoa(service.Port))},
+			Labels: map[string]string{
+				"__meta_creg_service":      service.Name,
+				"__meta_creg_container_id": container.ID,
+			},
+		}
+		if b.ID != "" {
+			group.Labels["__meta_creg_id"] = b.ID
+		}
+		for k, v := range labels {
+			group.Labels[k] = v
+		}
+
+		groups = append(groups, group)
+	}
+
+	return groups
+}
+
+// setContainer must be called with groupsMtx held.
+func (b *Backend) setContainer(container ctypes.ContainerInfo) {
+	groups := b.TargetGroups(container)
+	if len(groups) == 0 {
+		delete(b.groups, container.ID)
+		return
+	}
+	b.groups[container.ID] = groups
+}
+
+// Render returns the file content for the current target groups, sorted so
+// that unchanged groups render identically.
+func (b *Backend) Render() ([]byte, error) {
+	b.groupsMtx.Lock()
+	groups := []TargetGroup{}
+	for _, v := range b.groups {
+		groups = append(groups, v...)
+	}
+	b.groupsMtx.Unlock()
+
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Labels["__meta_creg_service"] != groups[j].Labels["__meta_creg_service"] {
+			return groups[i].Labels["__meta_creg_service"] < groups[j].Labels["__meta_creg_service"]
+		}
+		if groups[i].Targets[0] != groups[j].Targets[0] {
+			return groups[i].Targets[0] < groups[j].Targets[0]
+		}
+		return groups[i].Labels["__meta_creg_container_id"] < groups[j].Labels["__meta_creg_container_id"]
+	})
+
+	if b.Format == FormatYAML {
+		return yaml.Marshal(groups)
+	}
+
+	data, err := json.MarshalIndent(groups, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(data, '\n'), nil
+}
+
+// Write renders the target groups and atomically replaces the file if the
+// content changed.
+func (b *Backend) Write() error {
+	data, err := b.Render()
+	if err != nil {
+		return fmt.Errorf("could not render: %w", err)
+	}
+
+	changed, err := backends.WriteFileAtomic(b.Path, data, 0o644)
+	if err != nil {
+		return fmt.Errorf("could not write %s: %w", b.Path, err)
+	}
+	if changed {
+		b.Log.Debugf("Wrote %s", b.Path)
+	}
+
+	return nil
+}
+
+// Purge removes all targets from the file.
+func (b *Backend) Purge() error {
+	b.groupsMtx.Lock()
+	b.groups = map[string][]TargetGroup{}
+	b.groupsMtx.Unlock()
+
+	return b.Write()
+}
+
+// Refresh replaces the targets with the ones of containers and writes the
+// file.
+func (b *Backend) Refresh(containers []ctypes.ContainerInfo) error {
+	b.Log.Debugf("Refreshing %d prometheus containers", len(containers))
+
+	b.groupsMtx.Lock()
+	b.groups = map[string][]TargetGroup{}
+	for _, container := range containers {
+		b.setContainer(container)
+	}
+	b.groupsMtx.Unlock()
+
+	return b.Write()
+}
+
+func WithLogger(log *logrus.Entry) func(b *Backend) {
+	return func(b *Backend) {
+		b.Log = log.WithField("backend", "prometheus")
+	}
+}
+
+func WithForwardAddress(address string) func(b *Backend) {
+	return func(b *Backend) {
+		b.ForwardAddress = address
+	}
+}
+
+func WithStaticLabels(labels []string) func(b *Backend) {
+	return func(b *Backend) {
+		b.StaticLabels = labels
+	}
+}
+
+func WithID(id string) func(b *Backend) {
+	return func(b *Backend) {
+		b.ID = id
+	}
+}
+
+// WithFormat sets the file format, "json" or "yaml". It defaults to the
+// format matching the file extension.
+func WithFormat(format string) func(b *Backend) {
+	return func(b *Backend) {
+		b.Format = format
+	}
+}
+
+// WithName sets the backend name, see backends.Settings.
+func WithName(name string) func(b *Backend) {
+	return func(b *Backend) {
+		if name != "" {
+			b.Name = name
+		}
+	}
+}
+
+// WithAddressStrategy sets the address strategy, see backends.AddressStrategy.
+func WithAddressStrategy(strategy backends.AddressStrategy) func(b *Backend) {
+	return func(b *Backend) {
+		b.AddressStrategy = strategy
+	}
+}
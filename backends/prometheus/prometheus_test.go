@@ -0,0 +1,168 @@
+package prometheus_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/soupdiver/creg/backends/backendtest"
+	"github.com/soupdiver/creg/backends/prometheus"
+	ctypes "github.com/soupdiver/creg/types"
+)
+
+func newTestBackend(t *testing.T, path string) *prometheus.Backend {
+	b, err := prometheus.New(path,
+		prometheus.WithLogger(backendtest.Logger()),
+		prometheus.WithID("creg-test"),
+		prometheus.WithForwardAddress("10.0.0.1"),
+		prometheus.WithStaticLabels([]string{"dc=remote", "invalid-name=x"}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+var testContainers = []ctypes.ContainerInfo{
+	{
+		ID: "app",
+		Labels: map[string]string{
+			"creg.port":                   "9100/tcp:app-metrics",
+			"creg.prometheus":             "true",
+			"creg.prometheus.label.env":   "prod",
+			"creg.prometheus.label.__bad": "x",
+		},
+		NetworkSettings: ctypes.NetworkSettings{
+			Ports: map[ctypes.Port][]ctypes.PortBinding{"9100/tcp": {{HostIP: "0.0.0.0", HostPort: "19100"}}},
+		},
+	},
+	{ID: "disabled", Labels: map[string]string{"creg.port": "9100/tcp:other"}},
+}
+
+func readGroups(t *testing.T, path string, unmarshal func([]byte, interface{}) error) []prometheus.TargetGroup {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var groups []prometheus.TargetGroup
+	err = unmarshal(data, &groups)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return groups
+}
+
+func TestRefreshWritesTargetGroupsWithLabels(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "targets.json")
+	b := newTestBackend(t, path)
+
+	backendtest.Refresh(t, b, testContainers...)
+
+	groups := readGroups(t, path, json.Unmarshal)
+	if len(groups) != 1 {
+		t.Fatalf("expected %d groups, got %+v", 1, groups)
+	}
+
+	group := groups[0]
+	if len(group.Targets) != 1 || group.Targets[0] != "10.0.0.1:19100" {
+		t.Fatalf("unexpected targets: %+v", group.Targets)
+	}
+
+	expected := map[string]string{
+		"__meta_creg_service":      "app-metrics",
+		"__meta_creg_container_id": "app",
+		"__meta_creg_id":           "creg-test",
+		"dc":                       "remote",
+		"env":                      "prod",
+	}
+	if len(group.Labels) != len(expected) {
+		t.Fatalf("expected labels %+v, got %+v", expected, group.Labels)
+	}
+	for k, v := range expected {
+		if group.Labels[k] != v {
+			t.Fatalf("expected labels %+v, got %+v", expected, group.Labels)
+		}
+	}
+}
+
+func TestPurgeEmptiesFileWithoutLeftovers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "targets.json")
+	b := newTestBackend(t, path)
+
+	backendtest.Refresh(t, b, testContainers...)
+	backendtest.Purge(t, b)
+
+	groups := readGroups(t, path, json.Unmarshal)
+	if len(groups) != 0 {
+		t.Fatalf("expected %d groups, got %+v", 0, groups)
+	}
+
+	// Only the file itself is left, temporary files are cleaned up
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected only the target file, got %d entries", len(entries))
+	}
+}
+
+func TestYAML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "targets.yml")
+	b := newTestBackend(t, path)
+
+	err := b.Refresh(testContainers)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	groups := readGroups(t, path, yaml.Unmarshal)
+	if len(groups) != 1 || groups[0].Targets[0] != "10.0.0.1:19100" {
+		t.Fatalf("unexpected groups: %+v", groups)
+	}
+}
+
+func TestInvalidFormat(t *testing.T) {
+	_, err := prometheus.New("targets.json", prometheus.WithFormat("toml"))
+	if err == nil {
+		t.Fatal("expected unknown format to fail")
+	}
+}
+
+func TestRunWithPurgeKeepsUnchangedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "targets.json")
+	b := newTestBackend(t, path)
+
+	// File of a previous run
+	err := b.Refresh(testContainers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go b.Run(ctx, make(chan ctypes.ContainerEventV2), true, testContainers)
+	time.Sleep(100 * time.Millisecond)
+
+	// Purging on start must not write an empty file before the refresh
+	after, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !after.ModTime().Equal(before.ModTime()) {
+		t.Fatalf("expected %s not to be rewritten", path)
+	}
+}
@@ -0,0 +1,91 @@
+package publisher
+
+import (
+	"fmt"
+
+	"github.com/soupdiver/creg/backends"
+)
+
+// NATSConfig configures a nats backend in the config file.
+type NATSConfig struct {
+	// URL of the NATS servers, e.g. nats://localhost:4222
+	URL string `yaml:"url"`
+	// Subject template of each service
+	Subject string `yaml:"subject"`
+}
+
+// MQTTConfig configures an mqtt backend in the config file.
+type MQTTConfig struct {
+	// URL of the broker, e.g. tcp://localhost:1883
+	URL string `yaml:"url"`
+	// Topic template of each service
+	Topic string `yaml:"topic"`
+	// ClientID must be unique per broker, it defaults to the instance ID and
+	// the backend name
+	ClientID string `yaml:"client_id"`
+}
+
+func init() {
+	backends.Register("nats", backends.Factory{
+		Config: func() interface{} {
+			return &NATSConfig{Subject: DefaultNATSSubject}
+		},
+		New: func(config interface{}, settings backends.Settings) (backends.Backend, error) {
+			cfg := config.(*NATSConfig)
+
+			nc, err := NewNATS(cfg.URL)
+			if err != nil {
+				return nil, fmt.Errorf("could not create nats publisher: %w", err)
+			}
+
+			b, err := New(nc, DefaultNATSSubject, options(settings, cfg.Subject)...)
+			if err != nil {
+				nc.Close()
+				return nil, err
+			}
+			return b, nil
+		},
+	})
+
+	backends.Register("mqtt", backends.Factory{
+		Config: func() interface{} {
+			return &MQTTConfig{Topic: DefaultMQTTTopic}
+		},
+		New: func(config interface{}, settings backends.Settings) (backends.Backend, error) {
+			cfg := config.(*MQTTConfig)
+
+			clientID := cfg.ClientID
+			if clientID == "" {
+				name := settings.Name
+				if name == "" {
+					name = "mqtt"
+				}
+				clientID = settings.ID + "-" + name
+			}
+
+			mc, err := NewMQTT(cfg.URL, clientID)
+			if err != nil {
+				return nil, fmt.Errorf("could not create mqtt publisher: %w", err)
+			}
+
+			b, err := New(mc, DefaultMQTTTopic, options(settings, cfg.Topic)...)
+			if err != nil {
+				mc.Close()
+				return nil, err
+			}
+			return b, nil
+		},
+	})
+}
+
+func options(settings backends.Settings, topic string) []PublisherOption {
+	return []PublisherOption{
+		WithName(settings.Name),
+		WithLogger(settings.Log),
+		WithID(settings.ID),
+		WithForwardAddress(settings.ForwardAddress),
+		WithAddressStrategy(settings.AddressStrategy),
+		WithStaticLabels(settings.StaticLabels),
+		WithTopic(topic),
+	}
+}
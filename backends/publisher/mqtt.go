@@ -0,0 +1,61 @@
+package publisher
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// DefaultMQTTTopic is the topic template used by NewMQTT.
+const DefaultMQTTTopic = "creg/{{.Action}}/{{.Service.Name}}"
+
+// MQTT publishes to MQTT topics with QoS 1, Publish waits for the broker's
+// PUBACK.
+type MQTT struct {
+	Client mqtt.Client
+}
+
+// NewMQTT connects to the MQTT broker at url, e.g. tcp://localhost:1883.
+// Credentials can be part of url.
+func NewMQTT(url, clientID string) (*MQTT, error) {
+	opts := mqtt.NewClientOptions().
+		AddBroker(url).
+		SetClientID(clientID).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectTimeout(10 * time.Second)
+
+	client := mqtt.NewClient(opts)
+	token := client.Connect()
+	if !token.WaitTimeout(10*time.Second) || token.Error() != nil {
+		client.Disconnect(0)
+		if token.Error() != nil {
+			return nil, fmt.Errorf("could not connect to mqtt: %w", token.Error())
+		}
+		return nil, fmt.Errorf("could not connect to mqtt: timeout")
+	}
+
+	return &MQTT{Client: client}, nil
+}
+
+func (m *MQTT) Name() string {
+	return "mqtt"
+}
+
+func (m *MQTT) Publish(ctx context.Context, topic string, payload []byte) error {
+	token := m.Client.Publish(topic, 1, false, payload)
+
+	select {
+	case <-token.Done():
+		return token.Error()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (m *MQTT) Close() error {
+	m.Client.Disconnect(250)
+	return nil
+}
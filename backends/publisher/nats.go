@@ -0,0 +1,47 @@
+package publisher
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+)
+
+// DefaultNATSSubject is the subject template used by NewNATS.
+const DefaultNATSSubject = "creg.{{.Action}}.{{.Service.Name}}"
+
+// NATS publishes to NATS subjects. Publish waits for the server to
+// acknowledge the message with a flush.
+type NATS struct {
+	Conn *nats.Conn
+}
+
+// NewNATS connects to the NATS servers at url, a comma separated list of
+// nats:// URLs. The connection reconnects forever.
+func NewNATS(url string, options ...nats.Option) (*NATS, error) {
+	options = append([]nats.Option{nats.Name("creg"), nats.MaxReconnects(-1)}, options...)
+
+	conn, err := nats.Connect(url, options...)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to nats: %w", err)
+	}
+
+	return &NATS{Conn: conn}, nil
+}
+
+func (n *NATS) Name() string {
+	return "nats"
+}
+
+func (n *NATS) Publish(ctx context.Context, subject string, payload []byte) error {
+	err := n.Conn.Publish(subject, payload)
+	if err != nil {
+		return err
+	}
+
+	return n.Conn.FlushWithContext(ctx)
+}
+
+func (n *NATS) Close() error {
+	return n.Conn.Drain()
+}
@@ -0,0 +1,87 @@
+package publisher_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+	natstest "github.com/nats-io/nats-server/v2/test"
+	"github.com/nats-io/nats.go"
+
+	"github.com/soupdiver/creg/backends/backendtest"
+	"github.com/soupdiver/creg/backends/publisher"
+	ctypes "github.com/soupdiver/creg/types"
+)
+
+func TestNATSRunPublishesEventsOnce(t *testing.T) {
+	opts := natstest.DefaultTestOptions
+	opts.Port = server.RANDOM_PORT
+	s := natstest.RunServer(&opts)
+	t.Cleanup(s.Shutdown)
+
+	sub, err := nats.Connect(s.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(sub.Close)
+	msgs := make(chan *nats.Msg, 16)
+	_, err = sub.ChanSubscribe("creg.>", msgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sub.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nc, err := publisher.NewNATS(s.ClientURL())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := publisher.New(nc, publisher.DefaultNATSSubject,
+		publisher.WithLogger(backendtest.Logger()),
+		publisher.WithForwardAddress("10.0.0.1"),
+		publisher.WithRetryInterval(10*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The context of Run has no deadline, unlike the ones of Refresh and
+	// Purge
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan ctypes.ContainerEventV2)
+	done := make(chan error)
+	go func() {
+		done <- b.Run(ctx, events, false, nil)
+	}()
+
+	events <- ctypes.ContainerEventV2{Action: "start", Container: backendtest.Container("a", "8080")}
+
+	select {
+	case msg := <-msgs:
+		if msg.Subject != "creg.register.web" {
+			t.Fatalf("expected subject %q, got %q", "creg.register.web", msg.Subject)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message published")
+	}
+
+	// A published message is not retried
+	select {
+	case msg := <-msgs:
+		t.Fatalf("unexpected message on %s: %s", msg.Subject, msg.Data)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if b.Queued() != 0 {
+		t.Fatalf("expected empty queue, got %d", b.Queued())
+	}
+
+	cancel()
+	err = <-done
+	if err != nil {
+		t.Fatal(err)
+	}
+}
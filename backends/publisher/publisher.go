@@ -0,0 +1,390 @@
+package publisher
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/soupdiver/creg/backends"
+	ctypes "github.com/soupdiver/creg/types"
+)
+
+const (
+	ActionRegister   = "register"
+	ActionDeregister = "deregister"
+)
+
+// Publisher sends a payload to a NATS subject, MQTT topic or similar.
+// Publish only returns once the broker accepted the payload.
+type Publisher interface {
+	Name() string
+	Publish(ctx context.Context, topic string, payload []byte) error
+	Close() error
+}
+
+// Message is the JSON payload of every published event.
+type Message struct {
+	Action    string           `json:"action"`
+	Instance  string           `json:"instance,omitempty"`
+	Timestamp time.Time        `json:"timestamp"`
+	Service   backends.Service `json:"service"`
+}
+
+type pending struct {
+	seq     uint64
+	topic   string
+	payload []byte
+}
+
+// Backend publishes a Message for every service of starting and stopping
+// containers. The topic is rendered from Topic, a text/template executed
+// with the Message. Messages are queued and sent in order, a message which
+// could not be published stays at the head of the queue and is retried every
+// RetryInterval, so every message is delivered at least once as long as the
+// queue does not overflow.
+type Backend struct {
+	ID              string
+	Name            string
+	Log             *logrus.Entry
+	Publisher       Publisher
+	ForwardAddress  string
+	AddressStrategy backends.AddressStrategy
+	StaticLabels    []string
+	Topic           *texttemplate.Template
+	RetryInterval   time.Duration
+	// PublishTimeout limits how long a single publish may take, e.g. while
+	// the broker is reconnecting
+	PublishTimeout time.Duration
+	// MaxQueue limits the number of queued messages, the oldest ones are
+	// dropped first
+	MaxQueue int
+
+	// registered holds the services registered for each container by
+	// container ID, Purge deregisters them
+	registered    map[string][]backends.Service
+	registeredMtx sync.Mutex
+
+	queue    []pending
+	seq      uint64
+	queueMtx sync.Mutex
+	// sendMtx keeps messages in order
+	sendMtx sync.Mutex
+
+	topic string
+}
+
+type PublisherOption func(*Backend)
+
+// New creates a backend publishing with publisher. topic is the default
+// topic template, WithTopic overrides it.
+func New(publisher Publisher, topic string, options ...PublisherOption) (*Backend, error) {
+	b := &Backend{
+		Name:           publisher.Name(),
+		Log:            logrus.NewEntry(logrus.StandardLogger()),
+		Publisher:      publisher,
+		RetryInterval:  5 * time.Second,
+		PublishTimeout: 10 * time.Second,
+		MaxQueue:       10000,
+		registered:     map[string][]backends.Service{},
+		topic:          topic,
+	}
+
+	for _, option := range options {
+		option(b)
+	}
+
+	tmpl, err := texttemplate.New("topic").Option("missingkey=error").Parse(b.topic)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse topic template: %w", err)
+	}
+	b.Topic = tmpl
+
+	return b, nil
+}
+
+func (b *Backend) Run(ctx context.Context, events chan ctypes.ContainerEventV2, purgeOnStart bool, containersToRefresh []ctypes.ContainerInfo) error {
+	defer b.Publisher.Close()
+
+	var err error
+	if purgeOnStart {
+		err = b.Purge()
+		if err != nil {
+			return fmt.Errorf("could not purge: %w", err)
+		}
+	}
+
+	if len(containersToRefresh) > 0 {
+		err = b.Refresh(containersToRefresh)
+		if err != nil {
+			// The messages stay queued and are retried below
+			b.Log.Errorf("Could not Refresh: %s", err)
+		}
+	}
+
+	// Messages are published in their own goroutine, so a slow broker does
+	// not hold up events
+	wake := make(chan struct{}, 1)
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		b.publish(ctx, wake)
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			b.Log.Infof("Publisher exting: %s", "context cancelled")
+			<-published
+			if n := b.Queued(); n > 0 {
+				b.Log.Errorf("Dropping %d unpublished messages", n)
+			}
+			return nil
+		case event := <-events:
+			b.Log.Debugf("handle event publisher: %s", event.Action)
+
+			switch event.Action {
+			case "start":
+				b.register(event.Container)
+			case "stop":
+				b.deregister(event.Container.ID)
+			default:
+				continue
+			}
+
+			select {
+			case wake <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// publish flushes the queue whenever it is woken, and retries every
+// RetryInterval while messages are queued, until ctx is done.
+func (b *Backend) publish(ctx context.Context, wake <-chan struct{}) {
+	retry := time.NewTicker(b.RetryInterval)
+	defer retry.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-retry.C:
+			if b.Queued() == 0 {
+				continue
+			}
+		case <-wake:
+		}
+
+		err := b.Flush(ctx)
+		if err != nil {
+			b.Log.Errorf("Could not Flush: %s", err)
+		}
+	}
+}
+
+func (b *Backend) GetName() string {
+	return b.Name
+}
+
+// enqueue renders the topic of a message for each service and queues it.
+func (b *Backend) enqueue(action string, services []backends.Service) {
+	for _, service := range services {
+		msg := Message{
+			Action:    action,
+			Instance:  b.ID,
+			Timestamp: time.Now().UTC(),
+			Service:   service,
+		}
+
+		var topic bytes.Buffer
+		err := b.Topic.Execute(&topic, msg)
+		if err != nil {
+			b.Log.Errorf("Could not render topic for %s: %s", service.Name, err)
+			continue
+		}
+
+		payload, err := json.Marshal(msg)
+		if err != nil {
+			b.Log.Errorf("Could not encode message for %s: %s", service.Name, err)
+			continue
+		}
+
+		b.queueMtx.Lock()
+		b.seq++
+		b.queue = append(b.queue, pending{seq: b.seq, topic: topic.String(), payload: payload})
+		if over := len(b.queue) - b.MaxQueue; over > 0 {
+			b.Log.Errorf("Queue full, dropping %d messages", over)
+			b.queue = b.queue[over:]
+		}
+		b.queueMtx.Unlock()
+	}
+}
+
+func (b *Backend) register(container ctypes.ContainerInfo) {
+	services := backends.ServicesForContainer(container, b.AddressStrategy, b.ForwardAddress, b.StaticLabels, nil)
+	if len(services) == 0 {
+		return
+	}
+
+	b.registeredMtx.Lock()
+	b.registered[container.ID] = services
+	b.registeredMtx.Unlock()
+
+	b.enqueue(ActionRegister, services)
+}
+
+// deregister queues deregister messages for the services registered for the
+// container with id. Stopped containers have no ports anymore, so the
+// services are taken from the registration.
+func (b *Backend) deregister(id string) {
+	b.registeredMtx.Lock()
+	services := b.registered[id]
+	delete(b.registered, id)
+	b.registeredMtx.Unlock()
+
+	b.enqueue(ActionDeregister, services)
+}
+
+// Queued returns the number of messages waiting to be published.
+func (b *Backend) Queued() int {
+	b.queueMtx.Lock()
+	defer b.queueMtx.Unlock()
+
+	return len(b.queue)
+}
+
+// Flush publishes queued messages in order until the queue is empty or
+// publishing fails. Every publish is limited to PublishTimeout.
+func (b *Backend) Flush(ctx context.Context) error {
+	b.sendMtx.Lock()
+	defer b.sendMtx.Unlock()
+
+	for {
+		b.queueMtx.Lock()
+		if len(b.queue) == 0 {
+			b.queueMtx.Unlock()
+			return nil
+		}
+		msg := b.queue[0]
+		b.queueMtx.Unlock()
+
+		publishCtx, cancel := context.WithTimeout(ctx, b.PublishTimeout)
+		err := b.Publisher.Publish(publishCtx, msg.topic, msg.payload)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("could not publish to %s, %d messages queued: %w", msg.topic, b.Queued(), err)
+		}
+
+		b.queueMtx.Lock()
+		// The head may have been dropped because the queue overflowed
+		if len(b.queue) > 0 && b.queue[0].seq == msg.seq {
+			b.queue = b.queue[1:]
+		}
+		b.queueMtx.Unlock()
+	}
+}
+
+// Purge publishes deregister messages for every service this instance
+// registered.
+func (b *Backend) Purge() error {
+	b.registeredMtx.Lock()
+	var ids []string
+	for id := range b.registered {
+		ids = append(ids, id)
+	}
+	b.registeredMtx.Unlock()
+
+	for _, id := range ids {
+		b.deregister(id)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	return b.Flush(ctx)
+}
+
+// Refresh publishes register messages for every service of containers.
+func (b *Backend) Refresh(containers []ctypes.ContainerInfo) error {
+	b.Log.Debugf("Refreshing %d publisher containers", len(containers))
+
+	for _, container := range containers {
+		b.register(container)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	return b.Flush(ctx)
+}
+
+func WithLogger(log *logrus.Entry) func(b *Backend) {
+	return func(b *Backend) {
+		b.Log = log.WithField("backend", b.Name)
+	}
+}
+
+func WithForwardAddress(address string) func(b *Backend) {
+	return func(b *Backend) {
+		b.ForwardAddress = address
+	}
+}
+
+func WithStaticLabels(labels []string) func(b *Backend) {
+	return func(b *Backend) {
+		b.StaticLabels = labels
+	}
+}
+
+func WithID(id string) func(b *Backend) {
+	return func(b *Backend) {
+		b.ID = id
+	}
+}
+
+// WithTopic sets the topic template, e.g. creg.{{.Action}}.{{.Service.Name}}.
+// An empty template keeps the default.
+func WithTopic(topic string) func(b *Backend) {
+	return func(b *Backend) {
+		if topic != "" {
+			b.topic = topic
+		}
+	}
+}
+
+// WithRetryInterval sets how often publishing queued messages is retried.
+func WithRetryInterval(interval time.Duration) func(b *Backend) {
+	return func(b *Backend) {
+		b.RetryInterval = interval
+	}
+}
+
+// WithPublishTimeout sets how long a single publish may take.
+func WithPublishTimeout(timeout time.Duration) func(b *Backend) {
+	return func(b *Backend) {
+		b.PublishTimeout = timeout
+	}
+}
+
+// WithName sets the backend name, see backends.Settings.
+func WithName(name string) func(b *Backend) {
+	return func(b *Backend) {
+		if name != "" {
+			b.Name = name
+		}
+	}
+}
+
+// WithAddressStrategy sets the address strategy, see backends.AddressStrategy.
+func WithAddressStrategy(strategy backends.AddressStrategy) func(b *Backend) {
+	return func(b *Backend) {
+		b.AddressStrategy = strategy
+	}
+}
@@ -0,0 +1,192 @@
+package publisher_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/soupdiver/creg/backends/backendtest"
+	"github.com/soupdiver/creg/backends/publisher"
+	ctypes "github.com/soupdiver/creg/types"
+)
+
+type published struct {
+	topic   string
+	message publisher.Message
+}
+
+// fakePublisher records published messages and fails the next failures
+// calls.
+type fakePublisher struct {
+	failures  int
+	published []published
+}
+
+func (p *fakePublisher) Name() string { return "fake" }
+
+func (p *fakePublisher) Publish(ctx context.Context, topic string, payload []byte) error {
+	if p.failures > 0 {
+		p.failures--
+		return errors.New("broker unavailable")
+	}
+
+	var msg publisher.Message
+	err := json.Unmarshal(payload, &msg)
+	if err != nil {
+		return err
+	}
+	p.published = append(p.published, published{topic: topic, message: msg})
+	return nil
+}
+
+func (p *fakePublisher) Close() error { return nil }
+
+func newTestBackend(t *testing.T, p *fakePublisher) *publisher.Backend {
+	b, err := publisher.New(p, "creg.{{.Action}}.{{.Service.Name}}",
+		publisher.WithLogger(backendtest.Logger()),
+		publisher.WithID("test"),
+		publisher.WithForwardAddress("10.0.0.1"),
+		publisher.WithStaticLabels([]string{"dc=remote"}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func TestRefreshPublishesRegister(t *testing.T) {
+	p := &fakePublisher{}
+	b := newTestBackend(t, p)
+
+	backendtest.Refresh(t, b, backendtest.Container("a", "8080"))
+
+	if len(p.published) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(p.published))
+	}
+	msg := p.published[0]
+	if msg.topic != "creg.register.web" {
+		t.Fatalf("expected topic %q, got %q", "creg.register.web", msg.topic)
+	}
+	if msg.message.Instance != "test" || msg.message.Service.Name != "web" || msg.message.Service.Address != "10.0.0.1" || msg.message.Service.Port != 8080 {
+		t.Fatalf("unexpected message: %+v", msg.message)
+	}
+	if len(msg.message.Service.Tags) != 1 || msg.message.Service.Tags[0] != "dc=remote" {
+		t.Fatalf("expected tags [dc=remote], got %v", msg.message.Service.Tags)
+	}
+}
+
+func TestPurgePublishesDeregister(t *testing.T) {
+	p := &fakePublisher{}
+	b := newTestBackend(t, p)
+
+	backendtest.Refresh(t, b, backendtest.Container("a", "8080"))
+	backendtest.Purge(t, b)
+
+	if len(p.published) != 2 {
+		t.Fatalf("expected 2 messages, got %d", len(p.published))
+	}
+	msg := p.published[1]
+	if msg.topic != "creg.deregister.web" || msg.message.Action != publisher.ActionDeregister || msg.message.Service.Port != 8080 {
+		t.Fatalf("unexpected message on %s: %+v", msg.topic, msg.message)
+	}
+}
+
+func TestRetryInOrder(t *testing.T) {
+	p := &fakePublisher{failures: 2}
+	b := newTestBackend(t, p)
+
+	err := b.Refresh([]ctypes.ContainerInfo{backendtest.Container("a", "8080")})
+	if err == nil {
+		t.Fatal("expected publishing to fail")
+	}
+	err = b.Refresh([]ctypes.ContainerInfo{backendtest.Container("b", "8081")})
+	if err == nil {
+		t.Fatal("expected publishing to fail")
+	}
+	if b.Queued() != 2 {
+		t.Fatalf("expected 2 queued messages, got %d", b.Queued())
+	}
+
+	err = b.Flush(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.Queued() != 0 {
+		t.Fatalf("expected empty queue, got %d", b.Queued())
+	}
+
+	if len(p.published) != 2 || p.published[0].message.Service.Port != 8080 || p.published[1].message.Service.Port != 8081 {
+		t.Fatalf("expected messages in order, got %+v", p.published)
+	}
+}
+
+// hangingPublisher blocks every publish until its context is done, like a
+// QoS 1 publish while the broker reconnects.
+type hangingPublisher struct {
+	calls chan struct{}
+}
+
+func (p *hangingPublisher) Name() string { return "hanging" }
+
+func (p *hangingPublisher) Publish(ctx context.Context, topic string, payload []byte) error {
+	p.calls <- struct{}{}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (p *hangingPublisher) Close() error { return nil }
+
+func TestRunDoesNotWaitForPublish(t *testing.T) {
+	p := &hangingPublisher{calls: make(chan struct{}, 16)}
+	b, err := publisher.New(p, "creg.{{.Action}}.{{.Service.Name}}",
+		publisher.WithLogger(backendtest.Logger()),
+		publisher.WithForwardAddress("10.0.0.1"),
+		publisher.WithRetryInterval(time.Hour),
+		publisher.WithPublishTimeout(50*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan ctypes.ContainerEventV2)
+	done := make(chan error)
+	go func() {
+		done <- b.Run(ctx, events, false, nil)
+	}()
+
+	for _, id := range []string{"a", "b", "c"} {
+		select {
+		case events <- ctypes.ContainerEventV2{Action: "start", Container: backendtest.Container(id, "8080")}:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("event of %s blocked while publishing", id)
+		}
+	}
+
+	// The hanging publish times out and the head is published again once
+	// the next event wakes the publisher
+	<-p.calls
+	time.Sleep(100 * time.Millisecond)
+	events <- ctypes.ContainerEventV2{Action: "start", Container: backendtest.Container("d", "8080")}
+	select {
+	case <-p.calls:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publish did not time out")
+	}
+
+	cancel()
+	err = <-done
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestInvalidTopic(t *testing.T) {
+	_, err := publisher.New(&fakePublisher{}, "creg.{{.Action")
+	if err == nil {
+		t.Fatal("expected invalid template to fail")
+	}
+}
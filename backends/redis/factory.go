@@ -0,0 +1,53 @@
+package redis
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/soupdiver/creg/backends"
+)
+
+// BackendConfig configures a redis backend in the config file.
+type BackendConfig struct {
+	// Address host:port or a redis:// URL
+	Address string `yaml:"address"`
+	// PasswordFile contains the password
+	PasswordFile string `yaml:"password_file"`
+	// Prefix of all keys and the events channel
+	Prefix string `yaml:"prefix"`
+	// TTL of entries, refreshed by a heartbeat
+	TTL time.Duration `yaml:"ttl"`
+}
+
+func init() {
+	backends.Register("redis", backends.Factory{
+		Config: func() interface{} {
+			return &BackendConfig{Prefix: "creg", TTL: 30 * time.Second}
+		},
+		New: func(config interface{}, settings backends.Settings) (backends.Backend, error) {
+			cfg := config.(*BackendConfig)
+
+			options := []RedisOption{
+				WithName(settings.Name),
+				WithLogger(settings.Log),
+				WithID(settings.ID),
+				WithForwardAddress(settings.ForwardAddress),
+				WithAddressStrategy(settings.AddressStrategy),
+				WithStaticLabels(settings.StaticLabels),
+				WithPrefix(cfg.Prefix),
+				WithTTL(cfg.TTL),
+			}
+			if cfg.PasswordFile != "" {
+				password, err := os.ReadFile(cfg.PasswordFile)
+				if err != nil {
+					return nil, fmt.Errorf("could not read redis password: %w", err)
+				}
+				options = append(options, WithPassword(strings.TrimSpace(string(password))))
+			}
+
+			return New(cfg.Address, options...)
+		},
+	})
+}
@@ -0,0 +1,511 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/sirupsen/logrus"
+
+	"github.com/soupdiver/creg/backends"
+	ctypes "github.com/soupdiver/creg/types"
+)
+
+const (
+	ActionRegister   = "register"
+	ActionDeregister = "deregister"
+)
+
+// Entry is a registered service instance. It is stored as the hash
+// <prefix>:service:<name>:<id> and published on the events channel.
+type Entry struct {
+	Action      string   `json:"action,omitempty"`
+	ID          string   `json:"id"`
+	Service     string   `json:"service"`
+	Address     string   `json:"address"`
+	Port        int      `json:"port"`
+	Proto       string   `json:"proto"`
+	Tags        []string `json:"tags"`
+	ContainerID string   `json:"container_id"`
+	Instance    string   `json:"instance"`
+}
+
+// Backend stores services in Redis. For every service name the set
+// <prefix>:service:<name> holds the IDs of its entries, the set
+// <prefix>:services holds all service names. Entry hashes expire after TTL
+// unless the heartbeat refreshes them, so entries of a crashed creg
+// disappear. Set members can outlive their hash, readers have to skip
+// members whose hash does not exist. Every change is published as JSON
+// Entry on <prefix>:events.
+type Backend struct {
+	ID              string
+	Name            string
+	Log             *logrus.Entry
+	Client          *goredis.Client
+	ForwardAddress  string
+	AddressStrategy backends.AddressStrategy
+	StaticLabels    []string
+	Prefix          string
+	TTL             time.Duration
+
+	// registered holds the entries registered for each container by
+	// container ID
+	registered    map[string][]Entry
+	registeredMtx sync.Mutex
+
+	redisOptions *goredis.Options
+}
+
+type RedisOption func(*Backend)
+
+// New creates a backend for the Redis server at address, either host:port or
+// a redis:// or rediss:// URL.
+func New(address string, options ...RedisOption) (*Backend, error) {
+	redisOptions := &goredis.Options{Addr: address}
+	if strings.HasPrefix(address, "redis://") || strings.HasPrefix(address, "rediss://") {
+		var err error
+		redisOptions, err = goredis.ParseURL(address)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse redis url: %w", err)
+		}
+	}
+
+	b := &Backend{
+		ID:           "creg",
+		Name:         "redis",
+		Log:          logrus.NewEntry(logrus.StandardLogger()),
+		Prefix:       "creg",
+		TTL:          30 * time.Second,
+		registered:   map[string][]Entry{},
+		redisOptions: redisOptions,
+	}
+
+	for _, option := range options {
+		option(b)
+	}
+
+	if b.TTL < time.Second {
+		return nil, fmt.Errorf("ttl must be at least 1s: %s", b.TTL)
+	}
+
+	b.Client = goredis.NewClient(b.redisOptions)
+
+	return b, nil
+}
+
+func (b *Backend) Run(ctx context.Context, events chan ctypes.ContainerEventV2, purgeOnStart bool, containersToRefresh []ctypes.ContainerInfo) error {
+	var err error
+	if purgeOnStart {
+		err = b.Purge()
+		if err != nil {
+			return fmt.Errorf("could not purge: %w", err)
+		}
+	}
+
+	// Always refresh, this removes entries of containers which stopped while
+	// creg was not running
+	err = b.Refresh(containersToRefresh)
+	if err != nil {
+		return fmt.Errorf("could not refresh: %w", err)
+	}
+
+	heartbeat := time.NewTicker(b.TTL / 3)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			b.Log.Infof("Redis exting: %s", "context cancelled")
+			return nil
+		case <-heartbeat.C:
+			err := b.Heartbeat(ctx)
+			if err != nil {
+				b.Log.Errorf("Could not Heartbeat: %s", err)
+			}
+		case event := <-events:
+			b.Log.Debugf("handle event redis: %s", event.Action)
+
+			switch event.Action {
+			case "start":
+				entries := b.Entries(event.Container)
+				if len(entries) == 0 {
+					continue
+				}
+
+				err := b.Register(ctx, event.Container.ID, entries)
+				if err != nil {
+					b.Log.Errorf("Could not Register: %s", err)
+					continue
+				}
+			case "stop":
+				err := b.Deregister(ctx, event.Container.ID)
+				if err != nil {
+					b.Log.Errorf("Could not Deregister: %s", err)
+					continue
+				}
+			}
+		}
+	}
+}
+
+func (b *Backend) GetName() string {
+	return b.Name
+}
+
+func (b *Backend) servicesKey() string {
+	return b.Prefix + ":services"
+}
+
+func (b *Backend) serviceKey(service string) string {
+	return b.Prefix + ":service:" + service
+}
+
+func (b *Backend) entryKey(entry Entry) string {
+	return b.serviceKey(entry.Service) + ":" + entry.ID
+}
+
+// instanceKey is the set of entry keys owned by this instance, Purge deletes
+// them.
+func (b *Backend) instanceKey() string {
+	return b.Prefix + ":instance:" + b.ID
+}
+
+// EventsChannel is the pub/sub channel changes are published on.
+func (b *Backend) EventsChannel() string {
+	return b.Prefix + ":events"
+}
+
+// Entries returns an entry for every service of container.
+func (b *Backend) Entries(container ctypes.ContainerInfo) []Entry {
+	id := container.ID
+	if len(id) > 12 {
+		id = id[:12]
+	}
+
+	var entries []Entry
+	for _, service := range backends.ServicesForContainer(container, b.AddressStrategy, b.ForwardAddress, b.StaticLabels, nil) {
+		entries = append(entries, Entry{
+			ID:          fmt.Sprintf("%s-%s-%d-%s", b.ID, id, service.Port, service.Proto),
+			Service:     service.Name,
+			Address:     service.Address,
+			Port:        service.Port,
+			Proto:       service.Proto,
+			Tags:        service.Tags,
+			ContainerID: container.ID,
+			Instance:    b.ID,
+		})
+	}
+
+	return entries
+}
+
+// write stores entries and refreshes their expiry.
+func (b *Backend) write(ctx context.Context, entries []Entry) error {
+	_, err := b.Client.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		for _, entry := range entries {
+			tags, err := json.Marshal(entry.Tags)
+			if err != nil {
+				return err
+			}
+
+			key := b.entryKey(entry)
+			pipe.HSet(ctx, key, map[string]interface{}{
+				"id":           entry.ID,
+				"service":      entry.Service,
+				"address":      entry.Address,
+				"port":         entry.Port,
+				"proto":        entry.Proto,
+				"tags":         string(tags),
+				"container_id": entry.ContainerID,
+				"instance":     entry.Instance,
+			})
+			pipe.Expire(ctx, key, b.TTL)
+			pipe.SAdd(ctx, b.serviceKey(entry.Service), entry.ID)
+			pipe.Expire(ctx, b.serviceKey(entry.Service), b.TTL)
+			pipe.SAdd(ctx, b.servicesKey(), entry.Service)
+			pipe.Expire(ctx, b.servicesKey(), b.TTL)
+			pipe.SAdd(ctx, b.instanceKey(), key)
+			pipe.Expire(ctx, b.instanceKey(), b.TTL)
+		}
+		return nil
+	})
+
+	return err
+}
+
+// remove deletes entries by their keys.
+func (b *Backend) remove(ctx context.Context, keys []string) error {
+	_, err := b.Client.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+		for _, key := range keys {
+			i := strings.LastIndex(key, ":")
+			pipe.Del(ctx, key)
+			pipe.SRem(ctx, key[:i], key[i+1:])
+			pipe.SRem(ctx, b.instanceKey(), key)
+		}
+		return nil
+	})
+
+	return err
+}
+
+func (b *Backend) publish(ctx context.Context, action string, entries []Entry) error {
+	var errs []error
+	for _, entry := range entries {
+		entry.Action = action
+		data, err := json.Marshal(entry)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		err = b.Client.Publish(ctx, b.EventsChannel(), data).Err()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not publish %s: %w", entry.ID, err))
+		}
+	}
+
+	return backends.JoinErrors(errs)
+}
+
+// Register stores the entries of the container with id and publishes them.
+func (b *Backend) Register(ctx context.Context, id string, entries []Entry) error {
+	err := b.write(ctx, entries)
+	if err != nil {
+		return fmt.Errorf("could not write entries: %w", err)
+	}
+
+	b.registeredMtx.Lock()
+	b.registered[id] = entries
+	b.registeredMtx.Unlock()
+
+	return b.publish(ctx, ActionRegister, entries)
+}
+
+// Deregister removes the entries of the container with id and publishes
+// their removal.
+func (b *Backend) Deregister(ctx context.Context, id string) error {
+	b.registeredMtx.Lock()
+	entries := b.registered[id]
+	b.registeredMtx.Unlock()
+
+	if len(entries) == 0 {
+		return nil
+	}
+
+	var keys []string
+	for _, entry := range entries {
+		keys = append(keys, b.entryKey(entry))
+	}
+
+	err := b.remove(ctx, keys)
+	if err != nil {
+		return fmt.Errorf("could not remove entries: %w", err)
+	}
+
+	b.registeredMtx.Lock()
+	delete(b.registered, id)
+	b.registeredMtx.Unlock()
+
+	return b.publish(ctx, ActionDeregister, entries)
+}
+
+// Heartbeat rewrites all registered entries, which refreshes their expiry and
+// restores them if Redis lost them.
+func (b *Backend) Heartbeat(ctx context.Context) error {
+	b.registeredMtx.Lock()
+	var entries []Entry
+	for _, v := range b.registered {
+		entries = append(entries, v...)
+	}
+	b.registeredMtx.Unlock()
+
+	if len(entries) == 0 {
+		return nil
+	}
+
+	return b.write(ctx, entries)
+}
+
+// Purge removes all entries owned by this instance, including the ones of a
+// previous run which are not expired yet.
+func (b *Backend) Purge() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	keys, err := b.Client.SMembers(ctx, b.instanceKey()).Result()
+	if err != nil {
+		return fmt.Errorf("could not list entries: %w", err)
+	}
+
+	b.registeredMtx.Lock()
+	b.registered = map[string][]Entry{}
+	b.registeredMtx.Unlock()
+
+	if len(keys) == 0 {
+		return nil
+	}
+
+	// Expired entries are gone already and are not published
+	var entries []Entry
+	for _, key := range keys {
+		fields, err := b.Client.HGetAll(ctx, key).Result()
+		if err != nil || len(fields) == 0 {
+			continue
+		}
+		entry, err := ParseEntry(fields)
+		if err == nil {
+			entries = append(entries, entry)
+		}
+	}
+
+	err = b.remove(ctx, keys)
+	if err != nil {
+		return fmt.Errorf("could not remove entries: %w", err)
+	}
+
+	return b.publish(ctx, ActionDeregister, entries)
+}
+
+// Refresh registers the services of containers and removes all other entries
+// owned by this instance.
+func (b *Backend) Refresh(containers []ctypes.ContainerInfo) error {
+	b.Log.Debugf("Refreshing %d redis containers", len(containers))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	keys, err := b.Client.SMembers(ctx, b.instanceKey()).Result()
+	if err != nil {
+		return fmt.Errorf("could not list entries: %w", err)
+	}
+
+	wanted := map[string]struct{}{}
+	var errs []error
+	for _, container := range containers {
+		entries := b.Entries(container)
+		if len(entries) == 0 {
+			continue
+		}
+		for _, entry := range entries {
+			wanted[b.entryKey(entry)] = struct{}{}
+		}
+
+		err := b.Register(ctx, container.ID, entries)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not register %s: %w", container.ID, err))
+		}
+	}
+
+	var stale []string
+	for _, key := range keys {
+		if _, ok := wanted[key]; !ok {
+			stale = append(stale, key)
+		}
+	}
+
+	if len(stale) > 0 {
+		err = b.remove(ctx, stale)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not remove stale entries: %w", err))
+		}
+	}
+
+	return backends.JoinErrors(errs)
+}
+
+// ParseEntry reads an entry hash as returned by HGETALL.
+func ParseEntry(fields map[string]string) (Entry, error) {
+	port, err := strconv.Atoi(fields["port"])
+	if err != nil {
+		return Entry{}, fmt.Errorf("invalid port: %w", err)
+	}
+
+	entry := Entry{
+		ID:          fields["id"],
+		Service:     fields["service"],
+		Address:     fields["address"],
+		Port:        port,
+		Proto:       fields["proto"],
+		ContainerID: fields["container_id"],
+		Instance:    fields["instance"],
+	}
+
+	err = json.Unmarshal([]byte(fields["tags"]), &entry.Tags)
+	if err != nil {
+		return Entry{}, fmt.Errorf("invalid tags: %w", err)
+	}
+
+	return entry, nil
+}
+
+func WithLogger(log *logrus.Entry) func(b *Backend) {
+	return func(b *Backend) {
+		b.Log = log.WithField("backend", "redis")
+	}
+}
+
+func WithForwardAddress(address string) func(b *Backend) {
+	return func(b *Backend) {
+		b.ForwardAddress = address
+	}
+}
+
+func WithStaticLabels(labels []string) func(b *Backend) {
+	return func(b *Backend) {
+		b.StaticLabels = labels
+	}
+}
+
+func WithID(id string) func(b *Backend) {
+	return func(b *Backend) {
+		if id != "" {
+			b.ID = id
+		}
+	}
+}
+
+// WithPrefix sets the prefix of all keys and the events channel.
+func WithPrefix(prefix string) func(b *Backend) {
+	return func(b *Backend) {
+		b.Prefix = prefix
+	}
+}
+
+// WithTTL sets the expiry of entries, the heartbeat refreshes them every
+// third of it.
+func WithTTL(ttl time.Duration) func(b *Backend) {
+	return func(b *Backend) {
+		b.TTL = ttl
+	}
+}
+
+// WithPassword sets the password used to authenticate, overriding one in the
+// address URL.
+func WithPassword(password string) func(b *Backend) {
+	return func(b *Backend) {
+		if password != "" {
+			b.redisOptions.Password = password
+		}
+	}
+}
+
+// WithName sets the backend name, see backends.Settings.
+func WithName(name string) func(b *Backend) {
+	return func(b *Backend) {
+		if name != "" {
+			b.Name = name
+		}
+	}
+}
+
+// WithAddressStrategy sets the address strategy, see backends.AddressStrategy.
+func WithAddressStrategy(strategy backends.AddressStrategy) func(b *Backend) {
+	return func(b *Backend) {
+		b.AddressStrategy = strategy
+	}
+}
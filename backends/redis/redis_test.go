@@ -0,0 +1,162 @@
+package redis_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/soupdiver/creg/backends/backendtest"
+	"github.com/soupdiver/creg/backends/redis"
+)
+
+func newTestBackend(t *testing.T) (*redis.Backend, *miniredis.Miniredis) {
+	m := miniredis.RunT(t)
+
+	b, err := redis.New(m.Addr(),
+		redis.WithLogger(backendtest.Logger()),
+		redis.WithID("test"),
+		redis.WithForwardAddress("10.0.0.1"),
+		redis.WithStaticLabels([]string{"dc=remote"}),
+		redis.WithTTL(30*time.Second),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Client.Close() })
+
+	return b, m
+}
+
+func TestRegisterAndDeregister(t *testing.T) {
+	b, m := newTestBackend(t)
+	ctx := context.Background()
+
+	sub := b.Client.Subscribe(ctx, b.EventsChannel())
+	defer sub.Close()
+	_, err := sub.Receive(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := backendtest.Container("0123456789abcdef", "8080")
+	err = b.Register(ctx, c.ID, b.Entries(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	members, err := m.SMembers("creg:service:web")
+	if err != nil || len(members) != 1 || members[0] != "test-0123456789ab-8080-tcp" {
+		t.Fatalf("unexpected members: %v, %v", members, err)
+	}
+
+	key := "creg:service:web:" + members[0]
+	fields, err := b.Client.HGetAll(ctx, key).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry, err := redis.ParseEntry(fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Address != "10.0.0.1" || entry.Port != 8080 || entry.Instance != "test" || len(entry.Tags) != 1 || entry.Tags[0] != "dc=remote" {
+		t.Fatalf("unexpected entry: %+v", entry)
+	}
+	if ttl := m.TTL(key); ttl != 30*time.Second {
+		t.Fatalf("expected ttl %s, got %s", 30*time.Second, ttl)
+	}
+
+	msg, err := sub.ReceiveMessage(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var published redis.Entry
+	err = json.Unmarshal([]byte(msg.Payload), &published)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if published.Action != redis.ActionRegister || published.ID != entry.ID {
+		t.Fatalf("unexpected event: %+v", published)
+	}
+
+	err = b.Deregister(ctx, c.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Exists(key) {
+		t.Fatalf("expected %s to be deleted", key)
+	}
+
+	msg, err = sub.ReceiveMessage(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = json.Unmarshal([]byte(msg.Payload), &published)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if published.Action != redis.ActionDeregister {
+		t.Fatalf("unexpected event: %+v", published)
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	b, m := newTestBackend(t)
+	ctx := context.Background()
+
+	c := backendtest.Container("a", "8080")
+	err := b.Register(ctx, c.ID, b.Entries(c))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := "creg:service:web:test-a-8080-tcp"
+	m.FastForward(20 * time.Second)
+	err = b.Heartbeat(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl := m.TTL(key); ttl != 30*time.Second {
+		t.Fatalf("expected ttl %s, got %s", 30*time.Second, ttl)
+	}
+
+	// Without heartbeat the entry expires
+	m.FastForward(31 * time.Second)
+	if m.Exists(key) {
+		t.Fatalf("expected %s to expire", key)
+	}
+}
+
+// addForeignEntry adds an entry of another creg instance, which is never
+// touched.
+func addForeignEntry(m *miniredis.Miniredis) {
+	m.HSet("creg:service:web:other-x-80-tcp", "id", "other-x-80-tcp")
+	m.SAdd("creg:service:web", "other-x-80-tcp")
+}
+
+func TestRefreshRemovesStoppedContainers(t *testing.T) {
+	b, m := newTestBackend(t)
+	addForeignEntry(m)
+
+	backendtest.Refresh(t, b, backendtest.Container("a", "8080"), backendtest.Container("b", "8081"))
+	backendtest.Refresh(t, b, backendtest.Container("a", "8080"))
+
+	members, _ := m.SMembers("creg:service:web")
+	backendtest.ExpectStrings(t, []string{"other-x-80-tcp", "test-a-8080-tcp"}, members)
+}
+
+func TestPurgeKeepsEntriesOfOtherInstances(t *testing.T) {
+	b, m := newTestBackend(t)
+	addForeignEntry(m)
+
+	backendtest.Refresh(t, b, backendtest.Container("a", "8080"))
+	backendtest.Purge(t, b)
+
+	members, _ := m.SMembers("creg:service:web")
+	backendtest.ExpectStrings(t, []string{"other-x-80-tcp"}, members)
+	if !m.Exists("creg:service:web:other-x-80-tcp") {
+		t.Fatal("expected entry of other instance to be kept")
+	}
+}
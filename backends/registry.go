@@ -0,0 +1,106 @@
+package backends
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Settings are shared by all backends of a creg instance.
+type Settings struct {
+	// Name of the backend, events are routed to it with the creg.backends
+	// label. Backends use their type if it is empty.
+	Name           string
+	ID             string
+	ForwardAddress string
+	// AddressStrategy selects the address services are registered at,
+	// ForwardAddress is the fallback
+	AddressStrategy AddressStrategy
+	StaticLabels    []string
+	// EnableLabel is the label containers are enabled for creg with
+	EnableLabel string
+	// StateDir holds the state files of backends, state is not persisted if
+	// it is empty
+	StateDir string
+	Log      *logrus.Entry
+}
+
+// StatePath returns the state file of the backend, backendType is used if
+// the backend has no name. It is empty if state is not persisted.
+func (s Settings) StatePath(backendType string) string {
+	if s.StateDir == "" {
+		return ""
+	}
+
+	name := s.Name
+	if name == "" {
+		name = backendType
+	}
+
+	return filepath.Join(s.StateDir, s.ID+"-"+name+".json")
+}
+
+// Factory creates backends of one type.
+type Factory struct {
+	// Config returns the config of the type with its defaults set, the
+	// config of a backend is decoded into it
+	Config func() interface{}
+	// New creates a backend from the decoded config
+	New func(config interface{}, settings Settings) (Backend, error)
+}
+
+var (
+	factories    = map[string]Factory{}
+	factoriesMtx sync.RWMutex
+)
+
+// Register makes a backend type available by name, usually called from the
+// init function of the backend package. It panics if the name is taken.
+func Register(name string, factory Factory) {
+	factoriesMtx.Lock()
+	defer factoriesMtx.Unlock()
+
+	if _, ok := factories[name]; ok {
+		panic(fmt.Sprintf("backend type %q registered twice", name))
+	}
+	factories[name] = factory
+}
+
+// Types returns the names of all registered backend types, sorted.
+func Types() []string {
+	factoriesMtx.RLock()
+	defer factoriesMtx.RUnlock()
+
+	var names []string
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Create creates a backend of type name. decode fills the config of the type,
+// it is skipped if nil and the defaults are used.
+func Create(name string, settings Settings, decode func(config interface{}) error) (Backend, error) {
+	factoriesMtx.RLock()
+	factory, ok := factories[name]
+	factoriesMtx.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("unknown backend type: %q", name)
+	}
+
+	config := factory.Config()
+	if decode != nil {
+		err := decode(config)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode %s config: %w", name, err)
+		}
+	}
+
+	return factory.New(config, settings)
+}
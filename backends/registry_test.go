@@ -0,0 +1,82 @@
+package backends_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/soupdiver/creg/backends"
+	ctypes "github.com/soupdiver/creg/types"
+)
+
+type testBackend struct {
+	name    string
+	address string
+}
+
+func (b *testBackend) Run(ctx context.Context, events chan ctypes.ContainerEventV2, purgeOnStart bool, containersToRefresh []ctypes.ContainerInfo) error {
+	return nil
+}
+func (b *testBackend) Purge() error                                    { return nil }
+func (b *testBackend) Refresh(containers []ctypes.ContainerInfo) error { return nil }
+func (b *testBackend) GetName() string                                 { return b.name }
+
+type testConfig struct {
+	Address string
+}
+
+func init() {
+	backends.Register("test", backends.Factory{
+		Config: func() interface{} { return &testConfig{Address: "default"} },
+		New: func(config interface{}, settings backends.Settings) (backends.Backend, error) {
+			name := settings.Name
+			if name == "" {
+				name = "test"
+			}
+			return &testBackend{name: name, address: config.(*testConfig).Address}, nil
+		},
+	})
+}
+
+func TestCreate(t *testing.T) {
+	b, err := backends.Create("test", backends.Settings{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.GetName() != "test" || b.(*testBackend).address != "default" {
+		t.Fatalf("expected test with default address, got %s %s", b.GetName(), b.(*testBackend).address)
+	}
+
+	b, err = backends.Create("test", backends.Settings{Name: "other"}, func(config interface{}) error {
+		config.(*testConfig).Address = "10.0.0.1"
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.GetName() != "other" || b.(*testBackend).address != "10.0.0.1" {
+		t.Fatalf("expected other with 10.0.0.1, got %s %s", b.GetName(), b.(*testBackend).address)
+	}
+
+	_, err = backends.Create("test", backends.Settings{}, func(config interface{}) error {
+		return errors.New("invalid")
+	})
+	if err == nil {
+		t.Fatal("expected decode error")
+	}
+
+	_, err = backends.Create("unknown", backends.Settings{}, nil)
+	if err == nil {
+		t.Fatal("expected error for unknown type")
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic")
+		}
+	}()
+
+	backends.Register("test", backends.Factory{})
+}
@@ -0,0 +1,54 @@
+package rfc2136
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/soupdiver/creg/backends"
+)
+
+// BackendConfig configures an rfc2136 backend in the config file.
+type BackendConfig struct {
+	// Server accepting the updates, host:port
+	Server string `yaml:"server"`
+	Zone   string `yaml:"zone"`
+	TTL    uint32 `yaml:"ttl"`
+	// Target of SRV records, defaults to the service A record
+	Target string `yaml:"target"`
+	// KeyName of the TSIG key, updates are not signed if empty
+	KeyName      string `yaml:"key_name"`
+	KeyAlgorithm string `yaml:"key_algorithm"`
+	// KeySecretFile contains the base64 TSIG secret
+	KeySecretFile string `yaml:"key_secret_file"`
+}
+
+func init() {
+	backends.Register("rfc2136", backends.Factory{
+		Config: func() interface{} {
+			return &BackendConfig{TTL: 60, KeyAlgorithm: "hmac-sha256"}
+		},
+		New: func(config interface{}, settings backends.Settings) (backends.Backend, error) {
+			cfg := config.(*BackendConfig)
+
+			options := []RFC2136Option{
+				WithName(settings.Name),
+				WithLogger(settings.Log),
+				WithForwardAddress(settings.ForwardAddress),
+				WithAddressStrategy(settings.AddressStrategy),
+				WithStatePath(settings.StatePath("rfc2136")),
+				WithTTL(cfg.TTL),
+				WithTarget(cfg.Target),
+			}
+			if cfg.KeyName != "" {
+				secret, err := os.ReadFile(cfg.KeySecretFile)
+				if err != nil {
+					return nil, fmt.Errorf("could not read rfc2136 key secret: %w", err)
+				}
+				options = append(options, WithTSIG(cfg.KeyName, cfg.KeyAlgorithm, strings.TrimSpace(string(secret))))
+			}
+
+			return New(cfg.Server, cfg.Zone, options...)
+		},
+	})
+}
@@ -0,0 +1,442 @@
+package rfc2136
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+
+	"github.com/soupdiver/creg/backends"
+	"github.com/soupdiver/creg/backends/dnsutil"
+	ctypes "github.com/soupdiver/creg/types"
+)
+
+// Backend publishes services as A/AAAA and SRV records by sending RFC 2136
+// dynamic updates to an authoritative server, see dnsutil.Records for the
+// records created per service.
+type Backend struct {
+	Name            string
+	Log             *logrus.Entry
+	Server          string
+	Zone            string
+	TTL             uint32
+	ForwardAddress  string
+	AddressStrategy backends.AddressStrategy
+	// Target is the SRV target. It defaults to the A record of the service.
+	Target string
+	// Net is the transport used to send updates, "udp" or "tcp".
+	Net     string
+	Timeout time.Duration
+
+	KeyName      string
+	KeyAlgorithm string
+	KeySecret    string
+
+	// StatePath is the file the owned records are persisted in, see
+	// backends.LoadState
+	StatePath string
+
+	// owned holds the containers referencing a record, shared records like
+	// the A record of a service with multiple ports are only removed once the
+	// last container is gone. The server can not be queried for ownership, so
+	// Purge only ever removes records found here. Records loaded from the
+	// state have no containers until they are registered again, Refresh
+	// removes the ones which are not.
+	owned    map[string]ownedRecord
+	ownedMtx sync.Mutex
+}
+
+type ownedRecord struct {
+	rr         dns.RR
+	containers map[string]struct{}
+}
+
+type RFC2136Option func(*Backend)
+
+func New(server, zone string, options ...RFC2136Option) (*Backend, error) {
+	b := &Backend{
+		Name:         "rfc2136",
+		Log:          logrus.NewEntry(logrus.StandardLogger()),
+		Server:       server,
+		Zone:         dns.Fqdn(zone),
+		TTL:          60,
+		Net:          "udp",
+		Timeout:      5 * time.Second,
+		KeyAlgorithm: dns.HmacSHA256,
+		owned:        map[string]ownedRecord{},
+	}
+
+	for _, option := range options {
+		option(b)
+	}
+
+	if _, _, err := net.SplitHostPort(b.Server); err != nil {
+		b.Server = net.JoinHostPort(b.Server, "53")
+	}
+
+	if _, ok := dns.IsDomainName(b.Zone); !ok || zone == "" {
+		return nil, fmt.Errorf("invalid zone: %q", zone)
+	}
+
+	if b.KeyName != "" {
+		b.KeyName = dns.Fqdn(b.KeyName)
+		b.KeyAlgorithm = dns.Fqdn(strings.ToLower(b.KeyAlgorithm))
+		if b.KeySecret == "" {
+			return nil, fmt.Errorf("key %s has no secret", b.KeyName)
+		}
+	}
+
+	var owned []string
+	err := backends.LoadState(b.StatePath, &owned)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range owned {
+		rr, err := dns.NewRR(v)
+		if err != nil || rr == nil {
+			b.Log.Errorf("Invalid record in state %s: %q", b.StatePath, v)
+			continue
+		}
+		b.owned[rr.String()] = ownedRecord{rr: rr, containers: map[string]struct{}{}}
+	}
+
+	return b, nil
+}
+
+// saveOwned persists the owned records, it must be called with ownedMtx
+// held.
+func (b *Backend) saveOwned() error {
+	owned := make([]string, 0, len(b.owned))
+	for k := range b.owned {
+		owned = append(owned, k)
+	}
+	sort.Strings(owned)
+
+	return backends.SaveState(b.StatePath, owned)
+}
+
+func (b *Backend) Run(ctx context.Context, events chan ctypes.ContainerEventV2, purgeOnStart bool, containersToRefresh []ctypes.ContainerInfo) error {
+	var err error
+	if purgeOnStart {
+		err = b.Purge()
+		if err != nil {
+			return fmt.Errorf("could not purge: %w", err)
+		}
+	}
+
+	// Refresh also removes the records of a previous run when no container
+	// is running anymore
+	err = b.Refresh(containersToRefresh)
+	if err != nil {
+		return fmt.Errorf("could not refresh: %w", err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			b.Log.Infof("RFC2136 exting: %s", "context cancelled")
+			return nil
+		case event := <-events:
+			switch event.Action {
+			case "start":
+				records := b.RecordsForContainer(event.Container)
+				if len(records) == 0 {
+					continue
+				}
+
+				b.Log.Debugf("Registering records: %+v", records)
+				err := b.RegisterRecords(event.Container.ID, records)
+				if err != nil {
+					b.Log.Errorf("Could not RegisterRecords: %s", err)
+					continue
+				}
+			case "stop":
+				b.Log.Debugf("Unregistering records of %s", event.Container.ID)
+				err := b.UnregisterContainer(event.Container.ID)
+				if err != nil {
+					b.Log.Errorf("Could not UnregisterContainer: %s", err)
+					continue
+				}
+			}
+		}
+	}
+}
+
+func (b *Backend) GetName() string {
+	return b.Name
+}
+
+// RecordsForContainer returns the A/AAAA and SRV records for the services in
+// the creg.port label of container.
+func (b *Backend) RecordsForContainer(container ctypes.ContainerInfo) []dns.RR {
+	services := backends.ServicesForContainer(container, b.AddressStrategy, b.ForwardAddress, nil, nil)
+
+	records, err := dnsutil.Records(services, b.Zone, b.TTL, b.Target)
+	if err != nil {
+		b.Log.Errorf("Could not build records: %s", err)
+	}
+
+	return records
+}
+
+// RegisterRecords inserts the records of the container with id in a single
+// update and references them by the container. Records the container
+// referenced before but not anymore are released, see UnregisterContainer.
+func (b *Backend) RegisterRecords(id string, records []dns.RR) error {
+	err := b.update(records, nil)
+	if err != nil {
+		return err
+	}
+
+	keep := map[string]struct{}{}
+	b.ownedMtx.Lock()
+	for _, rr := range records {
+		v, ok := b.owned[rr.String()]
+		if !ok {
+			v.containers = map[string]struct{}{}
+		}
+		v.rr = rr
+		v.containers[id] = struct{}{}
+		b.owned[rr.String()] = v
+		keep[rr.String()] = struct{}{}
+	}
+	remove := b.release(func(container string) bool {
+		return container == id
+	}, keep)
+	b.ownedMtx.Unlock()
+
+	return b.remove(remove)
+}
+
+// UnregisterContainer releases the records referenced by the container with
+// id and removes the records which are not referenced anymore.
+func (b *Backend) UnregisterContainer(id string) error {
+	b.ownedMtx.Lock()
+	remove := b.release(func(container string) bool {
+		return container == id
+	}, nil)
+	b.ownedMtx.Unlock()
+
+	return b.remove(remove)
+}
+
+// release drops the references of the containers matched by match from all
+// owned records except the ones in keep, and returns the records which are
+// not referenced anymore. It must be called with ownedMtx held.
+func (b *Backend) release(match func(container string) bool, keep map[string]struct{}) []dns.RR {
+	var unreferenced []dns.RR
+	for k, v := range b.owned {
+		if _, ok := keep[k]; ok {
+			continue
+		}
+
+		released := false
+		for container := range v.containers {
+			if match(container) {
+				delete(v.containers, container)
+				released = true
+			}
+		}
+		if released && len(v.containers) == 0 {
+			unreferenced = append(unreferenced, v.rr)
+		}
+	}
+
+	return unreferenced
+}
+
+// remove deletes records in a single update and stops owning them.
+func (b *Backend) remove(records []dns.RR) error {
+	if len(records) > 0 {
+		err := b.update(nil, records)
+		if err != nil {
+			return err
+		}
+	}
+
+	b.ownedMtx.Lock()
+	defer b.ownedMtx.Unlock()
+	for _, rr := range records {
+		delete(b.owned, rr.String())
+	}
+
+	return b.saveOwned()
+}
+
+// Purge removes all records owned by this instance, including the ones of a
+// previous run found in the state. Records created by anything else are left
+// untouched.
+func (b *Backend) Purge() error {
+	b.ownedMtx.Lock()
+	var remove []dns.RR
+	for _, v := range b.owned {
+		remove = append(remove, v.rr)
+	}
+	b.ownedMtx.Unlock()
+
+	if len(remove) == 0 {
+		return nil
+	}
+
+	err := b.update(nil, remove)
+	if err != nil {
+		return err
+	}
+
+	b.ownedMtx.Lock()
+	defer b.ownedMtx.Unlock()
+	b.owned = map[string]ownedRecord{}
+
+	return b.saveOwned()
+}
+
+// Refresh registers the records of all given containers and removes owned
+// records no container references anymore, e.g. the ones of containers
+// which stopped while creg was not running.
+func (b *Backend) Refresh(containers []ctypes.ContainerInfo) error {
+	b.Log.Debugf("Refreshing %d rfc2136 containers", len(containers))
+
+	var errs []error
+	running := map[string]struct{}{}
+	wanted := map[string]struct{}{}
+	for _, container := range containers {
+		running[container.ID] = struct{}{}
+
+		records := b.RecordsForContainer(container)
+		if len(records) == 0 {
+			continue
+		}
+		// Records of containers which could not be registered are kept
+		for _, rr := range records {
+			wanted[rr.String()] = struct{}{}
+		}
+
+		err := b.RegisterRecords(container.ID, records)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("container %s: %w", container.ID, err))
+		}
+	}
+
+	b.ownedMtx.Lock()
+	b.release(func(container string) bool {
+		_, ok := running[container]
+		return !ok
+	}, wanted)
+	// Including the records from the state no container registered again
+	var remove []dns.RR
+	for k, v := range b.owned {
+		if _, ok := wanted[k]; !ok && len(v.containers) == 0 {
+			remove = append(remove, v.rr)
+		}
+	}
+	b.ownedMtx.Unlock()
+
+	err := b.remove(remove)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("could not remove unreferenced records: %w", err))
+	}
+
+	return backends.JoinErrors(errs)
+}
+
+// update sends a single dynamic update inserting and removing the given
+// records.
+func (b *Backend) update(insert, remove []dns.RR) error {
+	m := new(dns.Msg)
+	m.SetUpdate(b.Zone)
+	if len(insert) > 0 {
+		m.Insert(insert)
+	}
+	if len(remove) > 0 {
+		m.Remove(remove)
+	}
+
+	c := &dns.Client{Net: b.Net, Timeout: b.Timeout}
+	if b.KeyName != "" {
+		c.TsigSecret = map[string]string{b.KeyName: b.KeySecret}
+		m.SetTsig(b.KeyName, b.KeyAlgorithm, 300, time.Now().Unix())
+	}
+
+	r, _, err := c.Exchange(m, b.Server)
+	if err != nil {
+		return fmt.Errorf("could not send update: %w", err)
+	}
+
+	if r.Rcode != dns.RcodeSuccess {
+		return fmt.Errorf("update rejected: %s", dns.RcodeToString[r.Rcode])
+	}
+
+	return nil
+}
+
+func WithLogger(log *logrus.Entry) func(b *Backend) {
+	return func(b *Backend) {
+		b.Log = log.WithField("backend", "rfc2136")
+	}
+}
+
+func WithForwardAddress(address string) func(b *Backend) {
+	return func(b *Backend) {
+		b.ForwardAddress = address
+	}
+}
+
+func WithTTL(ttl uint32) func(b *Backend) {
+	return func(b *Backend) {
+		b.TTL = ttl
+	}
+}
+
+// WithTSIG signs updates with the given key. The algorithm defaults to
+// hmac-sha256 if empty, the secret is base64 encoded as in BIND key files.
+func WithTSIG(name, algorithm, secret string) func(b *Backend) {
+	return func(b *Backend) {
+		b.KeyName = name
+		if algorithm != "" {
+			b.KeyAlgorithm = algorithm
+		}
+		b.KeySecret = secret
+	}
+}
+
+// WithStatePath persists the owned records in the file path, so they are
+// purged after a restart.
+func WithStatePath(path string) func(b *Backend) {
+	return func(b *Backend) {
+		b.StatePath = path
+	}
+}
+
+func WithTarget(target string) func(b *Backend) {
+	return func(b *Backend) {
+		b.Target = target
+	}
+}
+
+func WithNet(network string) func(b *Backend) {
+	return func(b *Backend) {
+		b.Net = network
+	}
+}
+
+// WithName sets the backend name, see backends.Settings.
+func WithName(name string) func(b *Backend) {
+	return func(b *Backend) {
+		if name != "" {
+			b.Name = name
+		}
+	}
+}
+
+// WithAddressStrategy sets the address strategy, see backends.AddressStrategy.
+func WithAddressStrategy(strategy backends.AddressStrategy) func(b *Backend) {
+	return func(b *Backend) {
+		b.AddressStrategy = strategy
+	}
+}
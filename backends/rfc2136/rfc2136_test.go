@@ -0,0 +1,303 @@
+package rfc2136_test
+
+import (
+	"net"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/miekg/dns"
+
+	"github.com/soupdiver/creg/backends/backendtest"
+	"github.com/soupdiver/creg/backends/rfc2136"
+	ctypes "github.com/soupdiver/creg/types"
+)
+
+const (
+	testKeyName   = "creg-key."
+	testKeySecret = "c2VjcmV0LXNlY3JldC1zZWNyZXQtc2VjcmV0IQ=="
+)
+
+// fakeZone is an in-process authoritative server applying dynamic updates to
+// an in-memory zone.
+type fakeZone struct {
+	mtx     sync.Mutex
+	records map[string]dns.RR
+}
+
+func (z *fakeZone) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+
+	if r.Opcode != dns.OpcodeUpdate || r.IsTsig() == nil || w.TsigStatus() != nil {
+		m.Rcode = dns.RcodeRefused
+		if r.IsTsig() != nil {
+			m.SetTsig(testKeyName, dns.HmacSHA256, 300, int64(r.IsTsig().TimeSigned))
+		}
+		w.WriteMsg(m)
+		return
+	}
+
+	z.mtx.Lock()
+	for _, rr := range r.Ns {
+		// Records are keyed without TTL, removals carry a TTL of 0
+		key := dns.Copy(rr)
+		key.Header().Class = dns.ClassINET
+		key.Header().Ttl = 0
+
+		switch rr.Header().Class {
+		case dns.ClassINET:
+			z.records[key.String()] = rr
+		case dns.ClassNONE:
+			delete(z.records, key.String())
+		}
+	}
+	z.mtx.Unlock()
+
+	m.SetTsig(testKeyName, dns.HmacSHA256, 300, int64(r.IsTsig().TimeSigned))
+	w.WriteMsg(m)
+}
+
+func (z *fakeZone) Records() []string {
+	z.mtx.Lock()
+	defer z.mtx.Unlock()
+
+	var v []string
+	for _, rr := range z.records {
+		v = append(v, strings.Join(strings.Fields(rr.String()), " "))
+	}
+	sort.Strings(v)
+	return v
+}
+
+func startFakeZone(t *testing.T) (*fakeZone, string) {
+	zone := &fakeZone{records: map[string]dns.RR{}}
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan struct{})
+	srv := &dns.Server{
+		PacketConn:        pc,
+		Handler:           zone,
+		TsigSecret:        map[string]string{testKeyName: testKeySecret},
+		NotifyStartedFunc: func() { close(started) },
+		// The default accept func rejects updates
+		MsgAcceptFunc: func(dh dns.Header) dns.MsgAcceptAction { return dns.MsgAccept },
+	}
+	go srv.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { srv.Shutdown() })
+
+	return zone, pc.LocalAddr().String()
+}
+
+func newTestBackend(t *testing.T, server string, options ...rfc2136.RFC2136Option) *rfc2136.Backend {
+	options = append([]rfc2136.RFC2136Option{
+		rfc2136.WithLogger(backendtest.Logger()),
+		rfc2136.WithForwardAddress("10.0.0.1"),
+		rfc2136.WithTTL(30),
+		rfc2136.WithTSIG("creg-key", "hmac-sha256", testKeySecret),
+	}, options...)
+
+	b, err := rfc2136.New(server, "example.org", options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func TestRecordsForContainer(t *testing.T) {
+	b := newTestBackend(t, "127.0.0.1")
+
+	records := b.RecordsForContainer(ctypes.ContainerInfo{
+		Labels: map[string]string{"creg.port": "'80/tcp:Web,53/udp:dns,invalid:bad.name'"},
+		NetworkSettings: ctypes.NetworkSettings{
+			Ports: map[ctypes.Port][]ctypes.PortBinding{
+				"80/tcp": {{HostIP: "0.0.0.0", HostPort: "8080"}},
+			},
+		},
+	})
+
+	var v []string
+	for _, rr := range records {
+		v = append(v, strings.Join(strings.Fields(rr.String()), " "))
+	}
+	sort.Strings(v)
+
+	expected := []string{
+		"_dns._udp.example.org. 30 IN SRV 0 0 53 dns.example.org.",
+		"_web._tcp.example.org. 30 IN SRV 0 0 8080 web.example.org.",
+		"dns.example.org. 30 IN A 10.0.0.1",
+		"web.example.org. 30 IN A 10.0.0.1",
+	}
+	if strings.Join(v, "|") != strings.Join(expected, "|") {
+		t.Fatalf("expected %v, got %v", expected, v)
+	}
+}
+
+func TestRecordsForContainerIPv6AndTarget(t *testing.T) {
+	b := newTestBackend(t, "127.0.0.1", rfc2136.WithForwardAddress("2001:db8::1"), rfc2136.WithTarget("docker1.example.org"))
+
+	records := b.RecordsForContainer(ctypes.ContainerInfo{
+		Labels: map[string]string{"creg.port": "80:web"},
+	})
+
+	var v []string
+	for _, rr := range records {
+		v = append(v, strings.Join(strings.Fields(rr.String()), " "))
+	}
+	sort.Strings(v)
+
+	expected := []string{
+		"_web._tcp.example.org. 30 IN SRV 0 0 80 docker1.example.org.",
+		"web.example.org. 30 IN AAAA 2001:db8::1",
+	}
+	if strings.Join(v, "|") != strings.Join(expected, "|") {
+		t.Fatalf("expected %v, got %v", expected, v)
+	}
+}
+
+func TestRegisterUnregisterAndPurge(t *testing.T) {
+	zone, addr := startFakeZone(t)
+	b := newTestBackend(t, addr)
+
+	web := ctypes.ContainerInfo{ID: "web", Labels: map[string]string{"creg.port": "80/tcp:web"}}
+	web2 := ctypes.ContainerInfo{ID: "web2", Labels: map[string]string{"creg.port": "81/tcp:web"}}
+	db := ctypes.ContainerInfo{ID: "db", Labels: map[string]string{"creg.port": "5432/tcp:db"}}
+
+	err := b.Refresh([]ctypes.ContainerInfo{web, web2, db})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(zone.Records()) != 5 {
+		t.Fatalf("expected %d records, got %v", 5, zone.Records())
+	}
+
+	// The A record of web is still referenced by web2
+	err = b.UnregisterContainer(web.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"_db._tcp.example.org. 30 IN SRV 0 0 5432 db.example.org.",
+		"_web._tcp.example.org. 30 IN SRV 0 0 81 web.example.org.",
+		"db.example.org. 30 IN A 10.0.0.1",
+		"web.example.org. 30 IN A 10.0.0.1",
+	}
+	if v := zone.Records(); strings.Join(v, "|") != strings.Join(expected, "|") {
+		t.Fatalf("expected %v, got %v", expected, v)
+	}
+
+	err = b.Purge()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(zone.Records()) != 0 {
+		t.Fatalf("expected %d records, got %v", 0, zone.Records())
+	}
+}
+
+func TestPurgeAfterRestart(t *testing.T) {
+	zone, addr := startFakeZone(t)
+	state := filepath.Join(t.TempDir(), "rfc2136.json")
+	b := newTestBackend(t, addr, rfc2136.WithStatePath(state))
+
+	err := b.Refresh([]ctypes.ContainerInfo{{ID: "web", Labels: map[string]string{"creg.port": "80/tcp:web"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(zone.Records()) != 2 {
+		t.Fatalf("expected %d records, got %v", 2, zone.Records())
+	}
+
+	// A new instance knows the records of the previous one from the state
+	restarted := newTestBackend(t, addr, rfc2136.WithStatePath(state))
+	err = restarted.Purge()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(zone.Records()) != 0 {
+		t.Fatalf("expected %d records, got %v", 0, zone.Records())
+	}
+}
+
+func TestRepeatedStartDoesNotLeakReferences(t *testing.T) {
+	zone, addr := startFakeZone(t)
+	b := newTestBackend(t, addr)
+
+	web := ctypes.ContainerInfo{ID: "web", Labels: map[string]string{"creg.port": "80/tcp:web"}}
+	for i := 0; i < 2; i++ {
+		err := b.RegisterRecords(web.ID, b.RecordsForContainer(web))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := b.UnregisterContainer(web.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(zone.Records()) != 0 {
+		t.Fatalf("expected %d records, got %v", 0, zone.Records())
+	}
+}
+
+func TestRefreshAfterRestartRemovesStoppedContainers(t *testing.T) {
+	zone, addr := startFakeZone(t)
+	state := filepath.Join(t.TempDir(), "rfc2136.json")
+	b := newTestBackend(t, addr, rfc2136.WithStatePath(state))
+
+	web := ctypes.ContainerInfo{ID: "web", Labels: map[string]string{"creg.port": "80/tcp:web"}}
+	db := ctypes.ContainerInfo{ID: "db", Labels: map[string]string{"creg.port": "5432/tcp:db"}}
+	err := b.Refresh([]ctypes.ContainerInfo{web, db})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// db stopped while creg was not running
+	restarted := newTestBackend(t, addr, rfc2136.WithStatePath(state))
+	err = restarted.Refresh([]ctypes.ContainerInfo{web})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"_web._tcp.example.org. 30 IN SRV 0 0 80 web.example.org.",
+		"web.example.org. 30 IN A 10.0.0.1",
+	}
+	if v := zone.Records(); strings.Join(v, "|") != strings.Join(expected, "|") {
+		t.Fatalf("expected %v, got %v", expected, v)
+	}
+
+	// The state only holds the records of web anymore
+	restarted = newTestBackend(t, addr, rfc2136.WithStatePath(state))
+	err = restarted.Refresh(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zone.Records()) != 0 {
+		t.Fatalf("expected %d records, got %v", 0, zone.Records())
+	}
+}
+
+func TestRejectedUpdate(t *testing.T) {
+	_, addr := startFakeZone(t)
+	b := newTestBackend(t, addr, rfc2136.WithTSIG("creg-key", "hmac-sha256", "d3Jvbmc="))
+
+	err := b.Refresh([]ctypes.ContainerInfo{{ID: "web", Labels: map[string]string{"creg.port": "80/tcp:web"}}})
+	if err == nil {
+		t.Fatal("expected update with wrong key to fail")
+	}
+}
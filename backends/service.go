@@ -0,0 +1,50 @@
+package backends
+
+import (
+	"strconv"
+
+	ctypes "github.com/soupdiver/creg/types"
+)
+
+// Service is a single port of a container registered under a service name.
+type Service struct {
+	Name      string               `json:"name"`
+	Address   string               `json:"address"`
+	Port      int                  `json:"port"`
+	Proto     string               `json:"proto"`
+	Tags      []string             `json:"tags"`
+	Container ctypes.ContainerInfo `json:"container"`
+}
+
+// ServicesForContainer returns the services in the creg.port label of
+// container, reachable at the endpoint selected by strategy, address is the
+// forward address.
+func ServicesForContainer(container ctypes.ContainerInfo, strategy AddressStrategy, address string, staticLabels []string, filters []FilterFunc) []Service {
+	ports := ExtractPorts(container.Labels, ServiceLabelPort)
+
+	var services []Service
+	for key, service := range MapServices(ports, container.Labels, staticLabels, filters) {
+		proto, p := ctypes.SplitProtoPort(key)
+		if p == "" {
+			continue
+		}
+		containerPort := ctypes.Port(p + "/" + proto)
+
+		serviceAddress, servicePort := strategy.Endpoint(container, containerPort, address)
+		port, err := strconv.ParseUint(servicePort, 10, 16)
+		if err != nil {
+			continue
+		}
+
+		services = append(services, Service{
+			Name:      service.Name,
+			Address:   serviceAddress,
+			Port:      int(port),
+			Proto:     proto,
+			Tags:      service.Labels,
+			Container: container,
+		})
+	}
+
+	return services
+}
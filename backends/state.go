@@ -0,0 +1,59 @@
+package backends
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// LoadState decodes the JSON state file at path into v. Backends persist the
+// entries they own in systems which can not mark entries as created by creg,
+// so they are purged after a restart. A missing file or empty path leaves v
+// unchanged.
+func LoadState(path string, v interface{}) error {
+	if path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not read state: %w", err)
+	}
+
+	err = json.Unmarshal(data, v)
+	if err != nil {
+		return fmt.Errorf("could not decode state %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// SaveState writes v as JSON to the state file at path, an empty path does
+// not persist state.
+func SaveState(path string, v interface{}) error {
+	if path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("could not encode state: %w", err)
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return fmt.Errorf("could not create state directory: %w", err)
+	}
+
+	_, err = WriteFileAtomic(path, data, 0o644)
+	if err != nil {
+		return fmt.Errorf("could not write state: %w", err)
+	}
+
+	return nil
+}
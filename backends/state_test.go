@@ -0,0 +1,48 @@
+package backends_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/soupdiver/creg/backends"
+)
+
+func TestState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "backend.json")
+
+	var v []string
+	err := backends.LoadState(path, &v)
+	if err != nil {
+		t.Fatalf("expected no error for missing state, got %s", err)
+	}
+
+	err = backends.SaveState(path, []string{"a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = backends.LoadState(path, &v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v) != 2 || v[0] != "a" || v[1] != "b" {
+		t.Fatalf("expected [a b], got %v", v)
+	}
+}
+
+func TestStatePath(t *testing.T) {
+	settings := backends.Settings{ID: "creg-1"}
+	if path := settings.StatePath("consul"); path != "" {
+		t.Fatalf("expected no state path without state dir, got %s", path)
+	}
+
+	settings.StateDir = "/var/lib/creg"
+	if path := settings.StatePath("consul"); path != "/var/lib/creg/creg-1-consul.json" {
+		t.Fatalf("expected /var/lib/creg/creg-1-consul.json, got %s", path)
+	}
+
+	settings.Name = "consul-dc1"
+	if path := settings.StatePath("consul"); path != "/var/lib/creg/creg-1-consul-dc1.json" {
+		t.Fatalf("expected /var/lib/creg/creg-1-consul-dc1.json, got %s", path)
+	}
+}
@@ -0,0 +1,48 @@
+package template
+
+import (
+	"time"
+
+	"github.com/soupdiver/creg/backends"
+)
+
+// BackendConfig configures a template backend in the config file.
+type BackendConfig struct {
+	// Templates in the format source:destination
+	Templates []string `yaml:"templates"`
+	// ReloadCommand is run with sh -c after a rendered template changed
+	ReloadCommand string `yaml:"reload_command"`
+	// ReloadInterval is the minimum time between two reload commands
+	ReloadInterval time.Duration `yaml:"reload_interval"`
+}
+
+func init() {
+	backends.Register("template", backends.Factory{
+		Config: func() interface{} {
+			return &BackendConfig{ReloadInterval: time.Second}
+		},
+		New: func(config interface{}, settings backends.Settings) (backends.Backend, error) {
+			cfg := config.(*BackendConfig)
+
+			var templates []Template
+			for _, v := range cfg.Templates {
+				t, err := ParseTemplate(v)
+				if err != nil {
+					return nil, err
+				}
+				templates = append(templates, t)
+			}
+
+			return New(templates,
+				WithName(settings.Name),
+				WithLogger(settings.Log),
+				WithID(settings.ID),
+				WithForwardAddress(settings.ForwardAddress),
+				WithAddressStrategy(settings.AddressStrategy),
+				WithStaticLabels(settings.StaticLabels),
+				WithReloadCommand(cfg.ReloadCommand),
+				WithReloadInterval(cfg.ReloadInterval),
+			)
+		},
+	})
+}
@@ -0,0 +1,359 @@
+package template
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/soupdiver/creg/backends"
+	ctypes "github.com/soupdiver/creg/types"
+)
+
+// Template is a template file rendered to Destination.
+type Template struct {
+	Source      string
+	Destination string
+
+	tmpl *texttemplate.Template
+}
+
+// ParseTemplate parses a template flag in the format source:destination.
+func ParseTemplate(v string) (Template, error) {
+	source, destination, ok := strings.Cut(v, ":")
+	if !ok || source == "" || destination == "" {
+		return Template{}, fmt.Errorf("template must be in the format source:destination: %q", v)
+	}
+
+	return Template{Source: source, Destination: destination}, nil
+}
+
+// Data is passed to every template.
+type Data struct {
+	// ID of the creg instance
+	ID string
+	// Services sorted by name, address and port
+	Services []backends.Service
+}
+
+// Funcs are available in every template in addition to the text/template
+// builtins.
+var Funcs = texttemplate.FuncMap{
+	// byName groups services by name: {{ range $name, $services := byName .Services }}
+	"byName": func(services []backends.Service) map[string][]backends.Service {
+		m := map[string][]backends.Service{}
+		for _, service := range services {
+			m[service.Name] = append(m[service.Name], service)
+		}
+		return m
+	},
+	"join": func(sep string, v []string) string {
+		return strings.Join(v, sep)
+	},
+	"env": os.Getenv,
+}
+
+// Backend renders Go text/template files with the services of all containers
+// and runs a reload command whenever one of the files changed. Reloads are at
+// least ReloadInterval apart, changes in between are folded into one reload.
+type Backend struct {
+	ID              string
+	Name            string
+	Log             *logrus.Entry
+	Templates       []Template
+	ForwardAddress  string
+	AddressStrategy backends.AddressStrategy
+	StaticLabels    []string
+	ReloadCommand   string
+	ReloadInterval  time.Duration
+	ReloadTimeout   time.Duration
+
+	// services holds the services of each container by container ID
+	services    map[string][]backends.Service
+	servicesMtx sync.Mutex
+	// writeMtx serializes rendering so files are never written concurrently
+	writeMtx sync.Mutex
+
+	reload chan struct{}
+}
+
+type TemplateOption func(*Backend)
+
+func New(templates []Template, options ...TemplateOption) (*Backend, error) {
+	b := &Backend{
+		Name:           "template",
+		Log:            logrus.NewEntry(logrus.StandardLogger()),
+		ReloadInterval: time.Second,
+		ReloadTimeout:  time.Minute,
+		services:       map[string][]backends.Service{},
+		reload:         make(chan struct{}, 1),
+	}
+
+	for _, option := range options {
+		option(b)
+	}
+
+	for _, t := range templates {
+		tmpl, err := texttemplate.New(filepath.Base(t.Source)).Funcs(Funcs).ParseFiles(t.Source)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse template: %w", err)
+		}
+		t.tmpl = tmpl
+		b.Templates = append(b.Templates, t)
+	}
+
+	return b, nil
+}
+
+func (b *Backend) Run(ctx context.Context, events chan ctypes.ContainerEventV2, purgeOnStart bool, containersToRefresh []ctypes.ContainerInfo) error {
+	go b.reloader(ctx)
+
+	// Always refresh, this renders the templates even if there are no
+	// containers. Refresh replaces all services, so it purges as well,
+	// purging first would render empty templates and reload with them.
+	err := b.Refresh(containersToRefresh)
+	if err != nil {
+		// Every event writes again, a failed write is not fatal
+		b.Log.Errorf("Could not Refresh: %s", err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			b.Log.Infof("Template exting: %s", "context cancelled")
+			return nil
+		case event := <-events:
+			b.servicesMtx.Lock()
+			switch event.Action {
+			case "start":
+				b.setContainer(event.Container)
+			case "stop":
+				delete(b.services, event.Container.ID)
+			default:
+				b.servicesMtx.Unlock()
+				continue
+			}
+			b.servicesMtx.Unlock()
+
+			err := b.Write()
+			if err != nil {
+				b.Log.Errorf("Could not Write: %s", err)
+				continue
+			}
+		}
+	}
+}
+
+func (b *Backend) GetName() string {
+	return b.Name
+}
+
+// setContainer must be called with servicesMtx held.
+func (b *Backend) setContainer(container ctypes.ContainerInfo) {
+	services := backends.ServicesForContainer(container, b.AddressStrategy, b.ForwardAddress, b.StaticLabels, nil)
+	if len(services) == 0 {
+		delete(b.services, container.ID)
+		return
+	}
+	b.services[container.ID] = services
+}
+
+// Data returns the current template data.
+func (b *Backend) Data() Data {
+	b.servicesMtx.Lock()
+	services := []backends.Service{}
+	for _, v := range b.services {
+		services = append(services, v...)
+	}
+	b.servicesMtx.Unlock()
+
+	sort.Slice(services, func(i, j int) bool {
+		if services[i].Name != services[j].Name {
+			return services[i].Name < services[j].Name
+		}
+		if services[i].Address != services[j].Address {
+			return services[i].Address < services[j].Address
+		}
+		if services[i].Port != services[j].Port {
+			return services[i].Port < services[j].Port
+		}
+		return services[i].Container.ID < services[j].Container.ID
+	})
+
+	return Data{ID: b.ID, Services: services}
+}
+
+// Write renders all templates and atomically replaces the files whose content
+// changed. A reload is requested if any file changed.
+func (b *Backend) Write() error {
+	b.writeMtx.Lock()
+	defer b.writeMtx.Unlock()
+
+	data := b.Data()
+
+	var errs []error
+	changed := false
+	for _, t := range b.Templates {
+		var buf bytes.Buffer
+		err := t.tmpl.Execute(&buf, data)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not render %s: %w", t.Source, err))
+			continue
+		}
+
+		c, err := backends.WriteFileAtomic(t.Destination, buf.Bytes(), 0o644)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not write %s: %w", t.Destination, err))
+			continue
+		}
+		if c {
+			b.Log.Debugf("Wrote %s", t.Destination)
+			changed = true
+		}
+	}
+
+	if changed && b.ReloadCommand != "" {
+		select {
+		case b.reload <- struct{}{}:
+		default:
+			// A reload is pending already and will see this change
+		}
+	}
+
+	return backends.JoinErrors(errs)
+}
+
+// reloader runs the reload command for every requested reload, at most once
+// per ReloadInterval.
+func (b *Backend) reloader(ctx context.Context) {
+	var last time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-b.reload:
+		}
+
+		if wait := time.Until(last.Add(b.ReloadInterval)); wait > 0 {
+			b.Log.Debugf("Delay reload by %s", wait)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+
+		// Files written while waiting are covered by this reload
+		select {
+		case <-b.reload:
+		default:
+		}
+
+		err := b.Reload(ctx)
+		if err != nil {
+			b.Log.Errorf("Could not Reload: %s", err)
+		}
+		last = time.Now()
+	}
+}
+
+// Reload runs the reload command with sh -c.
+func (b *Backend) Reload(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, b.ReloadTimeout)
+	defer cancel()
+
+	b.Log.Debugf("Reload: %s", b.ReloadCommand)
+	out, err := exec.CommandContext(ctx, "sh", "-c", b.ReloadCommand).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+// Purge renders the templates without any services.
+func (b *Backend) Purge() error {
+	b.servicesMtx.Lock()
+	b.services = map[string][]backends.Service{}
+	b.servicesMtx.Unlock()
+
+	return b.Write()
+}
+
+// Refresh replaces the services with the ones of containers and renders the
+// templates.
+func (b *Backend) Refresh(containers []ctypes.ContainerInfo) error {
+	b.Log.Debugf("Refreshing %d template containers", len(containers))
+
+	b.servicesMtx.Lock()
+	b.services = map[string][]backends.Service{}
+	for _, container := range containers {
+		b.setContainer(container)
+	}
+	b.servicesMtx.Unlock()
+
+	return b.Write()
+}
+
+func WithLogger(log *logrus.Entry) func(b *Backend) {
+	return func(b *Backend) {
+		b.Log = log.WithField("backend", "template")
+	}
+}
+
+func WithForwardAddress(address string) func(b *Backend) {
+	return func(b *Backend) {
+		b.ForwardAddress = address
+	}
+}
+
+func WithStaticLabels(labels []string) func(b *Backend) {
+	return func(b *Backend) {
+		b.StaticLabels = labels
+	}
+}
+
+func WithID(id string) func(b *Backend) {
+	return func(b *Backend) {
+		b.ID = id
+	}
+}
+
+// WithReloadCommand sets the command run with sh -c after a file changed.
+func WithReloadCommand(command string) func(b *Backend) {
+	return func(b *Backend) {
+		b.ReloadCommand = command
+	}
+}
+
+// WithReloadInterval sets the minimum time between two reloads.
+func WithReloadInterval(interval time.Duration) func(b *Backend) {
+	return func(b *Backend) {
+		b.ReloadInterval = interval
+	}
+}
+
+// WithName sets the backend name, see backends.Settings.
+func WithName(name string) func(b *Backend) {
+	return func(b *Backend) {
+		if name != "" {
+			b.Name = name
+		}
+	}
+}
+
+// WithAddressStrategy sets the address strategy, see backends.AddressStrategy.
+func WithAddressStrategy(strategy backends.AddressStrategy) func(b *Backend) {
+	return func(b *Backend) {
+		b.AddressStrategy = strategy
+	}
+}
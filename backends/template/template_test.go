@@ -0,0 +1,192 @@
+package template_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/soupdiver/creg/backends/backendtest"
+	"github.com/soupdiver/creg/backends/template"
+	ctypes "github.com/soupdiver/creg/types"
+)
+
+const testTemplate = `{{ range $name, $services := byName .Services }}upstream {{ $name }} {
+{{- range $services }}
+  server {{ .Address }}:{{ .Port }}; # {{ .Container.ID }} {{ join "," .Tags }}
+{{- end }}
+}
+{{ end }}`
+
+func newTestBackend(t *testing.T, options ...template.TemplateOption) (*template.Backend, string) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "nginx.conf.tmpl")
+	err := os.WriteFile(source, []byte(testTemplate), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl, err := template.ParseTemplate(source + ":" + filepath.Join(dir, "nginx.conf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	options = append([]template.TemplateOption{
+		template.WithLogger(backendtest.Logger()),
+		template.WithForwardAddress("10.0.0.1"),
+		template.WithStaticLabels([]string{"dc=remote"}),
+	}, options...)
+
+	b, err := template.New([]template.Template{tmpl}, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b, tmpl.Destination
+}
+
+func TestRender(t *testing.T) {
+	b, path := newTestBackend(t)
+
+	err := b.Refresh([]ctypes.ContainerInfo{backendtest.Container("b", "8081"), backendtest.Container("a", "8080")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `upstream web {
+  server 10.0.0.1:8080; # a dc=remote
+  server 10.0.0.1:8081; # b dc=remote
+}
+`
+	if string(data) != expected {
+		t.Fatalf("expected %q, got %q", expected, data)
+	}
+
+	err = b.Purge()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 0 {
+		t.Fatalf("expected empty file, got %q", data)
+	}
+}
+
+func TestParseTemplate(t *testing.T) {
+	for _, v := range []string{"", "source", "source:", ":destination"} {
+		_, err := template.ParseTemplate(v)
+		if err == nil {
+			t.Fatalf("expected %q to fail", v)
+		}
+	}
+}
+
+func readReloads(t *testing.T, path string) []time.Time {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var reloads []time.Time
+	for _, v := range strings.Fields(string(data)) {
+		ns, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reloads = append(reloads, time.Unix(0, ns))
+	}
+
+	return reloads
+}
+
+func waitReloads(t *testing.T, path string, n int) []time.Time {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if reloads := readReloads(t, path); len(reloads) >= n {
+			return reloads
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("expected %d reloads, got %d", n, len(readReloads(t, path)))
+	return nil
+}
+
+func TestReloadRateLimit(t *testing.T) {
+	reloads := filepath.Join(t.TempDir(), "reloads")
+	interval := 300 * time.Millisecond
+
+	b, _ := newTestBackend(t,
+		template.WithReloadCommand("date +%s%N >> "+reloads),
+		template.WithReloadInterval(interval),
+	)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := make(chan ctypes.ContainerEventV2)
+	go b.Run(ctx, events, false, []ctypes.ContainerInfo{backendtest.Container("a", "8080")})
+
+	waitReloads(t, reloads, 1)
+
+	// Both changes fall into the same interval and cause a single reload
+	events <- ctypes.ContainerEventV2{Action: "start", Container: backendtest.Container("b", "8081")}
+	events <- ctypes.ContainerEventV2{Action: "start", Container: backendtest.Container("c", "8082")}
+	// Unchanged output does not reload
+	events <- ctypes.ContainerEventV2{Action: "start", Container: backendtest.Container("c", "8082")}
+
+	waitReloads(t, reloads, 2)
+	time.Sleep(2 * interval)
+	times := readReloads(t, reloads)
+	if len(times) != 2 {
+		t.Fatalf("expected %d reloads, got %d", 2, len(times))
+	}
+	if gap := times[1].Sub(times[0]); gap < interval {
+		t.Fatalf("expected reloads at least %s apart, got %s", interval, gap)
+	}
+}
+
+func TestRunWithPurgeKeepsUnchangedOutput(t *testing.T) {
+	b, destination := newTestBackend(t)
+	containers := []ctypes.ContainerInfo{backendtest.Container("a", "8080")}
+
+	// Output of a previous run
+	err := b.Refresh(containers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.Stat(destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go b.Run(ctx, make(chan ctypes.ContainerEventV2), true, containers)
+	time.Sleep(200 * time.Millisecond)
+
+	// Purging on start must not render empty templates before the refresh
+	after, err := os.Stat(destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !after.ModTime().Equal(before.ModTime()) {
+		t.Fatalf("expected %s not to be rewritten", destination)
+	}
+}
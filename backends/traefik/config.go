@@ -0,0 +1,40 @@
+package traefik
+
+// Config is the subset of the Traefik dynamic configuration creg renders for
+// the file provider.
+type Config struct {
+	HTTP *HTTPConfig `yaml:"http,omitempty"`
+}
+
+type HTTPConfig struct {
+	Routers     map[string]*Router                `yaml:"routers,omitempty"`
+	Services    map[string]*Service               `yaml:"services,omitempty"`
+	Middlewares map[string]map[string]interface{} `yaml:"middlewares,omitempty"`
+}
+
+type Router struct {
+	Rule        string     `yaml:"rule,omitempty"`
+	EntryPoints []string   `yaml:"entryPoints,omitempty"`
+	Middlewares []string   `yaml:"middlewares,omitempty"`
+	Service     string     `yaml:"service,omitempty"`
+	Priority    int        `yaml:"priority,omitempty"`
+	TLS         *RouterTLS `yaml:"tls,omitempty"`
+}
+
+type RouterTLS struct {
+	CertResolver string `yaml:"certResolver,omitempty"`
+	Options      string `yaml:"options,omitempty"`
+}
+
+type Service struct {
+	LoadBalancer *LoadBalancer `yaml:"loadBalancer,omitempty"`
+}
+
+type LoadBalancer struct {
+	Servers        []Server `yaml:"servers"`
+	PassHostHeader *bool    `yaml:"passHostHeader,omitempty"`
+}
+
+type Server struct {
+	URL string `yaml:"url"`
+}
@@ -0,0 +1,27 @@
+package traefik
+
+import (
+	"github.com/soupdiver/creg/backends"
+)
+
+// BackendConfig configures a traefik backend in the config file.
+type BackendConfig struct {
+	// Path of the dynamic configuration file to write
+	Path string `yaml:"path"`
+}
+
+func init() {
+	backends.Register("traefik", backends.Factory{
+		Config: func() interface{} { return &BackendConfig{} },
+		New: func(config interface{}, settings backends.Settings) (backends.Backend, error) {
+			cfg := config.(*BackendConfig)
+
+			return New(cfg.Path,
+				WithName(settings.Name),
+				WithLogger(settings.Log),
+				WithForwardAddress(settings.ForwardAddress),
+				WithAddressStrategy(settings.AddressStrategy),
+			)
+		},
+	})
+}
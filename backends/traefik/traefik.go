@@ -0,0 +1,449 @@
+package traefik
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+
+	"github.com/soupdiver/creg/backends"
+	ctypes "github.com/soupdiver/creg/types"
+)
+
+const (
+	LabelEnable = "traefik.enable"
+	labelPrefix = "traefik.http."
+)
+
+// Backend renders a Traefik dynamic configuration file for the file provider
+// from the traefik.http.* labels of containers labelled traefik.enable=true.
+// Servers point to ForwardAddress and the published host port, replicas
+// defining the same service are aggregated into one load balancer.
+type Backend struct {
+	Name            string
+	Log             *logrus.Entry
+	Path            string
+	ForwardAddress  string
+	AddressStrategy backends.AddressStrategy
+
+	// configs holds the parsed configuration of each container by container ID
+	configs    map[string]*HTTPConfig
+	configsMtx sync.Mutex
+}
+
+type TraefikOption func(*Backend)
+
+func New(path string, options ...TraefikOption) (*Backend, error) {
+	b := &Backend{
+		Name:    "traefik",
+		Log:     logrus.NewEntry(logrus.StandardLogger()),
+		Path:    path,
+		configs: map[string]*HTTPConfig{},
+	}
+
+	for _, option := range options {
+		option(b)
+	}
+
+	return b, nil
+}
+
+func (b *Backend) Run(ctx context.Context, events chan ctypes.ContainerEventV2, purgeOnStart bool, containersToRefresh []ctypes.ContainerInfo) error {
+	var err error
+	if purgeOnStart {
+		err = b.Purge()
+		if err != nil {
+			b.Log.Errorf("Could not Purge: %s", err)
+		}
+	}
+
+	// Always refresh, this writes the file even if there are no containers
+	err = b.Refresh(containersToRefresh)
+	if err != nil {
+		// Every event writes again, a failed write is not fatal
+		b.Log.Errorf("Could not Refresh: %s", err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			b.Log.Infof("Traefik exting: %s", "context cancelled")
+			return nil
+		case event := <-events:
+			b.configsMtx.Lock()
+			switch event.Action {
+			case "start":
+				b.setContainer(event.Container)
+			case "stop":
+				delete(b.configs, event.Container.ID)
+			default:
+				b.configsMtx.Unlock()
+				continue
+			}
+			b.configsMtx.Unlock()
+
+			err := b.Write()
+			if err != nil {
+				b.Log.Errorf("Could not Write: %s", err)
+				continue
+			}
+		}
+	}
+}
+
+func (b *Backend) GetName() string {
+	return b.Name
+}
+
+// ContainerConfig parses the traefik labels of container. It returns nil if
+// the container is not enabled for traefik or defines nothing.
+func (b *Backend) ContainerConfig(container ctypes.ContainerInfo) (*HTTPConfig, error) {
+	if v, ok := container.Labels[LabelEnable]; !ok || v != "true" {
+		return nil, nil
+	}
+
+	cfg := &HTTPConfig{
+		Routers:     map[string]*Router{},
+		Services:    map[string]*Service{},
+		Middlewares: map[string]map[string]interface{}{},
+	}
+
+	ports := map[string]string{}
+	schemes := map[string]string{}
+	var errs []error
+	for k, v := range container.Labels {
+		if !strings.HasPrefix(strings.ToLower(k), labelPrefix) {
+			continue
+		}
+
+		parts := strings.Split(k[len(labelPrefix):], ".")
+		if len(parts) < 3 {
+			continue
+		}
+		kind, name, option := strings.ToLower(parts[0]), parts[1], strings.ToLower(strings.Join(parts[2:], "."))
+
+		var err error
+		switch kind {
+		case "routers":
+			router, ok := cfg.Routers[name]
+			if !ok {
+				router = &Router{}
+				cfg.Routers[name] = router
+			}
+			err = setRouterOption(router, option, v)
+		case "services":
+			if _, ok := cfg.Services[name]; !ok {
+				cfg.Services[name] = &Service{}
+			}
+			switch option {
+			case "loadbalancer.server.port":
+				ports[name] = v
+			case "loadbalancer.server.scheme":
+				schemes[name] = v
+			case "loadbalancer.passhostheader":
+				var pass bool
+				pass, err = strconv.ParseBool(v)
+				cfg.Services[name].LoadBalancer = &LoadBalancer{PassHostHeader: &pass}
+			default:
+				err = fmt.Errorf("unsupported option")
+			}
+		case "middlewares":
+			middleware, ok := cfg.Middlewares[name]
+			if !ok {
+				middleware = map[string]interface{}{}
+				cfg.Middlewares[name] = middleware
+			}
+			setNested(middleware, parts[2:], v)
+		}
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", k, err))
+		}
+	}
+
+	// Routers without a service use the only service of the container or a
+	// service named after the router, like the docker provider does
+	for name, router := range cfg.Routers {
+		if router.Service != "" {
+			continue
+		}
+		if len(cfg.Services) == 1 {
+			for service := range cfg.Services {
+				router.Service = service
+			}
+			continue
+		}
+		router.Service = name
+		if _, ok := cfg.Services[name]; !ok {
+			cfg.Services[name] = &Service{}
+		}
+	}
+
+	for name, service := range cfg.Services {
+		address, port, err := b.endpoint(container, name, ports[name])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("service %s: %w", name, err))
+			delete(cfg.Services, name)
+			continue
+		}
+
+		scheme := schemes[name]
+		if scheme == "" {
+			scheme = "http"
+		}
+
+		if service.LoadBalancer == nil {
+			service.LoadBalancer = &LoadBalancer{}
+		}
+		service.LoadBalancer.Servers = []Server{{URL: scheme + "://" + net.JoinHostPort(address, port)}}
+	}
+
+	if len(cfg.Routers) == 0 && len(cfg.Services) == 0 && len(cfg.Middlewares) == 0 {
+		return nil, backends.JoinErrors(errs)
+	}
+
+	return cfg, backends.JoinErrors(errs)
+}
+
+// endpoint resolves the address and port of a service. containerPort is the
+// value of the loadbalancer.server.port label. Without it the creg.port
+// service of the same name or the only published port is used.
+func (b *Backend) endpoint(container ctypes.ContainerInfo, service, containerPort string) (string, string, error) {
+	if containerPort == "" {
+		for _, v := range backends.ServicesForContainer(container, b.AddressStrategy, b.ForwardAddress, nil, nil) {
+			if v.Name == service && v.Proto == "tcp" {
+				return v.Address, strconv.Itoa(v.Port), nil
+			}
+		}
+	}
+
+	return b.AddressStrategy.TCPEndpoint(container, containerPort, b.ForwardAddress)
+}
+
+func setRouterOption(router *Router, option, value string) error {
+	switch option {
+	case "rule":
+		router.Rule = value
+	case "entrypoints":
+		router.EntryPoints = splitList(value)
+	case "middlewares":
+		router.Middlewares = splitList(value)
+	case "service":
+		router.Service = value
+	case "priority":
+		priority, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		router.Priority = priority
+	case "tls":
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		if enabled && router.TLS == nil {
+			router.TLS = &RouterTLS{}
+		}
+	case "tls.certresolver":
+		if router.TLS == nil {
+			router.TLS = &RouterTLS{}
+		}
+		router.TLS.CertResolver = value
+	case "tls.options":
+		if router.TLS == nil {
+			router.TLS = &RouterTLS{}
+		}
+		router.TLS.Options = value
+	default:
+		return fmt.Errorf("unsupported option")
+	}
+
+	return nil
+}
+
+// setNested sets value at path in m, values containing commas become lists.
+func setNested(m map[string]interface{}, path []string, value string) {
+	for _, key := range path[:len(path)-1] {
+		next, ok := m[key].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			m[key] = next
+		}
+		m = next
+	}
+
+	if strings.Contains(value, ",") {
+		m[path[len(path)-1]] = splitList(value)
+		return
+	}
+	m[path[len(path)-1]] = value
+}
+
+func splitList(value string) []string {
+	var list []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+// setContainer must be called with configsMtx held.
+func (b *Backend) setContainer(container ctypes.ContainerInfo) {
+	cfg, err := b.ContainerConfig(container)
+	if err != nil {
+		b.Log.Errorf("Invalid traefik labels on %s: %s", container.ID, err)
+	}
+
+	if cfg == nil {
+		delete(b.configs, container.ID)
+		return
+	}
+	b.configs[container.ID] = cfg
+}
+
+// Config merges the configuration of all containers. Servers of services with
+// the same name are combined, for routers and middlewares defined by several
+// containers the one of the lowest container ID wins.
+func (b *Backend) Config() Config {
+	b.configsMtx.Lock()
+	defer b.configsMtx.Unlock()
+
+	ids := make([]string, 0, len(b.configs))
+	for id := range b.configs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	merged := &HTTPConfig{
+		Routers:     map[string]*Router{},
+		Services:    map[string]*Service{},
+		Middlewares: map[string]map[string]interface{}{},
+	}
+
+	for _, id := range ids {
+		cfg := b.configs[id]
+
+		for name, router := range cfg.Routers {
+			if _, ok := merged.Routers[name]; !ok {
+				merged.Routers[name] = router
+			}
+		}
+
+		for name, middleware := range cfg.Middlewares {
+			if _, ok := merged.Middlewares[name]; !ok {
+				merged.Middlewares[name] = middleware
+			}
+		}
+
+		for name, service := range cfg.Services {
+			existing, ok := merged.Services[name]
+			if !ok {
+				lb := *service.LoadBalancer
+				lb.Servers = append([]Server(nil), lb.Servers...)
+				merged.Services[name] = &Service{LoadBalancer: &lb}
+				continue
+			}
+
+		Servers:
+			for _, server := range service.LoadBalancer.Servers {
+				for _, v := range existing.LoadBalancer.Servers {
+					if v == server {
+						continue Servers
+					}
+				}
+				existing.LoadBalancer.Servers = append(existing.LoadBalancer.Servers, server)
+			}
+		}
+	}
+
+	for _, service := range merged.Services {
+		sort.Slice(service.LoadBalancer.Servers, func(i, j int) bool {
+			return service.LoadBalancer.Servers[i].URL < service.LoadBalancer.Servers[j].URL
+		})
+	}
+
+	if len(merged.Routers) == 0 && len(merged.Services) == 0 && len(merged.Middlewares) == 0 {
+		return Config{}
+	}
+
+	return Config{HTTP: merged}
+}
+
+// Write renders the merged configuration and atomically replaces the file if
+// the content changed.
+func (b *Backend) Write() error {
+	data, err := yaml.Marshal(b.Config())
+	if err != nil {
+		return fmt.Errorf("could not render: %w", err)
+	}
+
+	changed, err := backends.WriteFileAtomic(b.Path, data, 0o644)
+	if err != nil {
+		return fmt.Errorf("could not write %s: %w", b.Path, err)
+	}
+	if changed {
+		b.Log.Debugf("Wrote %s", b.Path)
+	}
+
+	return nil
+}
+
+// Purge removes all routers, services and middlewares from the file.
+func (b *Backend) Purge() error {
+	b.configsMtx.Lock()
+	b.configs = map[string]*HTTPConfig{}
+	b.configsMtx.Unlock()
+
+	return b.Write()
+}
+
+// Refresh replaces the configuration with the one of containers and writes
+// the file.
+func (b *Backend) Refresh(containers []ctypes.ContainerInfo) error {
+	b.Log.Debugf("Refreshing %d traefik containers", len(containers))
+
+	b.configsMtx.Lock()
+	b.configs = map[string]*HTTPConfig{}
+	for _, container := range containers {
+		b.setContainer(container)
+	}
+	b.configsMtx.Unlock()
+
+	return b.Write()
+}
+
+func WithLogger(log *logrus.Entry) func(b *Backend) {
+	return func(b *Backend) {
+		b.Log = log.WithField("backend", "traefik")
+	}
+}
+
+func WithForwardAddress(address string) func(b *Backend) {
+	return func(b *Backend) {
+		b.ForwardAddress = address
+	}
+}
+
+// WithName sets the backend name, see backends.Settings.
+func WithName(name string) func(b *Backend) {
+	return func(b *Backend) {
+		if name != "" {
+			b.Name = name
+		}
+	}
+}
+
+// WithAddressStrategy sets the address strategy, see backends.AddressStrategy.
+func WithAddressStrategy(strategy backends.AddressStrategy) func(b *Backend) {
+	return func(b *Backend) {
+		b.AddressStrategy = strategy
+	}
+}
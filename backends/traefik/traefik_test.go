@@ -0,0 +1,169 @@
+package traefik_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/soupdiver/creg/backends/backendtest"
+	"github.com/soupdiver/creg/backends/traefik"
+	ctypes "github.com/soupdiver/creg/types"
+)
+
+func newTestBackend(t *testing.T, path string) *traefik.Backend {
+	b, err := traefik.New(path,
+		traefik.WithLogger(backendtest.Logger()),
+		traefik.WithForwardAddress("10.0.0.1"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func replica(id, hostPort string) ctypes.ContainerInfo {
+	return ctypes.ContainerInfo{
+		ID: id,
+		Labels: map[string]string{
+			"traefik.enable":                                        "true",
+			"traefik.http.routers.web.rule":                         "Host(`web.example.com`)",
+			"traefik.http.routers.web.entrypoints":                  "websecure",
+			"traefik.http.routers.web.middlewares":                  "strip",
+			"traefik.http.routers.web.tls.certresolver":             "le",
+			"traefik.http.services.web.loadbalancer.server.port":    "80",
+			"traefik.http.middlewares.strip.stripprefix.prefixes":   "/a,/b",
+			"traefik.http.services.web.loadbalancer.passhostheader": "true",
+			"traefik.http.services.web.loadbalancer.server.scheme":  "http",
+		},
+		NetworkSettings: ctypes.NetworkSettings{
+			Ports: map[ctypes.Port][]ctypes.PortBinding{"80/tcp": {{HostIP: "0.0.0.0", HostPort: hostPort}}},
+		},
+	}
+}
+
+func readConfig(t *testing.T, path string) traefik.Config {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cfg traefik.Config
+	err = yaml.Unmarshal(data, &cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cfg
+}
+
+func TestRefreshAggregatesReplicas(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "creg.yml")
+	b := newTestBackend(t, path)
+
+	err := b.Refresh([]ctypes.ContainerInfo{
+		replica("b", "8081"),
+		replica("a", "8080"),
+		{ID: "disabled", Labels: map[string]string{"traefik.http.routers.other.rule": "Host(`other`)"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := readConfig(t, path)
+	if cfg.HTTP == nil {
+		t.Fatal("expected http configuration")
+	}
+
+	router, ok := cfg.HTTP.Routers["web"]
+	if !ok {
+		t.Fatalf("expected router web, got %+v", cfg.HTTP.Routers)
+	}
+	if router.Rule != "Host(`web.example.com`)" || router.Service != "web" || router.TLS == nil || router.TLS.CertResolver != "le" {
+		t.Fatalf("unexpected router: %+v", router)
+	}
+	if len(router.EntryPoints) != 1 || router.EntryPoints[0] != "websecure" {
+		t.Fatalf("unexpected entrypoints: %+v", router.EntryPoints)
+	}
+
+	service, ok := cfg.HTTP.Services["web"]
+	if !ok || service.LoadBalancer == nil {
+		t.Fatalf("expected service web, got %+v", cfg.HTTP.Services)
+	}
+	expected := []string{"http://10.0.0.1:8080", "http://10.0.0.1:8081"}
+	if len(service.LoadBalancer.Servers) != len(expected) {
+		t.Fatalf("expected servers %+v, got %+v", expected, service.LoadBalancer.Servers)
+	}
+	for i, v := range expected {
+		if service.LoadBalancer.Servers[i].URL != v {
+			t.Fatalf("expected servers %+v, got %+v", expected, service.LoadBalancer.Servers)
+		}
+	}
+	if service.LoadBalancer.PassHostHeader == nil || !*service.LoadBalancer.PassHostHeader {
+		t.Fatalf("expected passHostHeader, got %+v", service.LoadBalancer)
+	}
+
+	prefixes, ok := cfg.HTTP.Middlewares["strip"]["stripprefix"].(map[string]interface{})["prefixes"].([]interface{})
+	if !ok || len(prefixes) != 2 {
+		t.Fatalf("unexpected middlewares: %+v", cfg.HTTP.Middlewares)
+	}
+
+	err = b.Purge()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg = readConfig(t, path)
+	if cfg.HTTP != nil {
+		t.Fatalf("expected empty configuration, got %+v", cfg.HTTP)
+	}
+}
+
+func TestDefaultService(t *testing.T) {
+	b := newTestBackend(t, filepath.Join(t.TempDir(), "creg.yml"))
+
+	cfg, err := b.ContainerConfig(ctypes.ContainerInfo{
+		ID: "app",
+		Labels: map[string]string{
+			"traefik.enable":                "true",
+			"traefik.http.routers.app.rule": "Host(`app`)",
+		},
+		NetworkSettings: ctypes.NetworkSettings{
+			Ports: map[ctypes.Port][]ctypes.PortBinding{
+				"8080/tcp": {{HostIP: "0.0.0.0", HostPort: "18080"}},
+				"9000/tcp": {},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Routers["app"].Service != "app" {
+		t.Fatalf("expected service %q, got %q", "app", cfg.Routers["app"].Service)
+	}
+	servers := cfg.Services["app"].LoadBalancer.Servers
+	if len(servers) != 1 || servers[0].URL != "http://10.0.0.1:18080" {
+		t.Fatalf("unexpected servers: %+v", servers)
+	}
+}
+
+func TestUnpublishedPort(t *testing.T) {
+	b := newTestBackend(t, filepath.Join(t.TempDir(), "creg.yml"))
+
+	cfg, err := b.ContainerConfig(ctypes.ContainerInfo{
+		ID: "app",
+		Labels: map[string]string{
+			"traefik.enable": "true",
+			"traefik.http.services.app.loadbalancer.server.port": "9000",
+		},
+	})
+	if err == nil {
+		t.Fatal("expected unpublished port to fail")
+	}
+	if cfg != nil {
+		t.Fatalf("expected no configuration, got %+v", cfg)
+	}
+}
@@ -1,17 +1,20 @@
 package backends
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"strings"
 )
 
+// ExtractPorts parses the label prefix and the labels prefix.<anything>,
+// other labels starting with prefix like creg.port-mode are not ports.
 func ExtractPorts(labels map[string]string, prefix string) map[string]string {
 	ports := map[string]string{}
 
 	for k, v := range labels {
 		v := strings.Replace(v, "'", "", -1)
-		if strings.HasPrefix(k, prefix) {
+		if k == prefix || strings.HasPrefix(k, prefix+".") {
 			splitP := strings.Split(v, ",")
 			for _, v := range splitP {
 				split := strings.Split(v, ":")
@@ -58,3 +61,17 @@ func TraefikLabelFilter(serviceLabels *[]string, containerLabels map[string]stri
 		}
 	}
 }
+
+// JoinErrors combines errs into a single error, returning nil if errs is empty.
+func JoinErrors(errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+
+	msgs := make([]string, 0, len(errs))
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+
+	return errors.New(strings.Join(msgs, "; "))
+}
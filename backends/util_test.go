@@ -0,0 +1,83 @@
+package backends_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/soupdiver/creg/backends"
+)
+
+func TestExtractPorts(t *testing.T) {
+	ports := backends.ExtractPorts(map[string]string{
+		"creg.port":            "80/tcp:web",
+		"creg.port.metrics":    "9100:metrics",
+		backends.LabelPortMode: backends.PortContainer,
+		"creg.portal":          "1:portal",
+	}, backends.ServiceLabelPort)
+
+	expected := map[string]string{"80/tcp": "web", "9100": "metrics"}
+	if len(ports) != len(expected) {
+		t.Fatalf("expected %+v, got %+v", expected, ports)
+	}
+	for k, v := range expected {
+		if ports[k] != v {
+			t.Fatalf("expected %+v, got %+v", expected, ports)
+		}
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+
+	changed, err := backends.WriteFileAtomic(path, []byte("a"), 0o600)
+	if err != nil || !changed {
+		t.Fatalf("expected change, got %v, %v", changed, err)
+	}
+
+	changed, err = backends.WriteFileAtomic(path, []byte("a"), 0o600)
+	if err != nil || changed {
+		t.Fatalf("expected no change, got %v, %v", changed, err)
+	}
+
+	changed, err = backends.WriteFileAtomic(path, []byte("b"), 0o600)
+	if err != nil || !changed {
+		t.Fatalf("expected change, got %v, %v", changed, err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "b" {
+		t.Fatalf("expected %q, got %q", "b", data)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected mode %o, got %o", 0o600, info.Mode().Perm())
+	}
+}
+
+func TestParseDNSLabel(t *testing.T) {
+	entries, err := backends.ParseDNSLabel("app.lan; *.app.lan,10.0.0.2;bad..lan", "6.6.6.6")
+	if err == nil {
+		t.Fatal("expected error for bad..lan")
+	}
+
+	expected := []backends.DNSEntry{
+		{Domain: "app.lan", Answer: "6.6.6.6"},
+		{Domain: "*.app.lan", Answer: "10.0.0.2"},
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("expected %+v, got %+v", expected, entries)
+	}
+	for i := range entries {
+		if entries[i] != expected[i] {
+			t.Fatalf("expected %+v, got %+v", expected, entries)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package webhook
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/soupdiver/creg/backends"
+)
+
+// BackendConfig configures a webhook backend in the config file.
+type BackendConfig struct {
+	// URLs service changes are POSTed to
+	URLs []string `yaml:"urls"`
+	// SecretFile contains the secret requests are signed with
+	SecretFile string        `yaml:"secret_file"`
+	Timeout    time.Duration `yaml:"timeout"`
+	Retries    int           `yaml:"retries"`
+	// Batch sends payloads at most once per interval, 0 sends them right away
+	Batch time.Duration `yaml:"batch"`
+}
+
+func init() {
+	backends.Register("webhook", backends.Factory{
+		Config: func() interface{} {
+			return &BackendConfig{Timeout: 10 * time.Second, Retries: 3}
+		},
+		New: func(config interface{}, settings backends.Settings) (backends.Backend, error) {
+			cfg := config.(*BackendConfig)
+
+			options := []WebhookOption{
+				WithName(settings.Name),
+				WithLogger(settings.Log),
+				WithID(settings.ID),
+				WithForwardAddress(settings.ForwardAddress),
+				WithAddressStrategy(settings.AddressStrategy),
+				WithStaticLabels(settings.StaticLabels),
+				WithTimeout(cfg.Timeout),
+				WithRetries(cfg.Retries, time.Second),
+				WithBatchInterval(cfg.Batch),
+			}
+			if cfg.SecretFile != "" {
+				secret, err := os.ReadFile(cfg.SecretFile)
+				if err != nil {
+					return nil, fmt.Errorf("could not read webhook secret: %w", err)
+				}
+				options = append(options, WithSecret([]byte(strings.TrimSpace(string(secret)))))
+			}
+
+			return New(cfg.URLs, options...)
+		},
+	})
+}
@@ -0,0 +1,468 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/soupdiver/creg/backends"
+	ctypes "github.com/soupdiver/creg/types"
+)
+
+const (
+	ActionRegister   = "register"
+	ActionDeregister = "deregister"
+
+	// SignatureHeader holds the hex encoded HMAC-SHA256 of the body, prefixed
+	// with sha256=, if a secret is configured
+	SignatureHeader = "X-Creg-Signature"
+)
+
+// Payload is sent for every registered or deregistered service. Without
+// batching every request carries a single Payload, with batching a JSON array
+// of them.
+type Payload struct {
+	Action     string           `json:"action"`
+	InstanceID string           `json:"instance_id,omitempty"`
+	Service    string           `json:"service"`
+	Address    string           `json:"address"`
+	Port       int              `json:"port"`
+	Proto      string           `json:"proto"`
+	Tags       []string         `json:"tags"`
+	Container  ContainerPayload `json:"container"`
+	Timestamp  time.Time        `json:"timestamp"`
+}
+
+type ContainerPayload struct {
+	ID     string            `json:"id"`
+	Labels map[string]string `json:"labels"`
+}
+
+// Backend POSTs a Payload to every URL for each service of starting and
+// stopping containers. Failed requests are retried with exponential backoff
+// for network errors, 429 and 5xx responses.
+type Backend struct {
+	ID              string
+	Name            string
+	Log             *logrus.Entry
+	URLs            []string
+	ForwardAddress  string
+	AddressStrategy backends.AddressStrategy
+	StaticLabels    []string
+	Secret          []byte
+	Retries         int
+	RetryBackoff    time.Duration
+	// BatchInterval collects payloads and sends them together, at most once
+	// per interval. Zero sends every event right away.
+	BatchInterval time.Duration
+	HttpClient    http.Client
+
+	// registered holds the services registered for each container by
+	// container ID, Purge deregisters them
+	registered    map[string][]backends.Service
+	registeredMtx sync.Mutex
+
+	queue    []Payload
+	queueMtx sync.Mutex
+	// sendMtx keeps batches in order
+	sendMtx sync.Mutex
+}
+
+type WebhookOption func(*Backend)
+
+func New(urls []string, options ...WebhookOption) (*Backend, error) {
+	b := &Backend{
+		Name:         "webhook",
+		Log:          logrus.NewEntry(logrus.StandardLogger()),
+		URLs:         urls,
+		Retries:      3,
+		RetryBackoff: time.Second,
+		HttpClient: http.Client{
+			Timeout: time.Second * 10,
+		},
+		registered: map[string][]backends.Service{},
+	}
+
+	for _, option := range options {
+		option(b)
+	}
+
+	if len(b.URLs) == 0 {
+		return nil, fmt.Errorf("no webhook URLs")
+	}
+	for _, u := range b.URLs {
+		if !strings.HasPrefix(u, "http://") && !strings.HasPrefix(u, "https://") {
+			return nil, fmt.Errorf("webhook URL must be http or https: %q", u)
+		}
+	}
+
+	return b, nil
+}
+
+func (b *Backend) Run(ctx context.Context, events chan ctypes.ContainerEventV2, purgeOnStart bool, containersToRefresh []ctypes.ContainerInfo) error {
+	// Errors here only mean payloads could not be delivered, which happens
+	// for every event while a receiver is down, so they do not stop creg
+	if purgeOnStart {
+		err := b.Purge()
+		if err != nil {
+			b.Log.Errorf("Could not purge: %s", err)
+		}
+	}
+
+	if len(containersToRefresh) > 0 {
+		err := b.Refresh(containersToRefresh)
+		if err != nil {
+			b.Log.Errorf("Could not refresh: %s", err)
+		}
+	}
+
+	// Payloads are delivered in their own goroutine, so retries do not hold
+	// up events
+	wake := make(chan struct{}, 1)
+	delivered := make(chan struct{})
+	go func() {
+		defer close(delivered)
+		b.deliver(ctx, wake)
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			b.Log.Infof("Webhook exting: %s", "context cancelled")
+			<-delivered
+
+			// Deliver what is left of the queue
+			flushCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			err := b.Flush(flushCtx)
+			cancel()
+			if err != nil {
+				b.Log.Errorf("Could not Flush: %s", err)
+			}
+			return nil
+		case event := <-events:
+			b.Log.Debugf("handle event webhook: %s", event.Action)
+
+			switch event.Action {
+			case "start":
+				b.register(event.Container)
+			case "stop":
+				b.deregister(event.Container.ID)
+			default:
+				continue
+			}
+
+			if b.BatchInterval == 0 {
+				select {
+				case wake <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}
+}
+
+// deliver flushes the queue whenever it is woken, or once per BatchInterval
+// in batch mode, until ctx is done.
+func (b *Backend) deliver(ctx context.Context, wake <-chan struct{}) {
+	var tick <-chan time.Time
+	if b.BatchInterval > 0 {
+		ticker := time.NewTicker(b.BatchInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+		case <-wake:
+		}
+
+		// Deliveries are not cancelled with ctx, so payloads which were
+		// already taken off the queue are not lost on exit
+		flushCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err := b.Flush(flushCtx)
+		cancel()
+		if err != nil {
+			b.Log.Errorf("Could not Flush: %s", err)
+		}
+	}
+}
+
+func (b *Backend) GetName() string {
+	return b.Name
+}
+
+func (b *Backend) payload(action string, service backends.Service) Payload {
+	return Payload{
+		Action:     action,
+		InstanceID: b.ID,
+		Service:    service.Name,
+		Address:    service.Address,
+		Port:       service.Port,
+		Proto:      service.Proto,
+		Tags:       service.Tags,
+		Container: ContainerPayload{
+			ID:     service.Container.ID,
+			Labels: service.Container.Labels,
+		},
+		Timestamp: time.Now().UTC(),
+	}
+}
+
+func (b *Backend) enqueue(payloads ...Payload) {
+	b.queueMtx.Lock()
+	b.queue = append(b.queue, payloads...)
+	b.queueMtx.Unlock()
+}
+
+// register queues a register payload for every service of container.
+func (b *Backend) register(container ctypes.ContainerInfo) {
+	services := backends.ServicesForContainer(container, b.AddressStrategy, b.ForwardAddress, b.StaticLabels, nil)
+	if len(services) == 0 {
+		return
+	}
+
+	b.registeredMtx.Lock()
+	b.registered[container.ID] = services
+	b.registeredMtx.Unlock()
+
+	for _, service := range services {
+		b.enqueue(b.payload(ActionRegister, service))
+	}
+}
+
+// deregister queues a deregister payload for every service registered for
+// the container with id. Stopped containers have no ports anymore, so the
+// services are taken from the registration.
+func (b *Backend) deregister(id string) {
+	b.registeredMtx.Lock()
+	services := b.registered[id]
+	delete(b.registered, id)
+	b.registeredMtx.Unlock()
+
+	for _, service := range services {
+		b.enqueue(b.payload(ActionDeregister, service))
+	}
+}
+
+// Flush sends all queued payloads. Payloads which could not be delivered to
+// a URL after all retries are dropped.
+func (b *Backend) Flush(ctx context.Context) error {
+	b.sendMtx.Lock()
+	defer b.sendMtx.Unlock()
+
+	b.queueMtx.Lock()
+	payloads := b.queue
+	b.queue = nil
+	b.queueMtx.Unlock()
+
+	if len(payloads) == 0 {
+		return nil
+	}
+
+	var bodies [][]byte
+	if b.BatchInterval > 0 {
+		body, err := json.Marshal(payloads)
+		if err != nil {
+			return fmt.Errorf("could not encode payloads: %w", err)
+		}
+		bodies = append(bodies, body)
+	} else {
+		for _, payload := range payloads {
+			body, err := json.Marshal(payload)
+			if err != nil {
+				return fmt.Errorf("could not encode payload: %w", err)
+			}
+			bodies = append(bodies, body)
+		}
+	}
+
+	var errs []error
+	for _, u := range b.URLs {
+		for _, body := range bodies {
+			err := b.send(ctx, u, body)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("could not send to %s: %w", u, err))
+			}
+		}
+	}
+
+	return backends.JoinErrors(errs)
+}
+
+// Sign returns the value of the signature header for body.
+func (b *Backend) Sign(body []byte) string {
+	mac := hmac.New(sha256.New, b.Secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (b *Backend) send(ctx context.Context, u string, body []byte) error {
+	backoff := b.RetryBackoff
+	var err error
+	for attempt := 0; attempt <= b.Retries; attempt++ {
+		if attempt > 0 {
+			b.Log.Debugf("Retry %s in %s: %s", u, backoff, err)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+
+		var retry bool
+		retry, err = b.post(ctx, u, body)
+		if err == nil || !retry {
+			return err
+		}
+	}
+
+	return err
+}
+
+// post sends a single request and reports whether a failure is worth
+// retrying.
+func (b *Backend) post(ctx context.Context, u string, body []byte) (bool, error) {
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("User-Agent", "creg")
+	if len(b.Secret) > 0 {
+		r.Header.Set(SignatureHeader, b.Sign(body))
+	}
+
+	resp, err := b.HttpClient.Do(r)
+	if err != nil {
+		return ctx.Err() == nil, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+		return retry, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	return false, nil
+}
+
+// Purge sends a deregister payload for every service this instance
+// registered.
+func (b *Backend) Purge() error {
+	b.registeredMtx.Lock()
+	var ids []string
+	for id := range b.registered {
+		ids = append(ids, id)
+	}
+	b.registeredMtx.Unlock()
+
+	for _, id := range ids {
+		b.deregister(id)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	return b.Flush(ctx)
+}
+
+// Refresh sends a register payload for every service of containers.
+func (b *Backend) Refresh(containers []ctypes.ContainerInfo) error {
+	b.Log.Debugf("Refreshing %d webhook containers", len(containers))
+
+	for _, container := range containers {
+		b.register(container)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	return b.Flush(ctx)
+}
+
+func WithLogger(log *logrus.Entry) func(b *Backend) {
+	return func(b *Backend) {
+		b.Log = log.WithField("backend", "webhook")
+	}
+}
+
+func WithForwardAddress(address string) func(b *Backend) {
+	return func(b *Backend) {
+		b.ForwardAddress = address
+	}
+}
+
+func WithStaticLabels(labels []string) func(b *Backend) {
+	return func(b *Backend) {
+		b.StaticLabels = labels
+	}
+}
+
+func WithID(id string) func(b *Backend) {
+	return func(b *Backend) {
+		b.ID = id
+	}
+}
+
+// WithSecret signs every request with HMAC-SHA256, see SignatureHeader.
+func WithSecret(secret []byte) func(b *Backend) {
+	return func(b *Backend) {
+		b.Secret = secret
+	}
+}
+
+// WithTimeout sets the timeout of a single request.
+func WithTimeout(timeout time.Duration) func(b *Backend) {
+	return func(b *Backend) {
+		b.HttpClient.Timeout = timeout
+	}
+}
+
+// WithRetries sets how often a failed request is retried, the delay starts at
+// backoff and doubles with every retry.
+func WithRetries(retries int, backoff time.Duration) func(b *Backend) {
+	return func(b *Backend) {
+		b.Retries = retries
+		b.RetryBackoff = backoff
+	}
+}
+
+// WithBatchInterval enables batch mode, see Backend.BatchInterval.
+func WithBatchInterval(interval time.Duration) func(b *Backend) {
+	return func(b *Backend) {
+		b.BatchInterval = interval
+	}
+}
+
+// WithName sets the backend name, see backends.Settings.
+func WithName(name string) func(b *Backend) {
+	return func(b *Backend) {
+		if name != "" {
+			b.Name = name
+		}
+	}
+}
+
+// WithAddressStrategy sets the address strategy, see backends.AddressStrategy.
+func WithAddressStrategy(strategy backends.AddressStrategy) func(b *Backend) {
+	return func(b *Backend) {
+		b.AddressStrategy = strategy
+	}
+}
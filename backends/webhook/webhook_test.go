@@ -0,0 +1,223 @@
+package webhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/soupdiver/creg/backends/backendtest"
+	"github.com/soupdiver/creg/backends/webhook"
+	ctypes "github.com/soupdiver/creg/types"
+)
+
+type request struct {
+	body      []byte
+	signature string
+}
+
+// recorder records requests and fails the first failures of them, if
+// release is set requests are only answered once it is closed
+type recorder struct {
+	mtx      sync.Mutex
+	failures int
+	requests []request
+	release  chan struct{}
+}
+
+func (rec *recorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if rec.release != nil {
+		<-rec.release
+	}
+
+	rec.mtx.Lock()
+	defer rec.mtx.Unlock()
+
+	if rec.failures > 0 {
+		rec.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	body, _ := io.ReadAll(r.Body)
+	rec.requests = append(rec.requests, request{body: body, signature: r.Header.Get(webhook.SignatureHeader)})
+}
+
+func newTestBackend(t *testing.T, rec *recorder, options ...webhook.WebhookOption) *webhook.Backend {
+	server := httptest.NewServer(rec)
+	t.Cleanup(server.Close)
+
+	options = append([]webhook.WebhookOption{
+		webhook.WithLogger(backendtest.Logger()),
+		webhook.WithID("creg-test"),
+		webhook.WithForwardAddress("10.0.0.1"),
+		webhook.WithSecret([]byte("secret")),
+		webhook.WithRetries(2, time.Millisecond),
+	}, options...)
+
+	b, err := webhook.New([]string{server.URL}, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func TestRefreshRetriesSignedRegister(t *testing.T) {
+	rec := &recorder{failures: 2}
+	b := newTestBackend(t, rec)
+
+	backendtest.Refresh(t, b, backendtest.Container("a", "8080"), ctypes.ContainerInfo{ID: "unlabelled"})
+
+	if len(rec.requests) != 1 {
+		t.Fatalf("expected %d requests, got %d", 1, len(rec.requests))
+	}
+	if rec.requests[0].signature != b.Sign(rec.requests[0].body) {
+		t.Fatalf("unexpected signature %q", rec.requests[0].signature)
+	}
+
+	var payload webhook.Payload
+	err := json.Unmarshal(rec.requests[0].body, &payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload.Action != webhook.ActionRegister || payload.InstanceID != "creg-test" || payload.Service != "web" ||
+		payload.Address != "10.0.0.1" || payload.Port != 8080 || payload.Container.ID != "a" {
+		t.Fatalf("unexpected payload: %+v", payload)
+	}
+}
+
+func TestPurgeDeregistersRegisteredServices(t *testing.T) {
+	rec := &recorder{}
+	b := newTestBackend(t, rec)
+
+	backendtest.Refresh(t, b, backendtest.Container("a", "8080"))
+	backendtest.Purge(t, b)
+
+	if len(rec.requests) != 2 {
+		t.Fatalf("expected %d requests, got %d", 2, len(rec.requests))
+	}
+	var payload webhook.Payload
+	err := json.Unmarshal(rec.requests[1].body, &payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload.Action != webhook.ActionDeregister || payload.Port != 8080 {
+		t.Fatalf("unexpected payload: %+v", payload)
+	}
+}
+
+func TestRetriesExhausted(t *testing.T) {
+	rec := &recorder{failures: 3}
+	b := newTestBackend(t, rec)
+
+	err := b.Refresh([]ctypes.ContainerInfo{backendtest.Container("a", "8080")})
+	if err == nil {
+		t.Fatal("expected failing webhook to return an error")
+	}
+	if len(rec.requests) != 0 {
+		t.Fatalf("expected %d requests, got %d", 0, len(rec.requests))
+	}
+}
+
+// runBackend runs b until the returned function is called, which waits for
+// Run to return.
+func runBackend(t *testing.T, b *webhook.Backend, events chan ctypes.ContainerEventV2, containers []ctypes.ContainerInfo) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		errs <- b.Run(ctx, events, false, containers)
+	}()
+
+	return func() {
+		cancel()
+		select {
+		case err := <-errs:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Run did not return")
+		}
+	}
+}
+
+func TestRunDoesNotWaitForDelivery(t *testing.T) {
+	release := make(chan struct{})
+	var once sync.Once
+	unblock := func() { once.Do(func() { close(release) }) }
+	rec := &recorder{release: release}
+	b := newTestBackend(t, rec)
+	// Unblock the server before it is closed if the test fails
+	t.Cleanup(unblock)
+
+	events := make(chan ctypes.ContainerEventV2)
+	stop := runBackend(t, b, events, nil)
+
+	for _, id := range []string{"a", "b", "c"} {
+		select {
+		case events <- ctypes.ContainerEventV2{Action: "start", Container: backendtest.Container(id, "8080")}:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("event of %s blocked while a request was pending", id)
+		}
+	}
+
+	unblock()
+	stop()
+	if len(rec.requests) != 3 {
+		t.Fatalf("expected %d requests, got %d", 3, len(rec.requests))
+	}
+}
+
+func TestRunContinuesAfterFailedRefresh(t *testing.T) {
+	rec := &recorder{failures: 3}
+	b := newTestBackend(t, rec)
+
+	events := make(chan ctypes.ContainerEventV2)
+	stop := runBackend(t, b, events, []ctypes.ContainerInfo{backendtest.Container("a", "8080")})
+
+	select {
+	case events <- ctypes.ContainerEventV2{Action: "start", Container: backendtest.Container("b", "8081")}:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not handle events after a failed refresh")
+	}
+
+	stop()
+	if len(rec.requests) != 1 {
+		t.Fatalf("expected %d requests, got %d", 1, len(rec.requests))
+	}
+}
+
+func TestBatch(t *testing.T) {
+	rec := &recorder{}
+	b := newTestBackend(t, rec, webhook.WithBatchInterval(time.Minute))
+
+	err := b.Refresh([]ctypes.ContainerInfo{backendtest.Container("a", "8080"), backendtest.Container("b", "8081")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rec.requests) != 1 {
+		t.Fatalf("expected %d requests, got %d", 1, len(rec.requests))
+	}
+
+	var payloads []webhook.Payload
+	err = json.Unmarshal(rec.requests[0].body, &payloads)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payloads) != 2 {
+		t.Fatalf("expected %d payloads, got %+v", 2, payloads)
+	}
+}
+
+func TestInvalidURL(t *testing.T) {
+	_, err := webhook.New([]string{"ftp://example.com"})
+	if err == nil {
+		t.Fatal("expected invalid URL to fail")
+	}
+}
@@ -0,0 +1,38 @@
+package zookeeper
+
+import (
+	"time"
+
+	"github.com/soupdiver/creg/backends"
+)
+
+// BackendConfig configures a zookeeper backend in the config file.
+type BackendConfig struct {
+	// Servers host:port
+	Servers []string `yaml:"servers"`
+	// BasePath of the Curator service discovery
+	BasePath string `yaml:"base_path"`
+	// SessionTimeout after which services of a crashed creg disappear
+	SessionTimeout time.Duration `yaml:"session_timeout"`
+}
+
+func init() {
+	backends.Register("zookeeper", backends.Factory{
+		Config: func() interface{} {
+			return &BackendConfig{BasePath: "/services", SessionTimeout: 10 * time.Second}
+		},
+		New: func(config interface{}, settings backends.Settings) (backends.Backend, error) {
+			cfg := config.(*BackendConfig)
+
+			return New(cfg.Servers,
+				WithName(settings.Name),
+				WithLogger(settings.Log),
+				WithID(settings.ID),
+				WithForwardAddress(settings.ForwardAddress),
+				WithAddressStrategy(settings.AddressStrategy),
+				WithBasePath(cfg.BasePath),
+				WithSessionTimeout(cfg.SessionTimeout),
+			)
+		},
+	})
+}
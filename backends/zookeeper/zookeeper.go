@@ -0,0 +1,499 @@
+package zookeeper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-zookeeper/zk"
+	"github.com/sirupsen/logrus"
+
+	"github.com/soupdiver/creg/backends"
+	ctypes "github.com/soupdiver/creg/types"
+)
+
+// Instance is the JSON payload of a service instance as written by Curator's
+// JsonInstanceSerializer.
+type Instance struct {
+	Name                string      `json:"name"`
+	ID                  string      `json:"id"`
+	Address             string      `json:"address"`
+	Port                int         `json:"port"`
+	SSLPort             *int        `json:"sslPort"`
+	Payload             interface{} `json:"payload"`
+	RegistrationTimeUTC int64       `json:"registrationTimeUTC"`
+	ServiceType         string      `json:"serviceType"`
+	URISpec             interface{} `json:"uriSpec"`
+	Enabled             bool        `json:"enabled"`
+}
+
+// Conn is the part of *zk.Conn the backend uses.
+type Conn interface {
+	Create(path string, data []byte, flags int32, acl []zk.ACL) (string, error)
+	Delete(path string, version int32) error
+	Exists(path string) (bool, *zk.Stat, error)
+	Children(path string) ([]string, *zk.Stat, error)
+	SessionID() int64
+	Close()
+}
+
+// Backend registers services in ZooKeeper using the layout of Curator's
+// service discovery: every instance is an ephemeral znode
+// <BasePath>/<name>/<id> holding a JSON Instance. Ephemeral znodes are removed
+// by ZooKeeper when the session expires, so they are recreated whenever a
+// new session is established.
+type Backend struct {
+	ID              string
+	Name            string
+	Log             *logrus.Entry
+	Conn            Conn
+	Events          <-chan zk.Event
+	ForwardAddress  string
+	AddressStrategy backends.AddressStrategy
+	BasePath        string
+	SessionTimeout  time.Duration
+
+	// registered holds the instances registered for each container by
+	// container ID
+	registered    map[string][]Instance
+	registeredMtx sync.Mutex
+	// session is the ID of the session the registered znodes were created
+	// in
+	session int64
+}
+
+type ZookeeperOption func(*Backend)
+
+// New creates a backend for the ZooKeeper ensemble servers, a list of
+// host:port.
+func New(servers []string, options ...ZookeeperOption) (*Backend, error) {
+	b := &Backend{
+		ID:             "creg",
+		Name:           "zookeeper",
+		Log:            logrus.NewEntry(logrus.StandardLogger()),
+		BasePath:       "/services",
+		SessionTimeout: 10 * time.Second,
+		registered:     map[string][]Instance{},
+	}
+
+	for _, option := range options {
+		option(b)
+	}
+
+	if !strings.HasPrefix(b.BasePath, "/") {
+		return nil, fmt.Errorf("base path must be absolute: %q", b.BasePath)
+	}
+	b.BasePath = path.Clean(b.BasePath)
+
+	if b.Conn == nil {
+		conn, events, err := zk.Connect(servers, b.SessionTimeout, zk.WithLogger(b.Log))
+		if err != nil {
+			return nil, fmt.Errorf("could not connect to zookeeper: %w", err)
+		}
+		b.Conn, b.Events = conn, events
+	}
+
+	return b, nil
+}
+
+func (b *Backend) Run(ctx context.Context, events chan ctypes.ContainerEventV2, purgeOnStart bool, containersToRefresh []ctypes.ContainerInfo) error {
+	// Closing the session removes all ephemeral znodes
+	defer b.Conn.Close()
+
+	var err error
+	if purgeOnStart {
+		err = b.Purge()
+		if err != nil {
+			return fmt.Errorf("could not purge: %w", err)
+		}
+	}
+
+	// Always refresh, this removes znodes of containers which stopped while
+	// a previous session of creg was not expired yet
+	err = b.Refresh(containersToRefresh)
+	if err != nil {
+		return fmt.Errorf("could not refresh: %w", err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			b.Log.Infof("Zookeeper exting: %s", "context cancelled")
+			return nil
+		case event, ok := <-b.Events:
+			if !ok {
+				b.Events = nil
+				continue
+			}
+			if event.Type != zk.EventSession {
+				continue
+			}
+			b.Log.Debugf("zookeeper session: %s", event.State)
+
+			if event.State == zk.StateHasSession {
+				err := b.Restore()
+				if err != nil {
+					b.Log.Errorf("Could not Restore: %s", err)
+				}
+			}
+		case event := <-events:
+			b.Log.Debugf("handle event zookeeper: %s", event.Action)
+
+			switch event.Action {
+			case "start":
+				instances := b.Instances(event.Container)
+				if len(instances) == 0 {
+					continue
+				}
+
+				err := b.Register(event.Container.ID, instances)
+				if err != nil {
+					b.Log.Errorf("Could not Register: %s", err)
+					continue
+				}
+			case "stop":
+				err := b.Deregister(event.Container.ID)
+				if err != nil {
+					b.Log.Errorf("Could not Deregister: %s", err)
+					continue
+				}
+			}
+		}
+	}
+}
+
+func (b *Backend) GetName() string {
+	return b.Name
+}
+
+// Instances returns an instance for every service of container. The instance
+// ID is <ID>-<container ID>-<port>-<proto>. Curator instances have no tags,
+// the payload is left empty as its type is defined by the consumers.
+func (b *Backend) Instances(container ctypes.ContainerInfo) []Instance {
+	id := container.ID
+	if len(id) > 12 {
+		id = id[:12]
+	}
+
+	var instances []Instance
+	for _, service := range backends.ServicesForContainer(container, b.AddressStrategy, b.ForwardAddress, nil, nil) {
+		instances = append(instances, Instance{
+			Name:                service.Name,
+			ID:                  fmt.Sprintf("%s-%s-%d-%s", b.ID, id, service.Port, service.Proto),
+			Address:             service.Address,
+			Port:                service.Port,
+			RegistrationTimeUTC: time.Now().UnixMilli(),
+			ServiceType:         "DYNAMIC",
+			Enabled:             true,
+		})
+	}
+
+	return instances
+}
+
+// Path returns the znode of instance.
+func (b *Backend) Path(instance Instance) string {
+	return path.Join(b.BasePath, instance.Name, instance.ID)
+}
+
+// Owns reports whether the instance with id was registered by this creg
+// instance.
+func (b *Backend) Owns(id string) bool {
+	rest := strings.TrimPrefix(id, b.ID+"-")
+	return rest != id && strings.Count(rest, "-") == 2
+}
+
+// ensurePath creates the persistent parents of p.
+func (b *Backend) ensurePath(p string) error {
+	var current string
+	for _, part := range strings.Split(strings.Trim(p, "/"), "/") {
+		current += "/" + part
+		_, err := b.Conn.Create(current, nil, 0, zk.WorldACL(zk.PermAll))
+		if err != nil && !errors.Is(err, zk.ErrNodeExists) {
+			return fmt.Errorf("could not create %s: %w", current, err)
+		}
+	}
+
+	return nil
+}
+
+// create creates the ephemeral znode of instance. A znode left by an older
+// session is replaced, otherwise it would disappear when that session
+// expires.
+func (b *Backend) create(instance Instance) error {
+	p := b.Path(instance)
+	data, err := json.Marshal(instance)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < 3; i++ {
+		_, err = b.Conn.Create(p, data, zk.FlagEphemeral, zk.WorldACL(zk.PermAll))
+		switch {
+		case err == nil:
+			return nil
+		case errors.Is(err, zk.ErrNoNode):
+			err = b.ensurePath(path.Dir(p))
+			if err != nil {
+				return err
+			}
+		case errors.Is(err, zk.ErrNodeExists):
+			_, stat, err := b.Conn.Exists(p)
+			if err != nil {
+				return fmt.Errorf("could not stat %s: %w", p, err)
+			}
+			if stat != nil && stat.EphemeralOwner == b.Conn.SessionID() {
+				return nil
+			}
+			err = b.Conn.Delete(p, -1)
+			if err != nil && !errors.Is(err, zk.ErrNoNode) {
+				return fmt.Errorf("could not delete %s: %w", p, err)
+			}
+		default:
+			return fmt.Errorf("could not create %s: %w", p, err)
+		}
+	}
+
+	return fmt.Errorf("could not create %s: changed concurrently", p)
+}
+
+func (b *Backend) remove(p string) error {
+	err := b.Conn.Delete(p, -1)
+	if err != nil && !errors.Is(err, zk.ErrNoNode) {
+		return fmt.Errorf("could not delete %s: %w", p, err)
+	}
+
+	return nil
+}
+
+// Register creates the znodes of the instances of the container with id.
+func (b *Backend) Register(id string, instances []Instance) error {
+	var errs []error
+	for _, instance := range instances {
+		err := b.create(instance)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	b.registeredMtx.Lock()
+	b.registered[id] = instances
+	b.registeredMtx.Unlock()
+
+	return backends.JoinErrors(errs)
+}
+
+// Deregister deletes the znodes of the container with id.
+func (b *Backend) Deregister(id string) error {
+	b.registeredMtx.Lock()
+	instances := b.registered[id]
+	delete(b.registered, id)
+	b.registeredMtx.Unlock()
+
+	var errs []error
+	for _, instance := range instances {
+		err := b.remove(b.Path(instance))
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return backends.JoinErrors(errs)
+}
+
+// Restore recreates all registered znodes if the session changed, e.g.
+// after the previous one expired.
+func (b *Backend) Restore() error {
+	session := b.Conn.SessionID()
+
+	b.registeredMtx.Lock()
+	if session == b.session {
+		b.registeredMtx.Unlock()
+		return nil
+	}
+	b.session = session
+	var instances []Instance
+	for _, v := range b.registered {
+		instances = append(instances, v...)
+	}
+	b.registeredMtx.Unlock()
+
+	if len(instances) > 0 {
+		b.Log.Infof("Restoring %d zookeeper instances in new session", len(instances))
+	}
+
+	var errs []error
+	for _, instance := range instances {
+		err := b.create(instance)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return backends.JoinErrors(errs)
+}
+
+// owned returns the znodes below BasePath registered by this creg instance.
+func (b *Backend) owned() ([]string, error) {
+	names, _, err := b.Conn.Children(b.BasePath)
+	if errors.Is(err, zk.ErrNoNode) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not list services: %w", err)
+	}
+
+	var paths []string
+	for _, name := range names {
+		ids, _, err := b.Conn.Children(path.Join(b.BasePath, name))
+		if errors.Is(err, zk.ErrNoNode) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not list instances of %s: %w", name, err)
+		}
+
+		for _, id := range ids {
+			if b.Owns(id) {
+				paths = append(paths, path.Join(b.BasePath, name, id))
+			}
+		}
+	}
+
+	return paths, nil
+}
+
+// Purge deletes all znodes registered by this creg instance, including the
+// ones of a previous session which did not expire yet.
+func (b *Backend) Purge() error {
+	paths, err := b.owned()
+	if err != nil {
+		return err
+	}
+
+	b.registeredMtx.Lock()
+	b.registered = map[string][]Instance{}
+	b.registeredMtx.Unlock()
+
+	var errs []error
+	for _, p := range paths {
+		err := b.remove(p)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return backends.JoinErrors(errs)
+}
+
+// Refresh registers the services of containers and deletes all other znodes
+// registered by this creg instance.
+func (b *Backend) Refresh(containers []ctypes.ContainerInfo) error {
+	b.Log.Debugf("Refreshing %d zookeeper containers", len(containers))
+
+	paths, err := b.owned()
+	if err != nil {
+		return err
+	}
+
+	b.registeredMtx.Lock()
+	b.registered = map[string][]Instance{}
+	b.session = b.Conn.SessionID()
+	b.registeredMtx.Unlock()
+
+	wanted := map[string]struct{}{}
+	var errs []error
+	for _, container := range containers {
+		instances := b.Instances(container)
+		if len(instances) == 0 {
+			continue
+		}
+		for _, instance := range instances {
+			wanted[b.Path(instance)] = struct{}{}
+		}
+
+		err := b.Register(container.ID, instances)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("could not register %s: %w", container.ID, err))
+		}
+	}
+
+	for _, p := range paths {
+		if _, ok := wanted[p]; ok {
+			continue
+		}
+		err := b.remove(p)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return backends.JoinErrors(errs)
+}
+
+func WithLogger(log *logrus.Entry) func(b *Backend) {
+	return func(b *Backend) {
+		b.Log = log.WithField("backend", "zookeeper")
+	}
+}
+
+func WithForwardAddress(address string) func(b *Backend) {
+	return func(b *Backend) {
+		b.ForwardAddress = address
+	}
+}
+
+func WithID(id string) func(b *Backend) {
+	return func(b *Backend) {
+		if id != "" {
+			b.ID = id
+		}
+	}
+}
+
+// WithBasePath sets the znode below which services are registered, Curator's
+// ServiceDiscoveryBuilder.basePath.
+func WithBasePath(basePath string) func(b *Backend) {
+	return func(b *Backend) {
+		if basePath != "" {
+			b.BasePath = basePath
+		}
+	}
+}
+
+// WithSessionTimeout sets the ZooKeeper session timeout, znodes of a crashed
+// creg disappear after it.
+func WithSessionTimeout(timeout time.Duration) func(b *Backend) {
+	return func(b *Backend) {
+		b.SessionTimeout = timeout
+	}
+}
+
+// WithConn uses conn and its session events instead of connecting to
+// ZooKeeper.
+func WithConn(conn Conn, events <-chan zk.Event) func(b *Backend) {
+	return func(b *Backend) {
+		b.Conn, b.Events = conn, events
+	}
+}
+
+// WithName sets the backend name, see backends.Settings.
+func WithName(name string) func(b *Backend) {
+	return func(b *Backend) {
+		if name != "" {
+			b.Name = name
+		}
+	}
+}
+
+// WithAddressStrategy sets the address strategy, see backends.AddressStrategy.
+func WithAddressStrategy(strategy backends.AddressStrategy) func(b *Backend) {
+	return func(b *Backend) {
+		b.AddressStrategy = strategy
+	}
+}
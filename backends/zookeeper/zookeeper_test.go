@@ -0,0 +1,256 @@
+package zookeeper_test
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-zookeeper/zk"
+
+	"github.com/soupdiver/creg/backends/backendtest"
+	"github.com/soupdiver/creg/backends/zookeeper"
+	ctypes "github.com/soupdiver/creg/types"
+)
+
+type znode struct {
+	data  []byte
+	owner int64
+}
+
+// fakeZK is an in-process stand-in for a ZooKeeper server with a single
+// session.
+type fakeZK struct {
+	mtx     sync.Mutex
+	nodes   map[string]znode
+	session int64
+	events  chan zk.Event
+}
+
+func newFakeZK() *fakeZK {
+	return &fakeZK{
+		nodes:   map[string]znode{"/": {}},
+		session: 1,
+		events:  make(chan zk.Event, 1),
+	}
+}
+
+func (f *fakeZK) Create(p string, data []byte, flags int32, acl []zk.ACL) (string, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if _, ok := f.nodes[p]; ok {
+		return "", zk.ErrNodeExists
+	}
+	if _, ok := f.nodes[path.Dir(p)]; !ok {
+		return "", zk.ErrNoNode
+	}
+
+	node := znode{data: data}
+	if flags&zk.FlagEphemeral != 0 {
+		node.owner = f.session
+	}
+	f.nodes[p] = node
+	return p, nil
+}
+
+func (f *fakeZK) Delete(p string, version int32) error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if _, ok := f.nodes[p]; !ok {
+		return zk.ErrNoNode
+	}
+	delete(f.nodes, p)
+	return nil
+}
+
+func (f *fakeZK) Exists(p string) (bool, *zk.Stat, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	node, ok := f.nodes[p]
+	if !ok {
+		return false, nil, nil
+	}
+	return true, &zk.Stat{EphemeralOwner: node.owner}, nil
+}
+
+func (f *fakeZK) Children(p string) ([]string, *zk.Stat, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if _, ok := f.nodes[p]; !ok {
+		return nil, nil, zk.ErrNoNode
+	}
+
+	var children []string
+	for node := range f.nodes {
+		if node != "/" && path.Dir(node) == p {
+			children = append(children, path.Base(node))
+		}
+	}
+	sort.Strings(children)
+	return children, &zk.Stat{}, nil
+}
+
+func (f *fakeZK) SessionID() int64 {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	return f.session
+}
+
+func (f *fakeZK) Close() {}
+
+// expire drops the ephemeral znodes of the session and starts a new one.
+func (f *fakeZK) expire() {
+	f.mtx.Lock()
+	for p, node := range f.nodes {
+		if node.owner == f.session {
+			delete(f.nodes, p)
+		}
+	}
+	f.session++
+	f.mtx.Unlock()
+
+	f.events <- zk.Event{Type: zk.EventSession, State: zk.StateExpired}
+	f.events <- zk.Event{Type: zk.EventSession, State: zk.StateHasSession}
+}
+
+func (f *fakeZK) instance(t *testing.T, p string) zookeeper.Instance {
+	f.mtx.Lock()
+	node, ok := f.nodes[p]
+	f.mtx.Unlock()
+	if !ok {
+		t.Fatalf("expected %s to exist", p)
+	}
+
+	var instance zookeeper.Instance
+	err := json.Unmarshal(node.data, &instance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return instance
+}
+
+func (f *fakeZK) exists(p string) bool {
+	ok, _, _ := f.Exists(p)
+	return ok
+}
+
+func newTestBackend(t *testing.T, f *fakeZK) *zookeeper.Backend {
+	b, err := zookeeper.New(nil,
+		zookeeper.WithConn(f, f.events),
+		zookeeper.WithLogger(backendtest.Logger()),
+		zookeeper.WithID("test"),
+		zookeeper.WithForwardAddress("10.0.0.1"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+// addInstances adds instances of another creg instance and of a Curator
+// client, and a stale one of a previous run of this instance.
+func addInstances(f *fakeZK) {
+	f.nodes["/services"] = znode{}
+	f.nodes["/services/web"] = znode{}
+	f.nodes["/services/web/other-a-80-tcp"] = znode{owner: 9}
+	f.nodes["/services/web/5a0f0e5c-1b0c-4b0e-9d0a-0c6f0e8d6a1f"] = znode{owner: 9}
+	f.nodes["/services/web/test-gone-80-tcp"] = znode{owner: 9}
+}
+
+func TestRefreshCreatesEphemeralInstancesAndDeletesStaleOnes(t *testing.T) {
+	f := newFakeZK()
+	b := newTestBackend(t, f)
+	addInstances(f)
+
+	backendtest.Refresh(t, b, backendtest.Container("0123456789abcdef", "8080"))
+
+	p := "/services/web/test-0123456789ab-8080-tcp"
+	instance := f.instance(t, p)
+	if instance.Name != "web" || instance.ID != "test-0123456789ab-8080-tcp" || instance.Address != "10.0.0.1" || instance.Port != 8080 || instance.ServiceType != "DYNAMIC" || !instance.Enabled {
+		t.Fatalf("unexpected instance: %+v", instance)
+	}
+	if f.nodes[p].owner != f.session {
+		t.Fatalf("expected %s to be ephemeral", p)
+	}
+	if f.exists("/services/web/test-gone-80-tcp") {
+		t.Fatal("expected stale instance to be deleted")
+	}
+}
+
+func TestPurgeKeepsForeignInstances(t *testing.T) {
+	f := newFakeZK()
+	b := newTestBackend(t, f)
+	addInstances(f)
+
+	backendtest.Refresh(t, b, backendtest.Container("0123456789abcdef", "8080"))
+	backendtest.Purge(t, b)
+
+	children, _, _ := f.Children("/services/web")
+	backendtest.ExpectStrings(t, []string{"5a0f0e5c-1b0c-4b0e-9d0a-0c6f0e8d6a1f", "other-a-80-tcp"}, children)
+}
+
+func TestReplaceInstanceOfOldSession(t *testing.T) {
+	f := newFakeZK()
+	b := newTestBackend(t, f)
+
+	p := "/services/web/test-a-8080-tcp"
+	f.nodes["/services"] = znode{}
+	f.nodes["/services/web"] = znode{}
+	f.nodes[p] = znode{owner: 9}
+
+	err := b.Refresh([]ctypes.ContainerInfo{backendtest.Container("a", "8080")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if f.nodes[p].owner != f.session {
+		t.Fatalf("expected %s to be owned by session %d, got %d", p, f.session, f.nodes[p].owner)
+	}
+}
+
+func TestRestoreAfterSessionExpiry(t *testing.T) {
+	f := newFakeZK()
+	b := newTestBackend(t, f)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events := make(chan ctypes.ContainerEventV2)
+	done := make(chan error)
+	go func() {
+		done <- b.Run(ctx, events, false, nil)
+	}()
+
+	events <- ctypes.ContainerEventV2{Action: "start", Container: backendtest.Container("a", "8080")}
+	events <- ctypes.ContainerEventV2{Action: "start", Container: backendtest.Container("b", "8081")}
+	events <- ctypes.ContainerEventV2{Action: "stop", Container: ctypes.ContainerInfo{ID: "b"}}
+
+	f.expire()
+
+	p := "/services/web/test-a-8080-tcp"
+	deadline := time.Now().Add(5 * time.Second)
+	for !f.exists(p) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %s to be recreated", p)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if f.exists("/services/web/test-b-8081-tcp") {
+		t.Fatal("expected stopped container not to be recreated")
+	}
+
+	cancel()
+	err := <-done
+	if err != nil {
+		t.Fatal(err)
+	}
+}
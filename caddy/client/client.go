@@ -0,0 +1,174 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/soupdiver/creg/caddy"
+)
+
+// Client talks to the Caddy admin API.
+type Client struct {
+	Endpoint   *url.URL
+	HttpClient http.Client
+}
+
+type ClientOption func(*Client) error
+
+// APIError is returned for responses with a non-2xx status code.
+type APIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Status     string
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%s %s: unexpected status: %s", e.Method, e.Path, e.Status)
+	}
+	return fmt.Sprintf("%s %s: unexpected status: %s: %s", e.Method, e.Path, e.Status, e.Message)
+}
+
+func New(endpoint string, options ...ClientOption) (*Client, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse endpoint: %w", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("endpoint must be an absolute URL: %q", endpoint)
+	}
+
+	c := &Client{
+		Endpoint: u,
+		HttpClient: http.Client{
+			Timeout: time.Second * 10,
+		},
+	}
+
+	for _, option := range options {
+		err := option(c)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// URL returns the endpoint joined with path, keeping any path prefix the
+// endpoint already has.
+func (c *Client) URL(path string) string {
+	return c.Endpoint.JoinPath(path).String()
+}
+
+func (c *Client) doRequest(ctx context.Context, method, path string, in, res interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("could not encode request: %w", err)
+		}
+		body = bytes.NewReader(b)
+	}
+
+	r, err := http.NewRequestWithContext(ctx, method, c.URL(path), body)
+	if err != nil {
+		return err
+	}
+
+	if in != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	r.Header.Set("Accept", "application/json")
+
+	resp, err := c.HttpClient.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &APIError{
+			Method:     method,
+			Path:       path,
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+		}
+
+		var errRes caddy.ErrorResponse
+		if json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&errRes) == nil {
+			apiErr.Message = errRes.Error
+		}
+
+		return apiErr
+	}
+
+	if res != nil {
+		err = json.NewDecoder(resp.Body).Decode(res)
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("could not decode response: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func routesPath(server string) string {
+	return "config/apps/http/servers/" + url.PathEscape(server) + "/routes"
+}
+
+func idPath(id string) string {
+	return "id/" + url.PathEscape(id)
+}
+
+// Routes returns the routes of server. Routes which are not reverse proxies
+// are returned with the fields creg does not know about dropped.
+func (c *Client) Routes(ctx context.Context, server string) ([]caddy.Route, error) {
+	var res []caddy.Route
+	err := c.doRequest(ctx, http.MethodGet, routesPath(server), nil, &res)
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// AddRoute appends route to the routes of server.
+func (c *Client) AddRoute(ctx context.Context, server string, route caddy.Route) error {
+	return c.doRequest(ctx, http.MethodPost, routesPath(server), route, nil)
+}
+
+// ReplaceRoute replaces the route with the @id of route in place.
+func (c *Client) ReplaceRoute(ctx context.Context, route caddy.Route) error {
+	return c.doRequest(ctx, http.MethodPatch, idPath(route.ID), route, nil)
+}
+
+// DeleteRoute removes the route with the given @id. Deleting a route which
+// does not exist is not an error.
+func (c *Client) DeleteRoute(ctx context.Context, id string) error {
+	err := c.doRequest(ctx, http.MethodDelete, idPath(id), nil, nil)
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		return nil
+	}
+
+	return err
+}
+
+// WithTimeout sets the timeout of each request.
+func WithTimeout(timeout time.Duration) ClientOption {
+	return func(c *Client) error {
+		c.HttpClient.Timeout = timeout
+		return nil
+	}
+}
@@ -0,0 +1,29 @@
+package caddy
+
+// Route is the subset of a Caddy HTTP route creg creates, a reverse proxy
+// for a set of hosts. ID is the @id used to address the route through the
+// admin API.
+type Route struct {
+	ID       string     `json:"@id,omitempty"`
+	Match    []MatchSet `json:"match,omitempty"`
+	Handle   []Handler  `json:"handle,omitempty"`
+	Terminal bool       `json:"terminal,omitempty"`
+}
+
+type MatchSet struct {
+	Host []string `json:"host,omitempty"`
+}
+
+type Handler struct {
+	Handler   string     `json:"handler"`
+	Upstreams []Upstream `json:"upstreams,omitempty"`
+}
+
+type Upstream struct {
+	Dial string `json:"dial"`
+}
+
+// ErrorResponse is the body of failed admin API requests.
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
@@ -0,0 +1,99 @@
+// creg-plugin-file is the reference creg plugin. It writes all registered
+// services as a JSON array to the file given as its only argument.
+//
+//	creg --plugin "/usr/bin/creg-plugin-file /var/lib/creg/services.json"
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/soupdiver/creg/backends"
+	"github.com/soupdiver/creg/backends/plugin"
+)
+
+type handler struct {
+	path string
+	// services by container ID
+	services map[string][]backends.Service
+}
+
+func (h *handler) Init(params plugin.InitParams) (plugin.InitResult, error) {
+	if params.Version != plugin.ProtocolVersion {
+		return plugin.InitResult{}, fmt.Errorf("unsupported protocol version %d", params.Version)
+	}
+
+	return plugin.InitResult{Name: "file"}, nil
+}
+
+func (h *handler) Register(params plugin.ContainerParams) error {
+	h.services[params.Container.ID] = params.Services
+	return h.write()
+}
+
+func (h *handler) Deregister(params plugin.ContainerParams) error {
+	delete(h.services, params.Container.ID)
+	return h.write()
+}
+
+func (h *handler) Refresh(params plugin.RefreshParams) error {
+	h.services = map[string][]backends.Service{}
+	for _, container := range params.Containers {
+		h.services[container.Container.ID] = container.Services
+	}
+	return h.write()
+}
+
+func (h *handler) Purge() error {
+	h.services = map[string][]backends.Service{}
+	return h.write()
+}
+
+func (h *handler) write() error {
+	services := []backends.Service{}
+	for _, s := range h.services {
+		services = append(services, s...)
+	}
+	sort.Slice(services, func(i, j int) bool {
+		if services[i].Name != services[j].Name {
+			return services[i].Name < services[j].Name
+		}
+		if services[i].Container.ID != services[j].Container.ID {
+			return services[i].Container.ID < services[j].Container.ID
+		}
+		return services[i].Port < services[j].Port
+	})
+
+	data, err := json.MarshalIndent(services, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	changed, err := backends.WriteFileAtomic(h.path, append(data, '\n'), 0o644)
+	if err != nil {
+		return fmt.Errorf("could not write %s: %w", h.path, err)
+	}
+	if changed {
+		log.Printf("Wrote %d services", len(services))
+	}
+
+	return nil
+}
+
+func main() {
+	// Everything logged goes to stderr and ends up in creg's log
+	log.SetFlags(0)
+
+	if len(os.Args) != 2 {
+		log.Fatalf("usage: %s <file>", os.Args[0])
+	}
+
+	h := &handler{path: os.Args[1], services: map[string][]backends.Service{}}
+	err := plugin.Serve(os.Stdin, os.Stdout, h)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -1,20 +1,8 @@
 package config
 
-import (
-	consulapi "github.com/hashicorp/consul/api"
-	clientv3 "go.etcd.io/etcd/client/v3"
-)
-
+// Config holds the settings shared by all backends.
 type Config struct {
 	ID             string
 	ForwardAddress string
 	StaticLabels   []string
-
-	ConsulConfig *consulapi.Config
-	EtcdConfig   *clientv3.Config
-}
-
-var DefaultConfig = Config{
-	ConsulConfig: consulapi.DefaultConfig(),
-	EtcdConfig:   &clientv3.Config{},
 }
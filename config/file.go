@@ -0,0 +1,117 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"gopkg.in/yaml.v3"
+)
+
+// File is the config file. Settings left empty fall back to their flags,
+// backends are created in addition to the ones enabled by flags.
+//
+//	id: creg-1
+//	address: 10.0.0.1
+//	address_strategy: hostip
+//	backends:
+//	  - type: consul
+//	    name: consul-dc1
+//	    config:
+//	      address: consul-dc1:8500
+//	  - type: consul
+//	    name: consul-dc2
+//	    address_strategy: network
+//	    address_network: dc2
+//	    config:
+//	      address: consul-dc2:8500
+type File struct {
+	ID string `yaml:"id"`
+	// Address is detected from AddressInterface or the default route if
+	// empty
+	Address          string `yaml:"address"`
+	AddressInterface string `yaml:"address_interface"`
+	// AddressStrategy is static, network or hostip, see
+	// backends.AddressStrategy
+	AddressStrategy string   `yaml:"address_strategy"`
+	AddressNetwork  string   `yaml:"address_network"`
+	Labels          []string `yaml:"labels"`
+	Enable          string   `yaml:"enable"`
+	// StateDir must be writable, ownership of entries is only persisted
+	// across restarts if it is set
+	StateDir string    `yaml:"state_dir"`
+	Backends []Backend `yaml:"backends"`
+}
+
+// Backend is a backend instance, Config is decoded into the config of its
+// type.
+type Backend struct {
+	Type string `yaml:"type"`
+	// Name defaults to the type, it must be unique. Containers list the
+	// names or types of the backends they are registered in with the
+	// creg.backends label, e.g. consul-dc1 or consul for all consul backends.
+	Name string `yaml:"name"`
+	// Address settings override the ones of the File if set
+	Address          string    `yaml:"address"`
+	AddressInterface string    `yaml:"address_interface"`
+	AddressStrategy  string    `yaml:"address_strategy"`
+	AddressNetwork   string    `yaml:"address_network"`
+	Config           yaml.Node `yaml:"config"`
+}
+
+// NewBackend creates a backend instance with config, used to create
+// backends from flags.
+func NewBackend(backendType string, config map[string]interface{}) (Backend, error) {
+	b := Backend{Type: backendType}
+
+	err := b.Config.Encode(config)
+	if err != nil {
+		return Backend{}, fmt.Errorf("could not encode %s config: %w", backendType, err)
+	}
+
+	return b, nil
+}
+
+// Decode decodes the config of the instance into v. Unknown fields are an
+// error so typos do not go unnoticed.
+func (b Backend) Decode(v interface{}) error {
+	// An empty config leaves the defaults
+	if b.Config.Kind == 0 {
+		return nil
+	}
+
+	data, err := yaml.Marshal(&b.Config)
+	if err != nil {
+		return err
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	return dec.Decode(v)
+}
+
+// Load reads the config file at path.
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read config file: %w", err)
+	}
+
+	var f File
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	err = dec.Decode(&f)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("could not parse config file %s: %w", path, err)
+	}
+
+	for i, b := range f.Backends {
+		if b.Type == "" {
+			return nil, fmt.Errorf("backend %d in %s has no type", i+1, path)
+		}
+	}
+
+	return &f, nil
+}
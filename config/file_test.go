@@ -0,0 +1,129 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/soupdiver/creg/config"
+)
+
+type testConfig struct {
+	Address  string        `yaml:"address"`
+	Interval time.Duration `yaml:"interval"`
+	Servers  []string      `yaml:"servers"`
+	TTL      uint32        `yaml:"ttl"`
+}
+
+func writeFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "creg.yaml")
+	err := os.WriteFile(path, []byte(content), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	path := writeFile(t, `
+id: creg-1
+address: 10.0.0.1
+backends:
+  - type: consul
+    name: consul-a
+    config:
+      address: consul-a:8500
+      interval: 5s
+  - type: consul
+    name: consul-b
+    config:
+      address: consul-b:8500
+  - type: traefik
+`)
+
+	f, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if f.ID != "creg-1" || f.Address != "10.0.0.1" {
+		t.Fatalf("expected creg-1 and 10.0.0.1, got %s and %s", f.ID, f.Address)
+	}
+	if len(f.Backends) != 3 {
+		t.Fatalf("expected 3 backends, got %d", len(f.Backends))
+	}
+
+	// Defaults are kept for fields not in the config
+	cfg := testConfig{Address: "default", Interval: time.Minute, TTL: 60}
+	err = f.Backends[0].Decode(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Address != "consul-a:8500" || cfg.Interval != 5*time.Second || cfg.TTL != 60 {
+		t.Fatalf("unexpected config: %+v", cfg)
+	}
+
+	cfg = testConfig{Address: "default"}
+	err = f.Backends[2].Decode(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Address != "default" {
+		t.Fatalf("expected default address, got %s", cfg.Address)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	_, err := config.Load(writeFile(t, "backends:\n  - name: x\n"))
+	if err == nil {
+		t.Fatal("expected error for backend without type")
+	}
+
+	_, err = config.Load(writeFile(t, "adress: 10.0.0.1\n"))
+	if err == nil {
+		t.Fatal("expected error for unknown field")
+	}
+
+	f, err := config.Load(writeFile(t, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Backends) != 0 {
+		t.Fatalf("expected no backends, got %d", len(f.Backends))
+	}
+}
+
+func TestDecodeUnknownField(t *testing.T) {
+	f, err := config.Load(writeFile(t, "backends:\n  - type: consul\n    config:\n      adress: x\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cfg testConfig
+	err = f.Backends[0].Decode(&cfg)
+	if err == nil {
+		t.Fatal("expected error for unknown field")
+	}
+}
+
+func TestNewBackend(t *testing.T) {
+	b, err := config.NewBackend("consul", map[string]interface{}{
+		"address":  "consul:8500",
+		"interval": 90 * time.Second,
+		"servers":  []string{"a", "b"},
+		"ttl":      uint32(30),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cfg testConfig
+	err = b.Decode(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Address != "consul:8500" || cfg.Interval != 90*time.Second || len(cfg.Servers) != 2 || cfg.TTL != 30 {
+		t.Fatalf("unexpected config: %+v", cfg)
+	}
+}
@@ -107,15 +107,30 @@ func ConvertContainerFromDocker(in types.ContainerJSON) ctypes.ContainerInfo {
 
 func ConvertNetworkSettingsFromDocker(in *types.NetworkSettings) ctypes.NetworkSettings {
 	v := ctypes.NetworkSettings{
-		Ports: make(map[ctypes.Port][]ctypes.PortBinding),
+		Ports:    make(map[ctypes.Port][]ctypes.PortBinding),
+		Networks: make(map[string]ctypes.Network),
 	}
 
+	// Exposed ports which are not published have no bindings, ports bound to
+	// several host IPs have one binding per IP
 	for port, info := range in.Ports {
-		v.Ports[ctypes.Port(port.Port()+"/"+port.Proto())] = []ctypes.PortBinding{
-			{
-				HostIP:   info[0].HostIP,
-				HostPort: info[0].HostPort,
-			},
+		bindings := make([]ctypes.PortBinding, 0, len(info))
+		for _, binding := range info {
+			bindings = append(bindings, ctypes.PortBinding{
+				HostIP:   binding.HostIP,
+				HostPort: binding.HostPort,
+			})
+		}
+		v.Ports[ctypes.Port(port.Port()+"/"+port.Proto())] = bindings
+	}
+
+	for name, network := range in.Networks {
+		if network == nil {
+			continue
+		}
+		v.Networks[name] = ctypes.Network{
+			IPAddress:         network.IPAddress,
+			GlobalIPv6Address: network.GlobalIPv6Address,
 		}
 	}
 
@@ -0,0 +1,45 @@
+package docker_test
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/go-connections/nat"
+
+	"github.com/soupdiver/creg/docker"
+	ctypes "github.com/soupdiver/creg/types"
+)
+
+func TestConvertNetworkSettingsFromDocker(t *testing.T) {
+	in := &types.NetworkSettings{
+		NetworkSettingsBase: types.NetworkSettingsBase{
+			Ports: nat.PortMap{
+				"80/tcp": {
+					{HostIP: "0.0.0.0", HostPort: "8080"},
+					{HostIP: "::", HostPort: "8080"},
+				},
+				"9000/tcp": nil,
+			},
+		},
+		Networks: map[string]*network.EndpointSettings{
+			"bridge": {IPAddress: "172.17.0.2"},
+			"none":   nil,
+		},
+	}
+
+	v := docker.ConvertNetworkSettingsFromDocker(in)
+
+	if bindings := v.Ports["80/tcp"]; len(bindings) != 2 || bindings[1].HostIP != "::" {
+		t.Fatalf("expected 2 bindings, got %+v", bindings)
+	}
+	if bindings, ok := v.Ports["9000/tcp"]; !ok || len(bindings) != 0 {
+		t.Fatalf("expected unpublished port without bindings, got %+v", v.Ports)
+	}
+	if v.Networks["bridge"] != (ctypes.Network{IPAddress: "172.17.0.2"}) {
+		t.Fatalf("expected bridge network, got %+v", v.Networks)
+	}
+	if _, ok := v.Networks["none"]; ok {
+		t.Fatalf("expected nil network to be skipped, got %+v", v.Networks)
+	}
+}
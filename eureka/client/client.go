@@ -0,0 +1,178 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/soupdiver/creg/eureka"
+)
+
+// Client talks to the REST API of a Eureka server. The endpoint includes the
+// context path, usually http://host:8761/eureka. Credentials in the endpoint
+// are sent with basic auth.
+type Client struct {
+	Endpoint   *url.URL
+	HttpClient http.Client
+}
+
+type ClientOption func(*Client) error
+
+// APIError is returned for responses with a non-2xx status code.
+type APIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Status     string
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%s %s: unexpected status: %s", e.Method, e.Path, e.Status)
+	}
+	return fmt.Sprintf("%s %s: unexpected status: %s: %s", e.Method, e.Path, e.Status, e.Message)
+}
+
+// IsNotFound reports whether err is an APIError with status 404, returned
+// for heartbeats of instances the server does not know.
+func IsNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
+
+func New(endpoint string, options ...ClientOption) (*Client, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse endpoint: %w", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("endpoint must be an absolute URL: %q", endpoint)
+	}
+
+	c := &Client{
+		Endpoint: u,
+		HttpClient: http.Client{
+			Timeout: time.Second * 10,
+		},
+	}
+
+	for _, option := range options {
+		err := option(c)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// URL returns the endpoint joined with path, keeping any path prefix the
+// endpoint already has.
+func (c *Client) URL(path string) string {
+	return c.Endpoint.JoinPath(path).String()
+}
+
+func (c *Client) doRequest(ctx context.Context, method, path string, in, res interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("could not encode request: %w", err)
+		}
+		body = bytes.NewReader(b)
+	}
+
+	r, err := http.NewRequestWithContext(ctx, method, c.URL(path), body)
+	if err != nil {
+		return err
+	}
+
+	if in != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	r.Header.Set("Accept", "application/json")
+
+	resp, err := c.HttpClient.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &APIError{
+			Method:     method,
+			Path:       path,
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Message:    strings.TrimSpace(string(message)),
+		}
+	}
+
+	if res != nil {
+		err = json.NewDecoder(resp.Body).Decode(res)
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("could not decode response: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func appPath(app string) string {
+	return "apps/" + url.PathEscape(app)
+}
+
+func instancePath(app, id string) string {
+	return appPath(app) + "/" + url.PathEscape(id)
+}
+
+// Applications returns all registered applications with their instances.
+func (c *Client) Applications(ctx context.Context) ([]eureka.Application, error) {
+	var res eureka.ApplicationsResponse
+	err := c.doRequest(ctx, http.MethodGet, "apps", nil, &res)
+	if err != nil {
+		return nil, err
+	}
+
+	return res.Applications.Applications, nil
+}
+
+// Register registers instance with its app, registering an instance again
+// replaces it.
+func (c *Client) Register(ctx context.Context, instance eureka.Instance) error {
+	return c.doRequest(ctx, http.MethodPost, appPath(instance.App), eureka.InstanceRequest{Instance: instance}, nil)
+}
+
+// Heartbeat renews the lease of an instance. IsNotFound reports whether the
+// instance has to be registered again.
+func (c *Client) Heartbeat(ctx context.Context, app, id string) error {
+	return c.doRequest(ctx, http.MethodPut, instancePath(app, id), nil, nil)
+}
+
+// Cancel removes an instance. Cancelling an instance which does not exist is
+// not an error.
+func (c *Client) Cancel(ctx context.Context, app, id string) error {
+	err := c.doRequest(ctx, http.MethodDelete, instancePath(app, id), nil, nil)
+	if IsNotFound(err) {
+		return nil
+	}
+
+	return err
+}
+
+// WithTimeout sets the timeout of each request.
+func WithTimeout(timeout time.Duration) ClientOption {
+	return func(c *Client) error {
+		c.HttpClient.Timeout = timeout
+		return nil
+	}
+}
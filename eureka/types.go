@@ -0,0 +1,76 @@
+package eureka
+
+const (
+	StatusUp           = "UP"
+	StatusDown         = "DOWN"
+	StatusOutOfService = "OUT_OF_SERVICE"
+
+	// DataCenterMyOwn is the data center of instances not running on AWS.
+	DataCenterMyOwn = "MyOwn"
+	dataCenterClass = "com.netflix.appinfo.InstanceInfo$DefaultDataCenterInfo"
+)
+
+// Instance is a registered instance of an application as used by the Eureka
+// REST API.
+type Instance struct {
+	InstanceID       string            `json:"instanceId"`
+	HostName         string            `json:"hostName"`
+	App              string            `json:"app"`
+	IPAddr           string            `json:"ipAddr"`
+	VIPAddress       string            `json:"vipAddress,omitempty"`
+	SecureVIPAddress string            `json:"secureVipAddress,omitempty"`
+	Status           string            `json:"status"`
+	Port             Port              `json:"port"`
+	SecurePort       Port              `json:"securePort"`
+	HomePageURL      string            `json:"homePageUrl,omitempty"`
+	StatusPageURL    string            `json:"statusPageUrl,omitempty"`
+	HealthCheckURL   string            `json:"healthCheckUrl,omitempty"`
+	DataCenterInfo   DataCenterInfo    `json:"dataCenterInfo"`
+	LeaseInfo        LeaseInfo         `json:"leaseInfo"`
+	Metadata         map[string]string `json:"metadata,omitempty"`
+}
+
+type Port struct {
+	Port    int    `json:"$"`
+	Enabled string `json:"@enabled"`
+}
+
+// NewPort returns an enabled port, or a disabled one if port is 0.
+func NewPort(port int) Port {
+	if port == 0 {
+		return Port{Enabled: "false"}
+	}
+	return Port{Port: port, Enabled: "true"}
+}
+
+type DataCenterInfo struct {
+	Class string `json:"@class"`
+	Name  string `json:"name"`
+}
+
+// NewDataCenterInfo returns the info of a data center which is not on AWS.
+func NewDataCenterInfo(name string) DataCenterInfo {
+	return DataCenterInfo{Class: dataCenterClass, Name: name}
+}
+
+type LeaseInfo struct {
+	RenewalIntervalInSecs int `json:"renewalIntervalInSecs"`
+	DurationInSecs        int `json:"durationInSecs"`
+}
+
+// InstanceRequest is the body of a registration.
+type InstanceRequest struct {
+	Instance Instance `json:"instance"`
+}
+
+type Application struct {
+	Name      string     `json:"name"`
+	Instances []Instance `json:"instance"`
+}
+
+// ApplicationsResponse is the body of GET apps.
+type ApplicationsResponse struct {
+	Applications struct {
+		Applications []Application `json:"application"`
+	} `json:"applications"`
+}
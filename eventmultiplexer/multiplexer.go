@@ -9,29 +9,77 @@ import (
 	"github.com/soupdiver/creg/types"
 )
 
+// LabelBackends lists the backends a container is registered in, by backend
+// name or type separated by commas. Containers without it are registered in
+// all backends.
+const LabelBackends = "creg.backends"
+
 type DockerEventMultiplexer struct {
-	In     []<-chan types.ContainerEventV2
-	Out    map[string]chan types.ContainerEventV2
+	In  []<-chan types.ContainerEventV2
+	Out map[string]chan types.ContainerEventV2
+	// Types holds the backend type of each output by backend name
+	Types map[string]string
+	// done is closed when the output of a backend is removed, so a send to a
+	// backend which is not reading anymore does not block the others
+	done   map[string]chan struct{}
 	outMtx sync.RWMutex
 }
 
 func New(in ...<-chan types.ContainerEventV2) *DockerEventMultiplexer {
 	return &DockerEventMultiplexer{
-		In:  in,
-		Out: make(map[string]chan types.ContainerEventV2),
+		In:    in,
+		Out:   make(map[string]chan types.ContainerEventV2),
+		Types: make(map[string]string),
+		done:  make(map[string]chan struct{}),
 	}
 }
 
-func (m *DockerEventMultiplexer) NewOutput(backendName string) chan types.ContainerEventV2 {
+// NewOutput returns the events of the backend instance backendName of type
+// backendType.
+func (m *DockerEventMultiplexer) NewOutput(backendName, backendType string) chan types.ContainerEventV2 {
 	c := make(chan types.ContainerEventV2)
 
 	m.outMtx.Lock()
 	m.Out[backendName] = c
+	m.Types[backendName] = backendType
+	m.done[backendName] = make(chan struct{})
 	m.outMtx.Unlock()
 
 	return c
 }
 
+// RemoveOutput stops sending events to the backend backendName, it must be
+// called once the backend stopped reading its output.
+func (m *DockerEventMultiplexer) RemoveOutput(backendName string) {
+	m.outMtx.Lock()
+	defer m.outMtx.Unlock()
+
+	if done, ok := m.done[backendName]; ok {
+		close(done)
+	}
+	delete(m.Out, backendName)
+	delete(m.Types, backendName)
+	delete(m.done, backendName)
+}
+
+// Routes reports whether the container is registered in the backend instance
+// backendName of type backendType, see LabelBackends.
+func Routes(container types.ContainerInfo, backendName, backendType string) bool {
+	v, ok := container.Labels[LabelBackends]
+	if !ok {
+		return true
+	}
+
+	for _, backend := range strings.Split(v, ",") {
+		backend = strings.TrimSpace(backend)
+		if backend == backendName || backend == backendType || backend == "all" {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (m *DockerEventMultiplexer) Run(ctx context.Context) {
 	for _, input := range m.In {
 		input := input
@@ -47,21 +95,25 @@ func (m *DockerEventMultiplexer) Run(ctx context.Context) {
 						log.Printf("Multiplexer exiiting: %s", "channel closed")
 						return
 					}
+					// Sends block until the backend reads or its output is
+					// removed, the lock is only held to collect the outputs
+					var outs []chan types.ContainerEventV2
+					var dones []chan struct{}
+					m.outMtx.RLock()
 					for name, cOut := range m.Out {
-						// If no backends are defined, send to all
-						if v, ok := event.Container.Labels["creg.backends"]; !ok {
-							cOut <- event
-							continue
-						} else {
-							// If backends are defined filter for them
-							split := strings.Split(v, ",")
-						Backends:
-							for _, backend := range split {
-								if backend == name || backend == "all" {
-									cOut <- event
-									break Backends
-								}
-							}
+						if Routes(event.Container, name, m.Types[name]) {
+							outs = append(outs, cOut)
+							dones = append(dones, m.done[name])
+						}
+					}
+					m.outMtx.RUnlock()
+
+					for i, cOut := range outs {
+						select {
+						case cOut <- event:
+						case <-dones[i]:
+						case <-ctx.Done():
+							return
 						}
 					}
 				}
@@ -0,0 +1,112 @@
+package eventmultiplexer_test
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/soupdiver/creg/eventmultiplexer"
+	"github.com/soupdiver/creg/types"
+)
+
+func container(backends string) types.ContainerInfo {
+	c := types.ContainerInfo{ID: "a", Labels: map[string]string{}}
+	if backends != "" {
+		c.Labels[eventmultiplexer.LabelBackends] = backends
+	}
+	return c
+}
+
+func TestRoutes(t *testing.T) {
+	tests := []struct {
+		label    string
+		name     string
+		expected bool
+	}{
+		{"", "consul-dc1", true},
+		{"all", "consul-dc1", true},
+		{"consul", "consul-dc1", true},
+		{"consul-dc1", "consul-dc1", true},
+		{"consul-dc2", "consul-dc1", false},
+		{"etcd, consul-dc1", "consul-dc1", true},
+		{"etcd", "consul-dc1", false},
+	}
+
+	for _, test := range tests {
+		routed := eventmultiplexer.Routes(container(test.label), test.name, "consul")
+		if routed != test.expected {
+			t.Fatalf("expected %t for %q to %s, got %t", test.expected, test.label, test.name, routed)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	in := make(chan types.ContainerEventV2)
+	m := eventmultiplexer.New(in)
+	dc1 := m.NewOutput("consul-dc1", "consul")
+	dc2 := m.NewOutput("consul-dc2", "consul")
+	etcd := m.NewOutput("etcd", "etcd")
+	m.Run(ctx)
+
+	// Outputs are sent to one after another, so all are read at once
+	received := func() string {
+		var names []string
+		for {
+			select {
+			case <-dc1:
+				names = append(names, "consul-dc1")
+			case <-dc2:
+				names = append(names, "consul-dc2")
+			case <-etcd:
+				names = append(names, "etcd")
+			case <-time.After(50 * time.Millisecond):
+				sort.Strings(names)
+				return strings.Join(names, ",")
+			}
+		}
+	}
+
+	// By type
+	in <- types.ContainerEventV2{Action: "start", Container: container("consul")}
+	if names := received(); names != "consul-dc1,consul-dc2" {
+		t.Fatalf("expected consul-dc1,consul-dc2, got %s", names)
+	}
+
+	// By instance name
+	in <- types.ContainerEventV2{Action: "start", Container: container("consul-dc2,etcd")}
+	if names := received(); names != "consul-dc2,etcd" {
+		t.Fatalf("expected consul-dc2,etcd, got %s", names)
+	}
+}
+
+func TestRemovedOutputDoesNotBlock(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	in := make(chan types.ContainerEventV2)
+	m := eventmultiplexer.New(in)
+	m.NewOutput("stopped", "consul")
+	etcd := m.NewOutput("etcd", "etcd")
+	m.Run(ctx)
+
+	// The stopped backend never reads, a pending send is released by
+	// removing its output
+	in <- types.ContainerEventV2{Action: "start", Container: container("")}
+	m.RemoveOutput("stopped")
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-etcd:
+		case <-time.After(time.Second):
+			t.Fatalf("expected event %d to be delivered to etcd", i)
+		}
+		if i == 0 {
+			in <- types.ContainerEventV2{Action: "stop", Container: container("")}
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/soupdiver/creg/haproxy"
+)
+
+// Client sends commands to the HAProxy runtime API. Every command uses its
+// own connection, HAProxy closes it after answering in non-interactive mode.
+type Client struct {
+	Network string
+	Address string
+	Timeout time.Duration
+}
+
+type ClientOption func(*Client) error
+
+// CommandError is returned when HAProxy answers a command with an error
+// message.
+type CommandError struct {
+	Command string
+	Message string
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Command, e.Message)
+}
+
+// New creates a client for the stats socket at address. Addresses starting
+// with / are unix sockets, everything else is dialed as host:port.
+func New(address string, options ...ClientOption) (*Client, error) {
+	if address == "" {
+		return nil, fmt.Errorf("address must not be empty")
+	}
+
+	c := &Client{
+		Network: "tcp",
+		Address: address,
+		Timeout: time.Second * 10,
+	}
+	if strings.HasPrefix(address, "/") {
+		c.Network = "unix"
+	}
+
+	for _, option := range options {
+		err := option(c)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// Command sends a single command and returns the trimmed response.
+func (c *Client) Command(ctx context.Context, command string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, c.Network, c.Address)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	deadline, _ := ctx.Deadline()
+	err = conn.SetDeadline(deadline)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = io.WriteString(conn, command+"\n")
+	if err != nil {
+		return "", fmt.Errorf("could not send %q: %w", command, err)
+	}
+
+	out, err := io.ReadAll(conn)
+	if err != nil {
+		return "", fmt.Errorf("could not read response to %q: %w", command, err)
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// expect runs command and treats any response not starting with one of
+// prefixes as an error. An empty prefix accepts an empty response.
+func (c *Client) expect(ctx context.Context, command string, prefixes ...string) error {
+	out, err := c.Command(ctx, command)
+	if err != nil {
+		return err
+	}
+
+	for _, prefix := range prefixes {
+		if (prefix == "" && out == "") || (prefix != "" && strings.HasPrefix(out, prefix)) {
+			return nil
+		}
+	}
+
+	return &CommandError{Command: command, Message: out}
+}
+
+// Servers returns the servers of all backends.
+func (c *Client) Servers(ctx context.Context) ([]haproxy.Server, error) {
+	out, err := c.Command(ctx, "show servers state")
+	if err != nil {
+		return nil, err
+	}
+
+	return haproxy.ParseServersState(out)
+}
+
+// AddServer creates a server in backend, it starts in maintenance. Dynamic
+// servers require HAProxy 2.4 or newer.
+func (c *Client) AddServer(ctx context.Context, backend, server, address, port string) error {
+	return c.expect(ctx, fmt.Sprintf("add server %s/%s %s", backend, server, net.JoinHostPort(address, port)), "New server registered")
+}
+
+// DeleteServer removes a server, it has to be in maintenance and must not
+// have connections left.
+func (c *Client) DeleteServer(ctx context.Context, backend, server string) error {
+	return c.expect(ctx, fmt.Sprintf("del server %s/%s", backend, server), "Server deleted")
+}
+
+// SetServerAddress changes the address and port of a server.
+func (c *Client) SetServerAddress(ctx context.Context, backend, server, address, port string) error {
+	return c.expect(ctx, fmt.Sprintf("set server %s/%s addr %s port %s", backend, server, address, port), "", "IP changed", "port changed", "no need to change")
+}
+
+// SetServerState sets the admin state of a server to one of ready, drain or
+// maint.
+func (c *Client) SetServerState(ctx context.Context, backend, server, state string) error {
+	return c.expect(ctx, fmt.Sprintf("set server %s/%s state %s", backend, server, state), "")
+}
+
+// WithTimeout sets the timeout of each command.
+func WithTimeout(timeout time.Duration) ClientOption {
+	return func(c *Client) error {
+		c.Timeout = timeout
+		return nil
+	}
+}
@@ -0,0 +1,62 @@
+package haproxy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	StateReady = "ready"
+	StateDrain = "drain"
+	StateMaint = "maint"
+)
+
+// Server is an entry of the output of "show servers state".
+type Server struct {
+	Backend string
+	Name    string
+	Address string
+	Port    string
+	// AdminState is a bitfield, see the HAProxy management guide. Bit 0x01
+	// is set for servers forced into maintenance.
+	AdminState int
+}
+
+// ParseServersState parses the output of "show servers state". The first
+// line holds the format version, lines starting with # are comments.
+func ParseServersState(out string) ([]Server, error) {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) == 0 || strings.TrimSpace(lines[0]) != "1" {
+		return nil, fmt.Errorf("unsupported servers state format: %q", lines[0])
+	}
+
+	var servers []Server
+	for _, line := range lines[1:] {
+		if line = strings.TrimSpace(line); line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// be_id be_name srv_id srv_name srv_addr srv_op_state srv_admin_state
+		// ... srv_fqdn srv_port ...
+		fields := strings.Fields(line)
+		if len(fields) < 19 {
+			return nil, fmt.Errorf("invalid server state: %q", line)
+		}
+
+		adminState, err := strconv.Atoi(fields[6])
+		if err != nil {
+			return nil, fmt.Errorf("invalid server admin state: %q", line)
+		}
+
+		servers = append(servers, Server{
+			Backend:    fields[1],
+			Name:       fields[3],
+			Address:    fields[4],
+			Port:       fields[18],
+			AdminState: adminState,
+		})
+	}
+
+	return servers, nil
+}
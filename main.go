@@ -15,33 +15,114 @@ import (
 	flag "github.com/spf13/pflag"
 
 	"github.com/soupdiver/creg/backends"
-	adguardhomebackend "github.com/soupdiver/creg/backends/adguardhome"
-	"github.com/soupdiver/creg/backends/consul"
-	"github.com/soupdiver/creg/backends/etcd"
+	"github.com/soupdiver/creg/backends/plugin"
+	"github.com/soupdiver/creg/backends/publisher"
 	"github.com/soupdiver/creg/config"
 	"github.com/soupdiver/creg/docker"
 	"github.com/soupdiver/creg/eventmultiplexer"
 	"github.com/soupdiver/creg/podman"
 	"github.com/soupdiver/creg/types"
+
+	// Backends register their type in init
+	_ "github.com/soupdiver/creg/backends/adguardhome"
+	_ "github.com/soupdiver/creg/backends/caddy"
+	_ "github.com/soupdiver/creg/backends/consul"
+	_ "github.com/soupdiver/creg/backends/dnsserver"
+	_ "github.com/soupdiver/creg/backends/etcd"
+	_ "github.com/soupdiver/creg/backends/eureka"
+	_ "github.com/soupdiver/creg/backends/haproxy"
+	_ "github.com/soupdiver/creg/backends/hosts"
+	_ "github.com/soupdiver/creg/backends/mdns"
+	_ "github.com/soupdiver/creg/backends/pihole"
+	_ "github.com/soupdiver/creg/backends/powerdns"
+	_ "github.com/soupdiver/creg/backends/prometheus"
+	_ "github.com/soupdiver/creg/backends/redis"
+	_ "github.com/soupdiver/creg/backends/rfc2136"
+	_ "github.com/soupdiver/creg/backends/template"
+	_ "github.com/soupdiver/creg/backends/traefik"
+	_ "github.com/soupdiver/creg/backends/webhook"
+	_ "github.com/soupdiver/creg/backends/zookeeper"
 )
 
 // Create a new instance of the logger. You can have any number of instances.
 var logr = logrus.New()
 
 var (
-	fAddress         = flag.String("address", "", "Address to use for consul services")
-	fConsulAddress   = flag.String("consul", "", "Address of consul agent")
-	fEtcdAddress     = flag.String("etcd", "", "Address of etcd agent")
-	fAdguardHome     = flag.String("adguardhome", "", "Address of adguardhome server")
-	fAdguardHomeAuth = flag.String("adguardhomeauth", "", "Auth of adguardhome server")
-	fHelp            = flag.BoolP("help", "h", false, "Print usage")
-	fDebug           = flag.BoolP("debug", "d", false, "Debug log")
-	fDebugCaller     = flag.BoolP("debugCaller", "g", false, "Debug caller log")
-	fLabels          = flag.StringSliceP("labels", "l", []string{}, "Labels to append tp consul services")
-	fLogColor        = flag.Bool("color", true, "Colorize log output")
-	fSync            = flag.Bool("sync", false, "Sync consul services on start")
-	fEnableLabel     = flag.String("enable", "creg", "label on which to enable creg")
-	fID              = flag.String("id", "creg-default", "Instance ID")
+	fAddress              = flag.String("address", "", "Address to use for consul services, detected if empty")
+	fAddressInterface     = flag.String("addressinterface", "", "Network interface the address is detected from, the one of the default route if empty")
+	fAddressStrategy      = flag.String("addressstrategy", backends.AddressStatic, "Address services are registered at: static (--address), network (container IP and port on --addressnetwork or the creg.network label) or hostip (published host IP)")
+	fAddressNetwork       = flag.String("addressnetwork", "", "Docker network of the network address strategy, the only network of a container if empty")
+	fConsulAddress        = flag.String("consul", "", "Address of consul agent")
+	fEtcdAddress          = flag.String("etcd", "", "Address of etcd agent")
+	fAdguardHome          = flag.String("adguardhome", "", "Address of adguardhome server")
+	fAdguardHomeAuth      = flag.String("adguardhomeauth", "", "Auth of adguardhome server")
+	fAdguardHomeAuthFile  = flag.String("adguardhomeauthfile", "", "File containing auth of adguardhome server")
+	fAdguardHomeCACert    = flag.String("adguardhomecacert", "", "CA certificate to verify adguardhome server")
+	fAdguardHomeInsecure  = flag.Bool("adguardhomeinsecure", false, "Skip TLS verification of adguardhome server")
+	fPihole               = flag.String("pihole", "", "Address of pihole server")
+	fPiholePassword       = flag.String("piholepassword", "", "Password of pihole server")
+	fPiholePasswordFile   = flag.String("piholepasswordfile", "", "File containing password of pihole server")
+	fPiholeInsecure       = flag.Bool("piholeinsecure", false, "Skip TLS verification of pihole server")
+	fRFC2136              = flag.String("rfc2136", "", "Address of DNS server accepting RFC 2136 updates")
+	fRFC2136Zone          = flag.String("rfc2136zone", "", "Zone to update via RFC 2136")
+	fRFC2136TTL           = flag.Uint32("rfc2136ttl", 60, "TTL of RFC 2136 records")
+	fRFC2136Target        = flag.String("rfc2136target", "", "Target of RFC 2136 SRV records, defaults to the service A record")
+	fRFC2136KeyName       = flag.String("rfc2136keyname", "", "Name of the TSIG key for RFC 2136 updates")
+	fRFC2136KeyAlgorithm  = flag.String("rfc2136keyalgorithm", "hmac-sha256", "Algorithm of the TSIG key for RFC 2136 updates")
+	fRFC2136KeySecretFile = flag.String("rfc2136keysecretfile", "", "File containing the base64 TSIG secret for RFC 2136 updates")
+	fDNSServer            = flag.String("dnsserver", "", "Listen address of the built-in DNS server, e.g. :5353")
+	fDNSServerZone        = flag.String("dnsserverzone", "creg.local", "Zone served by the built-in DNS server")
+	fDNSServerTTL         = flag.Uint32("dnsserverttl", 60, "TTL of records served by the built-in DNS server")
+	fPrometheus           = flag.String("prometheus", "", "Path of the Prometheus file_sd file to write")
+	fPrometheusFormat     = flag.String("prometheusformat", "", "Format of the Prometheus file_sd file, json or yaml, defaults to the file extension")
+	fTraefik              = flag.String("traefik", "", "Path of the Traefik dynamic configuration file to write")
+	fCaddy                = flag.String("caddy", "", "Address of the Caddy admin API, e.g. http://localhost:2019")
+	fCaddyServer          = flag.String("caddyserver", "srv0", "Name of the Caddy HTTP server routes are added to")
+	fTemplates            = flag.StringSlice("template", []string{}, "Template to render in the format source:destination, can be repeated")
+	fTemplateReload       = flag.String("templatereload", "", "Command run with sh -c after a rendered template changed")
+	fTemplateInterval     = flag.Duration("templatereloadinterval", time.Second, "Minimum time between two template reload commands")
+	fHAProxy              = flag.String("haproxy", "", "Address of the HAProxy runtime API, a unix socket path or host:port")
+	fWebhooks             = flag.StringSlice("webhook", []string{}, "URL to POST service changes to, can be repeated")
+	fWebhookSecretFile    = flag.String("webhooksecretfile", "", "File containing the secret webhook requests are signed with")
+	fWebhookTimeout       = flag.Duration("webhooktimeout", 10*time.Second, "Timeout of a single webhook request")
+	fWebhookRetries       = flag.Int("webhookretries", 3, "How often a failed webhook request is retried")
+	fWebhookBatch         = flag.Duration("webhookbatch", 0, "Send webhook payloads in batches at most once per interval, 0 sends them right away")
+	fHosts                = flag.String("hosts", "", "Path of the hosts file to maintain a creg block in, e.g. /etc/hosts")
+	fHostsServices        = flag.Bool("hostsservices", false, "Add service names to the hosts file")
+	fHostsDomain          = flag.String("hostsdomain", "", "Domain appended to service names in the hosts file")
+	fRedis                = flag.String("redis", "", "Address of the Redis server, host:port or a redis:// URL")
+	fRedisPasswordFile    = flag.String("redispasswordfile", "", "File containing the Redis password")
+	fRedisPrefix          = flag.String("redisprefix", "creg", "Prefix of all Redis keys and the events channel")
+	fRedisTTL             = flag.Duration("redisttl", 30*time.Second, "Expiry of Redis entries, refreshed by a heartbeat")
+	fNATS                 = flag.String("nats", "", "NATS server URLs to publish registration events to, e.g. nats://localhost:4222")
+	fNATSSubject          = flag.String("natssubject", publisher.DefaultNATSSubject, "Template of the NATS subject of each service")
+	fMQTT                 = flag.String("mqtt", "", "MQTT broker to publish registration events to, e.g. tcp://localhost:1883")
+	fMQTTTopic            = flag.String("mqtttopic", publisher.DefaultMQTTTopic, "Template of the MQTT topic of each service")
+	fZookeeper            = flag.StringSlice("zookeeper", []string{}, "ZooKeeper servers to register services in, host:port")
+	fZookeeperPath        = flag.String("zookeeperpath", "/services", "ZooKeeper base path of the Curator service discovery")
+	fZookeeperTimeout     = flag.Duration("zookeepertimeout", 10*time.Second, "ZooKeeper session timeout, services of a crashed creg disappear after it")
+	fEureka               = flag.String("eureka", "", "Eureka server URL including the context path, e.g. http://localhost:8761/eureka")
+	fEurekaRenewal        = flag.Duration("eurekarenewal", 30*time.Second, "Eureka lease renewal interval, leases expire after three intervals")
+	fMDNS                 = flag.Bool("mdns", false, "Advertise services with a creg.mdns.type label via mDNS/DNS-SD")
+	fMDNSHost             = flag.String("mdnshost", "creg", "Host name in .local the advertised services point to")
+	fMDNSInterface        = flag.String("mdnsinterface", "", "Network interface to advertise on, all if empty")
+	fPowerDNS             = flag.String("powerdns", "", "PowerDNS API endpoint, e.g. http://localhost:8081")
+	fPowerDNSZone         = flag.String("powerdnszone", "", "Zone to manage via the PowerDNS API")
+	fPowerDNSServer       = flag.String("powerdnsserver", "localhost", "PowerDNS server ID")
+	fPowerDNSTTL          = flag.Uint32("powerdnsttl", 60, "TTL of PowerDNS records")
+	fPowerDNSTarget       = flag.String("powerdnstarget", "", "Target of PowerDNS SRV records, defaults to the service A record")
+	fPowerDNSKeyFile      = flag.String("powerdnskeyfile", "", "File containing the PowerDNS API key")
+	fPlugins              = flag.StringSlice("plugin", []string{}, "Plugin command line, optionally prefixed with name=, can be repeated")
+	fConfig               = flag.String("config", "", "YAML config file with settings and backend instances")
+	fHelp                 = flag.BoolP("help", "h", false, "Print usage")
+	fDebug                = flag.BoolP("debug", "d", false, "Debug log")
+	fDebugCaller          = flag.BoolP("debugCaller", "g", false, "Debug caller log")
+	fLabels               = flag.StringSliceP("labels", "l", []string{}, "Labels to append tp consul services")
+	fLogColor             = flag.Bool("color", true, "Colorize log output")
+	fSync                 = flag.Bool("sync", false, "Sync consul services on start")
+	fEnableLabel          = flag.String("enable", "creg", "label on which to enable creg")
+	fStateDir             = flag.String("statedir", "", "Directory backends persist the entries they own in, e.g. /var/lib/creg, so they can be purged after a restart. Not persisted if empty")
+	fID                   = flag.String("id", "creg-default", "Instance ID")
 )
 
 var (
@@ -64,8 +145,44 @@ func Run() error {
 		return nil
 	}
 
-	if fAddress == nil || *fAddress == "" {
-		return fmt.Errorf("address is required")
+	// Flags take precedence over the config file
+	var file *config.File
+	if *fConfig != "" {
+		var err error
+		file, err = config.Load(*fConfig)
+		if err != nil {
+			return err
+		}
+
+		if file.ID != "" && !flag.CommandLine.Changed("id") {
+			*fID = file.ID
+		}
+		if file.Address != "" && !flag.CommandLine.Changed("address") {
+			*fAddress = file.Address
+		}
+		if file.AddressInterface != "" && !flag.CommandLine.Changed("addressinterface") {
+			*fAddressInterface = file.AddressInterface
+		}
+		if file.AddressStrategy != "" && !flag.CommandLine.Changed("addressstrategy") {
+			*fAddressStrategy = file.AddressStrategy
+		}
+		if file.AddressNetwork != "" && !flag.CommandLine.Changed("addressnetwork") {
+			*fAddressNetwork = file.AddressNetwork
+		}
+		if len(file.Labels) > 0 && !flag.CommandLine.Changed("labels") {
+			*fLabels = file.Labels
+		}
+		if file.Enable != "" && !flag.CommandLine.Changed("enable") {
+			*fEnableLabel = file.Enable
+		}
+		if file.StateDir != "" && !flag.CommandLine.Changed("statedir") {
+			*fStateDir = file.StateDir
+		}
+	}
+
+	addressStrategy := backends.AddressStrategy{Mode: *fAddressStrategy, Network: *fAddressNetwork}
+	if err := addressStrategy.Validate(); err != nil {
+		return err
 	}
 
 	if len(*fLabels) > 0 {
@@ -105,19 +222,20 @@ func Run() error {
 
 	ctx = context.WithValue(ctx, "log", log)
 
-	// Get default config
-	cfg := config.DefaultConfig
-
-	// Overwrite settings as needed
-	cfg.ID = *fID
-	if *fConsulAddress != "" {
-		cfg.ConsulConfig.Address = *fConsulAddress
+	if *fAddress == "" {
+		address, err := backends.DetectAddress(*fAddressInterface)
+		if err != nil {
+			return fmt.Errorf("could not detect address, set --address: %w", err)
+		}
+		*fAddress = address
+		log.Printf("Detected address: %s", address)
 	}
-	if *fEtcdAddress != "" {
-		cfg.EtcdConfig.Endpoints = append(cfg.EtcdConfig.Endpoints, *fEtcdAddress)
+
+	cfg := config.Config{
+		ID:             *fID,
+		ForwardAddress: *fAddress,
+		StaticLabels:   *fLabels,
 	}
-	cfg.StaticLabels = *fLabels
-	cfg.ForwardAddress = *fAddress
 
 	// Setup Docker client
 	dockerClient, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
@@ -138,25 +256,57 @@ func Run() error {
 	multi.Run(ctx)
 
 	// Setup Backends
-	var enabledBackends []backends.Backend
-
-	if *fConsulAddress != "" {
-		log.WithField("label", *fEnableLabel).Printf("Enable consul")
-		enabledBackends = append(enabledBackends, ConsulFromConfig(cfg, log))
-		enabledBackends[len(enabledBackends)-1].(*consul.Backend).ServicePrefix = *fEnableLabel
+	instances, err := flagBackends()
+	if err != nil {
+		return err
+	}
+	if file != nil {
+		instances = append(instances, file.Backends...)
 	}
 
-	if *fEtcdAddress != "" {
-		log.Printf("Enable etcd")
-		enabledBackends = append(enabledBackends, EtcdFromConfig(cfg, log))
+	// Backend instances are routed to by name or type with the
+	// creg.backends label
+	var enabledBackends []backends.Backend
+	backendTypes := map[string]string{}
+	knownTypes := map[string]bool{}
+	for _, t := range backends.Types() {
+		knownTypes[t] = true
 	}
+	for _, instance := range instances {
+		backendLog := log
+		if instance.Name != "" {
+			backendLog = log.WithField("instance", instance.Name)
+		}
 
-	if *fAdguardHome != "" {
-		log.Printf("Enable adguardhome: %s", *fAdguardHomeAuth)
-		b, err := adguardhomebackend.New(*fAdguardHome, *fAdguardHomeAuth)
+		address, strategy, err := backendAddress(instance, cfg.ForwardAddress, addressStrategy)
 		if err != nil {
-			return fmt.Errorf("could not create adguardhome backend: %w", err)
+			return fmt.Errorf("invalid address of %s backend: %w", instance.Type, err)
 		}
+
+		b, err := backends.Create(instance.Type, backends.Settings{
+			Name:            instance.Name,
+			ID:              cfg.ID,
+			ForwardAddress:  address,
+			AddressStrategy: strategy,
+			StaticLabels:    cfg.StaticLabels,
+			EnableLabel:     *fEnableLabel,
+			StateDir:        *fStateDir,
+			Log:             backendLog,
+		}, instance.Decode)
+		if err != nil {
+			return fmt.Errorf("could not create %s backend: %w", instance.Type, err)
+		}
+
+		name := b.GetName()
+		if other, ok := backendTypes[name]; ok {
+			return fmt.Errorf("%s and %s backend are both named %q, set a name in the config file", other, instance.Type, name)
+		}
+		if name != instance.Type && knownTypes[name] {
+			return fmt.Errorf("%s backend must not be named after the backend type %q", instance.Type, name)
+		}
+		backendTypes[name] = instance.Type
+
+		log.Printf("Enable %s: %s", instance.Type, name)
 		enabledBackends = append(enabledBackends, b)
 	}
 
@@ -169,10 +319,23 @@ func Run() error {
 	// Start backends
 	var wg sync.WaitGroup
 	for _, backend := range enabledBackends {
+		name, backendType := backend.GetName(), backendTypes[backend.GetName()]
+
+		var backendContainers []types.ContainerInfo
+		for _, container := range containers {
+			if eventmultiplexer.Routes(container, name, backendType) {
+				backendContainers = append(backendContainers, container)
+			}
+		}
+
+		events := multi.NewOutput(name, backendType)
 		wg.Add(1)
 		go func(backend backends.Backend) {
 			defer wg.Done()
-			err := backend.Run(ctx, multi.NewOutput(backend.GetName()), *fSync, containers)
+			// A backend which stopped does not block events of the others
+			defer multi.RemoveOutput(backend.GetName())
+
+			err := backend.Run(ctx, events, *fSync, backendContainers)
 			if err != nil {
 				log.Printf("Backend failed: %s", err)
 			}
@@ -213,28 +376,194 @@ func SetupSignalHandler(cancel context.CancelFunc) {
 	}()
 }
 
-func ConsulFromConfig(cfg config.Config, log *logrus.Entry) *consul.Backend {
-	consulBackend, err := consul.New(cfg.ConsulConfig,
-		consul.WithStaticLabels(cfg.StaticLabels),
-		consul.WithLogger(log),
-		consul.WithID(cfg.ID),
-	)
-	if err != nil {
-		log.Fatalf("could not create consul backend: %s", err)
+// backendAddress applies the address settings a backend instance overrides
+// to the global forward address and address strategy.
+func backendAddress(instance config.Backend, address string, strategy backends.AddressStrategy) (string, backends.AddressStrategy, error) {
+	switch {
+	case instance.Address != "":
+		address = instance.Address
+	case instance.AddressInterface != "":
+		detected, err := backends.DetectAddress(instance.AddressInterface)
+		if err != nil {
+			return "", strategy, err
+		}
+		address = detected
 	}
-	consulBackend.ForwardAddress = cfg.ForwardAddress
 
-	return consulBackend
+	if instance.AddressStrategy != "" {
+		strategy.Mode = instance.AddressStrategy
+	}
+	if instance.AddressNetwork != "" {
+		strategy.Network = instance.AddressNetwork
+	}
+
+	return address, strategy, strategy.Validate()
 }
 
-func EtcdFromConfig(cfg config.Config, log *logrus.Entry) *etcd.Backend {
-	c, err := etcd.New(*cfg.EtcdConfig,
-		etcd.WithStaticLabels(cfg.StaticLabels),
-		etcd.WithLogger(log),
-	)
-	if err != nil {
-		log.Fatalf("could not create etcd backend: %s", err)
+// flagBackends returns the backends enabled by flags.
+func flagBackends() ([]config.Backend, error) {
+	type flagBackend struct {
+		Type   string
+		Name   string
+		Config map[string]interface{}
+	}
+	var enabled []flagBackend
+
+	if *fConsulAddress != "" {
+		enabled = append(enabled, flagBackend{Type: "consul", Config: map[string]interface{}{
+			"address": *fConsulAddress,
+		}})
+	}
+	if *fEtcdAddress != "" {
+		enabled = append(enabled, flagBackend{Type: "etcd", Config: map[string]interface{}{
+			"endpoints": []string{*fEtcdAddress},
+		}})
+	}
+	if *fAdguardHome != "" {
+		enabled = append(enabled, flagBackend{Type: "adguardhome", Config: map[string]interface{}{
+			"address":   *fAdguardHome,
+			"auth":      *fAdguardHomeAuth,
+			"auth_file": *fAdguardHomeAuthFile,
+			"ca_cert":   *fAdguardHomeCACert,
+			"insecure":  *fAdguardHomeInsecure,
+		}})
+	}
+	if *fPihole != "" {
+		enabled = append(enabled, flagBackend{Type: "pihole", Config: map[string]interface{}{
+			"address":       *fPihole,
+			"password":      *fPiholePassword,
+			"password_file": *fPiholePasswordFile,
+			"insecure":      *fPiholeInsecure,
+		}})
+	}
+	if *fRFC2136 != "" {
+		enabled = append(enabled, flagBackend{Type: "rfc2136", Config: map[string]interface{}{
+			"server":          *fRFC2136,
+			"zone":            *fRFC2136Zone,
+			"ttl":             *fRFC2136TTL,
+			"target":          *fRFC2136Target,
+			"key_name":        *fRFC2136KeyName,
+			"key_algorithm":   *fRFC2136KeyAlgorithm,
+			"key_secret_file": *fRFC2136KeySecretFile,
+		}})
+	}
+	if *fDNSServer != "" {
+		enabled = append(enabled, flagBackend{Type: "dnsserver", Config: map[string]interface{}{
+			"listen": *fDNSServer,
+			"zone":   *fDNSServerZone,
+			"ttl":    *fDNSServerTTL,
+		}})
+	}
+	if *fPrometheus != "" {
+		enabled = append(enabled, flagBackend{Type: "prometheus", Config: map[string]interface{}{
+			"path":   *fPrometheus,
+			"format": *fPrometheusFormat,
+		}})
+	}
+	if *fTraefik != "" {
+		enabled = append(enabled, flagBackend{Type: "traefik", Config: map[string]interface{}{
+			"path": *fTraefik,
+		}})
+	}
+	if *fCaddy != "" {
+		enabled = append(enabled, flagBackend{Type: "caddy", Config: map[string]interface{}{
+			"address": *fCaddy,
+			"server":  *fCaddyServer,
+		}})
+	}
+	if len(*fTemplates) > 0 {
+		enabled = append(enabled, flagBackend{Type: "template", Config: map[string]interface{}{
+			"templates":       *fTemplates,
+			"reload_command":  *fTemplateReload,
+			"reload_interval": *fTemplateInterval,
+		}})
+	}
+	if *fHAProxy != "" {
+		enabled = append(enabled, flagBackend{Type: "haproxy", Config: map[string]interface{}{
+			"address": *fHAProxy,
+		}})
+	}
+	if len(*fWebhooks) > 0 {
+		enabled = append(enabled, flagBackend{Type: "webhook", Config: map[string]interface{}{
+			"urls":        *fWebhooks,
+			"secret_file": *fWebhookSecretFile,
+			"timeout":     *fWebhookTimeout,
+			"retries":     *fWebhookRetries,
+			"batch":       *fWebhookBatch,
+		}})
+	}
+	if *fHosts != "" {
+		enabled = append(enabled, flagBackend{Type: "hosts", Config: map[string]interface{}{
+			"path":     *fHosts,
+			"services": *fHostsServices,
+			"domain":   *fHostsDomain,
+		}})
+	}
+	if *fRedis != "" {
+		enabled = append(enabled, flagBackend{Type: "redis", Config: map[string]interface{}{
+			"address":       *fRedis,
+			"password_file": *fRedisPasswordFile,
+			"prefix":        *fRedisPrefix,
+			"ttl":           *fRedisTTL,
+		}})
+	}
+	if *fNATS != "" {
+		enabled = append(enabled, flagBackend{Type: "nats", Config: map[string]interface{}{
+			"url":     *fNATS,
+			"subject": *fNATSSubject,
+		}})
+	}
+	if *fMQTT != "" {
+		enabled = append(enabled, flagBackend{Type: "mqtt", Config: map[string]interface{}{
+			"url":   *fMQTT,
+			"topic": *fMQTTTopic,
+		}})
+	}
+	if len(*fZookeeper) > 0 {
+		enabled = append(enabled, flagBackend{Type: "zookeeper", Config: map[string]interface{}{
+			"servers":         *fZookeeper,
+			"base_path":       *fZookeeperPath,
+			"session_timeout": *fZookeeperTimeout,
+		}})
+	}
+	if *fEureka != "" {
+		enabled = append(enabled, flagBackend{Type: "eureka", Config: map[string]interface{}{
+			"endpoint":         *fEureka,
+			"renewal_interval": *fEurekaRenewal,
+		}})
+	}
+	if *fMDNS {
+		enabled = append(enabled, flagBackend{Type: "mdns", Config: map[string]interface{}{
+			"host":      *fMDNSHost,
+			"interface": *fMDNSInterface,
+		}})
+	}
+	if *fPowerDNS != "" {
+		enabled = append(enabled, flagBackend{Type: "powerdns", Config: map[string]interface{}{
+			"endpoint": *fPowerDNS,
+			"zone":     *fPowerDNSZone,
+			"server":   *fPowerDNSServer,
+			"ttl":      *fPowerDNSTTL,
+			"target":   *fPowerDNSTarget,
+			"key_file": *fPowerDNSKeyFile,
+		}})
+	}
+	for _, p := range *fPlugins {
+		name, command := plugin.ParseCommand(p)
+		enabled = append(enabled, flagBackend{Type: "plugin", Name: name, Config: map[string]interface{}{
+			"command": command,
+		}})
+	}
+
+	var instances []config.Backend
+	for _, e := range enabled {
+		instance, err := config.NewBackend(e.Type, e.Config)
+		if err != nil {
+			return nil, err
+		}
+		instance.Name = e.Name
+		instances = append(instances, instance)
 	}
 
-	return c
+	return instances, nil
 }
@@ -0,0 +1,297 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/soupdiver/creg/pihole"
+)
+
+// Client talks to the Pi-hole v6 REST API.
+type Client struct {
+	Password   string
+	Endpoint   *url.URL
+	HttpClient http.Client
+
+	tlsConfig *tls.Config
+
+	sid    string
+	sidMtx sync.Mutex
+}
+
+type ClientOption func(*Client) error
+
+// APIError is returned for responses with a non-2xx status code.
+type APIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Status     string
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%s %s: unexpected status: %s", e.Method, e.Path, e.Status)
+	}
+	return fmt.Sprintf("%s %s: unexpected status: %s: %s", e.Method, e.Path, e.Status, e.Message)
+}
+
+func New(endpoint string, options ...ClientOption) (*Client, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse endpoint: %w", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("endpoint must be an absolute URL: %q", endpoint)
+	}
+
+	c := &Client{
+		Endpoint: u,
+		HttpClient: http.Client{
+			Timeout: time.Second * 10,
+		},
+	}
+
+	for _, option := range options {
+		err := option(c)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if c.HttpClient.Transport == nil {
+		c.HttpClient.Transport = &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: c.tlsConfig,
+			IdleConnTimeout: time.Second * 90,
+		}
+	}
+
+	return c, nil
+}
+
+// URL returns the endpoint joined with path, keeping any path prefix the
+// endpoint already has.
+func (c *Client) URL(path string) string {
+	return c.Endpoint.JoinPath(path).String()
+}
+
+// Login creates a new session with the configured password.
+func (c *Client) Login(ctx context.Context) error {
+	var res pihole.AuthResponse
+	err := c.send(ctx, http.MethodPost, "api/auth", "", pihole.AuthRequest{Password: c.Password}, &res)
+	if err != nil {
+		return fmt.Errorf("could not login: %w", err)
+	}
+	if !res.Session.Valid {
+		return fmt.Errorf("could not login: session not valid")
+	}
+
+	c.sidMtx.Lock()
+	c.sid = res.Session.SID
+	c.sidMtx.Unlock()
+
+	return nil
+}
+
+// doRequest sends the request with the current session and logs in again once
+// if the session is missing or expired.
+func (c *Client) doRequest(ctx context.Context, method, path string, in, res interface{}) error {
+	if c.Password == "" {
+		return c.send(ctx, method, path, "", in, res)
+	}
+
+	c.sidMtx.Lock()
+	sid := c.sid
+	c.sidMtx.Unlock()
+
+	if sid != "" {
+		err := c.send(ctx, method, path, sid, in, res)
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+			return err
+		}
+	}
+
+	err := c.Login(ctx)
+	if err != nil {
+		return err
+	}
+
+	c.sidMtx.Lock()
+	sid = c.sid
+	c.sidMtx.Unlock()
+
+	return c.send(ctx, method, path, sid, in, res)
+}
+
+func (c *Client) send(ctx context.Context, method, path, sid string, in, res interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("could not encode request: %w", err)
+		}
+		body = bytes.NewReader(b)
+	}
+
+	r, err := http.NewRequestWithContext(ctx, method, c.URL(path), body)
+	if err != nil {
+		return err
+	}
+
+	if sid != "" {
+		r.Header.Set("X-FTL-SID", sid)
+	}
+	if in != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	r.Header.Set("Accept", "application/json")
+
+	resp, err := c.HttpClient.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &APIError{
+			Method:     method,
+			Path:       path,
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+		}
+
+		var errRes pihole.ErrorResponse
+		if json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&errRes) == nil {
+			apiErr.Message = errRes.Error.Message
+		}
+
+		return apiErr
+	}
+
+	if res != nil {
+		err = json.NewDecoder(resp.Body).Decode(res)
+		if err != nil {
+			return fmt.Errorf("could not decode response: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// List returns all local DNS A and CNAME records.
+func (c *Client) List(ctx context.Context) ([]pihole.Record, error) {
+	var res pihole.ConfigResponse
+	err := c.doRequest(ctx, http.MethodGet, "api/config/dns", nil, &res)
+	if err != nil {
+		return nil, err
+	}
+
+	// Pi-hole validates entries on write, malformed ones are skipped
+	var records []pihole.Record
+	for _, v := range res.Config.DNS.Hosts {
+		record, err := pihole.ParseHost(v)
+		if err != nil {
+			continue
+		}
+		records = append(records, record)
+	}
+
+	for _, v := range res.Config.DNS.CNAMERecords {
+		record, err := pihole.ParseCNAME(v)
+		if err != nil {
+			continue
+		}
+		records = append(records, record)
+	}
+
+	return records, nil
+}
+
+// Add creates a local DNS record.
+func (c *Client) Add(ctx context.Context, record pihole.Record) error {
+	return c.doRequest(ctx, http.MethodPut, recordPath(record), nil, nil)
+}
+
+// Delete removes a local DNS record. Deleting a record which does not exist is
+// not an error.
+func (c *Client) Delete(ctx context.Context, record pihole.Record) error {
+	err := c.doRequest(ctx, http.MethodDelete, recordPath(record), nil, nil)
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound {
+		return nil
+	}
+
+	return err
+}
+
+func recordPath(record pihole.Record) string {
+	item := "hosts"
+	if record.Type == pihole.RecordTypeCNAME {
+		item = "cnameRecords"
+	}
+
+	return "api/config/dns/" + item + "/" + url.PathEscape(record.Value())
+}
+
+// WithPassword sets the web interface or app password. An empty password
+// disables authentication.
+func WithPassword(password string) ClientOption {
+	return func(c *Client) error {
+		c.Password = password
+		return nil
+	}
+}
+
+// WithPasswordFile reads the password from path, so it does not have to be
+// passed on the command line.
+func WithPasswordFile(path string) ClientOption {
+	return func(c *Client) error {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("could not read password file: %w", err)
+		}
+
+		password := strings.TrimSpace(string(b))
+		if password == "" {
+			return fmt.Errorf("password file %s is empty", path)
+		}
+
+		c.Password = password
+		return nil
+	}
+}
+
+// WithTimeout sets the timeout of a single request.
+func WithTimeout(timeout time.Duration) ClientOption {
+	return func(c *Client) error {
+		c.HttpClient.Timeout = timeout
+		return nil
+	}
+}
+
+// WithInsecureSkipVerify disables TLS certificate verification, Pi-hole ships
+// with a self-signed certificate by default.
+func WithInsecureSkipVerify() ClientOption {
+	return func(c *Client) error {
+		if c.tlsConfig == nil {
+			c.tlsConfig = &tls.Config{}
+		}
+		c.tlsConfig.InsecureSkipVerify = true
+		return nil
+	}
+}
@@ -0,0 +1,78 @@
+package pihole
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	RecordTypeA     = "A"
+	RecordTypeCNAME = "CNAME"
+)
+
+// Record is a Pi-hole local DNS record. A records map Domain to the IP in
+// Answer, CNAME records map Domain to the target domain in Answer.
+type Record struct {
+	Type   string
+	Domain string
+	Answer string
+}
+
+// Value returns the record in the format used by the Pi-hole config API,
+// "ip domain" for A records and "domain,target" for CNAME records.
+func (r Record) Value() string {
+	if r.Type == RecordTypeCNAME {
+		return r.Domain + "," + r.Answer
+	}
+	return r.Answer + " " + r.Domain
+}
+
+// ParseHost parses an entry of dns.hosts in the format "ip domain".
+func ParseHost(v string) (Record, error) {
+	fields := strings.Fields(v)
+	if len(fields) != 2 {
+		return Record{}, fmt.Errorf("invalid host record: %q", v)
+	}
+
+	return Record{Type: RecordTypeA, Domain: fields[1], Answer: fields[0]}, nil
+}
+
+// ParseCNAME parses an entry of dns.cnameRecords in the format
+// "domain,target[,ttl]".
+func ParseCNAME(v string) (Record, error) {
+	parts := strings.Split(v, ",")
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return Record{}, fmt.Errorf("invalid cname record: %q", v)
+	}
+
+	return Record{Type: RecordTypeCNAME, Domain: parts[0], Answer: parts[1]}, nil
+}
+
+type AuthRequest struct {
+	Password string `json:"password"`
+}
+
+type AuthResponse struct {
+	Session struct {
+		Valid    bool   `json:"valid"`
+		SID      string `json:"sid"`
+		Validity int    `json:"validity"`
+	} `json:"session"`
+}
+
+type ConfigResponse struct {
+	Config struct {
+		DNS struct {
+			Hosts        []string `json:"hosts"`
+			CNAMERecords []string `json:"cnameRecords"`
+		} `json:"dns"`
+	} `json:"config"`
+}
+
+type ErrorResponse struct {
+	Error struct {
+		Key     string `json:"key"`
+		Message string `json:"message"`
+		Hint    string `json:"hint"`
+	} `json:"error"`
+}
@@ -0,0 +1,163 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/soupdiver/creg/powerdns"
+)
+
+// Client talks to the HTTP API of a PowerDNS authoritative server.
+type Client struct {
+	Endpoint   *url.URL
+	HttpClient http.Client
+	APIKey     string
+}
+
+type ClientOption func(*Client) error
+
+// APIError is returned for responses with a non-2xx status code.
+type APIError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Status     string
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%s %s: unexpected status: %s", e.Method, e.Path, e.Status)
+	}
+	return fmt.Sprintf("%s %s: unexpected status: %s: %s", e.Method, e.Path, e.Status, e.Message)
+}
+
+func New(endpoint string, options ...ClientOption) (*Client, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse endpoint: %w", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("endpoint must be an absolute URL: %q", endpoint)
+	}
+
+	c := &Client{
+		Endpoint: u,
+		HttpClient: http.Client{
+			Timeout: time.Second * 10,
+		},
+	}
+
+	for _, option := range options {
+		err := option(c)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// URL returns the endpoint joined with path, keeping any path prefix the
+// endpoint already has.
+func (c *Client) URL(path string) string {
+	return c.Endpoint.JoinPath(path).String()
+}
+
+func (c *Client) doRequest(ctx context.Context, method, path string, in, res interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("could not encode request: %w", err)
+		}
+		body = bytes.NewReader(b)
+	}
+
+	r, err := http.NewRequestWithContext(ctx, method, c.URL(path), body)
+	if err != nil {
+		return err
+	}
+
+	if in != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	r.Header.Set("Accept", "application/json")
+	if c.APIKey != "" {
+		r.Header.Set("X-API-Key", c.APIKey)
+	}
+
+	resp, err := c.HttpClient.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &APIError{
+			Method:     method,
+			Path:       path,
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+		}
+
+		var errRes powerdns.ErrorResponse
+		if json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&errRes) == nil {
+			apiErr.Message = errRes.Error
+		}
+
+		return apiErr
+	}
+
+	if res != nil {
+		err = json.NewDecoder(resp.Body).Decode(res)
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("could not decode response: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func zonePath(server, zone string) string {
+	return "api/v1/servers/" + url.PathEscape(server) + "/zones/" + url.PathEscape(zone)
+}
+
+// Zone returns zone with all its rrsets.
+func (c *Client) Zone(ctx context.Context, server, zone string) (*powerdns.Zone, error) {
+	var res powerdns.Zone
+	err := c.doRequest(ctx, http.MethodGet, zonePath(server, zone), nil, &res)
+	if err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+// PatchRRSets replaces or deletes rrsets of zone in a single transaction,
+// depending on their ChangeType.
+func (c *Client) PatchRRSets(ctx context.Context, server, zone string, rrsets []powerdns.RRSet) error {
+	return c.doRequest(ctx, http.MethodPatch, zonePath(server, zone), powerdns.PatchRequest{RRSets: rrsets}, nil)
+}
+
+// WithTimeout sets the timeout of each request.
+func WithTimeout(timeout time.Duration) ClientOption {
+	return func(c *Client) error {
+		c.HttpClient.Timeout = timeout
+		return nil
+	}
+}
+
+// WithAPIKey sets the key sent in the X-API-Key header.
+func WithAPIKey(key string) ClientOption {
+	return func(c *Client) error {
+		c.APIKey = key
+		return nil
+	}
+}
@@ -0,0 +1,47 @@
+package powerdns
+
+const (
+	ChangeTypeReplace = "REPLACE"
+	ChangeTypeDelete  = "DELETE"
+)
+
+// Zone is the subset of a zone returned by the PowerDNS API creg uses.
+type Zone struct {
+	ID     string  `json:"id"`
+	Name   string  `json:"name"`
+	RRSets []RRSet `json:"rrsets"`
+}
+
+// RRSet is all records of a name and type. ChangeType is only set when
+// patching a zone.
+type RRSet struct {
+	Name       string    `json:"name"`
+	Type       string    `json:"type"`
+	TTL        uint32    `json:"ttl,omitempty"`
+	ChangeType string    `json:"changetype,omitempty"`
+	Records    []Record  `json:"records"`
+	Comments   []Comment `json:"comments"`
+}
+
+// Record is a single record in presentation format, e.g. 10.0.0.1 for A or
+// "0 0 8080 web.example.org." for SRV.
+type Record struct {
+	Content  string `json:"content"`
+	Disabled bool   `json:"disabled"`
+}
+
+type Comment struct {
+	Content    string `json:"content"`
+	Account    string `json:"account"`
+	ModifiedAt int64  `json:"modified_at,omitempty"`
+}
+
+// PatchRequest is the body of a zone PATCH.
+type PatchRequest struct {
+	RRSets []RRSet `json:"rrsets"`
+}
+
+// ErrorResponse is the body of failed API requests.
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
@@ -27,6 +27,14 @@ type ContainerInfo struct {
 
 type NetworkSettings struct {
 	Ports map[Port][]PortBinding
+	// Networks holds the endpoint of the container by network name
+	Networks map[string]Network
+}
+
+// Network is the endpoint of a container in a network
+type Network struct {
+	IPAddress         string
+	GlobalIPv6Address string
 }
 
 // type PortBinding struct {